@@ -0,0 +1,132 @@
+package mlsys
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Ablate answers which parts of the cost model drive the schedule of a
+// graph. For each feature it switches that feature off, prices the chosen
+// schedule without it, solves again without it, and prices the new
+// schedule with the full model as well. A feature whose removal leaves the
+// schedule alone only shifts the predicted latency; one that changes it
+// shapes decisions, and what the new schedule costs under the full model
+// is what ignoring the feature would lose.
+
+// Model features Ablate can switch off.
+const (
+	// FeatureOverlap is the overlap of transfers with compute: without
+	// it, every subgraph runs its transfers and compute back to back, and
+	// so also holds no extra buffers for them.
+	FeatureOverlap = "overlap"
+	// FeatureReuse is operand reuse across steps, when the problem enables
+	// it (see InputProblem.OperandReuse).
+	FeatureReuse = "reuse"
+	// FeatureOverhead is every fixed cost beyond bandwidth and compute:
+	// subgraph dispatch, DMA rows and descriptors, and slow-memory access
+	// latency.
+	FeatureOverhead = "overhead"
+)
+
+var modelFeatures = []string{FeatureOverlap, FeatureReuse, FeatureOverhead}
+
+// Ablation is how a schedule changes without one feature of the cost
+// model.
+type Ablation struct {
+	Feature string `json:"feature"`
+	// AblatedLatency is the latency of the chosen schedule priced without
+	// the feature.
+	AblatedLatency float64 `json:"ablated_latency"`
+	// ResolvedLatency is the latency of the schedule solved without the
+	// feature, priced without it, and ResolvedFullLatency that schedule's
+	// latency under the full model.
+	ResolvedLatency     float64 `json:"resolved_latency"`
+	ResolvedFullLatency float64 `json:"resolved_full_latency"`
+	// Subgraphs counts the subgraphs of the schedule solved without the
+	// feature, and ChangedSubgraphs those the chosen schedule has no
+	// subgraph of the same ops and granularity for.
+	Subgraphs        int `json:"subgraphs"`
+	ChangedSubgraphs int `json:"changed_subgraphs"`
+}
+
+// ParseAblations parses a comma-separated list of model features.
+func ParseAblations(list string) ([]string, error) {
+	var features []string
+	seen := make(map[string]bool)
+	for _, f := range strings.Split(list, ",") {
+		f = strings.ToLower(strings.TrimSpace(f))
+		known := false
+		for _, m := range modelFeatures {
+			known = known || f == m
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown model feature %q (want %s)", f, strings.Join(modelFeatures, ", "))
+		}
+		if !seen[f] {
+			seen[f] = true
+			features = append(features, f)
+		}
+	}
+	return features, nil
+}
+
+// Ablate reports, for each of features in turn, how chosen, a schedule
+// Solve returned for p with opts, changes without it. Cache buckets and
+// serving phases are not solved again.
+func Ablate(ctx context.Context, p InputProblem, opts Options, chosen OutputSolution, features []string) ([]Ablation, error) {
+	p.KVCache, p.Serving = nil, nil
+	opts.EmitIntegrity, opts.EmitMeta, opts.KernelNameFormat = false, false, ""
+	out := make([]Ablation, 0, len(features))
+	for _, f := range features {
+		q, qopts := p, opts
+		switch f {
+		case FeatureOverlap:
+			qopts.serialized = true
+		case FeatureReuse:
+			q.OperandReuse = false
+		case FeatureOverhead:
+			q.SubgraphDispatchOverhead, q.DMARowOverhead, q.DMATransferLatency, q.SlowMemoryLatency = 0, 0, 0, 0
+		default:
+			return nil, fmt.Errorf("unknown model feature %q", f)
+		}
+		a := Ablation{Feature: f}
+		var err error
+		if a.AblatedLatency, err = scheduleLatency(q, chosen, qopts); err != nil {
+			return nil, fmt.Errorf("%s: %w", f, err)
+		}
+		s, err := Solve(ctx, q, qopts)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if len(s.Subgraphs) == 0 {
+			return nil, fmt.Errorf("%s: %w", f, err)
+		}
+		a.ResolvedLatency = s.TotalLatency()
+		if a.ResolvedFullLatency, err = scheduleLatency(p, s, opts); err != nil {
+			return nil, fmt.Errorf("%s: %w", f, err)
+		}
+		for _, ops := range s.Subgraphs {
+			if len(ops) > 0 {
+				a.Subgraphs++
+			}
+		}
+		a.ChangedSubgraphs = ScheduleChanges(chosen, s)
+		out = append(out, a)
+	}
+	return out, nil
+}
+
+// scheduleLatency is the total latency of s under the cost model of p and
+// opts.
+func scheduleLatency(p InputProblem, s OutputSolution, opts Options) (float64, error) {
+	stats, err := AnalyzeSolution(p, s, opts)
+	if err != nil {
+		return 0, err
+	}
+	lat := make([]float64, len(stats))
+	for i, st := range stats {
+		lat[i] = st.Latency
+	}
+	return SumLatencies(lat), nil
+}
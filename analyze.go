@@ -0,0 +1,238 @@
+package mlsys
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// SubgraphStats is the cost model's breakdown of one scheduled subgraph at
+// the nominal bandwidth.
+type SubgraphStats struct {
+	Ops         []int
+	Granularity [3]int64
+	Steps       int64
+	// ComputeTime and MemoryTime are summed over all steps.
+	ComputeTime float64
+	MemoryTime  float64
+	Latency     float64
+	// Standalone is set when transfers and compute run back to back
+	// instead of overlapping.
+	Standalone bool
+	// Traffic is the number of elements moved between slow and fast memory,
+	// and TrafficBytes their size.
+	Traffic      int64
+	TrafficBytes int64
+}
+
+// AnalyzeSolution re-derives the cost model's view of every subgraph in s.
+// It does not check that s is a valid schedule for p, only that it can be
+// costed. Zero-op subgraphs are barriers and keep their latency from s.
+func AnalyzeSolution(p InputProblem, s OutputSolution, opts Options) ([]SubgraphStats, error) {
+	if err := checkOpTypes(p, opts.UnknownOps); err != nil {
+		return nil, err
+	}
+	if len(s.Granularities) != len(s.Subgraphs) {
+		return nil, errors.New("subgraphs/granularities length mismatch")
+	}
+	p, err := withSolutionModes(p, s)
+	if err != nil {
+		return nil, err
+	}
+	gi := buildGraphIndex(p, opts)
+	sc := newGroupScratch(p)
+	stats := make([]SubgraphStats, 0, len(s.Subgraphs))
+	for i, ops := range s.Subgraphs {
+		if len(ops) == 0 {
+			// Barriers do no work; their cost is whatever the schedule
+			// says it is.
+			st := SubgraphStats{Ops: ops, Granularity: s.Granularities[i]}
+			if i < len(s.SubgraphLatencies) {
+				st.Latency = s.SubgraphLatencies[i]
+			}
+			stats = append(stats, st)
+			continue
+		}
+		for _, op := range ops {
+			if op < 0 || op >= len(p.OpTypes) {
+				return nil, fmt.Errorf("subgraph %d: op index out of range: %d", i, op)
+			}
+		}
+		g := s.Granularities[i]
+		if g[0] <= 0 || g[1] <= 0 || g[2] <= 0 {
+			return nil, fmt.Errorf("subgraph %d: granularity entries must be > 0", i)
+		}
+		info := analyzeGroup(p, gi, ops, sc)
+		_, info = withRetained(p, info, retainedInto(gi, s, i))
+		nSteps, compute, _ := stepCosts(p, info, g, p.SlowMemoryBandwidth)
+		var traffic int64
+		var overhead float64
+		for _, c := range stepClasses(p, info, g, p.SlowMemoryBandwidth) {
+			traffic = addSat(traffic, mulSat(c.steps, c.elements))
+			overhead += float64(c.steps) * c.overhead
+		}
+		stats = append(stats, SubgraphStats{
+			Ops:          ops,
+			Granularity:  g,
+			Steps:        nSteps,
+			ComputeTime:  float64(nSteps) * compute,
+			MemoryTime:   float64(traffic)/p.SlowMemoryBandwidth + overhead,
+			Latency:      estimateSubgraphLatency(p, info, g),
+			Standalone:   info.standalone,
+			Traffic:      traffic,
+			TrafficBytes: mulSat(traffic, elementBytes(p)),
+		})
+	}
+	return stats, nil
+}
+
+// GroupCost is the cost model's breakdown of one candidate subgraph at one
+// granularity, at the nominal bandwidth.
+type GroupCost struct {
+	Ops         []int
+	Granularity [3]int64
+	// Chosen is set when Granularity is the solver's own pick.
+	Chosen bool
+	// Fusable reports whether the ops may share a subgraph at all, and
+	// Fits whether the footprint fits in fast memory.
+	Fusable bool
+	Fits    bool
+	// Inputs and Outputs are the tensors crossing the group's boundary.
+	Inputs  []int
+	Outputs []int
+	// WorkingSet is what one step moves at most; Footprint adds the
+	// buffers that stay in fast memory, at BufferDepth copies of the
+	// streamed tiles (0 when buffer depth is not modeled).
+	WorkingSet  int64
+	Footprint   int64
+	BufferDepth int
+	Steps       int64
+	// ComputePerStep and MemoryPerStep are the times of one step; the
+	// latter is for a step that moves the full working set. ComputeTime
+	// and MemoryTime are summed over all steps.
+	ComputePerStep float64
+	MemoryPerStep  float64
+	ComputeTime    float64
+	MemoryTime     float64
+	Latency        float64
+	Standalone     bool
+	Traffic        int64
+	TrafficBytes   int64
+}
+
+// CostGroup evaluates ops as one subgraph at granularity g, or at the
+// granularity the solver would choose when g is zero. Ops may be given in
+// any order. It works for any group, including ones the solver would never
+// form; Fusable and Fits say whether it could.
+func CostGroup(p InputProblem, ops []int, g [3]int64, opts Options) (GroupCost, error) {
+	p, ops, info, err := queryGroup(p, ops, opts)
+	if err != nil {
+		return GroupCost{}, err
+	}
+	c := GroupCost{Ops: ops, Fusable: len(ops) == 1 || info.fusable}
+	if g == [3]int64{} {
+		g, _ = chooseGranularityForGroup(p, info, opts.Compat)
+		c.Chosen = true
+	}
+	if g[0] <= 0 || g[1] <= 0 || g[2] <= 0 {
+		return GroupCost{}, errors.New("granularity entries must be > 0")
+	}
+	w, h, k := g[0], g[1], g[2]
+	c.Granularity = g
+	for _, in := range info.inputs {
+		c.Inputs = append(c.Inputs, in.tensor)
+	}
+	c.Outputs = append([]int{}, info.outputs...)
+	c.WorkingSet = workingSetElementsForGroup(p, info, w, h, k)
+	c.Footprint = footprintElementsForGroup(p, info, w, h, k)
+	c.Fits = checkGroupFits(p, info, g) == nil
+	c.BufferDepth = bufferDepth(p, info, w, h, k)
+	c.Steps, c.ComputePerStep, c.MemoryPerStep = stepCosts(p, info, g, p.SlowMemoryBandwidth)
+	c.ComputeTime = float64(c.Steps) * c.ComputePerStep
+	for _, sc := range stepClasses(p, info, g, p.SlowMemoryBandwidth) {
+		c.Traffic = addSat(c.Traffic, mulSat(sc.steps, sc.elements))
+		c.MemoryTime += float64(sc.steps) * sc.overhead
+	}
+	c.TrafficBytes = mulSat(c.Traffic, elementBytes(p))
+	c.MemoryTime += float64(c.Traffic) / p.SlowMemoryBandwidth
+	c.Latency = estimateSubgraphLatency(p, info, g)
+	c.Standalone = info.standalone
+	return c, nil
+}
+
+// TileCandidate is one granularity a group may run at, with its modeled
+// latency at the nominal bandwidth and at the bandwidth the solver decides
+// by (see the problem's bandwidth distribution and background traffic).
+type TileCandidate struct {
+	Granularity [3]int64
+	Latency     float64
+	Objective   float64
+	Footprint   int64
+}
+
+// RecommendTiles ranks the granularities the solver considers for ops run
+// as one subgraph, best first: every candidate that fits in fast memory,
+// by Objective, larger tiles first among equals. This is the order the
+// solver's own choice follows from v1.2 on. Nothing else of the problem
+// is scheduled. The error wraps ErrInfeasible when the ops cannot share a
+// subgraph or no candidate fits.
+func RecommendTiles(p InputProblem, ops []int, opts Options) ([]TileCandidate, error) {
+	p, ops, info, err := queryGroup(p, ops, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(ops) > 1 && !info.fusable {
+		return nil, fmt.Errorf("%w: ops %v cannot run as one subgraph", ErrInfeasible, ops)
+	}
+	ws, hs, k := candidateTiles(p, info)
+	bw, _ := decisionBandwidth(p)
+	var ranked []TileCandidate
+	for _, w := range ws {
+		for _, h := range hs {
+			if !fitsFastMemory(p, info, w, h, k) || !withinDMALimits(p, info, w, h, k) {
+				continue
+			}
+			g := [3]int64{w, h, k}
+			ranked = append(ranked, TileCandidate{
+				Granularity: g,
+				Latency:     estimateSubgraphLatency(p, info, g),
+				Objective:   estimateGroupLatencyAtBandwidth(p, info, g, bw),
+				Footprint:   footprintElementsForGroup(p, info, w, h, k),
+			})
+		}
+	}
+	if len(ranked) == 0 {
+		return nil, fmt.Errorf("%w: ops %v fit in fast memory at no tile", ErrInfeasible, ops)
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		a, b := ranked[i], ranked[j]
+		if a.Objective != b.Objective {
+			return a.Objective < b.Objective
+		}
+		return a.Granularity[0]*a.Granularity[1] > b.Granularity[0]*b.Granularity[1]
+	})
+	return ranked, nil
+}
+
+// queryGroup checks and analyzes a group given by a caller, returning p
+// with its capacity margin applied and the ops sorted.
+func queryGroup(p InputProblem, ops []int, opts Options) (InputProblem, []int, groupInfo, error) {
+	if err := checkOpTypes(p, opts.UnknownOps); err != nil {
+		return p, nil, groupInfo{}, err
+	}
+	p, err := withCapacityMargin(p, opts)
+	if err != nil {
+		return p, nil, groupInfo{}, err
+	}
+	if len(ops) == 0 {
+		return p, nil, groupInfo{}, errors.New("group has no ops")
+	}
+	for _, op := range ops {
+		if op < 0 || op >= len(p.OpTypes) {
+			return p, nil, groupInfo{}, fmt.Errorf("op index out of range: %d", op)
+		}
+	}
+	ops = sortedUnique(ops)
+	info := analyzeGroup(p, buildGraphIndex(p, opts), ops, newGroupScratch(p))
+	return p, ops, info, nil
+}
@@ -0,0 +1,41 @@
+package mlsys
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// OpAttributes is metadata a pipeline attaches to an op, such as its source
+// location or layer configuration. The solver never reads it; it passes
+// each value through to the solution unchanged.
+type OpAttributes map[string]json.RawMessage
+
+func validateOpAttributes(p InputProblem) error {
+	if p.OpAttributes != nil && len(p.OpAttributes) != len(p.OpTypes) {
+		return fmt.Errorf("op_attributes has %d entries for %d ops", len(p.OpAttributes), len(p.OpTypes))
+	}
+	return nil
+}
+
+// SubgraphOpAttributes lists, for every subgraph of s, the attributes of
+// its ops keyed by op index. Ops without attributes are left out, and
+// subgraphs with none get nil. s must only reference ops of p. It returns
+// nil when p carries no op_attributes.
+func SubgraphOpAttributes(p InputProblem, s OutputSolution) []map[int]OpAttributes {
+	if p.OpAttributes == nil {
+		return nil
+	}
+	out := make([]map[int]OpAttributes, len(s.Subgraphs))
+	for i, ops := range s.Subgraphs {
+		for _, op := range ops {
+			if len(p.OpAttributes[op]) == 0 {
+				continue
+			}
+			if out[i] == nil {
+				out[i] = make(map[int]OpAttributes)
+			}
+			out[i][op] = p.OpAttributes[op]
+		}
+	}
+	return out
+}
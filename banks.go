@@ -0,0 +1,55 @@
+package mlsys
+
+import "errors"
+
+// Fast memory may be banked: consecutive elements sit in consecutive
+// banks, round-robin over FastMemoryBanks, and each bank serves one access
+// per round. A matmul reads its right-hand tile, k rows of w elements
+// stored row by row, one column at a time, so the k elements of a column
+// are w apart and fall into only banks/gcd(w, banks) distinct banks. When
+// that is fewer than k, the column takes several rounds instead of one,
+// and every extra round costs BankConflictCost of compute time. Narrow
+// tiles and widths that are not a multiple of the bank count spread
+// columns over more banks.
+
+func validateBanks(p InputProblem) error {
+	if p.FastMemoryBanks < 0 {
+		return errors.New("fast_memory_banks must be >= 0")
+	}
+	if p.BankConflictCost < 0 {
+		return errors.New("bank_conflict_cost must be >= 0")
+	}
+	return nil
+}
+
+// bankConflictTime is the compute time one step of granularity [w, h, k]
+// loses to bank conflicts while reading its right-hand tiles.
+func bankConflictTime(p InputProblem, info groupInfo, w, h, k int64) float64 {
+	banks := p.FastMemoryBanks
+	if banks <= 1 || p.BankConflictCost == 0 || !info.tileable {
+		return 0
+	}
+	k = maxI64(1, k)
+	distinct := minI64(k, banks/gcd(w, banks))
+	extra := ceilDiv(k, distinct) - ceilDiv(k, banks)
+	if extra <= 0 {
+		return 0
+	}
+	var columns int64
+	for _, in := range info.inputs {
+		switch in.role {
+		case roleRHS:
+			columns += w
+		case roleHeadRHS:
+			columns += w * tileHeads(p, info, h)
+		}
+	}
+	return float64(columns*extra) * p.BankConflictCost
+}
+
+func gcd(a, b int64) int64 {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
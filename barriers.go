@@ -0,0 +1,65 @@
+package mlsys
+
+import "fmt"
+
+// Barrier requests a synchronization point once AfterOp has completed,
+// e.g. before the host reads a result back. Everything scheduled before the
+// barrier finishes before anything after it starts, so no subgraph spans
+// it. Cost is the modeled latency of the barrier itself.
+type Barrier struct {
+	AfterOp int     `json:"after_op"`
+	Cost    float64 `json:"cost"`
+	Label   string  `json:"label,omitempty"`
+}
+
+// A barrier appears in a schedule as a zero-op subgraph. It has the unit
+// granularity, no traversal order and retains nothing: tensors retained by
+// the subgraph before it do not survive the sync.
+var barrierGranularity = [3]int64{1, 1, 1}
+
+func validateBarriers(p InputProblem) error {
+	for i, b := range p.Barriers {
+		if b.AfterOp < 0 || b.AfterOp >= len(p.OpTypes) {
+			return fmt.Errorf("barrier %d: after_op out of range: %d", i, b.AfterOp)
+		}
+		if b.Cost < 0 {
+			return fmt.Errorf("barrier %d: cost must be >= 0", i)
+		}
+	}
+	return nil
+}
+
+// barrierSegments numbers the stretches of ops between barriers: ops in
+// different segments must not share a subgraph.
+func barrierSegments(p InputProblem) []int {
+	cuts := make([]int, len(p.OpTypes)+1)
+	for _, b := range p.Barriers {
+		cuts[b.AfterOp+1]++
+	}
+	segment := make([]int, len(p.OpTypes))
+	n := 0
+	for op := range segment {
+		n += cuts[op]
+		segment[op] = n
+	}
+	return segment
+}
+
+// barriersAfter groups the barriers by the plan they follow: the one that
+// holds their AfterOp.
+func barriersAfter(p InputProblem, plans []subgraphPlan) map[int][]Barrier {
+	if len(p.Barriers) == 0 {
+		return nil
+	}
+	at := make([]int, len(p.OpTypes))
+	for i, plan := range plans {
+		for _, op := range plan.ops {
+			at[op] = i
+		}
+	}
+	after := make(map[int][]Barrier)
+	for _, b := range p.Barriers {
+		after[at[b.AfterOp]] = append(after[at[b.AfterOp]], b)
+	}
+	return after
+}
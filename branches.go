@@ -0,0 +1,181 @@
+package mlsys
+
+import (
+	"fmt"
+	"sort"
+)
+
+// With ParallelBranches set, the ops of a subgraph are not assumed to run
+// one after another within a step. Elementwise ops run on the vector
+// engine and all others on the matrix engine, and two ops on different
+// engines may overlap when neither depends on the other. A step's compute
+// time is then the length of a list schedule of the group's op graph on
+// the two engines, which favours ops on the longest remaining path, and
+// the order ops start in is emitted per subgraph in op_orders.
+
+// branchDAG is the dependence graph among the ops of a group, in local
+// indices following the group's op order, which is topological.
+type branchDAG struct {
+	ops    []int
+	cost   []float64
+	vector []bool
+	preds  [][]int
+}
+
+// newBranchDAG builds the dependence graph of ops, or returns nil when
+// branches are not modeled or there is nothing to overlap.
+func newBranchDAG(p InputProblem, gi graphIndex, ops []int) *branchDAG {
+	if !p.ParallelBranches || len(ops) < 2 {
+		return nil
+	}
+	local := make(map[int]int, len(ops))
+	for i, op := range ops {
+		local[op] = i
+	}
+	d := &branchDAG{
+		ops:    ops,
+		cost:   make([]float64, len(ops)),
+		vector: make([]bool, len(ops)),
+		preds:  make([][]int, len(ops)),
+	}
+	for i, op := range ops {
+		d.cost[i] = gi.opCost(p, op)
+		d.vector[i] = gi.opTypes[op].class == classElementwise
+		seen := make(map[int]bool)
+		for _, t := range p.Inputs[op] {
+			for _, producer := range gi.producers[t] {
+				if j, ok := local[producer]; ok && j < i && !seen[j] {
+					seen[j] = true
+					d.preds[i] = append(d.preds[i], j)
+				}
+			}
+		}
+	}
+	return d
+}
+
+// schedule list-schedules the ops of one step on the two engines, with
+// elementwise ops' costs scaled by vectorScale. It returns the step's
+// compute time and the ops in the order they start.
+func (d *branchDAG) schedule(p InputProblem, vectorScale float64) (float64, []int) {
+	n := len(d.ops)
+	cost := make([]float64, n)
+	for i := range d.ops {
+		cost[i] = d.cost[i]
+		if d.vector[i] {
+			cost[i] *= vectorScale
+		}
+	}
+	// The bottom level of an op is the length of the longest path from its
+	// start to the end of the step.
+	succs := make([][]int, n)
+	for i, ps := range d.preds {
+		for _, j := range ps {
+			succs[j] = append(succs[j], i)
+		}
+	}
+	bottom := make([]float64, n)
+	for i := n - 1; i >= 0; i-- {
+		var longest float64
+		for _, j := range succs[i] {
+			longest = max(longest, bottom[j])
+		}
+		bottom[i] = cost[i] + longest
+	}
+
+	waiting := make([]int, n)
+	for i, ps := range d.preds {
+		waiting[i] = len(ps)
+	}
+	start := make([]float64, n)
+	finish := make([]float64, n)
+	var engineFree [2]float64
+	done := make([]bool, n)
+	picked := make([]int, 0, n)
+	var makespan float64
+	for len(picked) < n {
+		next := -1
+		for i := range d.ops {
+			if !done[i] && waiting[i] == 0 && (next < 0 || bottom[i] > bottom[next]) {
+				next = i
+			}
+		}
+		engine := 0
+		if d.vector[next] {
+			engine = 1
+		}
+		ready := engineFree[engine]
+		for _, j := range d.preds[next] {
+			ready = max(ready, finish[j])
+		}
+		start[next], finish[next] = ready, ready+cost[next]
+		engineFree[engine] = finish[next]
+		makespan = max(makespan, finish[next])
+		done[next] = true
+		picked = append(picked, next)
+		for _, j := range succs[next] {
+			waiting[j]--
+		}
+	}
+
+	sort.SliceStable(picked, func(a, b int) bool { return start[picked[a]] < start[picked[b]] })
+	order := make([]int, n)
+	for i, j := range picked {
+		order[i] = d.ops[j]
+	}
+	return makespan, order
+}
+
+// opOrder is the order the ops of a planned subgraph start in.
+func opOrder(p InputProblem, plan subgraphPlan) []int {
+	if plan.info.branches == nil {
+		return append([]int{}, plan.ops...)
+	}
+	g := plan.granularity
+	_, order := plan.info.branches.schedule(p, stepVectorScale(p, g[0], g[1]))
+	return order
+}
+
+// stepVectorScale is the factor elementwise costs are scaled by at a w x h
+// tile; see vectorScale.
+func stepVectorScale(p InputProblem, w, h int64) float64 {
+	if p.VectorWidth > 0 {
+		return vectorScale(p, w, h)
+	}
+	return 1
+}
+
+// validateOpOrders checks that each order lists its subgraph's ops once,
+// producers before their consumers.
+func validateOpOrders(p InputProblem, gi graphIndex, s OutputSolution) error {
+	if s.OpOrders == nil {
+		return nil
+	}
+	if len(s.OpOrders) != len(s.Subgraphs) {
+		return fmt.Errorf("op_orders has %d entries for %d subgraphs", len(s.OpOrders), len(s.Subgraphs))
+	}
+	for i, order := range s.OpOrders {
+		pos := make(map[int]int, len(order))
+		for k, op := range order {
+			pos[op] = k
+		}
+		same := len(order) == len(s.Subgraphs[i]) && len(pos) == len(order)
+		for _, op := range s.Subgraphs[i] {
+			_, ok := pos[op]
+			same = same && ok
+		}
+		if !same {
+			return fmt.Errorf("subgraph %d: op order is not a permutation of its ops", i)
+		}
+		for _, op := range order {
+			for _, t := range p.Inputs[op] {
+				for _, producer := range gi.producers[t] {
+					if k, ok := pos[producer]; ok && k > pos[op] {
+						return fmt.Errorf("subgraph %d: op %d is ordered before op %d, which produces its input %d", i, op, producer, t)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
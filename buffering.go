@@ -0,0 +1,91 @@
+package mlsys
+
+import (
+	"fmt"
+	"math"
+)
+
+// maxBufferDepth is the deepest buffering the solver considers.
+const maxBufferDepth = 3
+
+// With MaxBufferDepth set, every subgraph runs with 1 to MaxBufferDepth
+// copies of its streamed tiles in fast memory, and the roofline follows
+// the depth:
+//
+//   - 1: no overlap; each step loads, computes and stores in turn.
+//   - 2: double buffering; the next step's transfers overlap the current
+//     step's compute, so each step costs the larger of the two.
+//   - 3: triple buffering; a spare buffer lets fast steps absorb slow
+//     ones, so the subgraph costs the larger of its total compute and
+//     total transfer time.
+//
+// Deeper buffering never models slower, so each tile runs at the deepest
+// depth that fits; the tile chooser then trades larger tiles against
+// deeper buffering by latency. Without MaxBufferDepth the model is the
+// historical one: double-buffered latency at a single copy's footprint.
+
+func validateBufferDepth(p InputProblem) error {
+	if p.MaxBufferDepth < 0 || p.MaxBufferDepth > maxBufferDepth {
+		return fmt.Errorf("max_buffer_depth must be within [0, %d]", maxBufferDepth)
+	}
+	return nil
+}
+
+// bufferDepth is the depth a subgraph runs with at granularity [w, h, k],
+// or 0 when buffer depth is not modeled. Groups whose transfers cannot
+// overlap compute, untiled ones, which move everything in one step, and
+// those in a pooled cache, which allocates nothing, run single-buffered.
+func bufferDepth(p InputProblem, info groupInfo, w, h, k int64) int {
+	if p.MaxBufferDepth == 0 {
+		return 0
+	}
+	if info.standalone || !info.tileable || pooledCache(p) {
+		return 1
+	}
+	base := singleBufferFootprint(p, info, w, h, k)
+	streamed := streamedElements(p, info, w, h, k)
+	for d := p.MaxBufferDepth; d > 1; d-- {
+		if info.memory != nil {
+			if fitsMemoriesAtDepth(p, info, w, h, k, d) {
+				return d
+			}
+		} else if float64(base+int64(d-1)*streamed) <= p.FastMemoryCapacity {
+			return d
+		}
+	}
+	return 1
+}
+
+// streamedElements is the part of the working set that changes between
+// steps, and so is what extra buffers hold: everything but whole inputs.
+func streamedElements(p InputProblem, info groupInfo, w, h, k int64) int64 {
+	total := workingSetElementsForGroup(p, info, w, h, k)
+	for _, in := range info.inputs {
+		if in.role == roleWhole {
+			total -= p.Widths[in.tensor] * p.Heights[in.tensor]
+		}
+	}
+	return total
+}
+
+// rooflineLatency applies the roofline for the given buffer depth to
+// every class of steps; mem is the transfer time of one step of a class.
+func rooflineLatency(info groupInfo, depth int, classes []stepClass, computePerStep float64, mem func(stepClass) float64) float64 {
+	var latency, compute, transfer float64
+	for _, c := range classes {
+		steps := float64(c.steps)
+		m := mem(c)
+		switch {
+		case info.standalone || depth == 1:
+			latency += steps * (computePerStep + m)
+		default:
+			latency += steps * math.Max(computePerStep, m)
+		}
+		compute += steps * computePerStep
+		transfer += steps * m
+	}
+	if depth >= 3 && !info.standalone {
+		return math.Max(compute, transfer)
+	}
+	return latency
+}
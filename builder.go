@@ -0,0 +1,117 @@
+package mlsys
+
+import (
+	"errors"
+	"fmt"
+)
+
+// TensorID and OpID identify the tensors and ops of a ProblemBuilder; they
+// are the indices the built problem uses.
+type (
+	TensorID int
+	OpID     int
+)
+
+// ProblemBuilder assembles an InputProblem one tensor and op at a time,
+// for Go callers that would otherwise fill its parallel slices by hand.
+// Mistakes are reported by Build, together with everything
+// ValidateProblem checks.
+//
+//	var b mlsys.ProblemBuilder
+//	x := b.AddTensor(128, 128)
+//	w := b.AddTensor(128, 128)
+//	y := b.AddTensor(128, 128)
+//	b.AddOp("MatMul", 1000, []mlsys.TensorID{x, w}, []mlsys.TensorID{y})
+//	b.SetHardware(hw)
+//	p, err := b.Build()
+type ProblemBuilder struct {
+	widths, heights []int64
+	names           []string
+	inputs, outputs [][]int
+	baseCosts       []float64
+	opTypes         []string
+	hw              Hardware
+	hwSet           bool
+	err             error
+}
+
+// AddTensor adds a tensor of the given width and height.
+func (b *ProblemBuilder) AddTensor(width, height int64) TensorID {
+	return b.AddNamedTensor("", width, height)
+}
+
+// AddNamedTensor adds a tensor with a name, kept in TensorNames. The built
+// problem has TensorNames only if some tensor is named.
+func (b *ProblemBuilder) AddNamedTensor(name string, width, height int64) TensorID {
+	b.widths = append(b.widths, width)
+	b.heights = append(b.heights, height)
+	b.names = append(b.names, name)
+	return TensorID(len(b.widths) - 1)
+}
+
+// AddOp adds an op of type opType, reading inputs and writing outputs.
+func (b *ProblemBuilder) AddOp(opType string, baseCost float64, inputs, outputs []TensorID) OpID {
+	op := OpID(len(b.opTypes))
+	b.opTypes = append(b.opTypes, opType)
+	b.baseCosts = append(b.baseCosts, baseCost)
+	b.inputs = append(b.inputs, b.tensorIndices(op, inputs))
+	b.outputs = append(b.outputs, b.tensorIndices(op, outputs))
+	return op
+}
+
+// tensorIndices converts the operands of op, recording the first unknown
+// one as the builder's error.
+func (b *ProblemBuilder) tensorIndices(op OpID, ts []TensorID) []int {
+	out := make([]int, len(ts))
+	for i, t := range ts {
+		if (t < 0 || int(t) >= len(b.widths)) && b.err == nil {
+			b.err = fmt.Errorf("op %d: tensor %d was not added", op, t)
+		}
+		out[i] = int(t)
+	}
+	return out
+}
+
+// SetHardware sets the hardware description, replacing any set before.
+func (b *ProblemBuilder) SetHardware(hw Hardware) {
+	b.hw, b.hwSet = hw, true
+}
+
+// Build returns the problem, or the first mistake made building it. The
+// problem shares no slices with the builder, so adding to the builder
+// afterwards leaves it unchanged.
+func (b *ProblemBuilder) Build() (InputProblem, error) {
+	if b.err != nil {
+		return InputProblem{}, b.err
+	}
+	if !b.hwSet {
+		return InputProblem{}, errors.New("no hardware set")
+	}
+	p := InputProblem{
+		Widths:    append([]int64{}, b.widths...),
+		Heights:   append([]int64{}, b.heights...),
+		Inputs:    cloneIndexLists(b.inputs),
+		Outputs:   cloneIndexLists(b.outputs),
+		BaseCosts: append([]float64{}, b.baseCosts...),
+		OpTypes:   append([]string{}, b.opTypes...),
+	}
+	for _, name := range b.names {
+		if name != "" {
+			p.TensorNames = append([]string{}, b.names...)
+			break
+		}
+	}
+	p = WithHardware(p, b.hw)
+	if err := ValidateProblem(p); err != nil {
+		return InputProblem{}, err
+	}
+	return p, nil
+}
+
+func cloneIndexLists(lists [][]int) [][]int {
+	out := make([][]int, len(lists))
+	for i, l := range lists {
+		out[i] = append([]int{}, l...)
+	}
+	return out
+}
@@ -0,0 +1,165 @@
+package mlsys
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// ModelReport compares the cost model's subgraph latencies with measured
+// ones. Errors are relative to the measurement: a positive bias means the
+// model overestimates.
+type ModelReport struct {
+	Subgraphs int     `json:"subgraphs"`
+	MAPE      float64 `json:"mape"`
+	Bias      float64 `json:"bias"`
+	// ByOpType breaks the errors down by the op type that dominates each
+	// subgraph's base cost, in order of type name.
+	ByOpType     []OpTypeError `json:"by_op_type"`
+	Calibrations []Calibration `json:"calibrations"`
+	Comparisons  []Comparison  `json:"comparisons"`
+}
+
+// Comparison is one measured subgraph.
+type Comparison struct {
+	Subgraph  int     `json:"subgraph"`
+	OpType    string  `json:"op_type"`
+	Predicted float64 `json:"predicted"`
+	Measured  float64 `json:"measured"`
+	// MemoryBound is set when the model has the subgraph wait on
+	// transfers rather than compute.
+	MemoryBound bool `json:"memory_bound"`
+}
+
+// OpTypeError is the error of the subgraphs dominated by one op type.
+type OpTypeError struct {
+	OpType    string  `json:"op_type"`
+	Subgraphs int     `json:"subgraphs"`
+	MAPE      float64 `json:"mape"`
+	Bias      float64 `json:"bias"`
+}
+
+// Calibration suggests scaling a problem parameter by Scale to bring the
+// model in line with the measurements: the base costs of OpType, fitted
+// on the compute-bound subgraphs it dominates, or, with an empty OpType,
+// slow_memory_bandwidth, fitted on the memory-bound ones. Scale is the
+// median over those subgraphs of what the parameter would have to be
+// multiplied by for each prediction to match.
+type Calibration struct {
+	Parameter string  `json:"parameter"`
+	OpType    string  `json:"op_type,omitempty"`
+	Scale     float64 `json:"scale"`
+	Subgraphs int     `json:"subgraphs"`
+}
+
+// CompareMeasurements compares the latencies the cost model predicts for
+// the subgraphs of s, as AnalyzeSolution does, with measured, which maps
+// subgraph indices to measured latencies. Subgraphs without a measurement
+// are left out; barriers cannot be measured.
+func CompareMeasurements(p InputProblem, s OutputSolution, opts Options, measured map[int]float64) (ModelReport, error) {
+	stats, err := AnalyzeSolution(p, s, opts)
+	if err != nil {
+		return ModelReport{}, err
+	}
+	var r ModelReport
+	subgraphs := make([]int, 0, len(measured))
+	for i, m := range measured {
+		switch {
+		case i < 0 || i >= len(stats):
+			return ModelReport{}, fmt.Errorf("measurement for subgraph %d, but the schedule has %d", i, len(stats))
+		case len(s.Subgraphs[i]) == 0:
+			return ModelReport{}, fmt.Errorf("measurement for subgraph %d, a barrier", i)
+		case !(m > 0) || math.IsInf(m, 0):
+			return ModelReport{}, fmt.Errorf("subgraph %d: measured latency must be positive and finite, got %g", i, m)
+		}
+		subgraphs = append(subgraphs, i)
+	}
+	sort.Ints(subgraphs)
+	for _, i := range subgraphs {
+		st := stats[i]
+		r.Comparisons = append(r.Comparisons, Comparison{
+			Subgraph:    i,
+			OpType:      dominantOpType(p, st.Ops),
+			Predicted:   st.Latency,
+			Measured:    measured[i],
+			MemoryBound: st.MemoryTime > st.ComputeTime,
+		})
+	}
+	r.Subgraphs = len(r.Comparisons)
+	r.MAPE, r.Bias = relativeErrors(r.Comparisons)
+
+	byType := make(map[string][]Comparison)
+	for _, c := range r.Comparisons {
+		byType[c.OpType] = append(byType[c.OpType], c)
+	}
+	types := make([]string, 0, len(byType))
+	for t := range byType {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	var memoryRatios []float64
+	for _, c := range r.Comparisons {
+		if c.MemoryBound {
+			// Transfer time is inversely proportional to bandwidth.
+			memoryRatios = append(memoryRatios, c.Predicted/c.Measured)
+		}
+	}
+	for _, t := range types {
+		cs := byType[t]
+		e := OpTypeError{OpType: t, Subgraphs: len(cs)}
+		e.MAPE, e.Bias = relativeErrors(cs)
+		r.ByOpType = append(r.ByOpType, e)
+		var ratios []float64
+		for _, c := range cs {
+			if !c.MemoryBound {
+				ratios = append(ratios, c.Measured/c.Predicted)
+			}
+		}
+		if len(ratios) > 0 {
+			r.Calibrations = append(r.Calibrations, Calibration{Parameter: "base_costs", OpType: t, Scale: median(ratios), Subgraphs: len(ratios)})
+		}
+	}
+	if len(memoryRatios) > 0 {
+		r.Calibrations = append(r.Calibrations, Calibration{Parameter: "slow_memory_bandwidth", Scale: median(memoryRatios), Subgraphs: len(memoryRatios)})
+	}
+	return r, nil
+}
+
+// dominantOpType is the canonical type of the ops with the largest summed
+// base cost among ops, the first in op order on ties.
+func dominantOpType(p InputProblem, ops []int) string {
+	cost := make(map[string]float64)
+	best := ""
+	for _, op := range ops {
+		t := canonicalOpType(p.OpTypes[op])
+		cost[t] += p.BaseCosts[op]
+		if best == "" || cost[t] > cost[best] {
+			best = t
+		}
+	}
+	return best
+}
+
+// relativeErrors returns the mean absolute and the mean signed error of
+// cs, relative to the measurements.
+func relativeErrors(cs []Comparison) (mape, bias float64) {
+	if len(cs) == 0 {
+		return 0, 0
+	}
+	for _, c := range cs {
+		e := (c.Predicted - c.Measured) / c.Measured
+		mape += math.Abs(e)
+		bias += e
+	}
+	return mape / float64(len(cs)), bias / float64(len(cs))
+}
+
+func median(xs []float64) float64 {
+	sorted := append([]float64(nil), xs...)
+	sort.Float64s(sorted)
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
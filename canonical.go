@@ -0,0 +1,56 @@
+package mlsys
+
+import "sort"
+
+// CanonicalizeSolution returns a copy of s in canonical form, so that two
+// schedules that mean the same thing serialize identically: the ops of each
+// subgraph and each retained-tensor list are sorted ascending and
+// de-duplicated. Subgraph order is execution order and is kept. Nested
+// schedules (KV-cache buckets, serving phases) are canonicalized too.
+//
+// Field order in the JSON encoding is fixed by the struct definitions, so
+// canonicalizing and re-encoding also drops unknown fields and normalizes
+// key order.
+func CanonicalizeSolution(s OutputSolution) OutputSolution {
+	c := s
+	c.Subgraphs = make([][]int, len(s.Subgraphs))
+	for i, ops := range s.Subgraphs {
+		c.Subgraphs[i] = sortedUnique(ops)
+	}
+	if s.TensorsToRetain != nil {
+		c.TensorsToRetain = make([][]int, len(s.TensorsToRetain))
+		for i, ts := range s.TensorsToRetain {
+			c.TensorsToRetain[i] = sortedUnique(ts)
+		}
+	}
+	if s.KVCacheBuckets != nil {
+		c.KVCacheBuckets = make([]KVCacheBucket, len(s.KVCacheBuckets))
+		for i, b := range s.KVCacheBuckets {
+			b.Schedule = CanonicalizeSolution(b.Schedule)
+			c.KVCacheBuckets[i] = b
+		}
+	}
+	if s.Serving != nil {
+		sv := *s.Serving
+		sv.PinnedWeights = sortedUnique(sv.PinnedWeights)
+		sv.Prefill = CanonicalizeSolution(sv.Prefill)
+		sv.Decode = CanonicalizeSolution(sv.Decode)
+		c.Serving = &sv
+	}
+	return c
+}
+
+// sortedUnique returns a sorted copy of xs without duplicates. A nil or
+// empty list comes back as an empty, non-nil list, which encodes as [].
+func sortedUnique(xs []int) []int {
+	out := append([]int{}, xs...)
+	sort.Ints(out)
+	n := 0
+	for i, x := range out {
+		if i == 0 || x != out[n-1] {
+			out[n] = x
+			n++
+		}
+	}
+	return out[:n]
+}
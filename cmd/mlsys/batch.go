@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"mlsys"
+)
+
+// Per-problem outcomes in a batch report.
+const (
+	batchOK         = "ok"
+	batchInfeasible = "infeasible"
+	batchInvalid    = "invalid"
+	batchTimeout    = "timeout"
+	batchPanic      = "panic"
+)
+
+// batchReport is the combined report of a batch run.
+type batchReport struct {
+	Problems []batchResult `json:"problems"`
+	// Latency summarizes the total estimated latency of the problems that
+	// were solved, infeasible ones included.
+	Latency *latencySummary `json:"latency,omitempty"`
+	// OpTypes sums the latency attributed to each op type over all solved
+	// problems, largest first.
+	OpTypes []batchOpType `json:"op_types"`
+}
+
+type batchResult struct {
+	Problem      string  `json:"problem"`
+	Solution     string  `json:"solution,omitempty"`
+	Status       string  `json:"status"`
+	Error        string  `json:"error,omitempty"`
+	Ops          int     `json:"ops,omitempty"`
+	Subgraphs    int     `json:"subgraphs,omitempty"`
+	TotalLatency float64 `json:"total_latency,omitempty"`
+	Seconds      float64 `json:"seconds"`
+
+	opTypes []mlsys.OpTypeLatency
+}
+
+type latencySummary struct {
+	Count int     `json:"count"`
+	Min   float64 `json:"min"`
+	P50   float64 `json:"p50"`
+	P90   float64 `json:"p90"`
+	P99   float64 `json:"p99"`
+	Max   float64 `json:"max"`
+	Mean  float64 `json:"mean"`
+}
+
+type batchOpType struct {
+	OpType string `json:"op_type"`
+	// Problems is the number of problems with at least one op of the type.
+	Problems  int     `json:"problems"`
+	Ops       int     `json:"ops"`
+	Subgraphs int     `json:"subgraphs"`
+	Latency   float64 `json:"latency"`
+	// Share is Latency over the summed latency of all op types.
+	Share float64 `json:"share"`
+}
+
+// runBatch solves many problems in parallel, writes one solution per
+// problem and a combined report.
+func runBatch(args []string) {
+	fs := flag.NewFlagSet("mlsys batch", flag.ContinueOnError)
+	outDir := fs.String("out-dir", ".", "write each solution to this `directory`, under its problem's file name")
+	reportPath := fs.String("report", "batch_report.json", "write the combined report to this `path`")
+	jobs := fs.Int("j", runtime.NumCPU(), "solve this many problems at once")
+	unknownOp := fs.String("unknown-op", "elementwise", "handling of unregistered op types: error, elementwise or opaque")
+	hwPath := fs.String("hw", "", "take the hardware description from this `path` instead of the problem")
+	dialect := fs.String("dialect", "", "read the problem's field names in a dialect: camel, or the mapping file at this `path`")
+	compat := fs.String("compat", "latest", "pin heuristic decisions to an earlier release: v1.0, v1.1, v1.2, v1.3, v1.4, v1.5, v1.6, v1.7 or latest")
+	capacityMargin := fs.Float64("capacity-margin", 1, "fill at most this fraction of fast memory, leaving the rest as headroom, within (0, 1]")
+	groupCachePath := fs.String("group-cache", "", "reuse tile choices stored at this `path` by earlier runs, and store this run's")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: ./mlsys batch [flags] <path_to_input.json>...")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() == 0 || *jobs < 1 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	var opts mlsys.Options
+	var err error
+	if opts.UnknownOps, err = mlsys.ParseUnknownOpPolicy(*unknownOp); err != nil {
+		exit(exitUsage, err.Error())
+	}
+	if opts.Compat, err = mlsys.ParseCompatLevel(*compat); err != nil {
+		exit(exitUsage, err.Error())
+	}
+	opts.CapacityMargin = parseCapacityMargin(*capacityMargin)
+	if *groupCachePath != "" {
+		if opts.GroupCache, err = loadGroupCache(*groupCachePath); err != nil {
+			fatal(err.Error())
+		}
+	}
+	if err := os.MkdirAll(*outDir, 0o755); err != nil {
+		fatal(err.Error())
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	paths := fs.Args()
+	seen := make(map[string]string)
+	for _, path := range paths {
+		name := filepath.Base(path)
+		if other, ok := seen[name]; ok {
+			exit(exitUsage, fmt.Sprintf("%s and %s would write the same solution file", other, path))
+		}
+		seen[name] = path
+	}
+	results := make([]batchResult, len(paths))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(*jobs, len(paths)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				results[i] = solveBatchProblem(ctx, paths[i], *hwPath, *dialect, *outDir, opts)
+			}
+		}()
+	}
+	for i := range paths {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	if opts.GroupCache != nil {
+		if err := saveGroupCache(*groupCachePath, opts.GroupCache); err != nil {
+			fatal(err.Error())
+		}
+	}
+	report := summarizeBatch(results)
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		fatal(fmt.Sprintf("marshal report: %v", err))
+	}
+	if err := os.WriteFile(*reportPath, append(data, '\n'), 0o644); err != nil {
+		fatal(fmt.Sprintf("write report: %v", err))
+	}
+
+	failed := 0
+	for _, r := range report.Problems {
+		if r.Status != batchOK {
+			failed++
+			fmt.Fprintf(os.Stderr, "batch: problem=%s status=%s: %s\n", r.Problem, r.Status, r.Error)
+		}
+	}
+	if l := report.Latency; l != nil {
+		fmt.Fprintf(os.Stderr, "batch: latency min=%.4f p50=%.4f p90=%.4f p99=%.4f max=%.4f mean=%.4f\n", l.Min, l.P50, l.P90, l.P99, l.Max, l.Mean)
+	}
+	fmt.Fprintf(os.Stderr, "batch: problems=%d failed=%d\n", len(paths), failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// solveBatchProblem solves one problem of a batch and writes its solution.
+// A panic is recorded rather than ending the batch.
+func solveBatchProblem(ctx context.Context, path, hwPath, dialect, outDir string, opts mlsys.Options) (r batchResult) {
+	r.Problem = path
+	start := time.Now()
+	defer func() {
+		if v := recover(); v != nil {
+			r.Status, r.Error = batchPanic, fmt.Sprint(v)
+		}
+		r.Seconds = time.Since(start).Seconds()
+	}()
+
+	problem, err := readProblemWithHardware(path, hwPath, dialect)
+	if err == nil {
+		err = mlsys.ValidateProblem(problem)
+	}
+	if err != nil {
+		r.Status, r.Error = batchInvalid, err.Error()
+		return r
+	}
+	solution, err := mlsys.Solve(ctx, problem, opts)
+	switch {
+	case err == nil:
+		r.Status = batchOK
+	case errors.Is(err, mlsys.ErrInfeasible):
+		r.Status, r.Error = batchInfeasible, err.Error()
+	case errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded):
+		r.Status, r.Error = batchTimeout, err.Error()
+	default:
+		r.Status, r.Error = batchInvalid, err.Error()
+	}
+	if len(solution.Subgraphs) == 0 {
+		return r
+	}
+	r.Solution = filepath.Join(outDir, filepath.Base(path))
+	if err := writeSolution(r.Solution, solution); err != nil {
+		r.Solution, r.Status, r.Error = "", batchInvalid, err.Error()
+		return r
+	}
+	r.Ops = len(problem.OpTypes)
+	for _, ops := range solution.Subgraphs {
+		if len(ops) > 0 {
+			r.Subgraphs++
+		}
+	}
+	r.TotalLatency = solution.TotalLatency()
+	r.opTypes = mlsys.OpTypeReport(problem, solution)
+	return r
+}
+
+// summarizeBatch aggregates the per-problem results.
+func summarizeBatch(results []batchResult) batchReport {
+	report := batchReport{Problems: results, OpTypes: []batchOpType{}}
+	var totals []float64
+	index := make(map[string]int)
+	var all float64
+	for _, r := range results {
+		if r.Status != batchOK && r.Status != batchInfeasible {
+			continue
+		}
+		totals = append(totals, r.TotalLatency)
+		for _, t := range r.opTypes {
+			j, ok := index[t.OpType]
+			if !ok {
+				j = len(report.OpTypes)
+				index[t.OpType] = j
+				report.OpTypes = append(report.OpTypes, batchOpType{OpType: t.OpType})
+			}
+			agg := &report.OpTypes[j]
+			agg.Problems++
+			agg.Ops += t.Ops
+			agg.Subgraphs += t.Subgraphs
+			agg.Latency += t.Latency
+			all += t.Latency
+		}
+	}
+	for j := range report.OpTypes {
+		if all > 0 {
+			report.OpTypes[j].Share = report.OpTypes[j].Latency / all
+		}
+	}
+	sort.SliceStable(report.OpTypes, func(a, b int) bool { return report.OpTypes[a].Latency > report.OpTypes[b].Latency })
+
+	if len(totals) > 0 {
+		sort.Float64s(totals)
+		var sum float64
+		for _, t := range totals {
+			sum += t
+		}
+		report.Latency = &latencySummary{
+			Count: len(totals),
+			Min:   totals[0],
+			P50:   percentile(totals, 50),
+			P90:   percentile(totals, 90),
+			P99:   percentile(totals, 99),
+			Max:   totals[len(totals)-1],
+			Mean:  sum / float64(len(totals)),
+		}
+	}
+	return report
+}
+
+// percentile is the nearest-rank percentile of sorted.
+func percentile(sorted []float64, pct float64) float64 {
+	rank := int(math.Ceil(pct / 100 * float64(len(sorted))))
+	return sorted[max(rank, 1)-1]
+}
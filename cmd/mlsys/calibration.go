@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"mlsys"
+)
+
+// runValidateModel compares the cost model's latencies for a schedule with
+// latencies measured on hardware.
+func runValidateModel(args []string) {
+	fs := flag.NewFlagSet("mlsys validate-model", flag.ContinueOnError)
+	measurementsPath := fs.String("measurements", "", "read measured subgraph latencies from the CSV file at this `path`: subgraph,latency rows after a header")
+	unknownOp := fs.String("unknown-op", "elementwise", "handling of unregistered op types: error, elementwise or opaque")
+	hwPath := fs.String("hw", "", "take the hardware description from this `path` instead of the problem")
+	dialect := fs.String("dialect", "", "read the problem's field names in a dialect: camel, or the mapping file at this `path`")
+	compat := fs.String("compat", "latest", "model the schedule as this release does: v1.0, v1.1, v1.2, v1.3, v1.4, v1.5, v1.6, v1.7 or latest")
+	tolerance := fs.Float64("tolerance", 0.05, "suggest calibrating parameters whose fitted scale is off by more than this fraction")
+	asJSON := fs.Bool("json", false, "print the report as a JSON object")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: ./mlsys validate-model -measurements <path.csv> [flags] <path_to_input.json> <path_to_solution.json>")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() != 2 || *measurementsPath == "" || *tolerance < 0 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	stage := "reading the problem"
+	defer recoverCrash(fs, args, fs.Arg(0), &stage, nil)
+
+	opts := parseCheckOptions(*unknownOp, *compat, 1, 0, 0)
+	problem, err := readProblemWithHardware(fs.Arg(0), *hwPath, *dialect)
+	if err != nil {
+		exit(exitInvalidProblem, err.Error())
+	}
+	if err := checkProblem(problem); err != nil {
+		exit(exitInvalidProblem, err.Error())
+	}
+	stage = "reading the schedule"
+	solution, err := readSolution(fs.Arg(1))
+	if err != nil {
+		fatal(err.Error())
+	}
+	if err := mlsys.ValidateSolution(problem, solution, opts); err != nil {
+		fatal(err.Error())
+	}
+	stage = "reading the measurements"
+	measured, err := readMeasurements(*measurementsPath)
+	if err != nil {
+		fatal(err.Error())
+	}
+	stage = "comparing"
+	r, err := mlsys.CompareMeasurements(problem, solution, opts, measured)
+	if err != nil {
+		fatal(err.Error())
+	}
+	if *asJSON {
+		data, err := json.MarshalIndent(r, "", "  ")
+		if err != nil {
+			fatal(fmt.Sprintf("marshal report: %v", err))
+		}
+		fmt.Println(string(data))
+		return
+	}
+	for _, c := range r.Comparisons {
+		fmt.Printf("validate-model: subgraph=%d op_type=%q predicted=%.4f measured=%.4f error=%+.2f%% memory_bound=%t\n",
+			c.Subgraph, c.OpType, c.Predicted, c.Measured, 100*(c.Predicted-c.Measured)/c.Measured, c.MemoryBound)
+	}
+	for _, e := range r.ByOpType {
+		fmt.Printf("validate-model: op_type=%q subgraphs=%d mape=%.2f%% bias=%+.2f%%\n", e.OpType, e.Subgraphs, 100*e.MAPE, 100*e.Bias)
+	}
+	fmt.Printf("validate-model: subgraphs=%d mape=%.2f%% bias=%+.2f%%\n", r.Subgraphs, 100*r.MAPE, 100*r.Bias)
+	for _, c := range r.Calibrations {
+		if c.Scale >= 1-*tolerance && c.Scale <= 1+*tolerance {
+			continue
+		}
+		switch c.Parameter {
+		case "slow_memory_bandwidth":
+			fmt.Printf("suggest: slow_memory_bandwidth %g -> %g (x%.4f, from %d memory-bound subgraphs)\n",
+				problem.SlowMemoryBandwidth, problem.SlowMemoryBandwidth*c.Scale, c.Scale, c.Subgraphs)
+		default:
+			fmt.Printf("suggest: scale base_costs of %q ops by %.4f (from %d compute-bound subgraphs)\n", c.OpType, c.Scale, c.Subgraphs)
+		}
+	}
+}
+
+// readMeasurements reads a CSV file of subgraph,latency rows after a
+// header row.
+func readMeasurements(path string) (map[int]float64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("read measurements: %w", err)
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = 2
+	r.TrimLeadingSpace = true
+	if _, err := r.Read(); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("measurements %s: empty file", path)
+		}
+		return nil, fmt.Errorf("measurements %s: %w", path, err)
+	}
+	measured := make(map[int]float64)
+	for {
+		rec, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return measured, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("measurements %s: %w", path, err)
+		}
+		line, _ := r.FieldPos(0)
+		i, err := strconv.Atoi(strings.TrimSpace(rec[0]))
+		if err != nil {
+			return nil, fmt.Errorf("measurements %s:%d: subgraph: %w", path, line, err)
+		}
+		lat, err := strconv.ParseFloat(strings.TrimSpace(rec[1]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("measurements %s:%d: latency: %w", path, line, err)
+		}
+		if _, dup := measured[i]; dup {
+			return nil, fmt.Errorf("measurements %s:%d: subgraph %d measured twice", path, line, i)
+		}
+		measured[i] = lat
+	}
+}
@@ -0,0 +1,443 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"mlsys"
+	"mlsys/validate"
+)
+
+// command is one subcommand of the binary. Its flags and their help text
+// are those run defines; -h prints them.
+type command struct {
+	name    string
+	summary string
+	run     func(args []string)
+}
+
+// commands lists the subcommands in the order help shows them. It is set in
+// init because some commands dispatch back through run.
+var commands []command
+
+func init() {
+	commands = []command{
+		{"solve", "schedule a problem (the default when no command is named)", runSolve},
+		{"validate", "check a problem, and optionally a schedule for it, and list every finding", runValidate},
+		{"verify", "check that a schedule was solved for a problem and options", runVerify},
+		{"validate-model", "compare the cost model's latencies with measured ones", runValidateModel},
+		{"stats", "describe a problem's graph and how memory-bound its ops are", runStats},
+		{"requirements", "find the least fast memory and bandwidth that meet a target latency", runRequirements},
+		{"score", "re-derive the latency of a schedule with the cost model", runScore},
+		{"check-exec", "replay a schedule through the reference interpreter", runCheckExec},
+		{"viz", "write the modeled timeline of a schedule as a Perfetto trace", runViz},
+		{"bench", "time repeated solves of problems", runBench},
+		{"batch", "solve many problems in parallel with a combined report", runBatch},
+		{"canonicalize", "rewrite a schedule in canonical form", runCanonicalize},
+		{"minimize", "shrink a problem while the solver still fails on it", runMinimize},
+		{"tile", "choose tiles for a given partition", runTile},
+		{"retain", "choose tensors to keep in fast memory between subgraphs", runRetain},
+		{"cost", "print the cost model's view of one group of ops", runCost},
+		{"recommend", "rank the tiles of one group of ops", runRecommend},
+		{"config", "print the effective configuration of a command", runConfig},
+		{"help", "describe the commands, or one command's flags", runHelp},
+	}
+}
+
+// run runs the subcommand args[0] names with the rest of args, and reports
+// false when it names none.
+func run(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	for _, c := range commands {
+		if c.name == args[0] {
+			c.run(args[1:])
+			return true
+		}
+	}
+	return false
+}
+
+// printCommands lists the commands and their summaries.
+func printCommands() {
+	width := 0
+	for _, c := range commands {
+		width = max(width, len(c.name))
+	}
+	fmt.Fprintln(os.Stderr, "commands:")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-*s %s\n", width, c.name, c.summary)
+	}
+	fmt.Fprintln(os.Stderr, "Run ./mlsys help <command> for the flags of one.")
+}
+
+// runHelp lists the commands, or prints the usage of the one named.
+func runHelp(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: ./mlsys <command> [flags] [args]")
+		fmt.Fprintln(os.Stderr, "       ./mlsys [flags] <path_to_input.json> <path_to_output.json>  (solve)")
+		printCommands()
+		return
+	}
+	if args[0] == "help" || !run([]string{args[0], "-h"}) {
+		exit(exitUsage, fmt.Sprintf("unknown command %q", args[0]))
+	}
+}
+
+// runValidate prints every finding of the problem checks and, given a
+// schedule, of the schedule checks.
+func runValidate(args []string) {
+	fs := flag.NewFlagSet("mlsys validate", flag.ContinueOnError)
+	unknownOp := fs.String("unknown-op", "elementwise", "handling of unregistered op types: error, elementwise or opaque")
+	hwPath := fs.String("hw", "", "take the hardware description from this `path` instead of the problem")
+	dialect := fs.String("dialect", "", "read the problem's field names in a dialect: camel, or the mapping file at this `path`")
+	maxSubgraphs := fs.Int("max-subgraphs", 0, "require at most this many subgraphs, barriers aside (0: no limit)")
+	minOps := fs.Int("min-ops-per-subgraph", 0, "require at least this many ops in every subgraph (0: no limit)")
+	compat := fs.String("compat", "latest", "check fast-memory footprints as this release models them: v1.0, v1.1, v1.2, v1.3, v1.4, v1.5, v1.6, v1.7 or latest")
+	capacityMargin := fs.Float64("capacity-margin", 1, "require every subgraph to fit in this fraction of fast memory, within (0, 1]")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: ./mlsys validate [flags] <path_to_input.json> [<path_to_solution.json>]")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() != 1 && fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	stage := "reading the problem"
+	defer recoverCrash(fs, args, fs.Arg(0), &stage, nil)
+
+	opts := parseCheckOptions(*unknownOp, *compat, *capacityMargin, *maxSubgraphs, *minOps)
+	problem, err := readProblemWithHardware(fs.Arg(0), *hwPath, *dialect)
+	if err != nil {
+		exit(exitInvalidProblem, err.Error())
+	}
+	stage = "validating the problem"
+	report := validate.Problem(problem, validate.ProblemChecks())
+	printFindings(report)
+	if report.Err() != nil {
+		os.Exit(exitInvalidProblem)
+	}
+	if fs.NArg() == 2 {
+		stage = "validating the schedule"
+		solution, err := readSolution(fs.Arg(1))
+		if err != nil {
+			fatal(err.Error())
+		}
+		sreport := validate.Solution(problem, solution, opts, validate.SolutionChecks())
+		printFindings(sreport)
+		if sreport.Err() != nil {
+			os.Exit(1)
+		}
+		report = append(report, sreport...)
+	}
+	fmt.Fprintf(os.Stderr, "validate: ok warnings=%d\n", len(report.Warnings()))
+}
+
+// runVerify checks the integrity hashes of a schedule solved with
+// -emit-integrity against a problem and the options given.
+func runVerify(args []string) {
+	fs := flag.NewFlagSet("mlsys verify", flag.ContinueOnError)
+	unknownOp := fs.String("unknown-op", "elementwise", "handling of unregistered op types the schedule was solved with: error, elementwise or opaque")
+	hwPath := fs.String("hw", "", "take the hardware description from this `path` instead of the problem")
+	dialect := fs.String("dialect", "", "read the problem's field names in a dialect: camel, or the mapping file at this `path`")
+	compat := fs.String("compat", "latest", "the release the schedule was solved as: v1.0, v1.1, v1.2, v1.3, v1.4, v1.5, v1.6, v1.7 or latest")
+	maxSubgraphs := fs.Int("max-subgraphs", 0, "the subgraph cap the schedule was solved with (0: no limit)")
+	minOps := fs.Int("min-ops-per-subgraph", 0, "the fewest ops per subgraph the schedule was solved with (0: no limit)")
+	capacityMargin := fs.Float64("capacity-margin", 1, "the capacity margin the schedule was solved with, within (0, 1]")
+	dedupeBlocks := fs.Bool("dedupe-blocks", false, "the schedule was solved with -dedupe-blocks")
+	targetLatency := fs.Float64("target-latency", 0, "the target latency the schedule was solved with (0: none)")
+	stabilizeAgainst := fs.String("stabilize-against", "", "the schedule was solved against the previous schedule at this `path`")
+	maxChanges := fs.Int("max-changes", 0, "the -max-changes the schedule was solved with")
+	templatesPath := fs.String("templates", "", "the schedule was solved with the templates of the file at this `path`, or \"builtin\"")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: ./mlsys verify [flags] <path_to_input.json> <path_to_solution.json>")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	stage := "reading the problem"
+	defer recoverCrash(fs, args, fs.Arg(0), &stage, nil)
+
+	opts := parseCheckOptions(*unknownOp, *compat, *capacityMargin, *maxSubgraphs, *minOps)
+	opts.DedupeBlocks = *dedupeBlocks
+	opts.TargetLatency = *targetLatency
+	if *templatesPath != "" {
+		var err error
+		if opts.Templates, err = readTemplates(*templatesPath); err != nil {
+			exit(exitUsage, err.Error())
+		}
+	}
+	if *stabilizeAgainst != "" {
+		prev, err := readSolution(*stabilizeAgainst)
+		if err != nil {
+			exit(exitUsage, err.Error())
+		}
+		opts.Stabilize, opts.MaxChanges = &prev, *maxChanges
+	}
+	problem, err := readProblemWithHardware(fs.Arg(0), *hwPath, *dialect)
+	if err != nil {
+		exit(exitInvalidProblem, err.Error())
+	}
+	stage = "reading the schedule"
+	solution, err := readSolution(fs.Arg(1))
+	if err != nil {
+		fatal(err.Error())
+	}
+	stage = "verifying"
+	if err := mlsys.VerifyIntegrity(problem, solution, opts); err != nil {
+		fatal(err.Error())
+	}
+	fmt.Fprintf(os.Stderr, "verify: ok problem_sha256=%s options_sha256=%s\n", solution.Integrity.ProblemSHA256, solution.Integrity.OptionsSHA256)
+}
+
+func printFindings(r validate.Report) {
+	for _, f := range r {
+		fmt.Println(f)
+	}
+}
+
+// parseCheckOptions turns the flags commands that check a schedule share
+// into Options, exiting with exitUsage on a bad value.
+func parseCheckOptions(unknownOp, compat string, capacityMargin float64, maxSubgraphs, minOps int) mlsys.Options {
+	var opts mlsys.Options
+	var err error
+	if opts.UnknownOps, err = mlsys.ParseUnknownOpPolicy(unknownOp); err != nil {
+		exit(exitUsage, err.Error())
+	}
+	if opts.Compat, err = mlsys.ParseCompatLevel(compat); err != nil {
+		exit(exitUsage, err.Error())
+	}
+	opts.MaxSubgraphs, opts.MinOpsPerSubgraph = maxSubgraphs, minOps
+	opts.CapacityMargin = parseCapacityMargin(capacityMargin)
+	return opts
+}
+
+// scoreReport is what score prints with -json.
+type scoreReport struct {
+	// TotalLatency is the cost model's latency of the schedule and
+	// StatedLatency the sum the schedule itself gives.
+	TotalLatency      float64   `json:"total_latency"`
+	StatedLatency     float64   `json:"stated_latency"`
+	SubgraphLatencies []float64 `json:"subgraph_latencies"`
+	Subgraphs         int       `json:"subgraphs"`
+	TrafficBytes      int64     `json:"traffic_bytes"`
+}
+
+// runScore re-derives the latency of a schedule, which need not come from
+// this solver, under the cost model.
+func runScore(args []string) {
+	fs := flag.NewFlagSet("mlsys score", flag.ContinueOnError)
+	unknownOp := fs.String("unknown-op", "elementwise", "handling of unregistered op types: error, elementwise or opaque")
+	hwPath := fs.String("hw", "", "take the hardware description from this `path` instead of the problem")
+	dialect := fs.String("dialect", "", "read the problem's field names in a dialect: camel, or the mapping file at this `path`")
+	compat := fs.String("compat", "latest", "check fast-memory footprints as this release models them: v1.0, v1.1, v1.2, v1.3, v1.4, v1.5, v1.6, v1.7 or latest")
+	asJSON := fs.Bool("json", false, "print the score as a JSON object")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: ./mlsys score [flags] <path_to_input.json> <path_to_solution.json>")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	stage := "reading the problem"
+	defer recoverCrash(fs, args, fs.Arg(0), &stage, nil)
+
+	opts := parseCheckOptions(*unknownOp, *compat, 1, 0, 0)
+	problem, err := readProblemWithHardware(fs.Arg(0), *hwPath, *dialect)
+	if err != nil {
+		exit(exitInvalidProblem, err.Error())
+	}
+	if err := checkProblem(problem); err != nil {
+		exit(exitInvalidProblem, err.Error())
+	}
+	stage = "scoring the schedule"
+	solution, err := readSolution(fs.Arg(1))
+	if err != nil {
+		fatal(err.Error())
+	}
+	if err := mlsys.ValidateSolution(problem, solution, opts); err != nil {
+		fatal(err.Error())
+	}
+	stats, err := mlsys.AnalyzeSolution(problem, solution, opts)
+	if err != nil {
+		fatal(err.Error())
+	}
+	r := scoreReport{StatedLatency: solution.TotalLatency(), Subgraphs: len(stats)}
+	for _, st := range stats {
+		r.SubgraphLatencies = append(r.SubgraphLatencies, st.Latency)
+	}
+	r.TotalLatency = mlsys.SumLatencies(r.SubgraphLatencies)
+	if r.TrafficBytes, err = solution.TrafficBytes(problem, opts); err != nil {
+		fatal(err.Error())
+	}
+	if *asJSON {
+		data, err := json.MarshalIndent(r, "", "  ")
+		if err != nil {
+			fatal(fmt.Sprintf("marshal score: %v", err))
+		}
+		fmt.Println(string(data))
+		return
+	}
+	for i, lat := range r.SubgraphLatencies {
+		fmt.Printf("score: subgraph=%d latency=%.4f\n", i, lat)
+	}
+	fmt.Printf("score: total_latency=%.4f stated_latency=%.4f subgraphs=%d traffic_bytes=%d\n",
+		r.TotalLatency, r.StatedLatency, r.Subgraphs, r.TrafficBytes)
+}
+
+// runStats prints the statistics of a problem, down to the ops that lean
+// most on memory bandwidth.
+func runStats(args []string) {
+	fs := flag.NewFlagSet("mlsys stats", flag.ContinueOnError)
+	unknownOp := fs.String("unknown-op", "elementwise", "handling of unregistered op types: error, elementwise or opaque")
+	hwPath := fs.String("hw", "", "take the hardware description from this `path` instead of the problem")
+	dialect := fs.String("dialect", "", "read the problem's field names in a dialect: camel, or the mapping file at this `path`")
+	compat := fs.String("compat", "latest", "model the ops as this release does: v1.0, v1.1, v1.2, v1.3, v1.4, v1.5, v1.6, v1.7 or latest")
+	capacityMargin := fs.Float64("capacity-margin", 1, "fill at most this fraction of fast memory, leaving the rest as headroom, within (0, 1]")
+	top := fs.Int("n", 10, "list this many ops of lowest arithmetic intensity (0: all)")
+	asJSON := fs.Bool("json", false, "print the statistics of every op as a JSON object")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: ./mlsys stats [flags] <path_to_input.json>")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() != 1 || *top < 0 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	stage := "reading the problem"
+	defer recoverCrash(fs, args, fs.Arg(0), &stage, nil)
+
+	opts := parseCheckOptions(*unknownOp, *compat, *capacityMargin, 0, 0)
+	problem, err := readProblemWithHardware(fs.Arg(0), *hwPath, *dialect)
+	if err != nil {
+		exit(exitInvalidProblem, err.Error())
+	}
+	if err := checkProblem(problem); err != nil {
+		exit(exitInvalidProblem, err.Error())
+	}
+	stage = "computing statistics"
+	st, err := mlsys.ProblemStatistics(problem, opts)
+	if err != nil {
+		exit(exitInvalidProblem, err.Error())
+	}
+	if *asJSON {
+		data, err := json.MarshalIndent(st, "", "  ")
+		if err != nil {
+			fatal(fmt.Sprintf("marshal stats: %v", err))
+		}
+		fmt.Println(string(data))
+		return
+	}
+	printSummary(os.Stdout, "stats", st.ProblemSummary)
+	for _, b := range st.FanOut {
+		fmt.Printf("stats: consumers=%d tensors=%d\n", b.Consumers, b.Tensors)
+	}
+	fmt.Printf("stats: max_fan_out=%d mean_fan_out=%.3f\n", st.MaxFanOut, st.MeanFanOut)
+	fmt.Printf("stats: memory_bound_ops=%d memory_bound_fraction=%.4f\n", st.MemoryBoundOps, st.MemoryBoundFraction)
+	ops := append([]mlsys.OpStats{}, st.OpStats...)
+	sort.SliceStable(ops, func(i, j int) bool { return ops[i].Intensity < ops[j].Intensity })
+	if *top > 0 && *top < len(ops) {
+		ops = ops[:*top]
+	}
+	for _, o := range ops {
+		fmt.Printf("stats: op=%d type=%q intensity=%.6g compute=%.4f memory=%.4f traffic=%d traffic_bytes=%d memory_bound=%t\n",
+			o.Op, o.Type, o.Intensity, o.ComputeTime, o.MemoryTime, o.Traffic, o.TrafficBytes, o.MemoryBound)
+	}
+}
+
+// runViz writes the Perfetto trace of an existing schedule.
+func runViz(args []string) {
+	fs := flag.NewFlagSet("mlsys viz", flag.ContinueOnError)
+	unknownOp := fs.String("unknown-op", "elementwise", "handling of unregistered op types: error, elementwise or opaque")
+	hwPath := fs.String("hw", "", "take the hardware description from this `path` instead of the problem")
+	dialect := fs.String("dialect", "", "read the problem's field names in a dialect: camel, or the mapping file at this `path`")
+	compat := fs.String("compat", "latest", "model the schedule as this release does: v1.0, v1.1, v1.2, v1.3, v1.4, v1.5, v1.6, v1.7 or latest")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: ./mlsys viz [flags] <path_to_input.json> <path_to_solution.json> <path_to_trace.pftrace>")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() != 3 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	stage := "reading the problem"
+	defer recoverCrash(fs, args, fs.Arg(0), &stage, nil)
+
+	opts := parseCheckOptions(*unknownOp, *compat, 1, 0, 0)
+	problem, err := readProblemWithHardware(fs.Arg(0), *hwPath, *dialect)
+	if err != nil {
+		exit(exitInvalidProblem, err.Error())
+	}
+	if err := checkProblem(problem); err != nil {
+		exit(exitInvalidProblem, err.Error())
+	}
+	stage = "writing the trace"
+	solution, err := readSolution(fs.Arg(1))
+	if err != nil {
+		fatal(err.Error())
+	}
+	if err := mlsys.ValidateSolution(problem, solution, opts); err != nil {
+		fatal(err.Error())
+	}
+	if err := writePerfettoTrace(fs.Arg(2), problem, solution, opts); err != nil {
+		fatal(err.Error())
+	}
+}
+
+// runBench solves every problem several times and prints the spread of
+// the wall times.
+func runBench(args []string) {
+	fs := flag.NewFlagSet("mlsys bench", flag.ContinueOnError)
+	runs := fs.Int("runs", 5, "solve each problem this many times")
+	unknownOp := fs.String("unknown-op", "elementwise", "handling of unregistered op types: error, elementwise or opaque")
+	hwPath := fs.String("hw", "", "take the hardware description from this `path` instead of the problem")
+	dialect := fs.String("dialect", "", "read the problem's field names in a dialect: camel, or the mapping file at this `path`")
+	compat := fs.String("compat", "latest", "pin heuristic decisions to an earlier release: v1.0, v1.1, v1.2, v1.3, v1.4, v1.5, v1.6, v1.7 or latest")
+	capacityMargin := fs.Float64("capacity-margin", 1, "fill at most this fraction of fast memory, leaving the rest as headroom, within (0, 1]")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: ./mlsys bench [flags] <path_to_input.json>...")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() == 0 || *runs < 1 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	opts := parseCheckOptions(*unknownOp, *compat, *capacityMargin, 0, 0)
+	for _, path := range fs.Args() {
+		problem, err := readProblemWithHardware(path, *hwPath, *dialect)
+		if err == nil {
+			err = mlsys.ValidateProblem(problem)
+		}
+		if err != nil {
+			exit(exitInvalidProblem, fmt.Sprintf("%s: %v", path, err))
+		}
+		times := make([]float64, *runs)
+		var solution mlsys.OutputSolution
+		for i := range times {
+			start := time.Now()
+			solution, err = mlsys.Solve(context.Background(), problem, opts)
+			times[i] = time.Since(start).Seconds()
+			if err != nil {
+				fatal(fmt.Sprintf("%s: %v", path, err))
+			}
+		}
+		sort.Float64s(times)
+		fmt.Printf("bench: problem=%s runs=%d min_seconds=%.6f median_seconds=%.6f max_seconds=%.6f total_latency=%.4f\n",
+			path, *runs, times[0], times[len(times)/2], times[len(times)-1], solution.TotalLatency())
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Every flag of every command can also be set in a config file or the
+// environment. Layers apply in order, later ones winning:
+//
+//  1. the flag's default;
+//  2. the config file: -config, else $MLSYS_CONFIG, else config.json in
+//     the mlsys directory of the user configuration directory if present.
+//     It is a JSON object of flag names to values. Top-level entries apply
+//     to every command that has the flag; an object under a command name
+//     ("solve", "check-exec", ...) applies to that command only and must
+//     only name its flags;
+//  3. environment variables MLSYS_<FLAG>, the flag name upper-cased with
+//     dashes as underscores, e.g. MLSYS_CAPACITY_MARGIN;
+//  4. the command line.
+
+// configEnv names the config file when -config is not given.
+const configEnv = "MLSYS_CONFIG"
+
+// showConfig makes parseFlags print the effective configuration of the
+// command and exit instead of returning; see runConfig.
+var showConfig bool
+
+// flagSource says which layer set a flag.
+type flagSource struct {
+	layer string
+	value string
+}
+
+// applyConfig fills the flags of fs not set on the command line from the
+// environment and the config file at path, or the default one when path is
+// empty, and returns where every flag's value came from.
+func applyConfig(fs *flag.FlagSet, path string) (map[string]flagSource, string, error) {
+	sources := make(map[string]flagSource)
+	fs.VisitAll(func(f *flag.Flag) {
+		sources[f.Name] = flagSource{layer: "default", value: f.DefValue}
+	})
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	if path == "" {
+		path = os.Getenv(configEnv)
+	}
+	required := path != ""
+	if path == "" {
+		if dir, err := os.UserConfigDir(); err == nil {
+			path = filepath.Join(dir, "mlsys", "config.json")
+		}
+	}
+	values, err := readConfigFile(path, commandName(fs), fs)
+	switch {
+	case errors.Is(err, os.ErrNotExist) && !required:
+		path = ""
+	case err != nil:
+		return nil, "", err
+	}
+
+	set := func(name, value, layer string) error {
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("%s: -%s: %w", layer, name, err)
+		}
+		sources[name] = flagSource{layer: layer, value: value}
+		return nil
+	}
+	var setErr error
+	fs.VisitAll(func(f *flag.Flag) {
+		if setErr != nil {
+			return
+		}
+		if explicit[f.Name] {
+			sources[f.Name] = flagSource{layer: "flag", value: f.Value.String()}
+			return
+		}
+		if v, ok := values[f.Name]; ok {
+			setErr = set(f.Name, v, "config "+path)
+		}
+		env := "MLSYS_" + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		if v, ok := os.LookupEnv(env); ok && setErr == nil {
+			setErr = set(f.Name, v, "env "+env)
+		}
+	})
+	return sources, path, setErr
+}
+
+// readConfigFile returns the values the config file at path gives the flags
+// of fs, the flags of command.
+func readConfigFile(path, command string, fs *flag.FlagSet) (map[string]string, error) {
+	if path == "" {
+		return nil, os.ErrNotExist
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file map[string]json.RawMessage
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("config %s: %w", path, err)
+	}
+	values := make(map[string]string)
+	var section map[string]json.RawMessage
+	for key, raw := range file {
+		if key == command {
+			if err := json.Unmarshal(raw, &section); err != nil {
+				return nil, fmt.Errorf("config %s: %s: %w", path, key, err)
+			}
+			continue
+		}
+		if fs.Lookup(key) == nil {
+			continue // another command's flag or section
+		}
+		if values[key], err = configValue(raw); err != nil {
+			return nil, fmt.Errorf("config %s: %s: %w", path, key, err)
+		}
+	}
+	for key, raw := range section {
+		if fs.Lookup(key) == nil {
+			return nil, fmt.Errorf("config %s: %s: command has no flag -%s", path, command, key)
+		}
+		if values[key], err = configValue(raw); err != nil {
+			return nil, fmt.Errorf("config %s: %s.%s: %w", path, command, key, err)
+		}
+	}
+	return values, nil
+}
+
+// configValue turns a JSON string, number or boolean into flag syntax.
+func configValue(raw json.RawMessage) (string, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return "", err
+	}
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return fmt.Sprint(v), nil
+	}
+	return "", errors.New("value must be a string, number or boolean")
+}
+
+// commandName is the name config sections use for the command of fs.
+func commandName(fs *flag.FlagSet) string {
+	if name := strings.TrimPrefix(fs.Name(), "mlsys "); name != fs.Name() {
+		return name
+	}
+	return "solve"
+}
+
+// printConfig prints every flag of fs with its effective value and the
+// layer that set it.
+func printConfig(fs *flag.FlagSet, sources map[string]flagSource, path string) {
+	if path == "" {
+		path = "(none)"
+	}
+	fmt.Printf("# command %s, config file %s\n", commandName(fs), path)
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		src := sources[name]
+		fmt.Printf("%s=%q  # %s\n", name, src.value, src.layer)
+	}
+}
+
+// runConfig implements "mlsys config show [command] [flags]": it parses the
+// command's flags as the command would and prints the result.
+func runConfig(args []string) {
+	if len(args) == 0 || args[0] != "show" {
+		fmt.Fprintln(os.Stderr, "usage: ./mlsys config show [command] [flags]")
+		os.Exit(exitUsage)
+	}
+	args = args[1:]
+	showConfig = true
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		args = append([]string{"solve"}, args...)
+	}
+	if args[0] == "config" || !run(args) {
+		exit(exitUsage, fmt.Sprintf("unknown command %q", args[0]))
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"mlsys"
+)
+
+// runCost prints the cost model's view of one group of ops at one tile.
+func runCost(args []string) {
+	fs := flag.NewFlagSet("mlsys cost", flag.ContinueOnError)
+	problemPath := fs.String("problem", "", "the problem `path`")
+	opList := fs.String("ops", "", "comma-separated op `indices` of the group, e.g. 4,5,6")
+	tile := fs.String("tile", "", "granularity `WxHxK` or WxH (K=1); the solver's choice when empty")
+	unknownOp := fs.String("unknown-op", "elementwise", "handling of unregistered op types: error, elementwise or opaque")
+	hwPath := fs.String("hw", "", "take the hardware description from this `path` instead of the problem")
+	dialect := fs.String("dialect", "", "read the problem's field names in a dialect: camel, or the mapping file at this `path`")
+	compat := fs.String("compat", "latest", "cost the group as this release models it: v1.0, v1.1, v1.2, v1.3, v1.4, v1.5, v1.6, v1.7 or latest")
+	capacityMargin := fs.Float64("capacity-margin", 1, "fit against this fraction of fast memory, within (0, 1]")
+	asJSON := fs.Bool("json", false, "print the breakdown as a JSON object")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: ./mlsys cost -problem <path_to_input.json> -ops <i,j,...> [-tile WxHxK] [flags]")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if *problemPath == "" || *opList == "" || fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	stage := "parsing options"
+	defer recoverCrash(fs, args, *problemPath, &stage, nil)
+
+	var opts mlsys.Options
+	var err error
+	if opts.UnknownOps, err = mlsys.ParseUnknownOpPolicy(*unknownOp); err != nil {
+		exit(exitUsage, err.Error())
+	}
+	if opts.Compat, err = mlsys.ParseCompatLevel(*compat); err != nil {
+		exit(exitUsage, err.Error())
+	}
+	opts.CapacityMargin = parseCapacityMargin(*capacityMargin)
+	ops, err := parseOpList(*opList)
+	if err != nil {
+		exit(exitUsage, err.Error())
+	}
+	var g [3]int64
+	if *tile != "" {
+		if g, err = parseTile(*tile); err != nil {
+			exit(exitUsage, err.Error())
+		}
+	}
+
+	stage = "reading the problem"
+	problem, err := readProblemWithHardware(*problemPath, *hwPath, *dialect)
+	if err != nil {
+		exit(exitInvalidProblem, err.Error())
+	}
+	if err := checkProblem(problem); err != nil {
+		exit(exitInvalidProblem, err.Error())
+	}
+	stage = "costing the group"
+	c, err := mlsys.CostGroup(problem, ops, g, opts)
+	if err != nil {
+		exit(exitUsage, err.Error())
+	}
+	if *asJSON {
+		data, err := json.MarshalIndent(c, "", "  ")
+		if err != nil {
+			fatal(fmt.Sprintf("marshal cost: %v", err))
+		}
+		fmt.Println(string(data))
+		return
+	}
+	g = c.Granularity
+	fmt.Printf("cost: ops=%s tile=%dx%dx%d chosen=%t\n", joinInts(c.Ops), g[0], g[1], g[2], c.Chosen)
+	fmt.Printf("cost: inputs=%s outputs=%s fusable=%t standalone=%t\n", joinInts(c.Inputs), joinInts(c.Outputs), c.Fusable, c.Standalone)
+	fmt.Printf("cost: working_set=%d footprint=%d buffer_depth=%d fits=%t\n", c.WorkingSet, c.Footprint, c.BufferDepth, c.Fits)
+	fmt.Printf("cost: steps=%d compute_per_step=%.4f memory_per_step=%.4f\n", c.Steps, c.ComputePerStep, c.MemoryPerStep)
+	fmt.Printf("cost: compute_time=%.4f memory_time=%.4f traffic=%d traffic_bytes=%d\n", c.ComputeTime, c.MemoryTime, c.Traffic, c.TrafficBytes)
+	fmt.Printf("cost: latency=%.4f\n", c.Latency)
+}
+
+// runRecommend ranks the tiles of one group of ops by modeled latency.
+func runRecommend(args []string) {
+	fs := flag.NewFlagSet("mlsys recommend", flag.ContinueOnError)
+	problemPath := fs.String("problem", "", "the problem `path`, for the graph and hardware description")
+	opList := fs.String("ops", "", "comma-separated op `indices` of the op or fixed group, e.g. 4 or 4,5,6")
+	top := fs.Int("n", 10, "print at most this many tiles (0: all)")
+	unknownOp := fs.String("unknown-op", "elementwise", "handling of unregistered op types: error, elementwise or opaque")
+	hwPath := fs.String("hw", "", "take the hardware description from this `path` instead of the problem")
+	dialect := fs.String("dialect", "", "read the problem's field names in a dialect: camel, or the mapping file at this `path`")
+	compat := fs.String("compat", "latest", "model the group as this release does: v1.0, v1.1, v1.2, v1.3, v1.4, v1.5, v1.6, v1.7 or latest")
+	capacityMargin := fs.Float64("capacity-margin", 1, "fit against this fraction of fast memory, within (0, 1]")
+	asJSON := fs.Bool("json", false, "print the ranking as a JSON array")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: ./mlsys recommend -problem <path_to_input.json> -ops <i,j,...> [flags]")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if *problemPath == "" || *opList == "" || *top < 0 || fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	stage := "parsing options"
+	defer recoverCrash(fs, args, *problemPath, &stage, nil)
+
+	var opts mlsys.Options
+	var err error
+	if opts.UnknownOps, err = mlsys.ParseUnknownOpPolicy(*unknownOp); err != nil {
+		exit(exitUsage, err.Error())
+	}
+	if opts.Compat, err = mlsys.ParseCompatLevel(*compat); err != nil {
+		exit(exitUsage, err.Error())
+	}
+	opts.CapacityMargin = parseCapacityMargin(*capacityMargin)
+	ops, err := parseOpList(*opList)
+	if err != nil {
+		exit(exitUsage, err.Error())
+	}
+
+	stage = "reading the problem"
+	problem, err := readProblemWithHardware(*problemPath, *hwPath, *dialect)
+	if err != nil {
+		exit(exitInvalidProblem, err.Error())
+	}
+	if err := checkProblem(problem); err != nil {
+		exit(exitInvalidProblem, err.Error())
+	}
+	stage = "ranking tiles"
+	ranked, err := mlsys.RecommendTiles(problem, ops, opts)
+	if errors.Is(err, mlsys.ErrInfeasible) {
+		exit(exitInfeasible, err.Error())
+	}
+	if err != nil {
+		exit(exitUsage, err.Error())
+	}
+	if *top > 0 && len(ranked) > *top {
+		ranked = ranked[:*top]
+	}
+	if *asJSON {
+		data, err := json.MarshalIndent(ranked, "", "  ")
+		if err != nil {
+			fatal(fmt.Sprintf("marshal ranking: %v", err))
+		}
+		fmt.Println(string(data))
+		return
+	}
+	for i, c := range ranked {
+		g := c.Granularity
+		fmt.Printf("recommend: rank=%d tile=%dx%dx%d latency=%.4f objective=%.4f footprint=%d\n", i+1, g[0], g[1], g[2], c.Latency, c.Objective, c.Footprint)
+	}
+}
+
+// parseOpList parses comma-separated op indices.
+func parseOpList(s string) ([]int, error) {
+	var ops []int
+	for _, f := range strings.Split(s, ",") {
+		op, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil {
+			return nil, fmt.Errorf("bad op index %q", f)
+		}
+		ops = append(ops, op)
+	}
+	return ops, nil
+}
+
+// parseTile parses a granularity written WxHxK, or WxH with K = 1.
+func parseTile(s string) ([3]int64, error) {
+	parts := strings.Split(strings.ToLower(s), "x")
+	if len(parts) == 2 {
+		parts = append(parts, "1")
+	}
+	var g [3]int64
+	if len(parts) != 3 {
+		return g, fmt.Errorf("bad tile %q (want WxHxK or WxH)", s)
+	}
+	for i, part := range parts {
+		v, err := strconv.ParseInt(strings.TrimSpace(part), 10, 64)
+		if err != nil || v <= 0 {
+			return g, fmt.Errorf("bad tile %q (want positive WxHxK or WxH)", s)
+		}
+		g[i] = v
+	}
+	return g, nil
+}
+
+func joinInts(xs []int) string {
+	parts := make([]string, len(xs))
+	for i, x := range xs {
+		parts[i] = strconv.Itoa(x)
+	}
+	return strings.Join(parts, ",")
+}
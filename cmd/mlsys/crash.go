@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+
+	"mlsys"
+)
+
+// exitInternal is the exit status after a panic.
+const exitInternal = 4
+
+// crashReport is the crash.json of a diagnostic bundle. Options holds the
+// effective value of every flag, whichever layer set it, and Solve how far
+// the solver had got, when the command was solving.
+type crashReport struct {
+	Panic         string               `json:"panic"`
+	Stage         string               `json:"stage"`
+	Args          []string             `json:"args"`
+	Options       map[string]string    `json:"options"`
+	Solve         *mlsys.SolveProgress `json:"solve,omitempty"`
+	ProblemPath   string               `json:"problem_path"`
+	ProblemSHA256 string               `json:"problem_sha256,omitempty"`
+	GoVersion     string               `json:"go_version"`
+	Stack         string               `json:"stack"`
+}
+
+// recoverCrash turns a panic into a diagnostic bundle and exit status
+// exitInternal. It must be deferred directly. stage says what the command
+// was doing and progress, if not nil, is the Options.Progress of its
+// solves; both are read when the panic happens, as are the flags of fs.
+func recoverCrash(fs *flag.FlagSet, args []string, problemPath string, stage *string, progress *mlsys.SolveProgress) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	report := crashReport{
+		Panic:       fmt.Sprint(r),
+		Stage:       *stage,
+		Args:        append([]string{commandName(fs)}, args...),
+		Options:     make(map[string]string),
+		ProblemPath: problemPath,
+		GoVersion:   runtime.Version(),
+		Stack:       string(debug.Stack()),
+	}
+	fs.VisitAll(func(f *flag.Flag) { report.Options[f.Name] = f.Value.String() })
+	if progress != nil && progress.Stage != "" {
+		report.Solve = progress
+	}
+	dir, err := writeCrashBundle(report)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "internal error: %s (while %s)\n%s", report.Panic, report.Stage, report.Stack)
+		fmt.Fprintf(os.Stderr, "could not write a diagnostic bundle: %v\n", err)
+		os.Exit(exitInternal)
+	}
+	fmt.Fprintf(os.Stderr, "internal error: %s (while %s)\n", report.Panic, report.Stage)
+	fmt.Fprintf(os.Stderr, "a diagnostic bundle was written to %s; please attach it to a bug report\n", dir)
+	os.Exit(exitInternal)
+}
+
+// writeCrashBundle writes crash.json and, when it can be read, a copy of
+// the problem to a new temporary directory.
+func writeCrashBundle(report crashReport) (string, error) {
+	dir, err := os.MkdirTemp("", "mlsys-crash-")
+	if err != nil {
+		return "", err
+	}
+	if report.ProblemPath != "" {
+		if data, err := os.ReadFile(report.ProblemPath); err == nil {
+			sum := sha256.Sum256(data)
+			report.ProblemSHA256 = hex.EncodeToString(sum[:])
+			if err := os.WriteFile(filepath.Join(dir, "problem.json"), data, 0o644); err != nil {
+				return "", err
+			}
+		}
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "crash.json"), append(data, '\n'), 0o644); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
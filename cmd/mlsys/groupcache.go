@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"mlsys"
+)
+
+// loadGroupCache reads the cache at path, or starts an empty one if there
+// is no file yet.
+func loadGroupCache(path string) (*mlsys.GroupCache, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return mlsys.NewGroupCache(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return mlsys.ReadGroupCache(f)
+}
+
+// saveGroupCache writes the cache to path through a temporary file, so
+// that concurrent runs sharing the cache never read a partial one.
+func saveGroupCache(path string, c *mlsys.GroupCache) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("write group cache: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write group cache: %w", err)
+	}
+	if err := c.Write(tmp); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write group cache: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write group cache: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("write group cache: %w", err)
+	}
+	return nil
+}
@@ -1,211 +1,613 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/csv"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"math"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"mlsys"
+	"mlsys/validate"
+)
+
+// Exit statuses, so wrapper scripts can tell failures apart.
+const (
+	exitUsage          = 1 // bad flags or arguments
+	exitInvalidProblem = 2 // the problem cannot be read or fails validation
+	exitInfeasible     = 3 // some op fits in fast memory at no tile
+	// exitInternal (4), a panic, is in crash.go.
+	exitTimeout = 5 // interrupted; a partial schedule was written
 )
 
-type InputProblem struct {
-	Widths              []int64   `json:"widths"`
-	Heights             []int64   `json:"heights"`
-	Inputs              [][]int   `json:"inputs"`
-	Outputs             [][]int   `json:"outputs"`
-	BaseCosts           []float64 `json:"base_costs"`
-	OpTypes             []string  `json:"op_types"`
-	FastMemoryCapacity  float64   `json:"fast_memory_capacity"`
-	SlowMemoryBandwidth float64   `json:"slow_memory_bandwidth"`
-	NativeGranularity   [2]int64  `json:"native_granularity"`
+func main() {
+	if !run(os.Args[1:]) {
+		runSolve(os.Args[1:])
+	}
 }
 
-type OutputSolution struct {
-	Subgraphs         [][]int    `json:"subgraphs"`
-	Granularities     [][3]int64 `json:"granularities"`
-	TensorsToRetain   [][]int    `json:"tensors_to_retain"`
-	TraversalOrders   []*[]int64 `json:"traversal_orders"`
-	SubgraphLatencies []float64  `json:"subgraph_latencies"`
+func runSolve(args []string) {
+	fs := flag.NewFlagSet("mlsys", flag.ContinueOnError)
+	unknownOp := fs.String("unknown-op", "elementwise", "handling of unregistered op types: error, elementwise or opaque")
+	hwPath := fs.String("hw", "", "take the hardware description from this `path` instead of the problem")
+	dialect := fs.String("dialect", "", "read the problem's field names in a dialect: camel, or the mapping file at this `path`")
+	compat := fs.String("compat", "latest", "pin heuristic decisions to an earlier release: v1.0, v1.1, v1.2, v1.3, v1.4, v1.5, v1.6, v1.7 or latest")
+	emitDeps := fs.Bool("emit-deps", false, "add the subgraph dependency edge list to the solution")
+	emitOrders := fs.Bool("emit-op-orders", false, "add an op order per subgraph that minimizes the live intermediate tensors")
+	emitDMA := fs.Bool("dma-stats", false, "add the DMA descriptor counts and sizes of every subgraph to the solution")
+	templatesPath := fs.String("templates", "", "seed the search with the built-in schedule templates and those of the template file at this `path`; \"builtin\" for the built-in ones only")
+	stabilizeAgainst := fs.String("stabilize-against", "", "keep to the previous schedule at this `path`, changing as few subgraphs as -max-changes allows")
+	maxChanges := fs.Int("max-changes", 0, "with -stabilize-against, change at most this many subgraphs, where the fewest possible allow")
+	targetLatency := fs.Float64("target-latency", 0, "stop searching as soon as the schedule's total latency is at most this (0: search in full)")
+	dedupeBlocks := fs.Bool("dedupe-blocks", false, "schedule a run of repeated blocks of ops once and copy the schedule to every block")
+	emitIntegrity := fs.Bool("emit-integrity", false, "add SHA-256 hashes of the problem and options for verify to check")
+	kernelNames := fs.String("kernel-names", "", "name the kernel of every subgraph by this `format`, in which {hash} is a fingerprint of the kernel, {ops} its op types and {tile} its granularity (e.g. "+mlsys.DefaultKernelNameFormat+")")
+	warningsAsJSON := fs.Bool("warnings-as-json", false, "print the schedule's warnings to stderr, one JSON object per line, instead of adding them to the solution")
+	ablate := fs.String("ablate", "", "after solving, switch off each of these cost-model `features` in turn (overlap, reuse, overhead) and report how the schedule and its latency change")
+	samples := fs.Int("sample", 0, "after solving, draw this many random valid schedules and report their latency distribution next to the solver's (0: none)")
+	sampleSeed := fs.Uint64("sample-seed", 1, "with -sample, seed the random schedules with this value")
+	policyCmd := fs.String("policy", "", "let the process run by this shell `command` make the grouping and tiling decisions, exchanging JSON states and actions over its stdin and stdout")
+	emitMeta := fs.Bool("emit-meta", false, "add a meta section describing the run: solver version, options, wall time, candidates and machine")
+	perfettoPath := fs.String("perfetto-trace", "", "also write the modeled timeline as a Perfetto protobuf trace to this `path`")
+	outputFormat := fs.String("output-format", "json", "output file format: json (the contest schema) or csv (one row per subgraph)")
+	maxSubgraphs := fs.Int("max-subgraphs", 0, "schedule in at most this many subgraphs, barriers aside (0: no limit)")
+	minOps := fs.Int("min-ops-per-subgraph", 0, "give every subgraph at least this many ops (0: no limit)")
+	capacityMargin := fs.Float64("capacity-margin", 1, "fill at most this fraction of fast memory, leaving the rest as headroom, within (0, 1]")
+	groupCachePath := fs.String("group-cache", "", "reuse tile choices stored at this `path` by earlier runs, and store this run's")
+	dryRun := fs.Bool("dry-run", false, "validate and analyze the problem and print statistics, without solving; the output path may be omitted")
+	manifestPath := fs.String("manifest", "", "merge the problem from shards named in the manifest at this `path`; the inputs are the shard files, or those it lists")
+	watch := fs.Bool("watch", false, "re-solve whenever the input files change, until interrupted")
+	watchInterval := fs.Duration("watch-interval", 500*time.Millisecond, "with -watch, check the input files this often")
+	patchAgainst := fs.String("patch-against", "", "write a JSON Merge Patch against the solution at this `path` instead of the full solution")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: ./mlsys [solve] [flags] <path_to_input.json> <path_to_output.json>")
+		fmt.Fprintln(os.Stderr, "       ./mlsys [solve] -dry-run [flags] <path_to_input.json>")
+		fmt.Fprintln(os.Stderr, "       ./mlsys [solve] -manifest <path_to_manifest.json> [flags] [<path_to_shard.json>...] <path_to_output.json>")
+		fmt.Fprintln(os.Stderr, "       ./mlsys <command> [flags] [args]")
+		printCommands()
+		fmt.Fprintln(os.Stderr, "flags of solve:")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	inPaths := fs.Args()
+	var outPath string
+	switch {
+	case *manifestPath == "":
+		if fs.NArg() != 2 && !(*dryRun && fs.NArg() == 1) {
+			fs.Usage()
+			os.Exit(exitUsage)
+		}
+		inPaths, outPath = inPaths[:1], fs.Arg(1)
+	case !*dryRun:
+		// The shards, if any, then the output.
+		if len(inPaths) == 0 {
+			fs.Usage()
+			os.Exit(exitUsage)
+		}
+		inPaths, outPath = inPaths[:len(inPaths)-1], inPaths[len(inPaths)-1]
+	}
+	inPath := *manifestPath
+	if inPath == "" {
+		inPath = inPaths[0]
+	}
+	stage := "parsing options"
+	var progress mlsys.SolveProgress
+	defer recoverCrash(fs, args, inPath, &stage, &progress)
+
+	opts := mlsys.Options{Progress: &progress}
+	var err error
+	if opts.UnknownOps, err = mlsys.ParseUnknownOpPolicy(*unknownOp); err != nil {
+		exit(exitUsage, err.Error())
+	}
+	if opts.Compat, err = mlsys.ParseCompatLevel(*compat); err != nil {
+		exit(exitUsage, err.Error())
+	}
+	opts.EmitDependencies = *emitDeps
+	opts.EmitOpOrders = *emitOrders
+	opts.EmitDMAStats = *emitDMA
+	opts.EmitMeta = *emitMeta
+	opts.EmitIntegrity = *emitIntegrity
+	opts.DedupeBlocks = *dedupeBlocks
+	var ablations []string
+	if *ablate != "" {
+		if ablations, err = mlsys.ParseAblations(*ablate); err != nil {
+			exit(exitUsage, err.Error())
+		}
+	}
+	if *samples < 0 {
+		exit(exitUsage, fmt.Sprintf("sample count must be >= 0, got %d", *samples))
+	}
+	if *kernelNames != "" {
+		if err := mlsys.CheckKernelNameFormat(*kernelNames); err != nil {
+			exit(exitUsage, err.Error())
+		}
+		opts.KernelNameFormat = *kernelNames
+	}
+	if !(*targetLatency >= 0) || math.IsInf(*targetLatency, 0) {
+		exit(exitUsage, fmt.Sprintf("target latency must be >= 0 and finite, got %g", *targetLatency))
+	}
+	opts.TargetLatency = *targetLatency
+	if *maxChanges < 0 {
+		exit(exitUsage, "-max-changes must be >= 0")
+	}
+	if *stabilizeAgainst != "" {
+		prev, err := readSolution(*stabilizeAgainst)
+		if err != nil {
+			exit(exitUsage, err.Error())
+		}
+		opts.Stabilize, opts.MaxChanges = &prev, *maxChanges
+	}
+	if *templatesPath != "" {
+		if opts.Templates, err = readTemplates(*templatesPath); err != nil {
+			exit(exitUsage, err.Error())
+		}
+	}
+	if *maxSubgraphs < 0 || *minOps < 0 {
+		exit(exitUsage, "subgraph limits must be >= 0")
+	}
+	opts.MaxSubgraphs, opts.MinOpsPerSubgraph = *maxSubgraphs, *minOps
+	opts.CapacityMargin = parseCapacityMargin(*capacityMargin)
+	if *outputFormat != "json" && *outputFormat != "csv" {
+		exit(exitUsage, fmt.Sprintf("unknown output format %q (want json or csv)", *outputFormat))
+	}
+	if *watch && (*dryRun || *watchInterval <= 0) {
+		exit(exitUsage, "-watch needs a positive -watch-interval and cannot be combined with -dry-run")
+	}
+	if *patchAgainst != "" && *outputFormat != "json" {
+		exit(exitUsage, "-patch-against needs -output-format json")
+	}
+
+	stopPolicy := func() error { return nil }
+	if *policyCmd != "" {
+		if opts.Policy, stopPolicy, err = startPolicy(*policyCmd); err != nil {
+			exit(exitUsage, err.Error())
+		}
+	}
+
+	// solveOnce reads, solves and writes the problem once. It returns the
+	// schedule and the exit status, with an error when nothing was written.
+	solveOnce := func(ctx context.Context) (mlsys.OutputSolution, int, error) {
+		stage = "reading the problem"
+		var problem mlsys.InputProblem
+		if *manifestPath != "" {
+			problem, err = readShardedProblem(*manifestPath, inPaths, *hwPath, *dialect)
+		} else {
+			problem, err = readProblemWithHardware(inPath, *hwPath, *dialect)
+		}
+		if err != nil {
+			return mlsys.OutputSolution{}, exitInvalidProblem, err
+		}
+		stage = "validating the problem"
+		if err := checkProblem(problem); err != nil {
+			return mlsys.OutputSolution{}, exitInvalidProblem, err
+		}
+		if *dryRun {
+			stage = "analyzing the problem"
+			summary, err := mlsys.SummarizeProblem(problem, opts)
+			if err != nil {
+				return mlsys.OutputSolution{}, exitInvalidProblem, err
+			}
+			printSummary(os.Stderr, "dry-run", summary)
+			return mlsys.OutputSolution{}, 0, nil
+		}
+		if *groupCachePath != "" {
+			stage = "reading the group cache"
+			if opts.GroupCache, err = loadGroupCache(*groupCachePath); err != nil {
+				return mlsys.OutputSolution{}, 1, err
+			}
+		}
+
+		stage = "solving"
+		progress = mlsys.SolveProgress{}
+		solution, solveErr := mlsys.Solve(ctx, problem, opts)
+		status := 0
+		switch {
+		case solveErr == nil:
+		case errors.Is(solveErr, mlsys.ErrInfeasible):
+			status = exitInfeasible
+			fmt.Fprintf(os.Stderr, "error: %v; writing the schedule anyway\n", solveErr)
+		case errors.Is(solveErr, context.Canceled) || errors.Is(solveErr, context.DeadlineExceeded):
+			if len(solution.Subgraphs) == 0 {
+				return mlsys.OutputSolution{}, exitTimeout, solveErr
+			}
+			status = exitTimeout
+			fmt.Fprintf(os.Stderr, "warning: %v; writing the best schedule found so far\n", solveErr)
+		case errors.Is(solveErr, mlsys.ErrPolicy):
+			return mlsys.OutputSolution{}, 1, solveErr
+		default:
+			return mlsys.OutputSolution{}, exitInvalidProblem, solveErr
+		}
+		if opts.GroupCache != nil {
+			stage = "writing the group cache"
+			hits, misses := opts.GroupCache.Stats()
+			fmt.Fprintf(os.Stderr, "group-cache: hits=%d misses=%d entries=%d\n", hits, misses, opts.GroupCache.Len())
+			if err := saveGroupCache(*groupCachePath, opts.GroupCache); err != nil {
+				return mlsys.OutputSolution{}, 1, err
+			}
+		}
+		stage = "writing the solution"
+		logSolutionLatency(solution)
+		if *warningsAsJSON {
+			for _, w := range solution.Warnings {
+				data, err := json.Marshal(w)
+				if err != nil {
+					return mlsys.OutputSolution{}, 1, err
+				}
+				fmt.Fprintln(os.Stderr, string(data))
+			}
+			solution.Warnings = nil
+		}
+		if opts.Stabilize != nil {
+			fmt.Fprintf(os.Stderr, "stability: changes=%d max_changes=%d\n", mlsys.ScheduleChanges(*opts.Stabilize, solution), opts.MaxChanges)
+		}
+		switch {
+		case *outputFormat == "csv":
+			err = writeSolutionCSV(outPath, problem, solution, opts)
+		case *patchAgainst != "":
+			err = writeSolutionPatch(outPath, *patchAgainst, solution)
+		default:
+			err = writeSolution(outPath, solution)
+		}
+		if err != nil {
+			return mlsys.OutputSolution{}, 1, err
+		}
+		if *perfettoPath != "" {
+			stage = "writing the trace"
+			if err := writePerfettoTrace(*perfettoPath, problem, solution, opts); err != nil {
+				return mlsys.OutputSolution{}, 1, err
+			}
+		}
+		if ablations != nil && status == 0 {
+			stage = "ablating"
+			report, err := mlsys.Ablate(ctx, problem, opts, solution, ablations)
+			if err != nil {
+				return mlsys.OutputSolution{}, 1, err
+			}
+			for _, a := range report {
+				fmt.Fprintf(os.Stderr, "ablate: feature=%s ablated_latency=%.4f resolved_latency=%.4f resolved_full_latency=%.4f subgraphs=%d changed_subgraphs=%d\n",
+					a.Feature, a.AblatedLatency, a.ResolvedLatency, a.ResolvedFullLatency, a.Subgraphs, a.ChangedSubgraphs)
+			}
+		}
+		if *samples > 0 && status == 0 {
+			stage = "sampling schedules"
+			r, err := mlsys.SampleSchedules(ctx, problem, opts, solution, *samples, *sampleSeed)
+			if err != nil {
+				return mlsys.OutputSolution{}, 1, err
+			}
+			fmt.Fprintf(os.Stderr, "sample: samples=%d rejected=%d min=%.4f p5=%.4f median=%.4f mean=%.4f p95=%.4f max=%.4f solver=%.4f at_least_as_fast=%.4f median_speedup=%.4f\n",
+				r.Samples, r.Rejected, r.Min, r.P5, r.Median, r.Mean, r.P95, r.Max, r.SolverLatency, r.AtLeastAsFast, r.MedianSpeedup)
+		}
+		return solution, status, nil
+	}
+
+	// The contest harness kills the binary at its timeout; stopping on a
+	// signal still leaves time to write the best schedule found so far.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *watch {
+		watched := inPaths
+		if *manifestPath != "" {
+			watched = append([]string{*manifestPath}, inPaths...)
+		}
+		for _, path := range []string{*hwPath, *dialect} {
+			if path != "" && path != "camel" {
+				watched = append(watched, path)
+			}
+		}
+		watchSolve(ctx, watched, *watchInterval, solveOnce)
+		if err := stopPolicy(); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+		}
+		return
+	}
+	_, status, err := solveOnce(ctx)
+	if err := stopPolicy(); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+	}
+	if err != nil {
+		exit(status, err.Error())
+	}
+	os.Exit(status)
 }
 
-func main() {
-	if len(os.Args) != 3 {
-		fatal("usage: ./mlsys <path_to_input.json> <path_to_output.json>")
+func logSolutionLatency(s mlsys.OutputSolution) {
+	for i, lat := range s.SubgraphLatencies {
+		fmt.Fprintf(os.Stderr, "latency: subgraph=%d estimated_latency=%.4f\n", i, lat)
+	}
+	fmt.Fprintf(os.Stderr, "latency: total_estimated_latency=%.4f subgraphs=%d\n", s.TotalLatency(), len(s.SubgraphLatencies))
+	if len(s.SubgraphLatenciesP95) > 0 {
+		fmt.Fprintf(os.Stderr, "latency: total_p95_latency=%.4f\n", mlsys.SumLatencies(s.SubgraphLatenciesP95))
+	}
+	if len(s.SubgraphLatenciesInterference) > 0 {
+		fmt.Fprintf(os.Stderr, "latency: total_interference_latency=%.4f\n", mlsys.SumLatencies(s.SubgraphLatenciesInterference))
 	}
-	inPath := os.Args[1]
-	outPath := os.Args[2]
+	if len(s.SubgraphLatencyIntervals) > 0 {
+		var low, high []float64
+		for _, iv := range s.SubgraphLatencyIntervals {
+			low, high = append(low, iv.Low), append(high, iv.High)
+		}
+		fmt.Fprintf(os.Stderr, "latency: total_latency_low=%.4f total_latency_high=%.4f\n", mlsys.SumLatencies(low), mlsys.SumLatencies(high))
+	}
+	if len(s.SubgraphExecutionCounts) > 0 {
+		expected := make([]float64, len(s.SubgraphExecutionCounts))
+		for i, count := range s.SubgraphExecutionCounts {
+			expected[i] = count * s.SubgraphLatencies[i]
+		}
+		fmt.Fprintf(os.Stderr, "latency: total_expected_latency=%.4f\n", mlsys.SumLatencies(expected))
+	}
+	for _, b := range s.KVCacheBuckets {
+		fmt.Fprintf(os.Stderr, "latency: kv_length=%d-%d kv_resident=%t total_estimated_latency=%.4f subgraphs=%d\n",
+			b.MinLength, b.MaxLength, b.KVResident, b.TotalLatency, len(b.Schedule.Subgraphs))
+	}
+	for _, l := range s.LayerLatencies {
+		fmt.Fprintf(os.Stderr, "latency: layer=%q latency=%.4f ops=%d subgraphs=%d\n", l.Layer, l.Latency, l.Ops, l.Subgraphs)
+	}
+	if sv := s.Serving; sv != nil {
+		fmt.Fprintf(os.Stderr, "latency: prefill_latency=%.4f decode_latency_per_token=%.4f pinned_weights=%d\n",
+			sv.PrefillLatency, sv.DecodeLatencyPerToken, len(sv.PinnedWeights))
+	}
+}
 
-	problem, err := readProblem(inPath)
+// printSummary writes a problem summary as lines starting with prefix; it
+// serves dry runs and the stats command.
+func printSummary(w io.Writer, prefix string, s mlsys.ProblemSummary) {
+	fmt.Fprintf(w, "%s: ops=%d tensors=%d graph_inputs=%d graph_outputs=%d unknown_ops=%d\n",
+		prefix, s.Ops, s.Tensors, s.GraphInputs, s.GraphOutputs, s.UnknownOps)
+	types := make([]string, 0, len(s.OpsByType))
+	for t := range s.OpsByType {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	for _, t := range types {
+		fmt.Fprintf(w, "%s: op_type=%q ops=%d\n", prefix, t, s.OpsByType[t])
+	}
+	fmt.Fprintf(w, "%s: total_elements=%d largest_tensor=%d\n", prefix, s.TotalElements, s.LargestTensor)
+	for _, b := range s.TensorSizes {
+		fmt.Fprintf(w, "%s: tensor_elements<=%d tensors=%d\n", prefix, b.MaxElements, b.Tensors)
+	}
+	fmt.Fprintf(w, "%s: candidate_groups<=%d tile_evaluations<=%d\n", prefix, s.CandidateGroups, s.TileEvaluations)
+}
+
+// runCheckExec replays a solution through the reference interpreter and
+// compares its results with an unscheduled run.
+func runCheckExec(args []string) {
+	fs := flag.NewFlagSet("mlsys check-exec", flag.ContinueOnError)
+	unknownOp := fs.String("unknown-op", "elementwise", "handling of unregistered op types: error, elementwise or opaque")
+	hwPath := fs.String("hw", "", "take the hardware description from this `path` instead of the problem")
+	dialect := fs.String("dialect", "", "read the problem's field names in a dialect: camel, or the mapping file at this `path`")
+	maxElements := fs.Int64("max-elements", mlsys.DefaultCheckElements, "refuse problems whose tensors hold more elements than this in total")
+	maxSubgraphs := fs.Int("max-subgraphs", 0, "require at most this many subgraphs, barriers aside (0: no limit)")
+	minOps := fs.Int("min-ops-per-subgraph", 0, "require at least this many ops in every subgraph (0: no limit)")
+	compat := fs.String("compat", "latest", "check fast-memory footprints as this release models them: v1.0, v1.1, v1.2, v1.3, v1.4, v1.5, v1.6, v1.7 or latest")
+	capacityMargin := fs.Float64("capacity-margin", 1, "require every subgraph to fit in this fraction of fast memory, within (0, 1]")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: ./mlsys check-exec [flags] <path_to_input.json> <path_to_solution.json>")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	stage := "reading the problem"
+	defer recoverCrash(fs, args, fs.Arg(0), &stage, nil)
+
+	var opts mlsys.Options
+	var err error
+	if opts.UnknownOps, err = mlsys.ParseUnknownOpPolicy(*unknownOp); err != nil {
+		exit(exitUsage, err.Error())
+	}
+	if opts.Compat, err = mlsys.ParseCompatLevel(*compat); err != nil {
+		exit(exitUsage, err.Error())
+	}
+	opts.MaxSubgraphs, opts.MinOpsPerSubgraph = *maxSubgraphs, *minOps
+	opts.CapacityMargin = parseCapacityMargin(*capacityMargin)
+	problem, err := readProblemWithHardware(fs.Arg(0), *hwPath, *dialect)
+	if err != nil {
+		exit(exitInvalidProblem, err.Error())
+	}
+	if err := checkProblem(problem); err != nil {
+		exit(exitInvalidProblem, err.Error())
+	}
+	stage = "checking the schedule"
+	solution, err := readSolution(fs.Arg(1))
 	if err != nil {
 		fatal(err.Error())
 	}
-	if err := validateProblem(problem); err != nil {
+	if err := checkSolution(problem, solution, opts); err != nil {
 		fatal(err.Error())
 	}
-
-	solution := buildBaselineSolution(problem)
-	logSolutionLatency(solution)
-	if err := writeSolution(outPath, solution); err != nil {
+	if err := mlsys.CheckExecution(problem, solution, opts, *maxElements); err != nil {
 		fatal(err.Error())
 	}
+	fmt.Fprintf(os.Stderr, "check-exec: ok subgraphs=%d\n", len(solution.Subgraphs))
 }
 
-func logSolutionLatency(s OutputSolution) {
-	total := 0.0
-	for i, lat := range s.SubgraphLatencies {
-		total += lat
-		fmt.Fprintf(os.Stderr, "latency: subgraph=%d estimated_latency=%.4f\n", i, lat)
+// runCanonicalize rewrites an existing solution in canonical form so that
+// it diffs cleanly against others.
+func runCanonicalize(args []string) {
+	fs := flag.NewFlagSet("mlsys canonicalize", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: ./mlsys canonicalize <path_to_solution.json> <path_to_output.json>")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	solution, err := readSolution(fs.Arg(0))
+	if err != nil {
+		fatal(err.Error())
+	}
+	if err := writeSolution(fs.Arg(1), mlsys.CanonicalizeSolution(solution)); err != nil {
+		fatal(err.Error())
 	}
-	fmt.Fprintf(os.Stderr, "latency: total_estimated_latency=%.4f subgraphs=%d\n", total, len(s.SubgraphLatencies))
 }
 
-func readProblem(path string) (InputProblem, error) {
-	data, err := os.ReadFile(path)
+func readProblem(path string, d mlsys.Dialect) (mlsys.InputProblem, error) {
+	f, err := os.Open(path)
 	if err != nil {
-		return InputProblem{}, fmt.Errorf("read input: %w", err)
+		return mlsys.InputProblem{}, fmt.Errorf("read input: %w", err)
 	}
-	var p InputProblem
-	if err := json.Unmarshal(data, &p); err != nil {
-		return InputProblem{}, fmt.Errorf("parse input JSON: %w", err)
+	defer f.Close()
+	p, err := mlsys.DecodeProblemDialect(f, d)
+	if err != nil {
+		return mlsys.InputProblem{}, fmt.Errorf("parse input JSON: %w", err)
 	}
 	return p, nil
 }
 
-func validateProblem(p InputProblem) error {
-	nOps := len(p.OpTypes)
-	if nOps == 0 {
-		return errors.New("problem has no operations")
+// readProblemWithHardware reads the problem at path, in the dialect named
+// by dialect, and, when hwPath is set, replaces its hardware description
+// with the one stored there.
+func readProblemWithHardware(path, hwPath, dialect string) (mlsys.InputProblem, error) {
+	d, err := readDialect(dialect)
+	if err != nil {
+		return mlsys.InputProblem{}, err
+	}
+	p, err := readProblem(path, d)
+	if err != nil || hwPath == "" {
+		return p, err
+	}
+	hw, err := readHardware(hwPath)
+	if err != nil {
+		return mlsys.InputProblem{}, err
+	}
+	return mlsys.WithHardware(p, hw), nil
+}
+
+func readHardware(path string) (mlsys.Hardware, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return mlsys.Hardware{}, fmt.Errorf("read hardware: %w", err)
 	}
-	if len(p.Inputs) != nOps || len(p.Outputs) != nOps || len(p.BaseCosts) != nOps {
-		return errors.New("inputs/outputs/base_costs/op_types length mismatch")
+	defer f.Close()
+	hw, err := mlsys.DecodeHardware(f)
+	if err != nil {
+		return mlsys.Hardware{}, fmt.Errorf("parse hardware JSON: %w", err)
 	}
-	if len(p.Widths) != len(p.Heights) {
-		return errors.New("widths/heights length mismatch")
+	return hw, nil
+}
+
+// readTemplates returns the schedule templates of the template file at
+// path, which take precedence, followed by the built-in ones. A path of
+// "builtin" selects the built-in ones only.
+func readTemplates(path string) ([]mlsys.ScheduleTemplate, error) {
+	builtin := mlsys.BuiltinTemplates()
+	if path == "builtin" {
+		return builtin, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("read templates: %w", err)
 	}
-	if p.SlowMemoryBandwidth <= 0 {
-		return errors.New("slow_memory_bandwidth must be > 0")
+	defer f.Close()
+	user, err := mlsys.ReadTemplates(f)
+	if err != nil {
+		return nil, err
 	}
-	if p.FastMemoryCapacity <= 0 {
-		return errors.New("fast_memory_capacity must be > 0")
+	return append(user, builtin...), nil
+}
+
+// readShardedProblem merges the shards at shardPaths, or else those the
+// manifest lists, and applies the hardware description at hwPath if set.
+func readShardedProblem(manifestPath string, shardPaths []string, hwPath, dialect string) (mlsys.InputProblem, error) {
+	f, err := os.Open(manifestPath)
+	if err != nil {
+		return mlsys.InputProblem{}, fmt.Errorf("read manifest: %w", err)
 	}
-	if p.NativeGranularity[0] <= 0 || p.NativeGranularity[1] <= 0 {
-		return errors.New("native_granularity entries must be > 0")
+	m, err := mlsys.ReadShardManifest(f)
+	f.Close()
+	if err != nil {
+		return mlsys.InputProblem{}, err
 	}
-	for op := 0; op < nOps; op++ {
-		for _, t := range p.Inputs[op] {
-			if t < 0 || t >= len(p.Widths) {
-				return fmt.Errorf("op %d input tensor index out of range: %d", op, t)
+	if len(shardPaths) == 0 {
+		for _, s := range m.Shards {
+			if !filepath.IsAbs(s) {
+				s = filepath.Join(filepath.Dir(manifestPath), s)
 			}
+			shardPaths = append(shardPaths, s)
 		}
-		for _, t := range p.Outputs[op] {
-			if t < 0 || t >= len(p.Widths) {
-				return fmt.Errorf("op %d output tensor index out of range: %d", op, t)
-			}
+	}
+	shards := make([]mlsys.InputProblem, len(shardPaths))
+	for i, path := range shardPaths {
+		if shards[i], err = readProblemWithHardware(path, "", dialect); err != nil {
+			return mlsys.InputProblem{}, fmt.Errorf("%s: %w", path, err)
 		}
 	}
-	return nil
+	p, err := mlsys.MergeShards(m, shards)
+	if err != nil || hwPath == "" {
+		return p, err
+	}
+	hw, err := readHardware(hwPath)
+	if err != nil {
+		return mlsys.InputProblem{}, err
+	}
+	return mlsys.WithHardware(p, hw), nil
 }
 
-func buildBaselineSolution(p InputProblem) OutputSolution {
-	nOps := len(p.OpTypes)
-	s := OutputSolution{
-		Subgraphs:         make([][]int, 0, nOps),
-		Granularities:     make([][3]int64, 0, nOps),
-		TensorsToRetain:   make([][]int, 0, nOps),
-		TraversalOrders:   make([]*[]int64, 0, nOps),
-		SubgraphLatencies: make([]float64, 0, nOps),
+// readDialect resolves the -dialect flag: empty for the native field
+// names, camel, or the path of a mapping file.
+func readDialect(spec string) (mlsys.Dialect, error) {
+	switch spec {
+	case "":
+		return mlsys.Dialect{}, nil
+	case "camel":
+		return mlsys.CamelCaseDialect, nil
 	}
-
-	for op := 0; op < nOps; op++ {
-		g := chooseGranularityForOp(p, op)
-		lat := estimateSubgraphLatencySingleOp(p, op, g)
-
-		s.Subgraphs = append(s.Subgraphs, []int{op})
-		s.Granularities = append(s.Granularities, g)
-		s.TensorsToRetain = append(s.TensorsToRetain, []int{})
-		s.TraversalOrders = append(s.TraversalOrders, nil)
-		s.SubgraphLatencies = append(s.SubgraphLatencies, lat)
+	f, err := os.Open(spec)
+	if err != nil {
+		return mlsys.Dialect{}, fmt.Errorf("read dialect: %w", err)
 	}
-	return s
+	defer f.Close()
+	return mlsys.ReadDialect(f)
 }
 
-func chooseGranularityForOp(p InputProblem, op int) [3]int64 {
-	outTensor := p.Outputs[op][0]
-	maxW := minI64(p.NativeGranularity[0], p.Widths[outTensor])
-	maxH := minI64(p.NativeGranularity[1], p.Heights[outTensor])
-	if maxW < 1 {
-		maxW = 1
-	}
-	if maxH < 1 {
-		maxH = 1
-	}
-
-	candidatesW := descendingPowersOfTwo(maxW)
-	candidatesH := descendingPowersOfTwo(maxH)
-	best := [3]int64{1, 1, 1}
-	bestArea := int64(1)
-
-	for _, w := range candidatesW {
-		for _, h := range candidatesH {
-			k := int64(1)
-			if isMatMul(p.OpTypes[op]) && len(p.Inputs[op]) > 0 {
-				lhs := p.Inputs[op][0]
-				reduction := p.Widths[lhs]
-				if reduction > 0 {
-					k = minI64(reduction, 16)
-				}
-			}
-			if fitsFastMemory(p, op, w, h, k) {
-				area := w * h
-				if area > bestArea {
-					bestArea = area
-					best = [3]int64{w, h, k}
-				}
-			}
-		}
-	}
-	return best
+// checkProblem runs every problem check, prints the warnings and returns
+// the first error.
+func checkProblem(p mlsys.InputProblem) error {
+	return reportFindings(validate.Problem(p, validate.ProblemChecks()))
 }
 
-func fitsFastMemory(p InputProblem, op int, w, h, k int64) bool {
-	required := workingSetElementsForOp(p, op, w, h, k)
-	return float64(required) <= p.FastMemoryCapacity
+// checkSolution does the same for a schedule.
+func checkSolution(p mlsys.InputProblem, s mlsys.OutputSolution, opts mlsys.Options) error {
+	return reportFindings(validate.Solution(p, s, opts, validate.SolutionChecks()))
 }
 
-func workingSetElementsForOp(p InputProblem, op int, w, h, k int64) int64 {
-	if isMatMul(p.OpTypes[op]) {
-		lhs := h * maxI64(1, k)
-		rhs := w * maxI64(1, k)
-		out := w * h * maxI64(1, int64(len(p.Outputs[op])))
-		return lhs + rhs + out
+func reportFindings(r validate.Report) error {
+	for _, f := range r.Warnings() {
+		fmt.Fprintln(os.Stderr, f)
 	}
-	in := w * h * maxI64(1, int64(len(p.Inputs[op])))
-	out := w * h * maxI64(1, int64(len(p.Outputs[op])))
-	return in + out
+	return r.Err()
 }
 
-func estimateSubgraphLatencySingleOp(p InputProblem, op int, g [3]int64) float64 {
-	w, h, k := g[0], g[1], g[2]
-	outTensor := p.Outputs[op][0]
-	outW := p.Widths[outTensor]
-	outH := p.Heights[outTensor]
-	tilesW := ceilDiv(outW, w)
-	tilesH := ceilDiv(outH, h)
-	splitK := int64(1)
-	if isMatMul(p.OpTypes[op]) && len(p.Inputs[op]) > 0 {
-		lhs := p.Inputs[op][0]
-		reduction := p.Widths[lhs]
-		splitK = ceilDiv(reduction, maxI64(1, k))
-	}
-	nSteps := maxI64(1, tilesW*tilesH*splitK)
-
-	computePerStep := p.BaseCosts[op]
-	memPerStep := float64(workingSetElementsForOp(p, op, w, h, k)) / p.SlowMemoryBandwidth
-	stepLatency := math.Max(computePerStep, memPerStep)
-	return float64(nSteps) * stepLatency
+func readSolution(path string) (mlsys.OutputSolution, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return mlsys.OutputSolution{}, fmt.Errorf("read solution: %w", err)
+	}
+	var s mlsys.OutputSolution
+	if err := json.Unmarshal(data, &s); err != nil {
+		return mlsys.OutputSolution{}, fmt.Errorf("parse solution JSON: %w", err)
+	}
+	return s, nil
 }
 
-func writeSolution(path string, s OutputSolution) error {
+func writeSolution(path string, s mlsys.OutputSolution) error {
 	data, err := json.MarshalIndent(s, "", "  ")
 	if err != nil {
 		return fmt.Errorf("marshal solution: %w", err)
@@ -216,48 +618,101 @@ func writeSolution(path string, s OutputSolution) error {
 	return nil
 }
 
-func descendingPowersOfTwo(max int64) []int64 {
-	vals := make([]int64, 0)
-	v := int64(1)
-	for v*2 <= max {
-		v *= 2
+// writeSolutionPatch writes the merge patch from the solution at prevPath
+// to s.
+func writeSolutionPatch(path, prevPath string, s mlsys.OutputSolution) error {
+	prev, err := readSolution(prevPath)
+	if err != nil {
+		return err
 	}
-	for v >= 1 {
-		vals = append(vals, v)
-		v /= 2
+	patch, err := mlsys.SolutionPatch(prev, s)
+	if err != nil {
+		return fmt.Errorf("diff solution: %w", err)
 	}
-	if len(vals) == 0 {
-		return []int64{1}
+	full, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("marshal solution: %w", err)
 	}
-	return vals
-}
-
-func isMatMul(opType string) bool {
-	return opType == "MatMul" || opType == "matmul"
+	fmt.Fprintf(os.Stderr, "patch: bytes=%d full_bytes=%d\n", len(patch), len(full))
+	if err := os.WriteFile(path, append(patch, '\n'), 0o644); err != nil {
+		return fmt.Errorf("write solution patch: %w", err)
+	}
+	return nil
 }
 
-func ceilDiv(a, b int64) int64 {
-	if b <= 0 {
-		return 0
+// writeSolutionCSV writes one row per subgraph with the cost model's
+// breakdown, for loading into a spreadsheet.
+func writeSolutionCSV(path string, p mlsys.InputProblem, s mlsys.OutputSolution, opts mlsys.Options) error {
+	stats, err := mlsys.AnalyzeSolution(p, s, opts)
+	if err != nil {
+		return fmt.Errorf("analyze solution: %w", err)
+	}
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"subgraph", "ops", "tile_w", "tile_h", "tile_k", "steps", "compute_time", "memory_time", "latency", "traffic", "traffic_bytes"})
+	for i, st := range stats {
+		ops := make([]string, len(st.Ops))
+		for j, op := range st.Ops {
+			ops[j] = strconv.Itoa(op)
+		}
+		w.Write([]string{
+			strconv.Itoa(i),
+			strings.Join(ops, " "),
+			strconv.FormatInt(st.Granularity[0], 10),
+			strconv.FormatInt(st.Granularity[1], 10),
+			strconv.FormatInt(st.Granularity[2], 10),
+			strconv.FormatInt(st.Steps, 10),
+			strconv.FormatFloat(st.ComputeTime, 'f', -1, 64),
+			strconv.FormatFloat(st.MemoryTime, 'f', -1, 64),
+			strconv.FormatFloat(st.Latency, 'f', -1, 64),
+			strconv.FormatInt(st.Traffic, 10),
+			strconv.FormatInt(st.TrafficBytes, 10),
+		})
 	}
-	return (a + b - 1) / b
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return fmt.Errorf("encode csv: %w", err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("write solution: %w", err)
+	}
+	return nil
 }
 
-func minI64(a, b int64) int64 {
-	if a < b {
-		return a
+// parseFlags parses args into fs. Bad flags exit with exitUsage, and -h
+// with status 0 after printing the usage.
+func parseFlags(fs *flag.FlagSet, args []string) {
+	configPath := fs.String("config", "", "take flag values not given here from the config file at this `path` (default $MLSYS_CONFIG)")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		os.Exit(exitUsage)
+	}
+	sources, path, err := applyConfig(fs, *configPath)
+	if err != nil {
+		exit(exitUsage, err.Error())
+	}
+	if showConfig {
+		printConfig(fs, sources, path)
+		os.Exit(0)
 	}
-	return b
 }
 
-func maxI64(a, b int64) int64 {
-	if a > b {
-		return a
+// parseCapacityMargin checks a -capacity-margin value, exiting with
+// exitUsage when it is out of range.
+func parseCapacityMargin(m float64) float64 {
+	if !(m > 0 && m <= 1) {
+		exit(exitUsage, fmt.Sprintf("capacity margin must be within (0, 1], got %g", m))
 	}
-	return b
+	return m
 }
 
 func fatal(msg string) {
+	exit(1, msg)
+}
+
+func exit(status int, msg string) {
 	fmt.Fprintln(os.Stderr, "error:", msg)
-	os.Exit(1)
+	os.Exit(status)
 }
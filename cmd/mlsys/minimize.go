@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"mlsys"
+)
+
+// Failure kinds the minimizer can preserve.
+const (
+	failPanic   = "panic"
+	failError   = "error"
+	failInvalid = "invalid"
+	failAny     = "any"
+)
+
+// runMinimize delta-debugs a problem that makes the solver fail down to a
+// small one that still fails the same way.
+func runMinimize(args []string) {
+	fs := flag.NewFlagSet("mlsys minimize", flag.ContinueOnError)
+	problemPath := fs.String("problem", "", "the failing problem `path`")
+	predicate := fs.String("predicate", failAny, "failure to preserve: panic, error (Solve returns an error), invalid (the schedule fails validation or execution checking) or any")
+	outPath := fs.String("o", "minimized.json", "write the reduced problem to this `path`")
+	unknownOp := fs.String("unknown-op", "elementwise", "handling of unregistered op types: error, elementwise or opaque")
+	hwPath := fs.String("hw", "", "take the hardware description from this `path` instead of the problem")
+	dialect := fs.String("dialect", "", "read the problem's field names in a dialect: camel, or the mapping file at this `path`")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: ./mlsys minimize -problem <path_to_input.json> [flags]")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if *problemPath == "" || fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	switch *predicate {
+	case failPanic, failError, failInvalid, failAny:
+	default:
+		exit(exitUsage, fmt.Sprintf("unknown predicate %q (want panic, error, invalid or any)", *predicate))
+	}
+
+	var opts mlsys.Options
+	var err error
+	if opts.UnknownOps, err = mlsys.ParseUnknownOpPolicy(*unknownOp); err != nil {
+		exit(exitUsage, err.Error())
+	}
+	problem, err := readProblemWithHardware(*problemPath, *hwPath, *dialect)
+	if err != nil {
+		exit(exitInvalidProblem, err.Error())
+	}
+	if err := checkProblem(problem); err != nil {
+		exit(exitInvalidProblem, err.Error())
+	}
+
+	matches := func(p mlsys.InputProblem) bool {
+		kind, _ := solverFailure(p, opts)
+		return kind != "" && (*predicate == failAny || kind == *predicate)
+	}
+	kind, detail := solverFailure(problem, opts)
+	if !matches(problem) {
+		if kind == "" {
+			fatal("the solver does not fail on this problem")
+		}
+		fatal(fmt.Sprintf("the solver fails with %s, not %s: %s", kind, *predicate, detail))
+	}
+	fmt.Fprintf(os.Stderr, "minimize: original ops=%d tensors=%d failure=%s: %s\n", len(problem.OpTypes), len(problem.Widths), kind, detail)
+
+	reduced := mlsys.MinimizeProblem(problem, matches)
+	kind, detail = solverFailure(reduced, opts)
+	data, err := json.MarshalIndent(reduced, "", "  ")
+	if err != nil {
+		fatal(fmt.Sprintf("marshal problem: %v", err))
+	}
+	if err := os.WriteFile(*outPath, append(data, '\n'), 0o644); err != nil {
+		fatal(fmt.Sprintf("write problem: %v", err))
+	}
+	fmt.Fprintf(os.Stderr, "minimize: reduced ops=%d tensors=%d failure=%s: %s\n", len(reduced.OpTypes), len(reduced.Widths), kind, detail)
+}
+
+// solverFailure solves p and classifies how the solver failed on it, if it
+// did. Execution is only checked on problems small enough to interpret.
+func solverFailure(p mlsys.InputProblem, opts mlsys.Options) (kind, detail string) {
+	defer func() {
+		if r := recover(); r != nil {
+			kind, detail = failPanic, fmt.Sprint(r)
+		}
+	}()
+	s, err := mlsys.Solve(context.Background(), p, opts)
+	if err != nil {
+		return failError, err.Error()
+	}
+	if err := mlsys.ValidateSolution(p, s, opts); err != nil {
+		return failInvalid, err.Error()
+	}
+	var elements int64
+	for t := range p.Widths {
+		elements += p.Widths[t] * p.Heights[t]
+	}
+	if elements <= mlsys.DefaultCheckElements {
+		if err := mlsys.CheckExecution(p, s, opts, mlsys.DefaultCheckElements); err != nil {
+			return failInvalid, err.Error()
+		}
+	}
+	return "", ""
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+
+	"mlsys"
+)
+
+// Perfetto trace protobuf field numbers, from protos/perfetto/trace. The
+// encoder below writes just the handful of messages the trace needs, so
+// the binary stays free of a protobuf dependency.
+const (
+	fieldTracePacket = 1 // Trace.packet
+
+	fieldPacketTimestamp  = 8  // TracePacket.timestamp
+	fieldPacketSequenceID = 10 // TracePacket.trusted_packet_sequence_id
+	fieldPacketTrackEvent = 11 // TracePacket.track_event
+	fieldPacketTrackDesc  = 60 // TracePacket.track_descriptor
+
+	fieldTrackUUID    = 1 // TrackDescriptor.uuid
+	fieldTrackName    = 2 // TrackDescriptor.name
+	fieldTrackCounter = 8 // TrackDescriptor.counter
+
+	fieldCounterUnitName = 6 // CounterDescriptor.unit_name
+
+	fieldEventType         = 9  // TrackEvent.type
+	fieldEventTrackUUID    = 11 // TrackEvent.track_uuid
+	fieldEventName         = 23 // TrackEvent.name
+	fieldEventCounterValue = 30 // TrackEvent.counter_value
+
+	eventSliceBegin = 1
+	eventSliceEnd   = 2
+	eventCounter    = 4
+
+	traceSequenceID = 1
+)
+
+const (
+	trackSchedule uint64 = iota + 1
+	trackCompute
+	trackDMA
+	trackOccupancy
+)
+
+type protoBuf []byte
+
+func (b protoBuf) varint(v uint64) protoBuf {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+func (b protoBuf) uintField(field int, v uint64) protoBuf {
+	return b.varint(uint64(field)<<3 | 0).varint(v)
+}
+
+func (b protoBuf) bytesField(field int, v []byte) protoBuf {
+	return append(b.varint(uint64(field)<<3|2).varint(uint64(len(v))), v...)
+}
+
+func (b protoBuf) stringField(field int, v string) protoBuf {
+	return b.bytesField(field, []byte(v))
+}
+
+type perfettoTrace struct {
+	out protoBuf
+}
+
+func (t *perfettoTrace) packet(body protoBuf) {
+	body = body.uintField(fieldPacketSequenceID, traceSequenceID)
+	t.out = t.out.bytesField(fieldTracePacket, body)
+}
+
+func (t *perfettoTrace) track(uuid uint64, name string, counterUnit string) {
+	desc := protoBuf(nil).uintField(fieldTrackUUID, uuid).stringField(fieldTrackName, name)
+	if counterUnit != "" {
+		desc = desc.bytesField(fieldTrackCounter, protoBuf(nil).stringField(fieldCounterUnitName, counterUnit))
+	}
+	t.packet(protoBuf(nil).bytesField(fieldPacketTrackDesc, desc))
+}
+
+func (t *perfettoTrace) event(ts uint64, ev protoBuf) {
+	t.packet(protoBuf(nil).uintField(fieldPacketTimestamp, ts).bytesField(fieldPacketTrackEvent, ev))
+}
+
+func (t *perfettoTrace) slice(track uint64, name string, start, end uint64) {
+	t.event(start, protoBuf(nil).
+		uintField(fieldEventType, eventSliceBegin).
+		uintField(fieldEventTrackUUID, track).
+		stringField(fieldEventName, name))
+	t.event(end, protoBuf(nil).
+		uintField(fieldEventType, eventSliceEnd).
+		uintField(fieldEventTrackUUID, track))
+}
+
+func (t *perfettoTrace) counter(track uint64, ts uint64, v int64) {
+	t.event(ts, protoBuf(nil).
+		uintField(fieldEventType, eventCounter).
+		uintField(fieldEventTrackUUID, track).
+		uintField(fieldEventCounterValue, uint64(v)))
+}
+
+// writePerfettoTrace writes the modeled timeline of s as a Perfetto
+// protobuf trace, one model time unit per nanosecond. Each subgraph is a
+// slice on the schedule track, with its transfers and compute on their own
+// tracks and its per-step working set on a fast-memory occupancy counter.
+func writePerfettoTrace(path string, p mlsys.InputProblem, s mlsys.OutputSolution, opts mlsys.Options) error {
+	stats, err := mlsys.AnalyzeSolution(p, s, opts)
+	if err != nil {
+		return fmt.Errorf("analyze solution: %w", err)
+	}
+	var t perfettoTrace
+	t.track(trackSchedule, "Subgraphs", "")
+	t.track(trackCompute, "Compute engine", "")
+	t.track(trackDMA, "DMA queue", "")
+	t.track(trackOccupancy, "Fast memory occupancy", "elements")
+
+	ns := func(v float64) uint64 { return uint64(math.Round(v)) }
+	start := 0.0
+	for i, st := range stats {
+		name := fmt.Sprintf("subgraph %d", i)
+		if len(st.Ops) == 0 {
+			name = fmt.Sprintf("barrier %d", i)
+		}
+		end := start + st.Latency
+		t.slice(trackSchedule, name, ns(start), ns(end))
+		computeStart := start
+		if st.Standalone {
+			computeStart = start + st.MemoryTime
+		}
+		if st.MemoryTime > 0 {
+			t.slice(trackDMA, name, ns(start), ns(start+st.MemoryTime))
+		}
+		if st.ComputeTime > 0 {
+			t.slice(trackCompute, name, ns(computeStart), ns(computeStart+st.ComputeTime))
+		}
+		t.counter(trackOccupancy, ns(start), st.Traffic/max(1, st.Steps))
+		start = end
+	}
+	t.counter(trackOccupancy, ns(start), 0)
+
+	if err := os.WriteFile(path, t.out, 0o644); err != nil {
+		return fmt.Errorf("write trace: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+
+	"mlsys"
+)
+
+// startPolicy runs command through the shell as the policy of the solve,
+// speaking the protocol of mlsys.NewJSONPolicy over its standard input and
+// output; its standard error is passed through. stop closes both pipes,
+// which ends the protocol, and waits for it to exit.
+func startPolicy(command string) (pol mlsys.Policy, stop func() error, err error) {
+	cmd := exec.Command("/bin/sh", "-c", command)
+	cmd.Stderr = os.Stderr
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, nil, fmt.Errorf("start policy: %w", err)
+	}
+	stop = func() error {
+		in.Close()
+		out.Close()
+		if err := cmd.Wait(); err != nil {
+			return fmt.Errorf("policy: %w", err)
+		}
+		return nil
+	}
+	return mlsys.NewJSONPolicy(out, in), stop, nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"mlsys"
+)
+
+// runRequirements finds the least fast memory and bandwidth at which the
+// solver meets a target latency, instead of solving on the problem's own
+// hardware.
+func runRequirements(args []string) {
+	fs := flag.NewFlagSet("mlsys requirements", flag.ContinueOnError)
+	target := fs.Float64("target-latency", 0, "the total latency the schedule must meet")
+	unknownOp := fs.String("unknown-op", "elementwise", "handling of unregistered op types: error, elementwise or opaque")
+	hwPath := fs.String("hw", "", "take the hardware description from this `path` instead of the problem")
+	dialect := fs.String("dialect", "", "read the problem's field names in a dialect: camel, or the mapping file at this `path`")
+	compat := fs.String("compat", "latest", "solve as this release does: v1.0, v1.1, v1.2, v1.3, v1.4, v1.5, v1.6, v1.7 or latest")
+	capacityMargin := fs.Float64("capacity-margin", 1, "fill at most this fraction of fast memory, leaving the rest as headroom, within (0, 1]")
+	top := fs.Int("n", 10, "list this many of the slowest subgraphs (0: all)")
+	asJSON := fs.Bool("json", false, "print the requirements and every subgraph as a JSON object")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: ./mlsys requirements -target-latency <latency> [flags] <path_to_input.json>")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() != 1 || !(*target > 0) || *top < 0 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	stage := "reading the problem"
+	var progress mlsys.SolveProgress
+	defer recoverCrash(fs, args, fs.Arg(0), &stage, &progress)
+
+	opts := parseCheckOptions(*unknownOp, *compat, *capacityMargin, 0, 0)
+	opts.Progress = &progress
+	problem, err := readProblemWithHardware(fs.Arg(0), *hwPath, *dialect)
+	if err != nil {
+		exit(exitInvalidProblem, err.Error())
+	}
+	if err := checkProblem(problem); err != nil {
+		exit(exitInvalidProblem, err.Error())
+	}
+	stage = "searching"
+	r, err := mlsys.FindHardwareRequirements(context.Background(), problem, opts, *target)
+	if err != nil {
+		exit(exitInvalidProblem, err.Error())
+	}
+	if *asJSON {
+		data, err := json.MarshalIndent(r, "", "  ")
+		if err != nil {
+			fatal(fmt.Sprintf("marshal requirements: %v", err))
+		}
+		fmt.Println(string(data))
+		return
+	}
+	fmt.Printf("requirements: target_latency=%.4f latency=%.4f feasible=%t\n", r.TargetLatency, r.Latency, r.Feasible)
+	if r.MinFastMemoryCapacity > 0 {
+		fmt.Printf("requirements: min_fast_memory_capacity=%.0f (problem: %.0f)\n", r.MinFastMemoryCapacity, problem.FastMemoryCapacity)
+	} else {
+		fmt.Println("requirements: no fast memory capacity meets the target at the problem's bandwidth")
+	}
+	if r.MinSlowMemoryBandwidth > 0 {
+		fmt.Printf("requirements: min_slow_memory_bandwidth=%.6g (problem: %.6g)\n", r.MinSlowMemoryBandwidth, problem.SlowMemoryBandwidth)
+	} else {
+		fmt.Println("requirements: no slow memory bandwidth meets the target at the problem's fast memory capacity")
+	}
+	bs := r.Bottlenecks
+	if *top > 0 && *top < len(bs) {
+		bs = bs[:*top]
+	}
+	for _, b := range bs {
+		fmt.Printf("requirements: subgraph=%d ops=%d latency=%.4f share=%.4f footprint=%d memory_bound=%t balanced_bandwidth=%.6g\n",
+			b.Subgraph, len(b.Ops), b.Latency, b.Share, b.Footprint, b.MemoryBound, b.BalancedBandwidth)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"mlsys"
+)
+
+// runRetain recomputes the retained tensors of an existing solution,
+// keeping its partition and granularities.
+func runRetain(args []string) {
+	fs := flag.NewFlagSet("mlsys retain", flag.ContinueOnError)
+	problemPath := fs.String("problem", "", "the problem `path`")
+	solutionPath := fs.String("solution", "", "the solution `path` whose retention is redone")
+	outPath := fs.String("o", "solution.json", "write the updated solution to this `path`")
+	unknownOp := fs.String("unknown-op", "elementwise", "handling of unregistered op types: error, elementwise or opaque")
+	hwPath := fs.String("hw", "", "take the hardware description from this `path` instead of the problem")
+	dialect := fs.String("dialect", "", "read the problem's field names in a dialect: camel, or the mapping file at this `path`")
+	capacityMargin := fs.Float64("capacity-margin", 1, "retain only while the next subgraph fits in this fraction of fast memory, within (0, 1]")
+	compat := fs.String("compat", "latest", "pin heuristic decisions to an earlier release: v1.0, v1.1, v1.2, v1.3, v1.4, v1.5, v1.6, v1.7 or latest")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: ./mlsys retain -problem <path_to_input.json> -solution <path_to_solution.json> [flags]")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if *problemPath == "" || *solutionPath == "" || fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	stage := "parsing options"
+	defer recoverCrash(fs, args, *problemPath, &stage, nil)
+
+	var opts mlsys.Options
+	var err error
+	if opts.UnknownOps, err = mlsys.ParseUnknownOpPolicy(*unknownOp); err != nil {
+		exit(exitUsage, err.Error())
+	}
+	if opts.Compat, err = mlsys.ParseCompatLevel(*compat); err != nil {
+		exit(exitUsage, err.Error())
+	}
+	opts.CapacityMargin = parseCapacityMargin(*capacityMargin)
+	stage = "reading the problem"
+	problem, err := readProblemWithHardware(*problemPath, *hwPath, *dialect)
+	if err != nil {
+		exit(exitInvalidProblem, err.Error())
+	}
+	if err := checkProblem(problem); err != nil {
+		exit(exitInvalidProblem, err.Error())
+	}
+	stage = "reading the solution"
+	solution, err := readSolution(*solutionPath)
+	if err != nil {
+		fatal(err.Error())
+	}
+
+	stage = "computing retention"
+	before := retainedElements(problem, solution)
+	solution, err = mlsys.RetainTensors(problem, solution, opts)
+	if err != nil {
+		fatal(err.Error())
+	}
+	fmt.Fprintf(os.Stderr, "retain: retained_elements_before=%d retained_elements_after=%d\n", before, retainedElements(problem, solution))
+	for _, d := range solution.Residuals {
+		fmt.Fprintf(os.Stderr, "retain: residual tensor=%d subgraphs=%d..%d action=%s\n", d.Tensor, d.From, d.To, d.Action)
+	}
+	stage = "writing the solution"
+	if err := writeSolution(*outPath, solution); err != nil {
+		fatal(err.Error())
+	}
+}
+
+// retainedElements is the total size of the tensors s retains, counting
+// each retention separately.
+func retainedElements(p mlsys.InputProblem, s mlsys.OutputSolution) int64 {
+	var n int64
+	for _, ts := range s.TensorsToRetain {
+		for _, t := range ts {
+			if t >= 0 && t < len(p.Widths) {
+				n += p.Widths[t] * p.Heights[t]
+			}
+		}
+	}
+	return n
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"mlsys"
+)
+
+// runTile schedules a problem with a user-provided grouping of its ops,
+// choosing only granularities and retained tensors.
+func runTile(args []string) {
+	fs := flag.NewFlagSet("mlsys tile", flag.ContinueOnError)
+	problemPath := fs.String("problem", "", "the problem `path`")
+	partitionPath := fs.String("partition", "", "`path` to the grouping: a JSON object whose \"subgraphs\" lists the op groups in execution order, such as an earlier solution")
+	outPath := fs.String("o", "solution.json", "write the schedule to this `path`")
+	unknownOp := fs.String("unknown-op", "elementwise", "handling of unregistered op types: error, elementwise or opaque")
+	hwPath := fs.String("hw", "", "take the hardware description from this `path` instead of the problem")
+	dialect := fs.String("dialect", "", "read the problem's field names in a dialect: camel, or the mapping file at this `path`")
+	compat := fs.String("compat", "latest", "pin heuristic decisions to an earlier release: v1.0, v1.1, v1.2, v1.3, v1.4, v1.5, v1.6, v1.7 or latest")
+	emitDeps := fs.Bool("emit-deps", false, "add the subgraph dependency edge list to the solution")
+	emitOrders := fs.Bool("emit-op-orders", false, "add an op order per subgraph that minimizes the live intermediate tensors")
+	emitDMA := fs.Bool("dma-stats", false, "add the DMA descriptor counts and sizes of every subgraph to the solution")
+	capacityMargin := fs.Float64("capacity-margin", 1, "fill at most this fraction of fast memory, leaving the rest as headroom, within (0, 1]")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: ./mlsys tile -problem <path_to_input.json> -partition <path_to_partition.json> [flags]")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if *problemPath == "" || *partitionPath == "" || fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	stage := "parsing options"
+	defer recoverCrash(fs, args, *problemPath, &stage, nil)
+
+	var opts mlsys.Options
+	var err error
+	if opts.UnknownOps, err = mlsys.ParseUnknownOpPolicy(*unknownOp); err != nil {
+		exit(exitUsage, err.Error())
+	}
+	if opts.Compat, err = mlsys.ParseCompatLevel(*compat); err != nil {
+		exit(exitUsage, err.Error())
+	}
+	opts.EmitDependencies = *emitDeps
+	opts.EmitOpOrders = *emitOrders
+	opts.EmitDMAStats = *emitDMA
+	opts.CapacityMargin = parseCapacityMargin(*capacityMargin)
+
+	stage = "reading the problem"
+	problem, err := readProblemWithHardware(*problemPath, *hwPath, *dialect)
+	if err != nil {
+		exit(exitInvalidProblem, err.Error())
+	}
+	if err := checkProblem(problem); err != nil {
+		exit(exitInvalidProblem, err.Error())
+	}
+	stage = "reading the partition"
+	part, err := readSolution(*partitionPath)
+	if err != nil {
+		fatal(err.Error())
+	}
+	// Barrier entries are placed anew from the problem.
+	var partition [][]int
+	for _, ops := range part.Subgraphs {
+		if len(ops) > 0 {
+			partition = append(partition, ops)
+		}
+	}
+
+	stage = "tiling"
+	solution, tileErr := mlsys.TilePartition(context.Background(), problem, partition, opts)
+	status := 0
+	switch {
+	case tileErr == nil:
+	case errors.Is(tileErr, mlsys.ErrInfeasible) && len(solution.Subgraphs) > 0:
+		status = exitInfeasible
+		fmt.Fprintf(os.Stderr, "error: %v; writing the schedule anyway\n", tileErr)
+	case errors.Is(tileErr, mlsys.ErrInfeasible):
+		exit(exitInfeasible, tileErr.Error())
+	default:
+		exit(exitInvalidProblem, tileErr.Error())
+	}
+	stage = "writing the solution"
+	logSolutionLatency(solution)
+	if err := writeSolution(*outPath, solution); err != nil {
+		fatal(err.Error())
+	}
+	os.Exit(status)
+}
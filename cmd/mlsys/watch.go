@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"mlsys"
+)
+
+// fileStamp is what watchSolve compares to notice a changed file.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+	missing bool
+}
+
+func stampFiles(paths []string) []fileStamp {
+	stamps := make([]fileStamp, len(paths))
+	for i, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			stamps[i].missing = true
+			continue
+		}
+		stamps[i] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+	}
+	return stamps
+}
+
+// watchSolve runs solve now and again whenever one of paths changes, until
+// ctx is done, polling every interval. After every solve it prints one line
+// comparing the schedule with the previous one. Errors are reported and
+// the watch goes on.
+func watchSolve(ctx context.Context, paths []string, interval time.Duration, solve func(context.Context) (mlsys.OutputSolution, int, error)) {
+	var prev *mlsys.OutputSolution
+	stamps := stampFiles(paths)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		s, _, err := solve(ctx)
+		switch {
+		case ctx.Err() != nil:
+			return
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "watch: error: %v; waiting for the next change\n", err)
+		default:
+			fmt.Fprintln(os.Stderr, watchDelta(prev, s))
+			prev = &s
+		}
+		for changed := false; !changed; {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			now := stampFiles(paths)
+			for i := range now {
+				changed = changed || now[i] != stamps[i]
+			}
+			stamps = now
+		}
+	}
+}
+
+// watchDelta summarizes s against the previous schedule, if any, in one
+// line: the total latency and subgraph count and how they moved, and how
+// many subgraphs of s are new, with other ops or another tile.
+func watchDelta(prev *mlsys.OutputSolution, s mlsys.OutputSolution) string {
+	line := fmt.Sprintf("watch: total_latency=%.4f subgraphs=%d", s.TotalLatency(), len(s.Subgraphs))
+	if prev == nil {
+		return line
+	}
+	old := make(map[string]bool, len(prev.Subgraphs))
+	for i, ops := range prev.Subgraphs {
+		old[fmt.Sprint(ops, prev.Granularities[i])] = true
+	}
+	changed := 0
+	for i, ops := range s.Subgraphs {
+		if !old[fmt.Sprint(ops, s.Granularities[i])] {
+			changed++
+		}
+	}
+	delta := s.TotalLatency() - prev.TotalLatency()
+	pct := 0.0
+	if prev.TotalLatency() != 0 {
+		pct = 100 * delta / prev.TotalLatency()
+	}
+	return fmt.Sprintf("%s delta_latency=%+.4f (%+.2f%%) delta_subgraphs=%+d changed_subgraphs=%d",
+		line, delta, pct, len(s.Subgraphs)-len(prev.Subgraphs), changed)
+}
@@ -0,0 +1,91 @@
+package mlsys
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CompatLevel pins the solver's heuristics to the decisions of an earlier
+// release, so that upgrading for fixes does not change schedules. The zero
+// value, CompatLatest, follows the current heuristics.
+//
+// Behaviour that only triggers on optional input fields is not versioned:
+// an input that lacked the field produced the same schedule before it
+// existed.
+type CompatLevel int
+
+const (
+	CompatLatest CompatLevel = iota
+	// CompatV1_0 runs every op as its own subgraph with the largest tile
+	// that fits.
+	CompatV1_0
+	// CompatV1_1 adds the neighbour merge and memory-bound split passes.
+	CompatV1_1
+	// CompatV1_2 picks each tile by modeled latency rather than area.
+	CompatV1_2
+	// CompatV1_3 charges fast memory for the tensors a subgraph produces
+	// and consumes internally.
+	CompatV1_3
+	// CompatV1_4 fuses runs of memory-bound elementwise ops before the
+	// merge pass, beyond its group-size cap.
+	CompatV1_4
+	// CompatV1_5 lifts the merge pass's cap of maxGroupSize ops per
+	// subgraph.
+	CompatV1_5
+	// CompatV1_6 models the op types registered since v1.5 (GELU, Exp,
+	// LayerNorm, BatchNorm, Gather, Quantize and Dequantize) with their own
+	// semantics, where below it they are modeled as unknown op types were,
+	// and charges the partial-sum traffic of reductions split over several
+	// steps.
+	CompatV1_6
+	// CompatV1_7 credits the inputs a subgraph finds retained in fast
+	// memory instead of loading them, and searches the retained tensors
+	// together with the partition.
+	CompatV1_7
+)
+
+// currentCompat is the level CompatLatest currently stands for. Bump it,
+// and add a level above, whenever a change alters schedules for inputs that
+// were valid before.
+const currentCompat = CompatV1_7
+
+var compatNames = map[string]CompatLevel{
+	"v1.0": CompatV1_0,
+	"v1.1": CompatV1_1,
+	"v1.2": CompatV1_2,
+	"v1.3": CompatV1_3,
+	"v1.4": CompatV1_4,
+	"v1.5": CompatV1_5,
+	"v1.6": CompatV1_6,
+	"v1.7": CompatV1_7,
+}
+
+// ParseCompatLevel parses a --compat value such as "v1.1". The empty string
+// and "latest" select CompatLatest.
+func ParseCompatLevel(s string) (CompatLevel, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" || s == "latest" {
+		return CompatLatest, nil
+	}
+	if !strings.HasPrefix(s, "v") {
+		s = "v" + s
+	}
+	if c, ok := compatNames[s]; ok {
+		return c, nil
+	}
+	return 0, fmt.Errorf("unknown compat level %q (want latest or v1.0 through %s)", s, currentCompat)
+}
+
+func (c CompatLevel) String() string {
+	for name, level := range compatNames {
+		if level == c {
+			return name
+		}
+	}
+	return "latest"
+}
+
+// atLeast reports whether behaviour introduced at level v is enabled.
+func (c CompatLevel) atLeast(v CompatLevel) bool {
+	return c == CompatLatest || c >= v
+}
@@ -0,0 +1,700 @@
+package mlsys
+
+import (
+	"math"
+	"sort"
+	"strconv"
+)
+
+// graphIndex holds the producer/consumer relations that group analysis
+// needs, built once per problem.
+type graphIndex struct {
+	producers [][]int
+	consumers [][]int
+	// costPrefix[i] is the summed base cost of ops [0, i), so any op range
+	// costs the same however it was assembled.
+	costPrefix []float64
+	// opTypes holds each op's registry entry, resolved once.
+	opTypes []opTypeInfo
+	// pinned marks tensors resident in fast memory for the whole run.
+	pinned []bool
+	// tiles holds each op's tile constraints.
+	tiles []tileRule
+	// root is the tensor holding each tensor's storage: its base for a
+	// view, itself otherwise. views lists, per base, the indices into
+	// p.Views of its views.
+	root  []int
+	views [][]int
+	// misaligned marks views whose tiles are not tiles of their base.
+	misaligned []bool
+	// buffersIntermediates is set when tensors produced and consumed
+	// inside a group take fast memory, except between the producer and
+	// consumer pairs in registerFused.
+	buffersIntermediates bool
+	registerFused        map[[2]int]bool
+	// memory is the fast memory holding each tensor, nil when fast memory
+	// is pooled.
+	memory []int
+	// quantized marks the tensors that hold quantized elements, nil when
+	// there are none.
+	quantized []bool
+	// serialized runs every group standalone; see Options.serialized.
+	serialized bool
+	// partialSums charges the partial-sum traffic of split reductions.
+	partialSums bool
+	// creditsRetention spares a subgraph loading the inputs the one before
+	// it retains. It needs a single fast memory in scratchpad mode, whose
+	// capacity the retained tensors are taken from.
+	creditsRetention bool
+}
+
+func buildGraphIndex(p InputProblem, opts Options) graphIndex {
+	gi := graphIndex{
+		producers:  make([][]int, len(p.Widths)),
+		consumers:  make([][]int, len(p.Widths)),
+		costPrefix: make([]float64, len(p.OpTypes)+1),
+		opTypes:    make([]opTypeInfo, len(p.OpTypes)),
+		pinned:     make([]bool, len(p.Widths)),
+		tiles:      opTileRules(p),
+		root:       make([]int, len(p.Widths)),
+		views:      make([][]int, len(p.Widths)),
+		misaligned: make([]bool, len(p.Widths)),
+	}
+	for t := range gi.root {
+		gi.root[t] = t
+	}
+	for i, v := range p.Views {
+		gi.root[v.Tensor] = v.Base
+		gi.views[v.Base] = append(gi.views[v.Base], i)
+		gi.misaligned[v.Tensor] = !v.tileCompatible(p)
+	}
+	for _, t := range opts.pinned {
+		gi.pinned[t] = true
+	}
+	gi.buffersIntermediates = opts.Compat.atLeast(CompatV1_3)
+	gi.serialized = opts.serialized
+	gi.partialSums = opts.Compat.atLeast(CompatV1_6)
+	gi.creditsRetention = opts.Compat.atLeast(CompatV1_7) && len(p.FastMemories) == 0 && !pooledCache(p)
+	if len(p.RegisterFusable) > 0 {
+		gi.registerFused = make(map[[2]int]bool, len(p.RegisterFusable))
+		for _, pair := range p.RegisterFusable {
+			gi.registerFused[pair] = true
+		}
+	}
+	for op, name := range p.OpTypes {
+		gi.opTypes[op], _ = lookupOpType(name, opts.UnknownOps, opts.Compat)
+		gi.costPrefix[op+1] = gi.costPrefix[op] + gi.opCost(p, op)
+		for _, t := range p.Inputs[op] {
+			gi.consumers[t] = append(gi.consumers[t], op)
+		}
+		for _, t := range p.Outputs[op] {
+			gi.producers[t] = append(gi.producers[t], op)
+		}
+	}
+	// A view is produced with its base, and reading it reads the base.
+	for _, v := range p.Views {
+		gi.producers[v.Tensor] = gi.producers[v.Base]
+		gi.consumers[v.Base] = append(gi.consumers[v.Base], gi.consumers[v.Tensor]...)
+		gi.pinned[v.Tensor] = gi.pinned[v.Tensor] || gi.pinned[v.Base]
+	}
+	gi.memory = tensorMemories(p, gi.producers)
+	gi.quantized = quantizedTensors(p, gi)
+	return gi
+}
+
+// operandRole says how a granularity [w, h, k] slices a boundary input.
+type operandRole int
+
+const (
+	rolePointwise  operandRole = iota // w x h
+	roleLHS                           // h x k
+	roleRHS                           // k x w
+	roleWhole                         // the entire tensor, whatever the tile
+	roleHeadRHS                       // k x w for every head the tile spans
+	roleNormalized                    // w x h, read twice unless w spans the row
+	roleGathered                      // w x one scattered row per token
+	roleIndices                       // the full width x h
+)
+
+const numOperandRoles = 8
+
+type boundaryInput struct {
+	tensor int
+	role   operandRole
+}
+
+// slot indexes the (storage, role) pair of in in a dense bitset, so that a
+// view and its base, read the same way, are loaded once. A misaligned view
+// reads different tiles than its base and keeps a slot of its own.
+func (gi graphIndex) slot(in boundaryInput) int {
+	t := in.tensor
+	if !gi.misaligned[t] {
+		t = gi.root[t]
+	}
+	return t*numOperandRoles + int(in.role)
+}
+
+// groupInfo is the boundary analysis of a candidate subgraph. Tensors that
+// are produced and fully consumed inside the group are ephemeral and never
+// appear here.
+type groupInfo struct {
+	inputs  []boundaryInput
+	outputs []int
+	// gridTensor is the boundary output that defines the spatial tile grid.
+	gridTensor int
+	// reduction is the deepest reduction in the group, 0 if none.
+	reduction int64
+	baseCost  float64
+	// vectorCost is the part of baseCost spent in elementwise ops, which
+	// run on the vector engine.
+	vectorCost float64
+	// tileable and fusable hold only if they hold for every op.
+	tileable bool
+	fusable  bool
+	// standalone is set when an op's transfers cannot overlap its compute.
+	standalone bool
+	// tiles is the intersection of the ops' tile constraints.
+	tiles tileRule
+	// heads is the head count of the group's multi-head ops, 1 if none.
+	heads int64
+	// span is the op range [lo, hi) when contiguous reports that the group
+	// is exactly that range in ascending order.
+	span       [2]int
+	contiguous bool
+	// branches is the group's op graph when branch parallelism is modeled.
+	branches *branchDAG
+	// intermediates counts the tensors produced and consumed inside the
+	// group that need a tile buffer of their own.
+	intermediates int64
+	// memory is graphIndex.memory.
+	memory []int
+	// quantized is graphIndex.quantized.
+	quantized []bool
+	// luts is the set of lookup tables the group's ops keep resident.
+	luts uint64
+	// partialSums is graphIndex.partialSums.
+	partialSums bool
+}
+
+// analyzeGroup derives the boundary of ops. An input from outside the group
+// is loaded once per role, however many times its ops read it, and a
+// matmul's inputs past the second, such as a bias, are read as w x h
+// tiles. This holds for single ops as well: an op listing a tensor twice
+// loads it once, and a matmul's bias costs a full tile per step, where the
+// per-op model before groups counted every listed input and ignored
+// inputs past a matmul's second. sc must be clean on entry and is left
+// clean on return.
+func analyzeGroup(p InputProblem, gi graphIndex, ops []int, sc *groupScratch) groupInfo {
+	for _, op := range ops {
+		sc.inGroup.set(op)
+		for _, t := range p.Outputs[op] {
+			sc.produced.set(t)
+			for _, i := range gi.views[t] {
+				sc.produced.set(p.Views[i].Tensor)
+			}
+		}
+	}
+
+	info := groupInfo{gridTensor: -1, contiguous: true, tileable: true, fusable: true, heads: 1, memory: gi.memory, quantized: gi.quantized, partialSums: gi.partialSums}
+	for i, op := range ops {
+		if i > 0 && op != ops[i-1]+1 {
+			info.contiguous = false
+		}
+		info.baseCost += gi.opCost(p, op)
+		ti := gi.opTypes[op]
+		info.tileable = info.tileable && ti.tileable
+		info.fusable = info.fusable && ti.fusable
+		info.standalone = info.standalone || gi.serialized || ti.compute == computeStandalone
+		info.luts |= ti.lut
+		info.tiles = info.tiles.intersect(gi.tiles[op])
+		if ti.class == classElementwise {
+			info.vectorCost += gi.opCost(p, op)
+		}
+		heads, ok := joinHeads(info.heads, opHeads(p, op))
+		info.heads, info.fusable = heads, info.fusable && ok
+		if r := ti.reductionOperand; r >= 0 && r < len(p.Inputs[op]) {
+			info.reduction = maxI64(info.reduction, p.Widths[p.Inputs[op][r]])
+		}
+		for j, t := range p.Inputs[op] {
+			if sc.produced.has(t) && gi.misaligned[t] {
+				// The view's tiles are not tiles of its base, which this
+				// group produces.
+				info.fusable = false
+			}
+			if sc.produced.has(t) || gi.pinned[t] {
+				continue
+			}
+			in := boundaryInput{tensor: t, role: ti.role(j)}
+			if in.role == roleRHS && opHeads(p, op) > 1 {
+				in.role = roleHeadRHS
+			}
+			if !sc.loaded.has(gi.slot(in)) {
+				sc.loaded.set(gi.slot(in))
+				info.inputs = append(info.inputs, in)
+			}
+		}
+	}
+
+	for _, op := range ops {
+		for _, t := range p.Outputs[op] {
+			escapes := len(gi.consumers[t]) == 0
+			for _, c := range gi.consumers[t] {
+				if !sc.inGroup.has(c) {
+					escapes = true
+					break
+				}
+			}
+			if !escapes {
+				if gi.buffered(t) {
+					info.intermediates++
+				}
+				continue
+			}
+			info.outputs = append(info.outputs, t)
+			if info.gridTensor < 0 || p.Widths[t]*p.Heights[t] > p.Widths[info.gridTensor]*p.Heights[info.gridTensor] {
+				info.gridTensor = t
+			}
+		}
+	}
+	if info.gridTensor < 0 {
+		last := ops[len(ops)-1]
+		info.gridTensor = p.Outputs[last][0]
+	}
+	if info.heads > 1 && p.Heights[info.gridTensor]%info.heads != 0 {
+		// The tile grid cannot be split along the heads.
+		info.fusable = false
+	}
+	if info.contiguous {
+		info.span = [2]int{ops[0], ops[len(ops)-1] + 1}
+		info.baseCost = gi.costPrefix[info.span[1]] - gi.costPrefix[info.span[0]]
+	}
+	info.branches = newBranchDAG(p, gi, ops)
+
+	for _, op := range ops {
+		sc.inGroup.clear(op)
+		for _, t := range p.Outputs[op] {
+			sc.produced.clear(t)
+			for _, i := range gi.views[t] {
+				sc.produced.clear(p.Views[i].Tensor)
+			}
+		}
+	}
+	for _, in := range info.inputs {
+		sc.loaded.clear(gi.slot(in))
+	}
+	return info
+}
+
+// joinGroups derives the boundary of the op range a.span ∪ b.span from the
+// boundaries of its two neighbouring halves. Ops are in topological order,
+// so nothing in a consumes b's outputs: b's boundary outputs stay outputs,
+// b's inputs produced in a become ephemeral, and a's outputs survive only
+// if something outside the joined range still reads them.
+func joinGroups(p InputProblem, gi graphIndex, a, b groupInfo, sc *groupScratch) groupInfo {
+	lo, mid, hi := a.span[0], a.span[1], b.span[1]
+	inRange := func(op, from, to int) bool { return op >= from && op < to }
+
+	info := groupInfo{
+		gridTensor:    -1,
+		reduction:     maxI64(a.reduction, b.reduction),
+		intermediates: a.intermediates + b.intermediates,
+		baseCost:      gi.costPrefix[hi] - gi.costPrefix[lo],
+		vectorCost:    a.vectorCost + b.vectorCost,
+		tileable:      a.tileable && b.tileable,
+		fusable:       a.fusable && b.fusable,
+		standalone:    a.standalone || b.standalone,
+		tiles:         a.tiles.intersect(b.tiles),
+		span:          [2]int{lo, hi},
+		contiguous:    true,
+		memory:        gi.memory,
+		quantized:     gi.quantized,
+		luts:          a.luts | b.luts,
+		partialSums:   gi.partialSums,
+	}
+	heads, ok := joinHeads(a.heads, b.heads)
+	info.heads, info.fusable = heads, info.fusable && ok
+	info.inputs = make([]boundaryInput, 0, len(a.inputs)+len(b.inputs))
+	for _, in := range a.inputs {
+		sc.loaded.set(gi.slot(in))
+		info.inputs = append(info.inputs, in)
+	}
+	for _, in := range b.inputs {
+		producedInA := false
+		for _, op := range gi.producers[in.tensor] {
+			if inRange(op, lo, mid) {
+				producedInA = true
+				break
+			}
+		}
+		if producedInA && gi.misaligned[in.tensor] {
+			info.fusable = false
+		}
+		if producedInA || sc.loaded.has(gi.slot(in)) {
+			continue
+		}
+		sc.loaded.set(gi.slot(in))
+		info.inputs = append(info.inputs, in)
+	}
+	for _, in := range info.inputs {
+		sc.loaded.clear(gi.slot(in))
+	}
+
+	addOutput := func(t int) {
+		info.outputs = append(info.outputs, t)
+		if info.gridTensor < 0 || p.Widths[t]*p.Heights[t] > p.Widths[info.gridTensor]*p.Heights[info.gridTensor] {
+			info.gridTensor = t
+		}
+	}
+	for _, t := range a.outputs {
+		escapes := len(gi.consumers[t]) == 0
+		for _, c := range gi.consumers[t] {
+			if !inRange(c, lo, hi) {
+				escapes = true
+				break
+			}
+		}
+		if escapes {
+			addOutput(t)
+		} else if gi.buffered(t) {
+			info.intermediates++
+		}
+	}
+	for _, t := range b.outputs {
+		addOutput(t)
+	}
+	if info.gridTensor < 0 {
+		info.gridTensor = b.gridTensor
+	}
+	if info.heads > 1 && p.Heights[info.gridTensor]%info.heads != 0 {
+		info.fusable = false
+	}
+	if p.ParallelBranches {
+		ops := make([]int, 0, hi-lo)
+		for op := lo; op < hi; op++ {
+			ops = append(ops, op)
+		}
+		info.branches = newBranchDAG(p, gi, ops)
+	}
+	return info
+}
+
+func chooseGranularityForGroup(p InputProblem, info groupInfo, compat CompatLevel) ([3]int64, bool) {
+	candidatesW, candidatesH, k := candidateTiles(p, info)
+	best := [3]int64{1, 1, 1}
+	if info.tiles.constrained() {
+		if len(candidatesW) == 0 || len(candidatesH) == 0 {
+			// The ops' constraints contradict each other.
+			return best, false
+		}
+		// If nothing fits, fall back to the smallest permitted tile.
+		best = [3]int64{candidatesW[len(candidatesW)-1], candidatesH[len(candidatesH)-1], k}
+	}
+	bestArea := best[0] * best[1]
+	found := false
+	// The largest tile is not always the fastest: for bandwidth-bound groups
+	// a rectangular tile can move less boundary data per output element. So
+	// every fitting candidate is scored by its modeled latency at the
+	// decision bandwidth, and area only breaks ties.
+	// Before CompatV1_2 latency was only consulted under degraded
+	// bandwidth, and otherwise the largest fitting tile won.
+	decisionBW, degraded := decisionBandwidth(p)
+	byLatency := degraded || compat.atLeast(CompatV1_2)
+	bestLat := math.Inf(1)
+
+	for _, w := range candidatesW {
+		for _, h := range candidatesH {
+			if !fitsFastMemory(p, info, w, h, k) || !withinDMALimits(p, info, w, h, k) {
+				continue
+			}
+			found = true
+			area := w * h
+			if !byLatency {
+				if area > bestArea {
+					bestArea = area
+					best = [3]int64{w, h, k}
+				}
+				continue
+			}
+			lat := estimateGroupLatencyAtBandwidth(p, info, [3]int64{w, h, k}, decisionBW)
+			if lat < bestLat || (lat == bestLat && area > bestArea) {
+				bestLat = lat
+				bestArea = area
+				best = [3]int64{w, h, k}
+			}
+		}
+	}
+	return best, found
+}
+
+// candidateTiles lists the tile widths and heights the solver considers
+// for a group, largest first, and the reduction depth k it runs with.
+func candidateTiles(p InputProblem, info groupInfo) (candidatesW, candidatesH []int64, k int64) {
+	outTensor := info.gridTensor
+	maxW := minI64(p.NativeGranularity[0], p.Widths[outTensor])
+	maxH := minI64(p.NativeGranularity[1], p.Heights[outTensor])
+	if maxW < 1 {
+		maxW = 1
+	}
+	if maxH < 1 {
+		maxH = 1
+	}
+	k = 1
+	if info.reduction > 0 {
+		k = minI64(info.reduction, 16)
+	}
+
+	candidatesW = candidateSides(maxW, info.tiles.widths, info.tiles.wMul)
+	candidatesH = candidateSides(maxH, info.tiles.heights, info.tiles.hMul)
+	if headH := headHeight(p, info); headH > 0 {
+		aligned := candidatesH[:0:0]
+		for _, h := range candidatesH {
+			if headAligned(h, headH) {
+				aligned = append(aligned, h)
+			}
+		}
+		candidatesH = aligned
+	}
+	if !info.tileable {
+		candidatesW = []int64{p.Widths[outTensor]}
+		candidatesH = []int64{p.Heights[outTensor]}
+	}
+	return candidatesW, candidatesH, k
+}
+
+// fitsFastMemory reports whether a group fits in fast memory at some
+// buffer depth.
+func fitsFastMemory(p InputProblem, info groupInfo, w, h, k int64) bool {
+	if info.memory != nil {
+		return fitsMemoriesAtDepth(p, info, w, h, k, 1)
+	}
+	if pooledCache(p) {
+		return true
+	}
+	required := singleBufferFootprint(p, info, w, h, k)
+	return float64(required) <= p.FastMemoryCapacity
+}
+
+// footprintElementsForGroup is what a group occupies in fast memory at the
+// buffer depth it runs with.
+func footprintElementsForGroup(p InputProblem, info groupInfo, w, h, k int64) int64 {
+	total := singleBufferFootprint(p, info, w, h, k)
+	if d := bufferDepth(p, info, w, h, k); d > 1 {
+		total += int64(d-1) * streamedElements(p, info, w, h, k)
+	}
+	return total
+}
+
+// singleBufferFootprint is a group's footprint with one buffer: its
+// working set, a tile for each buffered intermediate, its lookup tables
+// and, when the reduction is split over several steps, the matmul
+// accumulator tile that holds partial sums between them. Intermediates and
+// the accumulator never move to slow memory, and the tables only once, so
+// they are not part of the working set that drives traffic.
+func singleBufferFootprint(p InputProblem, info groupInfo, w, h, k int64) int64 {
+	total := workingSetElementsForGroup(p, info, w, h, k) + info.intermediates*w*h + lutElements(p, info.luts)
+	splitK := info.reduction > maxI64(1, k)
+	if p.AccumulatorDType != "" && splitK {
+		total += int64(math.Ceil(float64(w*h) * accumulatorRatio(p)))
+	}
+	return total
+}
+
+func workingSetElementsForGroup(p InputProblem, info groupInfo, w, h, k int64) int64 {
+	k = maxI64(1, k)
+	var total int64
+	spanned := tileHeads(p, info, h)
+	for _, in := range info.inputs {
+		total += tensorElements(p, info, in.tensor, inputTileElements(p, in, w, h, k, spanned))
+	}
+	if !info.tileable {
+		// Untiled groups move every output whole.
+		for _, t := range info.outputs {
+			total += tensorElements(p, info, t, p.Widths[t]*p.Heights[t])
+		}
+		return total
+	}
+	// Every step moves one output tile. Under a split reduction that is
+	// the partial sum; stepClassesInOrder charges reading it back, or
+	// drops the write while an accumulator holds it.
+	total += outputTileElements(p, info, w, h)
+	return total
+}
+
+// inputTileElements is the size of the slice of a boundary input that one
+// step of granularity [w, h, k], spanning the given number of heads, reads.
+func inputTileElements(p InputProblem, in boundaryInput, w, h, k, heads int64) int64 {
+	k = maxI64(1, k)
+	switch in.role {
+	case roleLHS:
+		return h * k
+	case roleRHS:
+		return w * k
+	case roleHeadRHS:
+		return w * k * heads
+	case roleWhole:
+		return p.Widths[in.tensor] * p.Heights[in.tensor]
+	case roleGathered:
+		return w * gatheredRows(p, h)
+	case roleIndices:
+		return p.Widths[in.tensor] * h
+	default:
+		return w * h
+	}
+}
+
+func estimateSubgraphLatency(p InputProblem, info groupInfo, g [3]int64) float64 {
+	return estimateGroupLatencyAtBandwidth(p, info, g, p.SlowMemoryBandwidth)
+}
+
+// estimateGroupLatencyAtBandwidth evaluates the roofline model with an
+// explicit slow-memory bandwidth, so the same model serves nominal and
+// degraded-bandwidth estimates. The dispatch overhead is paid once per
+// subgraph, whatever the bandwidth.
+func estimateGroupLatencyAtBandwidth(p InputProblem, info groupInfo, g [3]int64, bandwidth float64) float64 {
+	_, computePerStep, _ := stepCosts(p, info, g, bandwidth)
+	depth := bufferDepth(p, info, g[0], g[1], g[2])
+	return p.SubgraphDispatchOverhead + classLatency(info, depth, stepClasses(p, info, g, bandwidth), computePerStep, bandwidth)
+}
+
+// stepCosts breaks a subgraph down into its number of execution steps and
+// the compute time of each step. memPerStep is the memory time of a step
+// that moves the full working set, which every step does unless operands
+// are reused (see stepClasses).
+func stepCosts(p InputProblem, info groupInfo, g [3]int64, bandwidth float64) (nSteps int64, computePerStep, memPerStep float64) {
+	w, h, k := g[0], g[1], g[2]
+	loops := tileLoops(p, info, g)
+	nSteps = stepCount(loops)
+	computePerStep = info.baseCost
+	if p.VectorWidth > 0 {
+		computePerStep += info.vectorCost * (vectorScale(p, w, h) - 1)
+	}
+	if info.branches != nil {
+		computePerStep, _ = info.branches.schedule(p, stepVectorScale(p, w, h))
+	}
+	computePerStep += bankConflictTime(p, info, w, h, k)
+	memPerStep = float64(workingSetElementsForGroup(p, info, w, h, k)+rereadElements(p, info, w, h, k))/bandwidth +
+		workingSetRowOverhead(p, info, w, h, k) + p.SlowMemoryLatency
+	return nSteps, computePerStep, memPerStep
+}
+
+// vectorScale is the vector-engine time of a w x h tile relative to a
+// native tile. The engine processes each row in ceil(w/VectorWidth) vector
+// operations, so cost tracks the tile size instead of padding to the native
+// tile, while rows that do not fill whole vectors pay for the unused lanes.
+func vectorScale(p InputProblem, w, h int64) float64 {
+	v := p.VectorWidth
+	native := p.NativeGranularity[1] * ceilDiv(p.NativeGranularity[0], v)
+	return float64(h*ceilDiv(w, v)) / float64(native)
+}
+
+// p95Bandwidth returns the bandwidth delivered at the 5th percentile, which
+// is what drives the 95th-percentile latency. Without a distribution it is
+// the interference-adjusted bandwidth.
+func p95Bandwidth(p InputProblem) float64 {
+	d := p.BandwidthDistribution
+	if d == nil {
+		return interferenceBandwidth(p)
+	}
+	if len(d.Percentiles) > 0 {
+		return percentileBandwidth(d.Percentiles, 5) - p.BackgroundDRAMTraffic
+	}
+	// One-sided 95% quantile of a normal distribution.
+	return interferenceBandwidth(p) - 1.645*d.Std
+}
+
+// percentilePoint is one point of BandwidthDistribution.Percentiles.
+type percentilePoint struct {
+	pct, bandwidth float64
+}
+
+// percentilePoints parses the points of a distribution, sorted by
+// percentile. Keys that are not numbers are skipped; validation rejects
+// them.
+func percentilePoints(points map[string]float64) []percentilePoint {
+	out := make([]percentilePoint, 0, len(points))
+	for key, bw := range points {
+		if pct, err := strconv.ParseFloat(key, 64); err == nil {
+			out = append(out, percentilePoint{pct, bw})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].pct < out[j].pct })
+	return out
+}
+
+// percentileBandwidth is the bandwidth at percentile pct, interpolated
+// linearly between the nearest given points, or extrapolated from the two
+// nearest when pct lies outside them. A single point stands for every
+// percentile.
+func percentileBandwidth(points map[string]float64, pct float64) float64 {
+	pts := percentilePoints(points)
+	switch len(pts) {
+	case 0:
+		return 0
+	case 1:
+		return pts[0].bandwidth
+	}
+	i := sort.Search(len(pts), func(i int) bool { return pts[i].pct >= pct })
+	if i < len(pts) && pts[i].pct == pct {
+		return pts[i].bandwidth
+	}
+	i = min(max(i, 1), len(pts)-1)
+	a, b := pts[i-1], pts[i]
+	return a.bandwidth + (pct-a.pct)*(b.bandwidth-a.bandwidth)/(b.pct-a.pct)
+}
+
+// interferenceBandwidth is the nominal bandwidth minus the share taken by
+// background DRAM traffic.
+func interferenceBandwidth(p InputProblem) float64 {
+	return p.SlowMemoryBandwidth - p.BackgroundDRAMTraffic
+}
+
+// decisionBandwidth picks the bandwidth tile choices are optimized against:
+// the P95 bandwidth when a distribution is given, the interference-adjusted
+// bandwidth when only background traffic is, and otherwise the nominal one.
+// The boolean reports whether the result differs from the nominal model.
+func decisionBandwidth(p InputProblem) (float64, bool) {
+	if p.BandwidthDistribution != nil {
+		return p95Bandwidth(p), true
+	}
+	if p.BackgroundDRAMTraffic > 0 {
+		return interferenceBandwidth(p), true
+	}
+	return p.SlowMemoryBandwidth, false
+}
+
+func descendingPowersOfTwo(max int64) []int64 {
+	vals := make([]int64, 0)
+	v := int64(1)
+	for v*2 <= max {
+		v *= 2
+	}
+	for v >= 1 {
+		vals = append(vals, v)
+		v /= 2
+	}
+	if len(vals) == 0 {
+		return []int64{1}
+	}
+	return vals
+}
+
+func ceilDiv(a, b int64) int64 {
+	if b <= 0 {
+		return 0
+	}
+	return (a + b - 1) / b
+}
+
+func minI64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxI64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,126 @@
+package mlsys
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// DecodeProblem reads a problem from r without buffering the whole
+// document. The per-tensor and per-op arrays, which make up nearly all of a
+// large file, are decoded one element at a time, so peak memory is the
+// decoded problem plus a small read buffer rather than both the raw bytes
+// and the decoded structure.
+func DecodeProblem(r io.Reader) (InputProblem, error) {
+	return DecodeProblemDialect(r, Dialect{})
+}
+
+// DecodeProblemDialect is DecodeProblem for a problem whose field names
+// follow dialect d.
+func DecodeProblemDialect(r io.Reader, d Dialect) (InputProblem, error) {
+	dec := json.NewDecoder(bufio.NewReaderSize(r, 1<<16))
+	var fields map[string]reflect.Type
+	if !d.isZero() {
+		fields = jsonFieldTypes(reflect.TypeOf(InputProblem{}))
+	}
+	var p InputProblem
+	if err := expectDelim(dec, '{'); err != nil {
+		return InputProblem{}, err
+	}
+
+	// Everything that is not a bulk array is small; collect it and let the
+	// struct tags place it, so new scalar fields need no changes here.
+	rest := make(map[string]json.RawMessage)
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return InputProblem{}, err
+		}
+		key, ok := tok.(string)
+		if !ok {
+			return InputProblem{}, fmt.Errorf("expected object key, got %v", tok)
+		}
+		if fields != nil {
+			key = d.fieldName(key, fields)
+		}
+		switch key {
+		case "widths":
+			err = decodeArray(dec, &p.Widths)
+		case "heights":
+			err = decodeArray(dec, &p.Heights)
+		case "inputs":
+			err = decodeArray(dec, &p.Inputs)
+		case "outputs":
+			err = decodeArray(dec, &p.Outputs)
+		case "base_costs":
+			err = decodeArray(dec, &p.BaseCosts)
+		case "op_types":
+			err = decodeArray(dec, &p.OpTypes)
+		default:
+			var raw json.RawMessage
+			err = dec.Decode(&raw)
+			if err == nil && fields != nil {
+				raw, err = d.rewriteRaw(raw, fields[key])
+			}
+			rest[key] = raw
+		}
+		if err != nil {
+			return InputProblem{}, fmt.Errorf("field %q: %w", key, err)
+		}
+	}
+	if err := expectDelim(dec, '}'); err != nil {
+		return InputProblem{}, err
+	}
+
+	if len(rest) > 0 {
+		data, err := json.Marshal(rest)
+		if err != nil {
+			return InputProblem{}, err
+		}
+		if err := json.Unmarshal(data, &p); err != nil {
+			return InputProblem{}, err
+		}
+	}
+	return p, nil
+}
+
+// decodeArray decodes a JSON array (or null) into dst element by element.
+func decodeArray[T any](dec *json.Decoder, dst *[]T) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok == nil {
+		*dst = nil
+		return nil
+	}
+	if d, ok := tok.(json.Delim); !ok || d != '[' {
+		return fmt.Errorf("expected array, got %v", tok)
+	}
+	out := make([]T, 0)
+	for dec.More() {
+		var v T
+		if err := dec.Decode(&v); err != nil {
+			return err
+		}
+		out = append(out, v)
+	}
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+	*dst = out
+	return nil
+}
+
+func expectDelim(dec *json.Decoder, want json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if d, ok := tok.(json.Delim); !ok || d != want {
+		return fmt.Errorf("expected %q, got %v", want, tok)
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package mlsys
+
+// Transformer graphs repeat one block of ops many times. With
+// Options.DedupeBlocks, Solve looks for the longest run of consecutive,
+// identical blocks, searches for a partition of the first only, and stamps
+// it out over the others, so the search costs about as much as for a
+// single block. A block is identical to the one before it when its ops
+// have the same types, costs and tensor shapes, and read the tensors the
+// corresponding ops of that block read shifted by one block length, the
+// same tensors, or graph inputs of the same shapes. No subgraph spans two
+// blocks. Every stamped subgraph must fingerprint as its original does
+// under the group cache's key, which covers everything the tile choice
+// depends on, so it reuses the original's tile; if one does not, or the
+// problem has fixed subgraphs, the problem is solved without
+// deduplication.
+
+// minRepeatedBlock is the fewest ops a block must have to be deduplicated.
+// Smaller periods are runs of one op type rather than model blocks, and
+// fusing across them is worth more than the search time saved.
+const minRepeatedBlock = 4
+
+// RepeatedBlock describes the repetition in a schedule that Solve
+// deduplicated: Count blocks of Ops ops each, starting at op FirstOp, and
+// scheduled as Subgraphs subgraphs each starting at FirstSubgraph, each
+// block's a copy of the first's with op indices shifted by Ops.
+type RepeatedBlock struct {
+	FirstOp       int `json:"first_op"`
+	Ops           int `json:"ops"`
+	Count         int `json:"count"`
+	FirstSubgraph int `json:"first_subgraph"`
+	Subgraphs     int `json:"subgraphs"`
+}
+
+// repeatedBlock is the repetition found in a problem, count < 2 when none.
+type repeatedBlock struct {
+	start, length, count int
+}
+
+// block returns the block that holds op, 0 before the first and count+1
+// after the last.
+func (b repeatedBlock) block(op int) int {
+	switch {
+	case op < b.start:
+		return 0
+	case op >= b.start+b.count*b.length:
+		return b.count + 1
+	}
+	return 1 + (op-b.start)/b.length
+}
+
+// findRepeatedBlock returns the repetition that leaves the fewest ops to
+// search, preferring shorter blocks on ties.
+func findRepeatedBlock(p InputProblem, gi graphIndex) repeatedBlock {
+	var best repeatedBlock
+	n := len(p.OpTypes)
+	if len(p.FixedSubgraphs) > 0 {
+		return best
+	}
+	for length := minRepeatedBlock; 2*length <= n; length++ {
+		// A run of ops each matching the op one block later, starting at
+		// start, covers run/length+1 blocks.
+		run := 0
+		for op := 0; op+length <= n; op++ {
+			if op+length < n && sameOpShifted(p, gi, op, length) {
+				run++
+				continue
+			}
+			start := op - run
+			if count := run/length + 1; count >= 2 && (count-1)*length > (best.count-1)*best.length {
+				best = repeatedBlock{start: start, length: length, count: count}
+			}
+			run = 0
+		}
+	}
+	return best
+}
+
+// sameOpShifted reports whether op+shift is a copy of op: same type, cost
+// and tensor shapes, reading what op reads shifted by shift, the same
+// tensor, or a graph input of the same shape.
+func sameOpShifted(p InputProblem, gi graphIndex, op, shift int) bool {
+	other := op + shift
+	if canonicalOpType(p.OpTypes[op]) != canonicalOpType(p.OpTypes[other]) ||
+		p.BaseCosts[op] != p.BaseCosts[other] ||
+		len(p.Inputs[op]) != len(p.Inputs[other]) || len(p.Outputs[op]) != len(p.Outputs[other]) {
+		return false
+	}
+	sameShape := func(a, b int) bool { return p.Widths[a] == p.Widths[b] && p.Heights[a] == p.Heights[b] }
+	for i, t := range p.Inputs[op] {
+		u := p.Inputs[other][i]
+		if !sameShape(t, u) {
+			return false
+		}
+		if t == u {
+			continue
+		}
+		pt, pu := gi.producers[t], gi.producers[u]
+		if len(pt) != len(pu) {
+			return false
+		}
+		for j := range pt {
+			if pu[j] != pt[j]+shift {
+				return false
+			}
+		}
+	}
+	for i, t := range p.Outputs[op] {
+		if !sameShape(t, p.Outputs[other][i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// separateBlocks stops the passes from fusing ops of different blocks.
+func (pl *planner) separateBlocks(b repeatedBlock) {
+	for op := range pl.segment {
+		pl.segment[op] = pl.segment[op]*(b.count+2) + b.block(op)
+	}
+}
+
+// stampRepeatedBlock inserts copies of the plans of the first block of b
+// for the others after them. plans must hold every op outside blocks
+// 2 through count, in order. It reports false when a copy does not
+// fingerprint as its original or would fuse ops the problem keeps apart.
+func stampRepeatedBlock(pl *planner, plans []subgraphPlan, b repeatedBlock) ([]subgraphPlan, bool) {
+	first, end := -1, len(plans)
+	for i, plan := range plans {
+		if blk := b.block(plan.ops[0]); blk == 1 && first < 0 {
+			first = i
+		} else if blk > 1 {
+			end = i
+			break
+		}
+	}
+	if first < 0 {
+		return plans, false
+	}
+	header := groupCacheHeader(pl.p, pl.compat)
+	bw, _ := decisionBandwidth(pl.p)
+	sc := newGroupScratch(pl.p)
+	out := append([]subgraphPlan{}, plans[:end]...)
+	for k := 1; k < b.count; k++ {
+		for _, orig := range plans[first:end] {
+			ops := make([]int, len(orig.ops))
+			for i, op := range orig.ops {
+				ops[i] = op + k*b.length
+				if pl.region[ops[i]] != pl.region[ops[0]] || pl.segment[ops[i]] != pl.segment[ops[0]] {
+					return plans, false
+				}
+			}
+			info := analyzeGroup(pl.p, pl.gi, ops, sc)
+			if groupCacheKey(pl.p, header, info) != groupCacheKey(pl.p, header, orig.info) {
+				return plans, false
+			}
+			g := orig.granularity
+			out = append(out, subgraphPlan{
+				ops:         ops,
+				info:        info,
+				granularity: g,
+				latency:     estimateSubgraphLatency(pl.p, info, g),
+				objective:   estimateGroupLatencyAtBandwidth(pl.p, info, g, bw),
+			})
+		}
+	}
+	return append(out, plans[end:]...), true
+}
+
+// reportRepeatedBlock describes the repetition b in s, or returns nil when
+// s does not repeat the schedule of the first block over the others.
+func reportRepeatedBlock(s OutputSolution, b repeatedBlock) *RepeatedBlock {
+	if b.count < 2 {
+		return nil
+	}
+	first := s.SubgraphForOp(b.start)
+	per := s.SubgraphForOp(b.start+b.length) - first
+	if first < 0 || per <= 0 || first+b.count*per > len(s.Subgraphs) {
+		return nil
+	}
+	for k := 1; k < b.count; k++ {
+		for i := first; i < first+per; i++ {
+			a, c := s.Subgraphs[i], s.Subgraphs[i+k*per]
+			if len(a) != len(c) || s.Granularities[i] != s.Granularities[i+k*per] {
+				return nil
+			}
+			for j := range a {
+				if c[j] != a[j]+k*b.length {
+					return nil
+				}
+			}
+		}
+	}
+	return &RepeatedBlock{FirstOp: b.start, Ops: b.length, Count: b.count, FirstSubgraph: first, Subgraphs: per}
+}
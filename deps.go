@@ -0,0 +1,84 @@
+package mlsys
+
+import "sort"
+
+// DependencyEdge says that subgraph To reads Tensor, which subgraph From
+// produces. Tensor is -1 for the ordering edges into and out of a barrier.
+type DependencyEdge struct {
+	From   int `json:"from"`
+	To     int `json:"to"`
+	Tensor int `json:"tensor"`
+}
+
+// SubgraphDependencies derives the subgraph-level dependency edges of s, so
+// that a runtime can run independent subgraphs out of order or in parallel.
+// Every subgraph between two barriers feeds the later barrier and depends on
+// the earlier one. Edges are sorted by To, then From, then Tensor.
+// s must only reference ops of p.
+func SubgraphDependencies(p InputProblem, s OutputSolution) []DependencyEdge {
+	entryOf := make([]int, len(p.OpTypes))
+	for i := range entryOf {
+		entryOf[i] = -1
+	}
+	for i, ops := range s.Subgraphs {
+		for _, op := range ops {
+			entryOf[op] = i
+		}
+	}
+	producer := make([]int, len(p.Widths))
+	for i := range producer {
+		producer[i] = -1
+	}
+	for op, outs := range p.Outputs {
+		for _, t := range outs {
+			producer[t] = op
+		}
+	}
+	for _, v := range p.Views {
+		producer[v.Tensor] = producer[v.Base]
+	}
+
+	type key struct{ from, tensor int }
+	var edges []DependencyEdge
+	lastBarrier := -1
+	for j, ops := range s.Subgraphs {
+		if len(ops) == 0 {
+			for i := lastBarrier + 1; i < j; i++ {
+				edges = append(edges, DependencyEdge{From: i, To: j, Tensor: -1})
+			}
+			if lastBarrier >= 0 && lastBarrier+1 == j {
+				edges = append(edges, DependencyEdge{From: lastBarrier, To: j, Tensor: -1})
+			}
+			lastBarrier = j
+			continue
+		}
+		if lastBarrier >= 0 {
+			edges = append(edges, DependencyEdge{From: lastBarrier, To: j, Tensor: -1})
+		}
+		seen := make(map[key]bool)
+		for _, op := range ops {
+			for _, t := range p.Inputs[op] {
+				if producer[t] < 0 {
+					continue
+				}
+				i := entryOf[producer[t]]
+				if i < 0 || i == j || seen[key{i, t}] {
+					continue
+				}
+				seen[key{i, t}] = true
+				edges = append(edges, DependencyEdge{From: i, To: j, Tensor: t})
+			}
+		}
+	}
+	sort.Slice(edges, func(a, b int) bool {
+		x, y := edges[a], edges[b]
+		if x.To != y.To {
+			return x.To < y.To
+		}
+		if x.From != y.From {
+			return x.From < y.From
+		}
+		return x.Tensor < y.Tensor
+	})
+	return edges
+}
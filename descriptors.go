@@ -0,0 +1,182 @@
+package mlsys
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Runtimes feed DMA engines through descriptor queues of bounded depth,
+// and short transfers waste most of a descriptor's setup. Each tile a step
+// moves is one descriptor when contiguous and one per row otherwise (see
+// dma.go), so the tile choice fixes how many descriptors of what sizes a
+// schedule issues. Two optional hardware limits constrain it:
+//
+//   - MaxDMADescriptors caps the descriptors one step may enqueue.
+//   - MinDMATransferSize is the fewest elements a descriptor may move,
+//     unless it moves a whole tensor or lookup table, which cannot be made
+//     larger.
+//
+// Tiles that break either limit are not considered, and ValidateSolution
+// rejects schedules that use them. Cache misses (see memorymodes.go) are
+// refills the cache issues itself, and are not counted.
+
+// DMAStats counts the DMA descriptors one subgraph issues over its run.
+type DMAStats struct {
+	Descriptors int64 `json:"descriptors"`
+	// MaxStepDescriptors is the most descriptors any one step enqueues.
+	MaxStepDescriptors int64 `json:"max_step_descriptors"`
+	// Transfers counts the descriptors by size in elements, smallest first.
+	Transfers []DMATransfers `json:"transfers"`
+}
+
+// DMATransfers is a number of descriptors of one size.
+type DMATransfers struct {
+	Elements int64 `json:"elements"`
+	Count    int64 `json:"count"`
+}
+
+func validateDMALimits(p InputProblem) error {
+	if p.MaxDMADescriptors < 0 {
+		return errors.New("max_dma_descriptors must be >= 0")
+	}
+	if p.MinDMATransferSize < 0 {
+		return errors.New("min_dma_transfer_size must be >= 0")
+	}
+	return nil
+}
+
+// tileDescriptors is the number and size of the descriptors that move a
+// rows x cols tile of t.
+func tileDescriptors(p InputProblem, t int, cols, rows int64) (count, size int64) {
+	cols = minI64(cols, p.Widths[t])
+	rows = minI64(rows, p.Heights[t])
+	if cols == p.Widths[t] && rowPitch(p, t) == p.Widths[t] {
+		return 1, cols * rows
+	}
+	return rows, cols
+}
+
+// stepDescriptors calls fn with the tensor, count and size of each run of
+// descriptors one step of class c issues. Lookup tables, loaded in one
+// descriptor, are tensor -1.
+func stepDescriptors(p InputProblem, info groupInfo, g [3]int64, c stepClass, fn func(t int, count, size int64)) {
+	w, h, k := g[0], g[1], g[2]
+	spanned := tileHeads(p, info, h)
+	for _, in := range info.inputs {
+		if c.moves(in.role) {
+			cols, rows := inputTileShape(p, in, w, h, k, spanned)
+			count, size := tileDescriptors(p, in.tensor, cols, rows)
+			if in.role == roleGathered {
+				count, size = rows, cols
+			}
+			fn(in.tensor, count*inputPasses(p, in, w), size)
+		}
+	}
+	if c.lut > 0 {
+		fn(-1, 1, c.lut)
+	}
+	if !c.moves(rolePointwise) {
+		return
+	}
+	for _, t := range info.outputs {
+		cols, rows := w, h
+		if !info.tileable {
+			cols, rows = p.Widths[t], p.Heights[t]
+		}
+		count, size := tileDescriptors(p, t, cols, rows)
+		fn(t, count, size)
+	}
+}
+
+// groupDMAStats counts the descriptors of a group at granularity g under
+// the given step classes.
+func groupDMAStats(p InputProblem, info groupInfo, g [3]int64, classes []stepClass) DMAStats {
+	var st DMAStats
+	bySize := make(map[int64]int64)
+	for _, c := range classes {
+		var perStep int64
+		stepDescriptors(p, info, g, c, func(_ int, count, size int64) {
+			perStep += count
+			bySize[size] = addSat(bySize[size], mulSat(count, c.steps))
+		})
+		st.Descriptors = addSat(st.Descriptors, mulSat(perStep, c.steps))
+		st.MaxStepDescriptors = max(st.MaxStepDescriptors, perStep)
+	}
+	st.Transfers = make([]DMATransfers, 0, len(bySize))
+	for size, count := range bySize {
+		st.Transfers = append(st.Transfers, DMATransfers{Elements: size, Count: count})
+	}
+	sort.Slice(st.Transfers, func(i, j int) bool { return st.Transfers[i].Elements < st.Transfers[j].Elements })
+	return st
+}
+
+// checkDMALimits reports the first DMA limit a group breaks at granularity
+// g under the given step classes.
+func checkDMALimits(p InputProblem, info groupInfo, g [3]int64, classes []stepClass) error {
+	if p.MaxDMADescriptors == 0 && p.MinDMATransferSize == 0 {
+		return nil
+	}
+	var err error
+	for _, c := range classes {
+		var perStep int64
+		stepDescriptors(p, info, g, c, func(t int, count, size int64) {
+			perStep += count
+			whole := t < 0 || size == p.Widths[t]*p.Heights[t]
+			if err == nil && p.MinDMATransferSize > 0 && size < p.MinDMATransferSize && !whole {
+				err = fmt.Errorf("moves tensor %d in transfers of %d elements at tile %dx%dx%d, below the minimum of %d",
+					t, size, g[0], g[1], g[2], p.MinDMATransferSize)
+			}
+		})
+		if err != nil {
+			return err
+		}
+		if p.MaxDMADescriptors > 0 && perStep > p.MaxDMADescriptors {
+			return fmt.Errorf("enqueues %d DMA descriptors in one step at tile %dx%dx%d, above the limit of %d",
+				perStep, g[0], g[1], g[2], p.MaxDMADescriptors)
+		}
+	}
+	return nil
+}
+
+// withinDMALimits reports whether a group keeps to the DMA limits at
+// [w, h, k], under the dataflow the schedule reports for it.
+func withinDMALimits(p InputProblem, info groupInfo, w, h, k int64) bool {
+	if p.MaxDMADescriptors == 0 && p.MinDMATransferSize == 0 {
+		return true
+	}
+	g := [3]int64{w, h, k}
+	return checkDMALimits(p, info, g, stepClasses(p, info, g, p.SlowMemoryBandwidth)) == nil
+}
+
+// DMAReport counts the DMA descriptors of every subgraph of s, under the
+// dataflow each reports or else the one the cost model picks. Barriers
+// issue none.
+func DMAReport(p InputProblem, s OutputSolution, opts Options) []DMAStats {
+	gi := buildGraphIndex(p, opts)
+	sc := newGroupScratch(p)
+	stats := make([]DMAStats, len(s.Subgraphs))
+	for i, ops := range s.Subgraphs {
+		if len(ops) == 0 {
+			stats[i].Transfers = []DMATransfers{}
+			continue
+		}
+		info := analyzeGroup(p, gi, sortedUnique(ops), sc)
+		stats[i] = groupDMAStats(p, info, s.Granularities[i], solutionStepClasses(p, info, s, i))
+	}
+	return stats
+}
+
+// solutionStepClasses is the step classes of subgraph i of s, under the
+// dataflow it reports or else the one the cost model picks.
+func solutionStepClasses(p InputProblem, info groupInfo, s OutputSolution, i int) []stepClass {
+	g := s.Granularities[i]
+	if s.SubgraphDataflows != nil && p.OperandReuse && info.tileable {
+		for _, d := range dataflows {
+			if d.name == s.SubgraphDataflows[i] {
+				return stepClassesInOrder(p, info, g, d.order)
+			}
+		}
+	}
+	return stepClasses(p, info, g, p.SlowMemoryBandwidth)
+}
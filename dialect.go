@@ -0,0 +1,130 @@
+package mlsys
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// A Dialect maps the field names another tool writes problems with onto
+// the ones DecodeProblem expects, so that exporters need no conversion
+// step. It applies to the keys of every object that stands for a struct:
+// the problem and the objects nested in it, such as fast_memories entries
+// and views. Keys of maps whose keys are data, such as lut_elements, are
+// left as they are.
+type Dialect struct {
+	// Fields maps a tool's field names to the names used here, e.g.
+	// "tensorWidths" to "widths".
+	Fields map[string]string `json:"fields,omitempty"`
+	// CamelCase also accepts every field in camelCase or any other
+	// spelling that differs only in case and underscores, e.g.
+	// fastMemoryCapacity for fast_memory_capacity.
+	CamelCase bool `json:"camel_case,omitempty"`
+}
+
+// CamelCaseDialect reads problems whose field names are in camelCase.
+var CamelCaseDialect = Dialect{CamelCase: true}
+
+// ReadDialect reads a dialect mapping file, a JSON object of the form
+// {"camel_case": true, "fields": {"tensorWidths": "widths"}}.
+func ReadDialect(r io.Reader) (Dialect, error) {
+	var d Dialect
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&d); err != nil {
+		return Dialect{}, fmt.Errorf("dialect: %w", err)
+	}
+	for from, to := range d.Fields {
+		if from == "" || to == "" {
+			return Dialect{}, errors.New("dialect: field names must not be empty")
+		}
+	}
+	return d, nil
+}
+
+func (d Dialect) isZero() bool {
+	return len(d.Fields) == 0 && !d.CamelCase
+}
+
+// fieldName is the name key stands for among fields, the JSON names of a
+// struct's fields; keys it cannot place are returned unchanged.
+func (d Dialect) fieldName(key string, fields map[string]reflect.Type) string {
+	if name, ok := d.Fields[key]; ok {
+		return name
+	}
+	if _, ok := fields[key]; ok || !d.CamelCase {
+		return key
+	}
+	for name := range fields {
+		if foldFieldName(name) == foldFieldName(key) {
+			return name
+		}
+	}
+	return key
+}
+
+func foldFieldName(s string) string {
+	return strings.ToLower(strings.ReplaceAll(s, "_", ""))
+}
+
+// rewrite renames the struct keys in v, a decoded JSON value, for the Go
+// type t it decodes into. t is nil for values without a known type.
+func (d Dialect) rewrite(v any, t reflect.Type) any {
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == nil {
+		return v
+	}
+	switch x := v.(type) {
+	case map[string]any:
+		switch t.Kind() {
+		case reflect.Struct:
+			fields := jsonFieldTypes(t)
+			out := make(map[string]any, len(x))
+			for k, e := range x {
+				name := d.fieldName(k, fields)
+				out[name] = d.rewrite(e, fields[name])
+			}
+			return out
+		case reflect.Map:
+			for k, e := range x {
+				x[k] = d.rewrite(e, t.Elem())
+			}
+		}
+	case []any:
+		if t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+			for i, e := range x {
+				x[i] = d.rewrite(e, t.Elem())
+			}
+		}
+	}
+	return v
+}
+
+// rewriteRaw renames the struct keys in the encoded value raw of type t.
+func (d Dialect) rewriteRaw(raw json.RawMessage, t reflect.Type) (json.RawMessage, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(d.rewrite(v, t))
+}
+
+// jsonFieldTypes maps the JSON names of the fields of struct type t to
+// their types.
+func jsonFieldTypes(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		if name := jsonFieldName(t.Field(i)); name != "" {
+			fields[name] = t.Field(i).Type
+		}
+	}
+	return fields
+}
@@ -0,0 +1,130 @@
+package mlsys
+
+import (
+	"errors"
+	"fmt"
+)
+
+// A DMA transfer of a tile is one burst when the tile is a contiguous run
+// of its tensor's storage: whole rows of a tensor whose row pitch equals
+// its width. Any other tile is strided, and moves as one descriptor per
+// row, each paying DMARowOverhead on top of the bandwidth cost. Which tile
+// shapes that favours depends on each tensor's layout: narrow tiles of a
+// wide tensor pay for every row, so short-wide tiles win there.
+//
+// Every descriptor, a burst or a row, also pays DMATransferLatency before
+// its data streams at full bandwidth. A transfer of n elements therefore
+// runs at n / (latency*bandwidth + n) of peak: tiny tiles spend most of
+// their time in setup, and larger ones approach the peak.
+
+func validateRowPitches(p InputProblem) error {
+	if p.DMARowOverhead < 0 {
+		return errors.New("dma_row_overhead must be >= 0")
+	}
+	if p.DMATransferLatency < 0 {
+		return errors.New("dma_transfer_latency must be >= 0")
+	}
+	if p.TensorRowPitches == nil {
+		return nil
+	}
+	if len(p.TensorRowPitches) != len(p.Widths) {
+		return fmt.Errorf("tensor_row_pitches has %d entries for %d tensors", len(p.TensorRowPitches), len(p.Widths))
+	}
+	for t, pitch := range p.TensorRowPitches {
+		if pitch != 0 && pitch < p.Widths[t] {
+			return fmt.Errorf("tensor %d: row pitch %d is below its width %d", t, pitch, p.Widths[t])
+		}
+	}
+	return nil
+}
+
+// rowPitch returns the distance in elements between the starts of two
+// rows of t: its declared pitch, its stride within its base for a view,
+// or else its width.
+func rowPitch(p InputProblem, t int) int64 {
+	if p.TensorRowPitches != nil && p.TensorRowPitches[t] > 0 {
+		return p.TensorRowPitches[t]
+	}
+	for _, v := range p.Views {
+		if v.Tensor == t {
+			return v.stride(p)
+		}
+	}
+	return p.Widths[t]
+}
+
+// tileRowOverhead is the descriptor overhead of moving a rows x cols tile
+// of t: the latency of each descriptor, and the row overhead of each row
+// unless the tile is contiguous.
+func tileRowOverhead(p InputProblem, t int, cols, rows int64) float64 {
+	if p.DMARowOverhead == 0 && p.DMATransferLatency == 0 {
+		return 0
+	}
+	count, _ := tileDescriptors(p, t, cols, rows)
+	overhead := float64(count) * p.DMATransferLatency
+	if w := p.Widths[t]; cols < w || rowPitch(p, t) != w {
+		overhead += float64(count) * p.DMARowOverhead
+	}
+	return overhead
+}
+
+// inputTileOverhead is the descriptor overhead of the slice of a boundary
+// input one step of granularity [w, h, k] reads; see inputTileElements.
+func inputTileOverhead(p InputProblem, in boundaryInput, w, h, k, heads int64) float64 {
+	cols, rows := inputTileShape(p, in, w, h, k, heads)
+	if in.role == roleGathered {
+		return gatherRowOverhead(p, rows)
+	}
+	return float64(inputPasses(p, in, w)) * tileRowOverhead(p, in.tensor, cols, rows)
+}
+
+// inputTileShape is the columns and rows of the slice of a boundary input
+// one step of granularity [w, h, k] reads.
+func inputTileShape(p InputProblem, in boundaryInput, w, h, k, heads int64) (cols, rows int64) {
+	k = maxI64(1, k)
+	t := in.tensor
+	switch in.role {
+	case roleLHS:
+		return k, h
+	case roleRHS:
+		return w, k
+	case roleHeadRHS:
+		return w, k * heads
+	case roleWhole:
+		return p.Widths[t], p.Heights[t]
+	case roleGathered:
+		return w, gatheredRows(p, h)
+	case roleIndices:
+		return p.Widths[t], h
+	default:
+		return w, h
+	}
+}
+
+// outputTileOverhead is the descriptor overhead of writing back the
+// output tiles of one step.
+func outputTileOverhead(p InputProblem, info groupInfo, w, h int64) float64 {
+	var total float64
+	for _, t := range info.outputs {
+		if info.tileable {
+			total += tileRowOverhead(p, t, w, h)
+		} else {
+			total += tileRowOverhead(p, t, p.Widths[t], p.Heights[t])
+		}
+	}
+	return total
+}
+
+// workingSetRowOverhead is the descriptor overhead of a step that moves
+// the full working set.
+func workingSetRowOverhead(p InputProblem, info groupInfo, w, h, k int64) float64 {
+	if p.DMARowOverhead == 0 && p.DMATransferLatency == 0 {
+		return 0
+	}
+	spanned := tileHeads(p, info, h)
+	total := outputTileOverhead(p, info, w, h)
+	for _, in := range info.inputs {
+		total += inputTileOverhead(p, in, w, h, k, spanned)
+	}
+	return total
+}
@@ -0,0 +1,48 @@
+package mlsys
+
+import (
+	"fmt"
+	"strings"
+)
+
+// dtypeSizes maps the data types the model understands to their size in
+// bytes.
+var dtypeSizes = map[string]int{
+	"int8": 1, "fp8": 1,
+	"fp16": 2, "bf16": 2,
+	"int32": 4, "fp32": 4,
+	"fp64": 8,
+}
+
+// defaultDataDType is the element type tensors are assumed to hold when
+// data_dtype is not given.
+const defaultDataDType = "fp16"
+
+func dtypeSize(name string) (int, bool) {
+	size, ok := dtypeSizes[strings.ToLower(name)]
+	return size, ok
+}
+
+func validateDTypes(p InputProblem) error {
+	for field, name := range map[string]string{"data_dtype": p.DataDType, "accumulator_dtype": p.AccumulatorDType, "quantized_dtype": p.QuantizedDType} {
+		if name == "" {
+			continue
+		}
+		if _, ok := dtypeSize(name); !ok {
+			return fmt.Errorf("%s: unknown data type %q", field, name)
+		}
+	}
+	return nil
+}
+
+// accumulatorRatio is the size of an accumulator element in tensor
+// elements, e.g. 2 for fp32 accumulators over fp16 data.
+func accumulatorRatio(p InputProblem) float64 {
+	data := p.DataDType
+	if data == "" {
+		data = defaultDataDType
+	}
+	dataSize, _ := dtypeSize(data)
+	accSize, _ := dtypeSize(p.AccumulatorDType)
+	return float64(accSize) / float64(dataSize)
+}
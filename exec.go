@@ -0,0 +1,227 @@
+package mlsys
+
+import (
+	"fmt"
+	"math"
+)
+
+// DefaultCheckElements bounds the total tensor size CheckExecution accepts
+// unless the caller asks for more; the interpreter is meant for small
+// problems.
+const DefaultCheckElements = 1 << 22
+
+// CheckExecution runs p twice on synthetic data with a reference
+// interpreter: once op by op in index order, and once following s, where a
+// subgraph only sees graph inputs, boundary outputs earlier subgraphs wrote
+// back to slow memory, and the tensors the previous subgraph retained. It
+// reports the first read of a tensor the schedule has not made available,
+// an op that runs twice or never, or a graph output that differs between
+// the two runs. Zero-op subgraphs are barriers: they may not retain
+// anything, and nothing retained before them survives.
+//
+// Execution is at subgraph granularity: tiles are not simulated, since
+// every tile of a subgraph sees the same set of tensors.
+func CheckExecution(p InputProblem, s OutputSolution, opts Options, maxElements int64) error {
+	if err := checkOpTypes(p, opts.UnknownOps); err != nil {
+		return err
+	}
+	var total int64
+	for t := range p.Widths {
+		if p.Widths[t] <= 0 || p.Heights[t] <= 0 {
+			return fmt.Errorf("tensor %d has an empty shape", t)
+		}
+		total += p.Widths[t] * p.Heights[t]
+	}
+	if total > maxElements {
+		return fmt.Errorf("problem has %d tensor elements, above the interpreter limit of %d", total, maxElements)
+	}
+	if len(s.TensorsToRetain) != 0 && len(s.TensorsToRetain) != len(s.Subgraphs) {
+		return fmt.Errorf("tensors_to_retain has %d entries for %d subgraphs", len(s.TensorsToRetain), len(s.Subgraphs))
+	}
+	gi := buildGraphIndex(p, opts)
+
+	reference := make([][]float64, len(p.Widths))
+	seedGraphInputs(p, gi, reference)
+	for op := range p.OpTypes {
+		read := func(t int) ([]float64, error) {
+			if reference[t] == nil {
+				return nil, fmt.Errorf("op %d reads tensor %d before it is produced; ops must be in topological order", op, t)
+			}
+			return reference[t], nil
+		}
+		if err := interpretOp(p, gi, op, read, reference); err != nil {
+			return err
+		}
+	}
+
+	slow := make([][]float64, len(p.Widths))
+	seedGraphInputs(p, gi, slow)
+	var retained map[int][]float64
+	ran := make([]bool, len(p.OpTypes))
+	sc := newGroupScratch(p)
+	for i, ops := range s.Subgraphs {
+		if len(ops) == 0 {
+			// A barrier: the sync drops whatever was retained.
+			if len(s.TensorsToRetain) > 0 && len(s.TensorsToRetain[i]) > 0 {
+				return fmt.Errorf("subgraph %d is a barrier but retains tensors", i)
+			}
+			retained = nil
+			continue
+		}
+		for _, op := range ops {
+			if op < 0 || op >= len(p.OpTypes) {
+				return fmt.Errorf("subgraph %d: op index out of range: %d", i, op)
+			}
+			if ran[op] {
+				return fmt.Errorf("subgraph %d: op %d already ran", i, op)
+			}
+			ran[op] = true
+		}
+
+		local := make([][]float64, len(p.Widths))
+		for _, op := range ops {
+			read := func(t int) ([]float64, error) {
+				switch {
+				case local[t] != nil:
+					return local[t], nil
+				case retained[t] != nil:
+					return retained[t], nil
+				case slow[t] != nil:
+					return slow[t], nil
+				}
+				return nil, fmt.Errorf("subgraph %d: op %d reads tensor %d, which is neither in slow memory nor retained", i, op, t)
+			}
+			if err := interpretOp(p, gi, op, read, local); err != nil {
+				return err
+			}
+		}
+
+		info := analyzeGroup(p, gi, ops, sc)
+		for _, t := range info.outputs {
+			slow[t] = local[t]
+			materializeViews(p, gi, t, slow)
+		}
+		next := make(map[int][]float64)
+		if len(s.TensorsToRetain) > 0 {
+			for _, t := range s.TensorsToRetain[i] {
+				if t < 0 || t >= len(p.Widths) {
+					return fmt.Errorf("subgraph %d: retained tensor index out of range: %d", i, t)
+				}
+				switch {
+				case local[t] != nil:
+					next[t] = local[t]
+				case retained[t] != nil:
+					next[t] = retained[t]
+				case slow[t] != nil && groupReads(p, ops, t):
+					next[t] = slow[t]
+				default:
+					return fmt.Errorf("subgraph %d retains tensor %d, which it neither produced nor loaded", i, t)
+				}
+			}
+		}
+		retained = next
+	}
+	for op, ok := range ran {
+		if !ok {
+			return fmt.Errorf("op %d is not in any subgraph", op)
+		}
+	}
+
+	for t := range p.Widths {
+		if len(gi.producers[t]) == 0 || len(gi.consumers[t]) > 0 {
+			continue
+		}
+		if slow[t] == nil {
+			return fmt.Errorf("graph output tensor %d is never written back to slow memory", t)
+		}
+		for j := range reference[t] {
+			if slow[t][j] != reference[t][j] {
+				return fmt.Errorf("graph output tensor %d differs from the reference at element %d: %g != %g", t, j, slow[t][j], reference[t][j])
+			}
+		}
+	}
+	return nil
+}
+
+func groupReads(p InputProblem, ops []int, t int) bool {
+	for _, op := range ops {
+		for _, in := range p.Inputs[op] {
+			if in == t {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// seedGraphInputs fills every tensor without a producer with deterministic
+// synthetic data, and views of such tensors with the matching slice.
+func seedGraphInputs(p InputProblem, gi graphIndex, mem [][]float64) {
+	for t := range p.Widths {
+		if len(gi.producers[t]) > 0 || gi.root[t] != t {
+			continue
+		}
+		data := make([]float64, p.Widths[t]*p.Heights[t])
+		for j := range data {
+			data[j] = float64((t*7919+j*104729)%2003)/1001 - 1
+		}
+		mem[t] = data
+		materializeViews(p, gi, t, mem)
+	}
+}
+
+// interpretOp computes op's outputs into dst. The arithmetic is synthetic
+// but depends on every input element the op's class reads, so a schedule
+// that feeds an op the wrong data changes the result. Operand shapes that
+// do not line up are wrapped rather than rejected.
+func interpretOp(p InputProblem, gi graphIndex, op int, read func(int) ([]float64, error), dst [][]float64) error {
+	ins := make([][]float64, len(p.Inputs[op]))
+	for j, t := range p.Inputs[op] {
+		data, err := read(t)
+		if err != nil {
+			return err
+		}
+		ins[j] = data
+	}
+	for o, t := range p.Outputs[op] {
+		w, h := p.Widths[t], p.Heights[t]
+		out := make([]float64, w*h)
+		bias := float64(o) * 0.5
+		switch {
+		case gi.opTypes[op].class == classMatMul && len(ins) >= 2:
+			lhs, rhs := p.Inputs[op][0], p.Inputs[op][1]
+			wa, ha := p.Widths[lhs], p.Heights[lhs]
+			wb, hb := p.Widths[rhs], p.Heights[rhs]
+			for y := int64(0); y < h; y++ {
+				for x := int64(0); x < w; x++ {
+					sum := 0.0
+					for k := int64(0); k < wa; k++ {
+						sum += ins[0][k%wa+(y%ha)*wa] * ins[1][x%wb+(k%hb)*wb]
+					}
+					out[x+y*w] = math.Tanh(sum/float64(wa) + bias)
+				}
+			}
+		case gi.opTypes[op].class == classOpaque:
+			mix := 0.0
+			for _, in := range ins {
+				for _, v := range in {
+					mix += v
+				}
+			}
+			for j := range out {
+				out[j] = math.Tanh(mix/float64(len(out)) + float64(j%7) + bias)
+			}
+		default:
+			for j := range out {
+				sum := bias
+				for k, in := range ins {
+					sum += in[j%len(in)] * float64(k+1)
+				}
+				out[j] = math.Tanh(sum)
+			}
+		}
+		dst[t] = out
+		materializeViews(p, gi, t, dst)
+	}
+	return nil
+}
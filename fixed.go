@@ -0,0 +1,117 @@
+package mlsys
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Fixed subgraphs are op groups decided before the solver runs, such as
+// vendor-fused kernels. Each runs as exactly one subgraph: the passes
+// neither merge other ops into it nor split it, but its granularity is
+// chosen like any other's. A fixed subgraph is scheduled where its last op
+// would be, so ops listed between its members must not consume what they
+// produce.
+
+func validateFixedSubgraphs(p InputProblem) error {
+	if len(p.FixedSubgraphs) == 0 {
+		return nil
+	}
+	nOps := len(p.OpTypes)
+	region, _ := regionIndex(p)
+	segment := barrierSegments(p)
+	base := make(map[int]int, len(p.Views))
+	for _, v := range p.Views {
+		base[v.Tensor] = v.Base
+	}
+	root := func(t int) int {
+		if b, ok := base[t]; ok {
+			return b
+		}
+		return t
+	}
+
+	owner := make([]int, nOps)
+	for op := range owner {
+		owner[op] = -1
+	}
+	for i, ops := range p.FixedSubgraphs {
+		if len(ops) == 0 {
+			return fmt.Errorf("fixed subgraph %d has no ops", i)
+		}
+		for _, op := range ops {
+			if op < 0 || op >= nOps {
+				return fmt.Errorf("fixed subgraph %d: op index out of range: %d", i, op)
+			}
+			if owner[op] >= 0 {
+				return fmt.Errorf("fixed subgraph %d: op %d is already in fixed subgraph %d", i, op, owner[op])
+			}
+			owner[op] = i
+			if region[op] != region[ops[0]] || segment[op] != segment[ops[0]] {
+				return fmt.Errorf("fixed subgraph %d: ops %d and %d are in different regions or separated by a barrier", i, ops[0], op)
+			}
+		}
+	}
+	for i, ops := range p.FixedSubgraphs {
+		sorted := append([]int(nil), ops...)
+		sort.Ints(sorted)
+		written := make(map[int]bool)
+		for op := sorted[0]; op < sorted[len(sorted)-1]; op++ {
+			if owner[op] == i {
+				for _, t := range p.Outputs[op] {
+					written[root(t)] = true
+				}
+				continue
+			}
+			for _, t := range p.Inputs[op] {
+				if written[root(t)] {
+					return fmt.Errorf("fixed subgraph %d: op %d reads tensor %d from inside it but runs before its last op", i, op, t)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// fixedSubgraphAt maps the last op of each fixed subgraph to its sorted op
+// list, and every other member to nil.
+func fixedSubgraphAt(p InputProblem) map[int][]int {
+	if len(p.FixedSubgraphs) == 0 {
+		return nil
+	}
+	at := make(map[int][]int)
+	for _, ops := range p.FixedSubgraphs {
+		sorted := append([]int(nil), ops...)
+		sort.Ints(sorted)
+		for _, op := range sorted {
+			at[op] = nil
+		}
+		at[sorted[len(sorted)-1]] = sorted
+	}
+	return at
+}
+
+// checkFixedSubgraphs reports whether every fixed subgraph of p is a
+// subgraph of s.
+func checkFixedSubgraphs(p InputProblem, s OutputSolution) error {
+	if len(p.FixedSubgraphs) == 0 {
+		return nil
+	}
+	at := make(map[int]int)
+	for i, ops := range s.Subgraphs {
+		for _, op := range ops {
+			at[op] = i
+		}
+	}
+	for i, ops := range p.FixedSubgraphs {
+		j := at[ops[0]]
+		if len(s.Subgraphs[j]) != len(ops) {
+			return fmt.Errorf("fixed subgraph %d is not scheduled as one subgraph", i)
+		}
+		for _, op := range ops {
+			if at[op] != j {
+				return fmt.Errorf("fixed subgraph %d is not scheduled as one subgraph", i)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package mlsys
+
+import (
+	"errors"
+	"math"
+)
+
+// A gather (embedding lookup) reads one row of its table, its first input,
+// for every token of the output, picked by its second input, a column of
+// indices. What it moves therefore depends on the tokens a tile covers
+// rather than on the size of the table: a step of granularity [w, h, k]
+// fetches w columns of h table rows, one descriptor per row, since the rows
+// are scattered, along with the h indices that select them. GatherLocality
+// is the fraction of a tile's lookups that repeat a row the tile already
+// fetched, as with frequent tokens, and saves their transfers.
+
+var gatherOp = opTypeInfo{
+	class:            classElementwise,
+	compute:          computePerStep,
+	operandRoles:     []operandRole{roleGathered, roleIndices},
+	reductionOperand: -1,
+	tileable:         true,
+	fusable:          true,
+}
+
+func validateGatherLocality(p InputProblem) error {
+	if p.GatherLocality < 0 || p.GatherLocality > 1 {
+		return errors.New("gather_locality must be between 0 and 1")
+	}
+	return nil
+}
+
+// gatheredRows is the number of distinct table rows a tile of h tokens
+// fetches.
+func gatheredRows(p InputProblem, h int64) int64 {
+	if p.GatherLocality == 0 {
+		return h
+	}
+	return maxI64(1, int64(math.Ceil(float64(h)*(1-p.GatherLocality))))
+}
+
+// gatherRowOverhead is the descriptor overhead of fetching rows scattered
+// table rows: every row is a descriptor of its own and pays the row
+// overhead.
+func gatherRowOverhead(p InputProblem, rows int64) float64 {
+	return float64(rows) * (p.DMATransferLatency + p.DMARowOverhead)
+}
@@ -0,0 +1,237 @@
+package mlsys
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+)
+
+// groupCacheVersion is bumped whenever the fingerprint encoding changes, so
+// that caches written by older builds are dropped rather than misread.
+const groupCacheVersion = 1
+
+// GroupCache remembers the tile chosen for candidate subgraphs across
+// solves. Entries are keyed by a fingerprint of everything the tile choice
+// depends on: the hardware description, the compat level, and the group's
+// boundary analysis with tensor and op indices replaced by the shapes,
+// costs and types they stand for. Graphs that share sub-structures, such as
+// variants of one model, therefore share entries even where their op and
+// tensor numbering differs.
+//
+// A GroupCache is safe for concurrent use. Pass it in Options.GroupCache;
+// ReadGroupCache and Write persist it.
+type GroupCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedTile
+	hits    int
+	misses  int
+}
+
+type cachedTile struct {
+	Granularity [3]int64 `json:"granularity"`
+	Fits        bool     `json:"fits"`
+}
+
+type groupCacheFile struct {
+	Version int                   `json:"version"`
+	Entries map[string]cachedTile `json:"entries"`
+}
+
+// NewGroupCache returns an empty cache.
+func NewGroupCache() *GroupCache {
+	return &GroupCache{entries: make(map[string]cachedTile)}
+}
+
+// ReadGroupCache reads a cache written by Write. A cache from a build with
+// a different fingerprint encoding is discarded, and an empty one returned.
+func ReadGroupCache(r io.Reader) (*GroupCache, error) {
+	var f groupCacheFile
+	if err := json.NewDecoder(r).Decode(&f); err != nil {
+		return nil, fmt.Errorf("group cache: %w", err)
+	}
+	c := NewGroupCache()
+	if f.Version == groupCacheVersion {
+		for k, e := range f.Entries {
+			c.entries[k] = e
+		}
+	}
+	return c, nil
+}
+
+// Write stores the cache as JSON.
+func (c *GroupCache) Write(w io.Writer) error {
+	c.mu.Lock()
+	f := groupCacheFile{Version: groupCacheVersion, Entries: c.entries}
+	err := json.NewEncoder(w).Encode(f)
+	c.mu.Unlock()
+	return err
+}
+
+// Len is the number of cached groups.
+func (c *GroupCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// Stats reports the lookups answered from the cache and those that were
+// not, since the cache was created or read.
+func (c *GroupCache) Stats() (hits, misses int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.misses
+}
+
+func (c *GroupCache) lookup(key string) (cachedTile, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if ok {
+		c.hits++
+	} else {
+		c.misses++
+	}
+	return e, ok
+}
+
+func (c *GroupCache) store(key string, e cachedTile) {
+	c.mu.Lock()
+	c.entries[key] = e
+	c.mu.Unlock()
+}
+
+// groupCacheHeader encodes the problem-wide inputs of the tile choice.
+// Fast-memory capacity is taken after any capacity margin was applied.
+func groupCacheHeader(p InputProblem, compat CompatLevel) []byte {
+	level := compat
+	if level == CompatLatest {
+		level = currentCompat
+	}
+	bw, degraded := decisionBandwidth(p)
+	buf := binary.AppendUvarint(nil, uint64(level))
+	buf = appendFloat(buf, p.FastMemoryCapacity)
+	buf = appendFloat(buf, p.SlowMemoryBandwidth)
+	buf = appendFloat(buf, bw)
+	buf = appendBool(buf, degraded)
+	buf = binary.AppendVarint(buf, p.NativeGranularity[0])
+	buf = binary.AppendVarint(buf, p.NativeGranularity[1])
+	buf = binary.AppendVarint(buf, p.VectorWidth)
+	buf = appendString(buf, p.DataDType)
+	buf = appendString(buf, p.AccumulatorDType)
+	buf = appendBool(buf, p.OperandReuse)
+	buf = appendFloat(buf, p.SubgraphDispatchOverhead)
+	buf = appendFloat(buf, p.DMARowOverhead)
+	buf = binary.AppendVarint(buf, p.FastMemoryBanks)
+	buf = appendFloat(buf, p.BankConflictCost)
+	buf = binary.AppendVarint(buf, int64(p.MaxBufferDepth))
+	if len(p.FastMemories) > 0 {
+		buf = binary.AppendUvarint(buf, uint64(len(p.FastMemories)))
+		for _, m := range p.FastMemories {
+			buf = appendFloat(buf, m.Capacity)
+		}
+		buf = binary.AppendVarint(buf, int64(memoryOfClass(p, classActivation)))
+	}
+	if p.DMATransferLatency > 0 || p.SlowMemoryLatency > 0 {
+		buf = appendFloat(buf, p.DMATransferLatency)
+		buf = appendFloat(buf, p.SlowMemoryLatency)
+	}
+	if p.MaxDMADescriptors > 0 || p.MinDMATransferSize > 0 {
+		buf = binary.AppendVarint(buf, p.MaxDMADescriptors)
+		buf = binary.AppendVarint(buf, p.MinDMATransferSize)
+	}
+	if p.QuantizedDType != "" {
+		buf = appendString(buf, p.QuantizedDType)
+	}
+	if p.GatherLocality > 0 {
+		buf = appendFloat(buf, p.GatherLocality)
+	}
+	for i, m := range memoryModes(p) {
+		if resolvedMode(m) == MemoryCache {
+			buf = binary.AppendUvarint(buf, uint64(i+1))
+		}
+	}
+	return buf
+}
+
+// groupCacheKey fingerprints an analyzed group under the given header.
+func groupCacheKey(p InputProblem, header []byte, info groupInfo) string {
+	buf := append([]byte(nil), header...)
+	tensor := func(t int) {
+		buf = binary.AppendVarint(buf, p.Widths[t])
+		buf = binary.AppendVarint(buf, p.Heights[t])
+		buf = binary.AppendVarint(buf, rowPitch(p, t))
+		if info.memory != nil {
+			buf = binary.AppendVarint(buf, int64(info.memory[t]))
+		}
+		if info.quantized != nil {
+			buf = appendBool(buf, info.quantized[t])
+		}
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(info.inputs)))
+	for _, in := range info.inputs {
+		buf = binary.AppendUvarint(buf, uint64(in.role))
+		tensor(in.tensor)
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(info.outputs)))
+	for _, t := range info.outputs {
+		tensor(t)
+	}
+	tensor(info.gridTensor)
+	buf = binary.AppendVarint(buf, info.reduction)
+	buf = appendFloat(buf, info.baseCost)
+	buf = appendFloat(buf, info.vectorCost)
+	buf = appendBool(buf, info.tileable)
+	buf = appendBool(buf, info.standalone)
+	buf = appendInts(appendBool(buf, info.tiles.widths != nil), info.tiles.widths)
+	buf = appendInts(appendBool(buf, info.tiles.heights != nil), info.tiles.heights)
+	buf = binary.AppendVarint(buf, info.tiles.wMul)
+	buf = binary.AppendVarint(buf, info.tiles.hMul)
+	buf = binary.AppendVarint(buf, info.heads)
+	buf = binary.AppendVarint(buf, info.intermediates)
+	if d := info.branches; d != nil {
+		buf = binary.AppendUvarint(buf, uint64(len(d.ops)))
+		for i := range d.ops {
+			buf = appendFloat(buf, d.cost[i])
+			buf = appendBool(buf, d.vector[i])
+			buf = binary.AppendUvarint(buf, uint64(len(d.preds[i])))
+			for _, j := range d.preds[i] {
+				buf = binary.AppendUvarint(buf, uint64(j))
+			}
+		}
+	} else {
+		buf = binary.AppendUvarint(buf, 0)
+	}
+	if info.luts != 0 {
+		buf = binary.AppendVarint(buf, lutElements(p, info.luts))
+	}
+	sum := sha256.Sum256(buf)
+	return hex.EncodeToString(sum[:])
+}
+
+func appendFloat(buf []byte, f float64) []byte {
+	return binary.LittleEndian.AppendUint64(buf, math.Float64bits(f))
+}
+
+func appendBool(buf []byte, b bool) []byte {
+	if b {
+		return append(buf, 1)
+	}
+	return append(buf, 0)
+}
+
+func appendString(buf []byte, s string) []byte {
+	return append(binary.AppendUvarint(buf, uint64(len(s))), s...)
+}
+
+func appendInts(buf []byte, xs []int64) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(xs)))
+	for _, x := range xs {
+		buf = binary.AppendVarint(buf, x)
+	}
+	return buf
+}
@@ -0,0 +1,157 @@
+package mlsys
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// Hardware is the part of a problem that describes the machine rather than
+// the model: memories, bandwidths, native tile, engines and overheads. It
+// is stored as a file of its own so that one hardware spec can be combined
+// with many graphs. The fields carry the problem's JSON names, so a
+// problem file with its graph arrays removed is a valid hardware file.
+type Hardware struct {
+	FastMemoryCapacity       float64                `json:"fast_memory_capacity"`
+	SlowMemoryBandwidth      float64                `json:"slow_memory_bandwidth"`
+	NativeGranularity        [2]int64               `json:"native_granularity"`
+	BandwidthDistribution    *BandwidthDistribution `json:"bandwidth_distribution,omitempty"`
+	BackgroundDRAMTraffic    float64                `json:"background_dram_traffic,omitempty"`
+	CostModelUncertainty     *CostModelUncertainty  `json:"cost_model_uncertainty,omitempty"`
+	VectorWidth              int64                  `json:"vector_width,omitempty"`
+	AccumulatorDType         string                 `json:"accumulator_dtype,omitempty"`
+	OperandReuse             bool                   `json:"operand_reuse,omitempty"`
+	SubgraphDispatchOverhead float64                `json:"subgraph_dispatch_overhead,omitempty"`
+	DMARowOverhead           float64                `json:"dma_row_overhead,omitempty"`
+	DMATransferLatency       float64                `json:"dma_transfer_latency,omitempty"`
+	SlowMemoryLatency        float64                `json:"slow_memory_latency,omitempty"`
+	LUTElements              map[string]int64       `json:"lut_elements,omitempty"`
+	FastMemoryBanks          int64                  `json:"fast_memory_banks,omitempty"`
+	BankConflictCost         float64                `json:"bank_conflict_cost,omitempty"`
+	MaxBufferDepth           int                    `json:"max_buffer_depth,omitempty"`
+	ParallelBranches         bool                   `json:"parallel_branches,omitempty"`
+	FastMemories             []FastMemory           `json:"fast_memories,omitempty"`
+	FastMemoryMode           string                 `json:"fast_memory_mode,omitempty"`
+	MaxDMADescriptors        int64                  `json:"max_dma_descriptors,omitempty"`
+	MinDMATransferSize       int64                  `json:"min_dma_transfer_size,omitempty"`
+}
+
+// HardwareOf extracts the hardware description of p.
+func HardwareOf(p InputProblem) Hardware {
+	return Hardware{
+		FastMemoryCapacity:       p.FastMemoryCapacity,
+		SlowMemoryBandwidth:      p.SlowMemoryBandwidth,
+		NativeGranularity:        p.NativeGranularity,
+		BandwidthDistribution:    p.BandwidthDistribution,
+		BackgroundDRAMTraffic:    p.BackgroundDRAMTraffic,
+		CostModelUncertainty:     p.CostModelUncertainty,
+		VectorWidth:              p.VectorWidth,
+		AccumulatorDType:         p.AccumulatorDType,
+		OperandReuse:             p.OperandReuse,
+		SubgraphDispatchOverhead: p.SubgraphDispatchOverhead,
+		DMARowOverhead:           p.DMARowOverhead,
+		DMATransferLatency:       p.DMATransferLatency,
+		SlowMemoryLatency:        p.SlowMemoryLatency,
+		LUTElements:              p.LUTElements,
+		FastMemoryBanks:          p.FastMemoryBanks,
+		BankConflictCost:         p.BankConflictCost,
+		MaxBufferDepth:           p.MaxBufferDepth,
+		ParallelBranches:         p.ParallelBranches,
+		FastMemories:             p.FastMemories,
+		FastMemoryMode:           p.FastMemoryMode,
+		MaxDMADescriptors:        p.MaxDMADescriptors,
+		MinDMATransferSize:       p.MinDMATransferSize,
+	}
+}
+
+// WithHardware returns p with its whole hardware description replaced by
+// hw. Fields hw leaves out take their defaults, whatever p had.
+func WithHardware(p InputProblem, hw Hardware) InputProblem {
+	p.FastMemoryCapacity = hw.FastMemoryCapacity
+	p.SlowMemoryBandwidth = hw.SlowMemoryBandwidth
+	p.NativeGranularity = hw.NativeGranularity
+	p.BandwidthDistribution = hw.BandwidthDistribution
+	p.BackgroundDRAMTraffic = hw.BackgroundDRAMTraffic
+	p.CostModelUncertainty = hw.CostModelUncertainty
+	p.VectorWidth = hw.VectorWidth
+	p.AccumulatorDType = hw.AccumulatorDType
+	p.OperandReuse = hw.OperandReuse
+	p.SubgraphDispatchOverhead = hw.SubgraphDispatchOverhead
+	p.DMARowOverhead = hw.DMARowOverhead
+	p.DMATransferLatency = hw.DMATransferLatency
+	p.SlowMemoryLatency = hw.SlowMemoryLatency
+	p.LUTElements = hw.LUTElements
+	p.FastMemoryBanks = hw.FastMemoryBanks
+	p.BankConflictCost = hw.BankConflictCost
+	p.MaxBufferDepth = hw.MaxBufferDepth
+	p.ParallelBranches = hw.ParallelBranches
+	p.FastMemories = hw.FastMemories
+	p.FastMemoryMode = hw.FastMemoryMode
+	p.MaxDMADescriptors = hw.MaxDMADescriptors
+	p.MinDMATransferSize = hw.MinDMATransferSize
+	return p
+}
+
+// DecodeHardware reads a hardware description. Unknown fields are
+// rejected, so that a misspelt parameter is not silently left at its
+// default; the problem's graph fields are the exception, and are ignored,
+// so that a full problem file can serve as a hardware file.
+func DecodeHardware(r io.Reader) (Hardware, error) {
+	var raw map[string]json.RawMessage
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return Hardware{}, err
+	}
+	if raw == nil {
+		return Hardware{}, errors.New("hardware description is not a JSON object")
+	}
+	known := make(map[string]bool)
+	for _, name := range jsonFieldNames(Hardware{}) {
+		known[name] = true
+	}
+	graph := make(map[string]bool)
+	for _, name := range jsonFieldNames(InputProblem{}) {
+		graph[name] = !known[name]
+	}
+	for key := range raw {
+		if !known[key] && !graph[key] {
+			return Hardware{}, fmt.Errorf("unknown hardware field %q", key)
+		}
+		if graph[key] {
+			delete(raw, key)
+		}
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return Hardware{}, err
+	}
+	var hw Hardware
+	if err := json.Unmarshal(data, &hw); err != nil {
+		return Hardware{}, err
+	}
+	return hw, nil
+}
+
+// jsonFieldNames lists the JSON names of the fields of the struct v.
+func jsonFieldNames(v any) []string {
+	t := reflect.TypeOf(v)
+	names := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		if name := jsonFieldName(t.Field(i)); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// jsonFieldName is the JSON name of struct field f, or "" when it is not
+// encoded.
+func jsonFieldName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if name == "-" {
+		return ""
+	}
+	return name
+}
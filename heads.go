@@ -0,0 +1,96 @@
+package mlsys
+
+import "fmt"
+
+// Multi-head ops. An op with H heads is H independent copies of itself:
+// each of its tensors stacks the H per-head blocks along its height, and
+// head i only ever reads block i of its inputs. For a matmul this means
+// every head has its own right-hand operand, so a tile that spans several
+// heads needs several right-hand tiles, while a tile within one head needs
+// only that head's.
+
+func validateOpHeads(p InputProblem) error {
+	if p.OpHeads == nil {
+		return nil
+	}
+	if len(p.OpHeads) != len(p.OpTypes) {
+		return fmt.Errorf("op_heads has %d entries for %d ops", len(p.OpHeads), len(p.OpTypes))
+	}
+	for op, heads := range p.OpHeads {
+		if heads < 0 {
+			return fmt.Errorf("op %d: head count must be >= 0", op)
+		}
+		if heads <= 1 {
+			continue
+		}
+		for _, ts := range [][]int{p.Inputs[op], p.Outputs[op]} {
+			for _, t := range ts {
+				if t < 0 || t >= len(p.Heights) {
+					continue // reported by the index checks
+				}
+				if p.Heights[t]%heads != 0 {
+					return fmt.Errorf("op %d: height %d of tensor %d is not divisible by its %d heads", op, p.Heights[t], t, heads)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// opHeads returns the head count of op, 1 when it is not split in heads.
+func opHeads(p InputProblem, op int) int64 {
+	if op < len(p.OpHeads) && p.OpHeads[op] > 1 {
+		return p.OpHeads[op]
+	}
+	return 1
+}
+
+// joinHeads combines the head counts of two parts of a group. Ops without
+// heads fit in any group; two different head counts cannot share tiles.
+func joinHeads(a, b int64) (int64, bool) {
+	switch {
+	case a == 1:
+		return b, true
+	case b == 1 || a == b:
+		return a, true
+	default:
+		return a, false
+	}
+}
+
+// headHeight is the height of one head's block of the grid tensor, or 0
+// when the group has no heads.
+func headHeight(p InputProblem, info groupInfo) int64 {
+	if info.heads <= 1 {
+		return 0
+	}
+	return p.Heights[info.gridTensor] / info.heads
+}
+
+// headAligned reports whether a tile height stays within one head or
+// covers whole heads, so that no tile straddles a head boundary.
+func headAligned(h, headH int64) bool {
+	return headH == 0 || h <= headH || h%headH == 0
+}
+
+// tileHeads is the number of heads a tile of height h spans.
+func tileHeads(p InputProblem, info groupInfo, h int64) int64 {
+	headH := headHeight(p, info)
+	if headH == 0 || h <= headH {
+		return 1
+	}
+	return h / headH
+}
+
+// headedRows is the trip count of the row loop for a tile of height h:
+// rows are tiled within each head, or whole heads at a time.
+func headedRows(p InputProblem, info groupInfo, h int64) int64 {
+	headH := headHeight(p, info)
+	if headH == 0 {
+		return ceilDiv(p.Heights[info.gridTensor], h)
+	}
+	if h <= headH {
+		return info.heads * ceilDiv(headH, h)
+	}
+	return ceilDiv(info.heads, h/headH)
+}
@@ -0,0 +1,118 @@
+package mlsys
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Integrity ties a schedule to the problem and options it was solved for,
+// so that a deployment pipeline can tell it apart from a schedule for
+// another revision of the model. Solve adds it when Options.EmitIntegrity
+// is set; VerifyIntegrity checks it. The hashes are not signatures: they
+// catch mix-ups, not tampering.
+type Integrity struct {
+	// ProblemSHA256 hashes the problem as decoded, so reformatting the
+	// file, reordering its fields or reading it through a dialect leaves
+	// it unchanged.
+	ProblemSHA256 string `json:"problem_sha256"`
+	// OptionsSHA256 hashes the options that shape the schedule: the
+	// unknown-op policy, the compat level with latest resolved to the
+	// release it stands for, the subgraph limits, the capacity margin,
+	// block deduplication, the templates, the target latency, and the
+	// partition and tiles of the previous schedule kept to with its change
+	// budget, and whether a policy made the decisions. Options that only
+	// add reports to the solution, and the group cache, are left out.
+	OptionsSHA256 string `json:"options_sha256"`
+}
+
+// integrityOptions is what OptionsSHA256 hashes.
+type integrityOptions struct {
+	UnknownOps        string             `json:"unknown_ops"`
+	Compat            string             `json:"compat"`
+	MaxSubgraphs      int                `json:"max_subgraphs"`
+	MinOpsPerSubgraph int                `json:"min_ops_per_subgraph"`
+	CapacityMargin    float64            `json:"capacity_margin"`
+	DedupeBlocks      bool               `json:"dedupe_blocks"`
+	Templates         []ScheduleTemplate `json:"templates"`
+	TargetLatency     float64            `json:"target_latency,omitempty"`
+	// Stabilize hashes the partition and tiles of the previous schedule.
+	Stabilize  string `json:"stabilize,omitempty"`
+	MaxChanges int    `json:"max_changes,omitempty"`
+	// Policy is set when a policy made the decisions, which no hash can
+	// reproduce; it only keeps such schedules from passing as searched.
+	Policy bool `json:"policy,omitempty"`
+}
+
+// ErrIntegrity is returned, wrapped, when a schedule was not solved for the
+// problem or options it is checked against.
+var ErrIntegrity = errors.New("schedule integrity check failed")
+
+// ComputeIntegrity returns the integrity hashes of p solved with opts.
+func ComputeIntegrity(p InputProblem, opts Options) (Integrity, error) {
+	problem, err := json.Marshal(p)
+	if err != nil {
+		return Integrity{}, fmt.Errorf("hash problem: %w", err)
+	}
+	compat := opts.Compat
+	if compat == CompatLatest {
+		compat = currentCompat
+	}
+	margin := opts.CapacityMargin
+	if margin == 0 {
+		margin = 1
+	}
+	var stabilize string
+	if prev := opts.Stabilize; prev != nil {
+		data, err := json.Marshal(struct {
+			Subgraphs     [][]int    `json:"subgraphs"`
+			Granularities [][3]int64 `json:"granularities"`
+		}{prev.Subgraphs, prev.Granularities})
+		if err != nil {
+			return Integrity{}, fmt.Errorf("hash previous schedule: %w", err)
+		}
+		sum := sha256.Sum256(data)
+		stabilize = hex.EncodeToString(sum[:])
+	}
+	options, err := json.Marshal(integrityOptions{
+		UnknownOps:        opts.UnknownOps.String(),
+		Compat:            compat.String(),
+		MaxSubgraphs:      opts.MaxSubgraphs,
+		MinOpsPerSubgraph: opts.MinOpsPerSubgraph,
+		CapacityMargin:    margin,
+		DedupeBlocks:      opts.DedupeBlocks,
+		Templates:         append([]ScheduleTemplate{}, opts.Templates...),
+		TargetLatency:     opts.TargetLatency,
+		Stabilize:         stabilize,
+		MaxChanges:        opts.MaxChanges,
+		Policy:            opts.Policy != nil,
+	})
+	if err != nil {
+		return Integrity{}, fmt.Errorf("hash options: %w", err)
+	}
+	ph, oh := sha256.Sum256(problem), sha256.Sum256(options)
+	return Integrity{ProblemSHA256: hex.EncodeToString(ph[:]), OptionsSHA256: hex.EncodeToString(oh[:])}, nil
+}
+
+// VerifyIntegrity checks that s carries integrity hashes and that they are
+// those of p solved with opts.
+func VerifyIntegrity(p InputProblem, s OutputSolution, opts Options) error {
+	if s.Integrity == nil {
+		return fmt.Errorf("%w: the schedule carries no integrity hashes", ErrIntegrity)
+	}
+	want, err := ComputeIntegrity(p, opts)
+	if err != nil {
+		return err
+	}
+	if s.Integrity.ProblemSHA256 != want.ProblemSHA256 {
+		return fmt.Errorf("%w: the schedule was solved for another problem (problem_sha256 %s, want %s)",
+			ErrIntegrity, s.Integrity.ProblemSHA256, want.ProblemSHA256)
+	}
+	if s.Integrity.OptionsSHA256 != want.OptionsSHA256 {
+		return fmt.Errorf("%w: the schedule was solved with other options (options_sha256 %s, want %s)",
+			ErrIntegrity, s.Integrity.OptionsSHA256, want.OptionsSHA256)
+	}
+	return nil
+}
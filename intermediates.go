@@ -0,0 +1,47 @@
+package mlsys
+
+import "fmt"
+
+// A tensor produced and fully consumed inside a subgraph never moves to
+// slow memory, but unless its producer hands it to its consumers through
+// registers, its tiles are staged in fast memory between them. Since
+// CompatV1_3 each such intermediate is charged a tile buffer; before, they
+// were free, which underestimated the footprint of every fused chain.
+// RegisterFusable marks the producer and consumer pairs that really do
+// fuse element by element; Q/DQ ops always do (see quant.go).
+
+func validateRegisterFusable(p InputProblem) error {
+	for i, pair := range p.RegisterFusable {
+		a, c := pair[0], pair[1]
+		if a < 0 || a >= len(p.OpTypes) || c < 0 || c >= len(p.OpTypes) {
+			return fmt.Errorf("register_fusable %d: op index out of range", i)
+		}
+		feeds := false
+		for _, t := range p.Outputs[a] {
+			for _, u := range p.Inputs[c] {
+				feeds = feeds || t == u
+			}
+		}
+		if !feeds {
+			return fmt.Errorf("register_fusable %d: op %d reads nothing op %d writes", i, c, a)
+		}
+	}
+	return nil
+}
+
+// buffered reports whether t, produced and fully consumed inside a group,
+// needs a tile buffer there: unless every op reading it is register-fused
+// with every op writing it, its tiles are staged in fast memory.
+func (gi graphIndex) buffered(t int) bool {
+	if !gi.buffersIntermediates {
+		return false
+	}
+	for _, producer := range gi.producers[t] {
+		for _, consumer := range gi.consumers[t] {
+			if !gi.registerFused[[2]int{producer, consumer}] && !gi.conversionEdge(producer, consumer) {
+				return true
+			}
+		}
+	}
+	return false
+}
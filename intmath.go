@@ -0,0 +1,60 @@
+package mlsys
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+// Step and element counts are exact int64s throughout, and only turn into
+// float64 times at the end. ValidateProblem keeps every tensor within
+// maxTensorElements, which float64 represents exactly, and products over a
+// subgraph's steps saturate at math.MaxInt64 instead of wrapping around, so
+// a tile too small for a huge tensor is costed as hopelessly slow rather
+// than, after a wrap to a negative count, as free.
+
+// maxTensorElements is the largest tensor ValidateProblem accepts, 2^53
+// elements.
+const maxTensorElements = 1 << 53
+
+func validateTensorSizes(p InputProblem) error {
+	for t := range p.Widths {
+		w, h := p.Widths[t], p.Heights[t]
+		if w < 0 || h < 0 {
+			return fmt.Errorf("tensor %d: negative size %dx%d", t, w, h)
+		}
+		if w > 0 && h > maxTensorElements/w {
+			return fmt.Errorf("tensor %d: %dx%d elements, above the limit of 2^53", t, w, h)
+		}
+	}
+	return nil
+}
+
+// mulSat returns a*b for non-negative a and b, or math.MaxInt64 when that
+// overflows.
+func mulSat(a, b int64) int64 {
+	hi, lo := bits.Mul64(uint64(a), uint64(b))
+	if hi != 0 || lo > math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return int64(lo)
+}
+
+// addSat returns a+b for non-negative a and b, or math.MaxInt64 when that
+// overflows.
+func addSat(a, b int64) int64 {
+	if a > math.MaxInt64-b {
+		return math.MaxInt64
+	}
+	return a + b
+}
+
+// stepCount is the number of steps of a subgraph with the given tile loop
+// trip counts, at least 1.
+func stepCount(loops [numLoops]int64) int64 {
+	n := int64(1)
+	for _, l := range loops {
+		n = mulSat(n, l)
+	}
+	return maxI64(1, n)
+}
@@ -0,0 +1,140 @@
+package mlsys
+
+import (
+	"math"
+	"testing"
+)
+
+func TestMulSat(t *testing.T) {
+	for _, tc := range []struct {
+		a, b, want int64
+	}{
+		{0, math.MaxInt64, 0},
+		{1, math.MaxInt64, math.MaxInt64},
+		{2, math.MaxInt64 / 2, math.MaxInt64 - 1},
+		{2, math.MaxInt64/2 + 1, math.MaxInt64},
+		{1 << 31, 1 << 31, 1 << 62},
+		{1 << 32, 1 << 31, math.MaxInt64},
+		{math.MaxInt64, math.MaxInt64, math.MaxInt64},
+	} {
+		if got := mulSat(tc.a, tc.b); got != tc.want {
+			t.Errorf("mulSat(%d, %d) = %d, want %d", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
+
+func TestAddSat(t *testing.T) {
+	for _, tc := range []struct {
+		a, b, want int64
+	}{
+		{0, 0, 0},
+		{math.MaxInt64, 0, math.MaxInt64},
+		{math.MaxInt64, 1, math.MaxInt64},
+		{math.MaxInt64 - 1, 1, math.MaxInt64},
+		{math.MaxInt64 / 2, math.MaxInt64/2 + 1, math.MaxInt64},
+		{math.MaxInt64 / 2, math.MaxInt64/2 + 2, math.MaxInt64},
+		{math.MaxInt64, math.MaxInt64, math.MaxInt64},
+	} {
+		if got := addSat(tc.a, tc.b); got != tc.want {
+			t.Errorf("addSat(%d, %d) = %d, want %d", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
+
+func TestStepCount(t *testing.T) {
+	for _, tc := range []struct {
+		loops [numLoops]int64
+		want  int64
+	}{
+		{[numLoops]int64{1, 1, 1}, 1},
+		{[numLoops]int64{0, 5, 5}, 1},
+		{[numLoops]int64{1 << 20, 1 << 20, 1 << 20}, 1 << 60},
+		{[numLoops]int64{1 << 21, 1 << 21, 1 << 21}, math.MaxInt64},
+		{[numLoops]int64{1 << 26, 1 << 26, 1 << 26}, math.MaxInt64},
+	} {
+		if got := stepCount(tc.loops); got != tc.want {
+			t.Errorf("stepCount(%v) = %d, want %d", tc.loops, got, tc.want)
+		}
+	}
+}
+
+func TestValidateTensorSizes(t *testing.T) {
+	for _, tc := range []struct {
+		w, h int64
+		ok   bool
+	}{
+		{1 << 26, 1 << 27, true},
+		{1 << 53, 1, true},
+		{1, 1 << 53, true},
+		{0, math.MaxInt64, true},
+		{1 << 26, 1<<27 + 1, false},
+		{1 << 53, 2, false},
+		{math.MaxInt64, math.MaxInt64, false},
+		{-1, 4, false},
+		{4, -1, false},
+	} {
+		p := InputProblem{Widths: []int64{tc.w}, Heights: []int64{tc.h}}
+		if err := validateTensorSizes(p); (err == nil) != tc.ok {
+			t.Errorf("validateTensorSizes(%dx%d) = %v, want ok=%v", tc.w, tc.h, err, tc.ok)
+		}
+	}
+}
+
+// hugeProblem is one op of the given type over square tensors with side
+// 1<<26, 2^52 elements each.
+func hugeProblem(opType string) InputProblem {
+	const side = 1 << 26
+	p := InputProblem{
+		OpTypes:             []string{opType},
+		BaseCosts:           []float64{1},
+		FastMemoryCapacity:  1 << 20,
+		SlowMemoryBandwidth: 1,
+		NativeGranularity:   [2]int64{128, 128},
+	}
+	if opType == "MatMul" {
+		p.Widths, p.Heights = []int64{side, side, side}, []int64{side, side, side}
+		p.Inputs, p.Outputs = [][]int{{0, 1}}, [][]int{{2}}
+	} else {
+		p.Widths, p.Heights = []int64{side, side}, []int64{side, side}
+		p.Inputs, p.Outputs = [][]int{{0}}, [][]int{{1}}
+	}
+	return p
+}
+
+func TestCostGroupHugeTensors(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		opType   string
+		g        [3]int64
+		steps    int64
+		traffic  int64
+		bytes    int64
+		saturate bool
+	}{
+		// 2^19 x 2^19 steps, each reading and writing a 2^14-element
+		// tile: exact, just inside int64 once counted in bytes.
+		{name: "pointwise native tile", opType: "Pointwise", g: [3]int64{128, 128, 1}, steps: 1 << 38, traffic: 1 << 53, bytes: 1 << 54},
+		// 2^78 steps at a 1x1x1 tile: every count saturates.
+		{name: "matmul unit tile", opType: "MatMul", g: [3]int64{1, 1, 1}, saturate: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := hugeProblem(tc.opType)
+			if err := validateTensorSizes(p); err != nil {
+				t.Fatalf("validateTensorSizes: %v", err)
+			}
+			c, err := CostGroup(p, []int{0}, tc.g, Options{})
+			if err != nil {
+				t.Fatalf("CostGroup: %v", err)
+			}
+			if tc.saturate {
+				tc.steps, tc.traffic, tc.bytes = math.MaxInt64, math.MaxInt64, math.MaxInt64
+			}
+			if c.Steps != tc.steps || c.Traffic != tc.traffic || c.TrafficBytes != tc.bytes {
+				t.Errorf("steps, traffic, bytes = %d, %d, %d, want %d, %d, %d", c.Steps, c.Traffic, c.TrafficBytes, tc.steps, tc.traffic, tc.bytes)
+			}
+			if !(c.Latency > 0) || math.IsInf(c.Latency, 0) {
+				t.Errorf("latency = %g, want positive and finite", c.Latency)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package mlsys
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Code generators compile one kernel per subgraph, and caching those
+// kernels across runs and models needs a name that stays the same for the
+// same kernel. With Options.KernelNameFormat, Solve names every subgraph
+// by a fingerprint of what its kernel computes: the canonical types of its
+// ops in order and how they feed each other, the shapes, row pitches and
+// quantization of the tensors they touch, the granularity and traversal
+// order, which boundary tensors are retained in fast memory, and the
+// hardware's native tile, vector width and element types. Op and tensor
+// indices are replaced by their order of appearance, so the same subgraph
+// in another graph, or elsewhere in this one, gets the same name. Base
+// costs are left out, so recalibrating the cost model renames nothing.
+
+// DefaultKernelNameFormat names kernels by their fingerprint alone.
+const DefaultKernelNameFormat = "kernel_{hash}"
+
+// kernelNameVersion is bumped whenever the fingerprint changes, so that
+// names from older builds are never reused for other kernels.
+const kernelNameVersion = 1
+
+var kernelNamePlaceholder = regexp.MustCompile(`\{[^}]*\}`)
+
+// CheckKernelNameFormat checks that format holds {hash}, so that names
+// tell kernels apart, and no placeholders but {hash}, {ops} and {tile}.
+func CheckKernelNameFormat(format string) error {
+	for _, ph := range kernelNamePlaceholder.FindAllString(format, -1) {
+		if ph != "{hash}" && ph != "{ops}" && ph != "{tile}" {
+			return fmt.Errorf("kernel name format %q: unknown placeholder %s (want {hash}, {ops} or {tile})", format, ph)
+		}
+	}
+	if !strings.Contains(format, "{hash}") {
+		return fmt.Errorf("kernel name format %q must contain {hash}", format)
+	}
+	return nil
+}
+
+// KernelNames names every subgraph of s by format, in which {hash} stands
+// for 16 hex digits of the subgraph's fingerprint, {ops} for its op types
+// joined by underscores, and {tile} for its granularity as WxHxK. Barriers
+// get empty names.
+func KernelNames(p InputProblem, s OutputSolution, opts Options, format string) ([]string, error) {
+	if err := CheckKernelNameFormat(format); err != nil {
+		return nil, err
+	}
+	if len(s.Granularities) != len(s.Subgraphs) {
+		return nil, fmt.Errorf("subgraphs/granularities length mismatch")
+	}
+	gi := buildGraphIndex(p, opts)
+	header := binary.AppendUvarint(nil, kernelNameVersion)
+	header = binary.AppendVarint(header, p.NativeGranularity[0])
+	header = binary.AppendVarint(header, p.NativeGranularity[1])
+	header = binary.AppendVarint(header, p.VectorWidth)
+	header = appendString(header, p.DataDType)
+	header = appendString(header, p.AccumulatorDType)
+	header = appendString(header, p.QuantizedDType)
+
+	names := make([]string, len(s.Subgraphs))
+	for i, ops := range s.Subgraphs {
+		if len(ops) == 0 {
+			continue
+		}
+		ops = sortedUnique(ops)
+		for _, op := range ops {
+			if op < 0 || op >= len(p.OpTypes) {
+				return nil, fmt.Errorf("subgraph %d: op index out of range: %d", i, op)
+			}
+		}
+		var before, after map[int]bool
+		if i > 0 && i-1 < len(s.TensorsToRetain) {
+			before = intSet(s.TensorsToRetain[i-1])
+		}
+		if i < len(s.TensorsToRetain) {
+			after = intSet(s.TensorsToRetain[i])
+		}
+		var order []int64
+		if i < len(s.TraversalOrders) && s.TraversalOrders[i] != nil {
+			order = *s.TraversalOrders[i]
+		}
+		g := s.Granularities[i]
+		sum := sha256.Sum256(kernelFingerprint(p, gi, header, ops, g, order, before, after))
+
+		types := make([]string, len(ops))
+		for j, op := range ops {
+			types[j] = canonicalOpType(p.OpTypes[op])
+		}
+		names[i] = strings.NewReplacer(
+			"{hash}", hex.EncodeToString(sum[:8]),
+			"{ops}", strings.Join(types, "_"),
+			"{tile}", fmt.Sprintf("%dx%dx%d", g[0], g[1], g[2]),
+		).Replace(format)
+	}
+	return names, nil
+}
+
+// kernelFingerprint encodes the kernel of ops, which must be sorted, with
+// tensors numbered in order of first use. before and after hold the
+// tensors retained into and out of the subgraph.
+func kernelFingerprint(p InputProblem, gi graphIndex, header []byte, ops []int, g [3]int64, order []int64, before, after map[int]bool) []byte {
+	buf := append([]byte(nil), header...)
+	local := make(map[int]int)
+	tensor := func(t int) {
+		if id, ok := local[t]; ok {
+			buf = binary.AppendUvarint(buf, uint64(id))
+			return
+		}
+		local[t] = len(local)
+		buf = binary.AppendUvarint(buf, uint64(local[t]))
+		buf = binary.AppendVarint(buf, p.Widths[t])
+		buf = binary.AppendVarint(buf, p.Heights[t])
+		buf = binary.AppendVarint(buf, rowPitch(p, t))
+		buf = appendBool(buf, gi.quantized != nil && gi.quantized[t])
+		buf = appendBool(buf, before[t])
+		buf = appendBool(buf, after[t])
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(ops)))
+	for _, op := range ops {
+		buf = appendString(buf, canonicalOpType(p.OpTypes[op]))
+		buf = binary.AppendUvarint(buf, uint64(len(p.Inputs[op])))
+		for _, t := range p.Inputs[op] {
+			tensor(t)
+		}
+		buf = binary.AppendUvarint(buf, uint64(len(p.Outputs[op])))
+		for _, t := range p.Outputs[op] {
+			tensor(t)
+		}
+	}
+	for _, x := range g {
+		buf = binary.AppendVarint(buf, x)
+	}
+	buf = appendInts(appendBool(buf, order != nil), order)
+	return buf
+}
+
+func intSet(xs []int) map[int]bool {
+	set := make(map[int]bool, len(xs))
+	for _, x := range xs {
+		set[x] = true
+	}
+	return set
+}
@@ -0,0 +1,169 @@
+package mlsys
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// KVCache describes the attention cache of an autoregressive decode-step
+// graph. Each listed tensor has one extent equal to the current cache
+// length, which grows by one per generated token; the extents given in
+// Widths/Heights are those of the step being scheduled by Solve.
+type KVCache struct {
+	Tensors []int `json:"tensors"`
+	// Dim is the extent that grows with the cache: "width" (the default)
+	// or "height".
+	Dim       string `json:"dim,omitempty"`
+	MinLength int64  `json:"min_length"`
+	MaxLength int64  `json:"max_length"`
+	// Buckets are ascending upper bounds of the length buckets. When empty,
+	// powers of two from MinLength up to MaxLength are used.
+	Buckets []int64 `json:"buckets,omitempty"`
+}
+
+// KVCacheBucket is the schedule for cache lengths in [MinLength, MaxLength].
+// It is planned at MaxLength, the worst case within the bucket.
+type KVCacheBucket struct {
+	MinLength int64 `json:"min_length"`
+	MaxLength int64 `json:"max_length"`
+	// KVResident reports that the cache tensors stay in fast memory across
+	// decode steps, so the schedule never loads them. Their footprint is
+	// deducted from the capacity every subgraph sees; the one-off cost of
+	// loading the cache is amortized over the steps and not included.
+	KVResident   bool           `json:"kv_resident"`
+	TotalLatency float64        `json:"total_latency"`
+	Schedule     OutputSolution `json:"schedule"`
+}
+
+func validateKVCache(p InputProblem) error {
+	kv := p.KVCache
+	if kv == nil {
+		return nil
+	}
+	if len(kv.Tensors) == 0 {
+		return errors.New("kv_cache.tensors must not be empty")
+	}
+	for _, t := range kv.Tensors {
+		if t < 0 || t >= len(p.Widths) {
+			return fmt.Errorf("kv_cache tensor index out of range: %d", t)
+		}
+	}
+	if kv.Dim != "" && kv.Dim != "width" && kv.Dim != "height" {
+		return fmt.Errorf("kv_cache.dim must be \"width\" or \"height\", got %q", kv.Dim)
+	}
+	if kv.MinLength < 1 || kv.MaxLength < kv.MinLength {
+		return errors.New("kv_cache lengths must satisfy 1 <= min_length <= max_length")
+	}
+	for i, b := range kv.Buckets {
+		if b < kv.MinLength || b > kv.MaxLength {
+			return fmt.Errorf("kv_cache bucket %d lies outside [min_length, max_length]", b)
+		}
+		if i > 0 && b <= kv.Buckets[i-1] {
+			return errors.New("kv_cache.buckets must be strictly ascending")
+		}
+	}
+	return nil
+}
+
+// kvBucketBounds returns the upper bound of every bucket; the last is always
+// MaxLength.
+func kvBucketBounds(kv *KVCache) []int64 {
+	bounds := append([]int64(nil), kv.Buckets...)
+	if len(bounds) == 0 {
+		for b := int64(1); b < kv.MaxLength; b *= 2 {
+			if b >= kv.MinLength {
+				bounds = append(bounds, b)
+			}
+		}
+	}
+	if len(bounds) == 0 || bounds[len(bounds)-1] < kv.MaxLength {
+		bounds = append(bounds, kv.MaxLength)
+	}
+	return bounds
+}
+
+// withExtent returns a copy of p in which the given tensors have their dim
+// ("width" or "height") set to length.
+func withExtent(p InputProblem, tensors []int, dim string, length int64) InputProblem {
+	q := p
+	q.Widths = append([]int64(nil), p.Widths...)
+	q.Heights = append([]int64(nil), p.Heights...)
+	for _, t := range tensors {
+		if dim == "height" {
+			q.Heights[t] = length
+		} else {
+			q.Widths[t] = length
+		}
+	}
+	return q
+}
+
+// pinTensors returns p with the footprint of tensors taken off the fast
+// memory capacity, and opts with those tensors pinned.
+func pinTensors(p InputProblem, opts Options, tensors []int) (InputProblem, Options) {
+	for _, t := range tensors {
+		p.FastMemoryCapacity -= float64(p.Widths[t] * p.Heights[t])
+	}
+	opts.pinned = append(append([]int(nil), opts.pinned...), tensors...)
+	return p, opts
+}
+
+// solveKVCacheBuckets plans one schedule per cache-length bucket. For each
+// bucket it plans with the cache streamed from slow memory and, if the
+// cache fits, with the cache pinned in fast memory, keeping the faster of
+// the two.
+func solveKVCacheBuckets(ctx context.Context, p InputProblem, opts Options) ([]KVCacheBucket, error) {
+	kv := p.KVCache
+	var buckets []KVCacheBucket
+	lo := kv.MinLength
+	for _, hi := range kvBucketBounds(kv) {
+		q := withExtent(p, kv.Tensors, kv.Dim, hi)
+		plans, err := solvePlans(ctx, q, opts)
+		if err != nil {
+			return buckets, err
+		}
+		bucket := KVCacheBucket{MinLength: lo, MaxLength: hi, TotalLatency: totalLatency(plans)}
+		bucket.Schedule = assembleSolution(q, plans)
+
+		var footprint int64
+		for _, t := range kv.Tensors {
+			footprint += q.Widths[t] * q.Heights[t]
+		}
+		if float64(footprint) < q.FastMemoryCapacity {
+			resident, pinnedOpts := pinTensors(q, opts, kv.Tensors)
+			pinnedPlans, err := solvePlans(ctx, resident, pinnedOpts)
+			if err != nil {
+				return buckets, err
+			}
+			if allFit(resident, pinnedPlans) && totalLatency(pinnedPlans) < bucket.TotalLatency {
+				bucket.KVResident = true
+				bucket.TotalLatency = totalLatency(pinnedPlans)
+				bucket.Schedule = assembleSolution(resident, pinnedPlans)
+			}
+		}
+		buckets = append(buckets, bucket)
+		lo = hi + 1
+	}
+	return buckets, nil
+}
+
+func totalLatency(plans []subgraphPlan) float64 {
+	var total compensatedSum
+	for _, plan := range plans {
+		total.Add(plan.latency)
+	}
+	return total.Total()
+}
+
+// allFit reports whether every subgraph's footprint fits in fast memory.
+// Single ops that fit nowhere are still planned with the smallest tile, so
+// a schedule can come back from the passes without fitting.
+func allFit(p InputProblem, plans []subgraphPlan) bool {
+	for _, plan := range plans {
+		if float64(footprintOf(p, plan)) > p.FastMemoryCapacity {
+			return false
+		}
+	}
+	return true
+}
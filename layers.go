@@ -0,0 +1,113 @@
+package mlsys
+
+import "fmt"
+
+// LayerLatency is the share of a schedule's latency attributed to one model
+// layer or module.
+type LayerLatency struct {
+	Layer   string  `json:"layer"`
+	Latency float64 `json:"latency"`
+	// Ops is the number of ops in the layer and Subgraphs the number of
+	// subgraphs holding at least one of them.
+	Ops       int `json:"ops"`
+	Subgraphs int `json:"subgraphs"`
+}
+
+// unassignedLayer labels ops with an empty layer name.
+const unassignedLayer = "(unassigned)"
+
+func validateOpLayers(p InputProblem) error {
+	if p.OpLayers != nil && len(p.OpLayers) != len(p.OpTypes) {
+		return fmt.Errorf("op_layers has %d entries for %d ops", len(p.OpLayers), len(p.OpTypes))
+	}
+	return nil
+}
+
+// LayerReport attributes the latency of every subgraph in s to the layers
+// of its ops, in proportion to their base costs (evenly if the subgraph has
+// no base cost), and sums it per layer. Layers are listed in order of first
+// appearance. s must only reference ops of p. It returns nil when p
+// carries no op_layers.
+func LayerReport(p InputProblem, s OutputSolution) []LayerLatency {
+	if p.OpLayers == nil {
+		return nil
+	}
+	shares := attributeLatency(p, s, func(op int) string {
+		if p.OpLayers[op] == "" {
+			return unassignedLayer
+		}
+		return p.OpLayers[op]
+	})
+	report := make([]LayerLatency, len(shares))
+	for i, sh := range shares {
+		report[i] = LayerLatency{Layer: sh.name, Latency: sh.latency.Total(), Ops: sh.ops, Subgraphs: sh.subgraphs}
+	}
+	return report
+}
+
+// OpTypeLatency is the share of a schedule's latency attributed to one op
+// type.
+type OpTypeLatency struct {
+	OpType  string  `json:"op_type"`
+	Latency float64 `json:"latency"`
+	// Ops is the number of ops of the type and Subgraphs the number of
+	// subgraphs holding at least one of them.
+	Ops       int `json:"ops"`
+	Subgraphs int `json:"subgraphs"`
+}
+
+// OpTypeReport attributes the latency of s to op types as LayerReport does
+// to layers. Types are canonical registry names, listed in order of first
+// appearance.
+func OpTypeReport(p InputProblem, s OutputSolution) []OpTypeLatency {
+	shares := attributeLatency(p, s, func(op int) string { return canonicalOpType(p.OpTypes[op]) })
+	report := make([]OpTypeLatency, len(shares))
+	for i, sh := range shares {
+		report[i] = OpTypeLatency{OpType: sh.name, Latency: sh.latency.Total(), Ops: sh.ops, Subgraphs: sh.subgraphs}
+	}
+	return report
+}
+
+type latencyShare struct {
+	name           string
+	latency        compensatedSum
+	ops, subgraphs int
+}
+
+// attributeLatency splits the latency of every subgraph in s over its ops
+// by base cost and sums it per name.
+func attributeLatency(p InputProblem, s OutputSolution, name func(op int) string) []latencyShare {
+	index := make(map[string]int)
+	var report []latencyShare
+	for op := range p.OpTypes {
+		if _, ok := index[name(op)]; !ok {
+			index[name(op)] = len(report)
+			report = append(report, latencyShare{name: name(op)})
+		}
+		report[index[name(op)]].ops++
+	}
+	for i, ops := range s.Subgraphs {
+		if i >= len(s.SubgraphLatencies) {
+			break
+		}
+		lat := s.SubgraphLatencies[i]
+		cost := 0.0
+		for _, op := range ops {
+			cost += p.BaseCosts[op]
+		}
+		seen := make(map[int]bool)
+		for _, op := range ops {
+			share := 1 / float64(len(ops))
+			if cost > 0 {
+				share = p.BaseCosts[op] / cost
+			}
+			j := index[name(op)]
+			report[j].latency.Add(lat * share)
+			if !seen[j] {
+				seen[j] = true
+				report[j].subgraphs++
+			}
+		}
+	}
+	return report
+}
@@ -0,0 +1,140 @@
+package mlsys
+
+// maxExactOrderOps bounds the group size for which livenessOrder searches
+// every topological order; larger groups are ordered greedily.
+const maxExactOrderOps = 16
+
+// livenessOrder orders the ops of one subgraph, producers first, so that
+// the peak size of the tensors they hold live is smallest. A tensor is
+// live from the op that produces it until its last consumer in the group
+// has run, or to the end of the subgraph when it leaves the group. Sizes
+// are whole-tensor elements, which every tile scales alike.
+func livenessOrder(p InputProblem, gi graphIndex, ops []int) []int {
+	n := len(ops)
+	if n < 2 {
+		return append([]int{}, ops...)
+	}
+	local := make(map[int]int, n)
+	for i, op := range ops {
+		local[op] = i
+	}
+	preds := make([][]int, n)
+	var outs [][]int
+	for i, op := range ops {
+		for _, t := range p.Inputs[op] {
+			for _, producer := range gi.producers[t] {
+				if j, ok := local[producer]; ok && j != i {
+					preds[i] = append(preds[i], j)
+				}
+			}
+		}
+		outs = append(outs, p.Outputs[op])
+	}
+	// consumers lists, per produced tensor, the ops of the group that read
+	// it; escapes marks tensors read outside it or graph outputs.
+	type produced struct {
+		size      int64
+		producer  int
+		consumers []int
+		escapes   bool
+	}
+	var tensors []produced
+	for i := range ops {
+		for _, t := range outs[i] {
+			pt := produced{size: p.Widths[t] * p.Heights[t], producer: i}
+			pt.escapes = len(gi.consumers[t]) == 0
+			for _, c := range gi.consumers[t] {
+				if j, ok := local[c]; ok {
+					pt.consumers = append(pt.consumers, j)
+				} else {
+					pt.escapes = true
+				}
+			}
+			tensors = append(tensors, pt)
+		}
+	}
+	live := func(done func(int) bool) int64 {
+		var total int64
+		for _, pt := range tensors {
+			if !done(pt.producer) {
+				continue
+			}
+			alive := pt.escapes
+			for _, c := range pt.consumers {
+				alive = alive || !done(c)
+			}
+			if alive {
+				total += pt.size
+			}
+		}
+		return total
+	}
+	outSize := make([]int64, n)
+	for _, pt := range tensors {
+		outSize[pt.producer] += pt.size
+	}
+	ready := func(i int, done func(int) bool) bool {
+		for _, j := range preds[i] {
+			if !done(j) {
+				return false
+			}
+		}
+		return true
+	}
+
+	order := make([]int, 0, n)
+	if n <= maxExactOrderOps {
+		// peak[mask] is the smallest peak over orders that run exactly the
+		// ops in mask; last[mask] is the op such an order ends with.
+		full := 1<<n - 1
+		peak := make([]int64, full+1)
+		last := make([]int, full+1)
+		reached := make([]bool, full+1)
+		reached[0] = true
+		for mask := 0; mask < full; mask++ {
+			if !reached[mask] {
+				continue
+			}
+			done := func(j int) bool { return mask&(1<<j) != 0 }
+			base := live(done)
+			for i := 0; i < n; i++ {
+				if done(i) || !ready(i, done) {
+					continue
+				}
+				next := mask | 1<<i
+				cost := max(peak[mask], base+outSize[i])
+				if !reached[next] || cost < peak[next] {
+					reached[next], peak[next], last[next] = true, cost, i
+				}
+			}
+		}
+		for mask := full; mask != 0; mask &^= 1 << last[mask] {
+			order = append(order, ops[last[mask]])
+		}
+		for i, j := 0, len(order)-1; i < j; i, j = i+1, j-1 {
+			order[i], order[j] = order[j], order[i]
+		}
+		return order
+	}
+
+	// Greedy: run the ready op that leaves the least live afterwards.
+	isDone := make([]bool, n)
+	done := func(j int) bool { return isDone[j] }
+	for len(order) < n {
+		best, bestLive := -1, int64(0)
+		for i := 0; i < n; i++ {
+			if isDone[i] || !ready(i, done) {
+				continue
+			}
+			isDone[i] = true
+			after := live(done)
+			isDone[i] = false
+			if best < 0 || after < bestLive {
+				best, bestLive = i, after
+			}
+		}
+		isDone[best] = true
+		order = append(order, ops[best])
+	}
+	return order
+}
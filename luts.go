@@ -0,0 +1,89 @@
+package mlsys
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+// Activation functions such as GELU and exp are evaluated through a lookup
+// table, or a block of polynomial constants, that must sit in fast memory
+// while they run. A subgraph holding such ops keeps each distinct table
+// resident for its whole run: the table counts toward the footprint, and
+// is loaded from slow memory once, by the first step, rather than with
+// every tile. Ops of one type share their table. LUTElements overrides the
+// default table sizes per op type.
+
+// lutTables lists the tables, indexed by bit of opTypeInfo.lut, with the
+// op type that needs each and its default size in elements.
+var lutTables = []struct {
+	op       string
+	elements int64
+}{
+	{"gelu", 256},
+	{"exp", 256},
+}
+
+// lutOp is an elementwise op that needs table i.
+func lutOp(i int) opTypeInfo {
+	op := elementwiseOp
+	op.lut = 1 << i
+	return op
+}
+
+func validateLUTElements(p InputProblem) error {
+	for op, n := range p.LUTElements {
+		if lutIndex(op) < 0 {
+			return fmt.Errorf("lut_elements: op type %q has no lookup table", op)
+		}
+		if n < 0 {
+			return fmt.Errorf("lut_elements: %q must be >= 0", op)
+		}
+	}
+	return nil
+}
+
+func lutIndex(op string) int {
+	key := canonicalOpType(op)
+	for i, t := range lutTables {
+		if t.op == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// lutElements is the combined size of the tables in the bitmask luts.
+func lutElements(p InputProblem, luts uint64) int64 {
+	var total int64
+	for ; luts != 0; luts &= luts - 1 {
+		t := lutTables[bits.TrailingZeros64(luts)]
+		n := t.elements
+		for op, m := range p.LUTElements {
+			if canonicalOpType(op) == t.op {
+				n = m
+			}
+		}
+		total += n
+	}
+	return total
+}
+
+// withLUTLoad charges the group's tables to its first step, splitting that
+// step from its class when the class has others.
+func withLUTLoad(p InputProblem, info groupInfo, classes []stepClass) []stepClass {
+	n := lutElements(p, info.luts)
+	if n == 0 || len(classes) == 0 {
+		return classes
+	}
+	first := classes[0]
+	first.steps = 1
+	first.elements += n
+	first.overhead += float64(bits.OnesCount64(info.luts)) * p.DMATransferLatency
+	first.lut = n
+	if classes[0].steps <= 1 {
+		classes[0] = first
+		return classes
+	}
+	classes[0].steps--
+	return append([]stepClass{first}, classes...)
+}
@@ -0,0 +1,52 @@
+package mlsys
+
+import "fmt"
+
+// With Options.CapacityMargin set, schedules target only that fraction of
+// fast memory, leaving the rest as headroom for runtime metadata and
+// allocator overhead. Each entry point scales the problem's capacity once
+// on the way in, so tile fitting, buffer depths, retention budgets, KV
+// cache pinning and ValidateSolution all see the same reduced capacity.
+
+// withCapacityMargin returns p with its fast-memory capacity scaled by the
+// margin in opts.
+func withCapacityMargin(p InputProblem, opts Options) (InputProblem, error) {
+	m := opts.CapacityMargin
+	if m == 0 {
+		return p, nil
+	}
+	if !(m > 0 && m <= 1) {
+		return p, fmt.Errorf("capacity margin must be within (0, 1], got %g", m)
+	}
+	p.FastMemoryCapacity *= m
+	if p.FastMemories != nil {
+		memories := make([]FastMemory, len(p.FastMemories))
+		for i, fm := range p.FastMemories {
+			fm.Capacity *= m
+			memories[i] = fm
+		}
+		p.FastMemories = memories
+	}
+	return p, nil
+}
+
+// checkSubgraphFits reports the first subgraph of s whose footprint at its
+// granularity overflows fast memory, or one of the fast memories, or that
+// breaks the DMA limits. Where gi credits retention, the tensors retained
+// into a subgraph take their whole size from its capacity.
+func checkSubgraphFits(p InputProblem, gi graphIndex, s OutputSolution) error {
+	sc := newGroupScratch(p)
+	for i, ops := range s.Subgraphs {
+		if len(ops) == 0 {
+			continue
+		}
+		q, info := withRetained(p, analyzeGroup(p, gi, sortedUnique(ops), sc), retainedInto(gi, s, i))
+		if err := checkGroupFits(q, info, s.Granularities[i]); err != nil {
+			return fmt.Errorf("subgraph %d %v", i, err)
+		}
+		if err := checkDMALimits(p, info, s.Granularities[i], solutionStepClasses(p, info, s, i)); err != nil {
+			return fmt.Errorf("subgraph %d %v", i, err)
+		}
+	}
+	return nil
+}
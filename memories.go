@@ -0,0 +1,188 @@
+package mlsys
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Many accelerators split fast memory into buffers of their own, e.g. a
+// weight SRAM beside an activation SRAM. FastMemories lists them with
+// their capacities and the tensor classes each holds, and a subgraph must
+// then fit in every memory separately. Each tensor has a class: "weight"
+// for graph inputs, tensors no op produces, and "activation" for the
+// rest, unless TensorClasses names another. Intermediate and accumulator
+// tiles count as activations, and lookup tables as weights. Both default
+// classes and every class named in TensorClasses must be routed to
+// exactly one memory.
+//
+// Retention budgets and KV-cache pinning are not routed: they still count
+// against the pooled FastMemoryCapacity, which should be the memories'
+// total.
+
+// FastMemory is one of several separate fast memories.
+type FastMemory struct {
+	Name     string   `json:"name"`
+	Capacity float64  `json:"capacity"`
+	Classes  []string `json:"classes"`
+	// Mode is scratchpad (the default), cache or auto; see memorymodes.go.
+	Mode string `json:"mode,omitempty"`
+}
+
+// Default tensor classes.
+const (
+	classWeight     = "weight"
+	classActivation = "activation"
+)
+
+func validateFastMemories(p InputProblem) error {
+	if p.TensorClasses != nil && len(p.TensorClasses) != len(p.Widths) {
+		return fmt.Errorf("tensor_classes has %d entries for %d tensors", len(p.TensorClasses), len(p.Widths))
+	}
+	if len(p.FastMemories) == 0 {
+		if p.TensorClasses != nil {
+			return errors.New("tensor_classes requires fast_memories")
+		}
+		return nil
+	}
+	names := make(map[string]bool)
+	routed := make(map[string]string)
+	for _, m := range p.FastMemories {
+		if m.Name == "" || names[m.Name] {
+			return fmt.Errorf("fast memory names must be unique and non-empty, got %q", m.Name)
+		}
+		names[m.Name] = true
+		if m.Capacity <= 0 {
+			return fmt.Errorf("fast memory %q: capacity must be > 0", m.Name)
+		}
+		for _, c := range m.Classes {
+			if other, ok := routed[c]; ok {
+				return fmt.Errorf("tensor class %q is routed to both %q and %q", c, other, m.Name)
+			}
+			routed[c] = m.Name
+		}
+	}
+	for _, c := range []string{classWeight, classActivation} {
+		if _, ok := routed[c]; !ok {
+			return fmt.Errorf("tensor class %q is not routed to any fast memory", c)
+		}
+	}
+	for t, c := range p.TensorClasses {
+		if _, ok := routed[c]; c != "" && !ok {
+			return fmt.Errorf("tensor %d: class %q is not routed to any fast memory", t, c)
+		}
+	}
+	return nil
+}
+
+// tensorMemories maps each tensor to the index in p.FastMemories of the
+// memory holding it, or returns nil when fast memory is pooled.
+func tensorMemories(p InputProblem, producers [][]int) []int {
+	if len(p.FastMemories) == 0 {
+		return nil
+	}
+	memory := make([]int, len(p.Widths))
+	for t := range memory {
+		class := classActivation
+		if len(producers[t]) == 0 {
+			class = classWeight
+		}
+		if p.TensorClasses != nil && p.TensorClasses[t] != "" {
+			class = p.TensorClasses[t]
+		}
+		memory[t] = memoryOfClass(p, class)
+	}
+	return memory
+}
+
+func memoryOfClass(p InputProblem, class string) int {
+	for i, m := range p.FastMemories {
+		for _, c := range m.Classes {
+			if c == class {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// memoryUsage splits a group's single-buffer footprint at [w, h, k] by
+// memory; streamed is the part of each that extra buffers duplicate. The
+// totals are singleBufferFootprint and streamedElements.
+func memoryUsage(p InputProblem, info groupInfo, w, h, k int64) (base, streamed []int64) {
+	base = make([]int64, len(p.FastMemories))
+	streamed = make([]int64, len(p.FastMemories))
+	act := memoryOfClass(p, classActivation)
+	spanned := tileHeads(p, info, h)
+	for _, in := range info.inputs {
+		n := tensorElements(p, info, in.tensor, inputTileElements(p, in, w, h, k, spanned))
+		base[info.memory[in.tensor]] += n
+		if in.role != roleWhole {
+			streamed[info.memory[in.tensor]] += n
+		}
+	}
+	if !info.tileable {
+		for _, t := range info.outputs {
+			n := tensorElements(p, info, t, p.Widths[t]*p.Heights[t])
+			base[info.memory[t]] += n
+			streamed[info.memory[t]] += n
+		}
+	} else if len(info.outputs) == 0 {
+		base[act] += w * h
+		streamed[act] += w * h
+	} else {
+		for _, t := range info.outputs {
+			n := tensorElements(p, info, t, w*h)
+			base[info.memory[t]] += n
+			streamed[info.memory[t]] += n
+		}
+	}
+	base[act] += info.intermediates * w * h
+	base[memoryOfClass(p, classWeight)] += lutElements(p, info.luts)
+	if p.AccumulatorDType != "" && info.reduction > maxI64(1, k) {
+		base[act] += int64(math.Ceil(float64(w*h) * accumulatorRatio(p)))
+	}
+	return base, streamed
+}
+
+// fitsMemoriesAtDepth reports whether a group fits in every memory with
+// depth copies of its streamed tiles.
+func fitsMemoriesAtDepth(p InputProblem, info groupInfo, w, h, k int64, depth int) bool {
+	return overflowingMemory(p, info, w, h, k, depth) < 0
+}
+
+// overflowingMemory is the index of the first scratchpad memory a group
+// overflows at the given depth, or -1.
+func overflowingMemory(p InputProblem, info groupInfo, w, h, k int64, depth int) int {
+	base, streamed := memoryUsage(p, info, w, h, k)
+	for i, m := range p.FastMemories {
+		need := base[i] + int64(max(depth, 1)-1)*streamed[i]
+		if !memoryIsCache(p, i) && float64(need) > m.Capacity {
+			return i
+		}
+	}
+	return -1
+}
+
+// checkGroupFits reports whether a group fits in fast memory at [w, h, k]
+// and the buffer depth it runs with, describing the overflow if not.
+func checkGroupFits(p InputProblem, info groupInfo, g [3]int64) error {
+	w, h, k := g[0], g[1], g[2]
+	if info.memory == nil {
+		if pooledCache(p) {
+			return nil
+		}
+		if need := footprintElementsForGroup(p, info, w, h, k); float64(need) > p.FastMemoryCapacity {
+			return fmt.Errorf("needs %d elements of fast memory at tile %dx%dx%d, above the capacity of %g", need, w, h, k, p.FastMemoryCapacity)
+		}
+		return nil
+	}
+	depth := bufferDepth(p, info, w, h, k)
+	if i := overflowingMemory(p, info, w, h, k, depth); i >= 0 {
+		base, streamed := memoryUsage(p, info, w, h, k)
+		need := base[i] + int64(max(depth, 1)-1)*streamed[i]
+		m := p.FastMemories[i]
+		return fmt.Errorf("needs %d elements of fast memory %q at tile %dx%dx%d, above its capacity of %g", need, m.Name, w, h, k, m.Capacity)
+	}
+	return nil
+}
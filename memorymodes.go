@@ -0,0 +1,218 @@
+package mlsys
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// A level of fast memory, the pooled capacity or one of FastMemories, runs
+// either as a scratchpad or as a hardware cache:
+//
+//   - scratchpad: the schedule allocates it explicitly. A subgraph's tiles
+//     must fit, extra buffers are allocated for overlap, and operands the
+//     dataflow reuses stay resident.
+//   - cache: nothing is allocated, so nothing has to fit. The level holds
+//     as much of a subgraph's footprint as its capacity allows, and the
+//     rest misses: that fraction of whatever a scratchpad would keep
+//     resident between steps, reused operands, intermediates and partial
+//     sums, is moved again every step. Extra buffers are not allocated in
+//     a pooled cache.
+//   - auto: Solve tries both modes for every such level and keeps the
+//     fastest schedule, reporting its choice in memory_modes. Elsewhere an
+//     unresolved level is modeled as a scratchpad, the strict choice,
+//     unless the solution being checked states the mode it assumed.
+//
+// Retention and KV-cache pinning are explicit allocations and count against
+// the capacity whatever the mode.
+
+// Memory modes.
+const (
+	MemoryScratchpad = "scratchpad"
+	MemoryCache      = "cache"
+	MemoryAuto       = "auto"
+)
+
+func validateMemoryModes(p InputProblem) error {
+	known := func(m string) bool {
+		return m == "" || m == MemoryScratchpad || m == MemoryCache || m == MemoryAuto
+	}
+	if !known(p.FastMemoryMode) {
+		return fmt.Errorf("unknown fast_memory_mode %q (want scratchpad, cache or auto)", p.FastMemoryMode)
+	}
+	if p.FastMemoryMode != "" && len(p.FastMemories) > 0 {
+		return errors.New("fast_memory_mode does not apply with fast_memories; set each memory's mode")
+	}
+	for _, m := range p.FastMemories {
+		if !known(m.Mode) {
+			return fmt.Errorf("fast memory %q: unknown mode %q (want scratchpad, cache or auto)", m.Name, m.Mode)
+		}
+	}
+	return nil
+}
+
+// memoryModes lists the mode of every level of fast memory: one per entry
+// of FastMemories, or the pooled level's alone.
+func memoryModes(p InputProblem) []string {
+	if len(p.FastMemories) == 0 {
+		return []string{p.FastMemoryMode}
+	}
+	modes := make([]string, len(p.FastMemories))
+	for i, m := range p.FastMemories {
+		modes[i] = m.Mode
+	}
+	return modes
+}
+
+// withMemoryModes returns p with the levels of fast memory set to modes,
+// as listed by memoryModes.
+func withMemoryModes(p InputProblem, modes []string) InputProblem {
+	if len(p.FastMemories) == 0 {
+		p.FastMemoryMode = modes[0]
+		return p
+	}
+	memories := make([]FastMemory, len(p.FastMemories))
+	for i, m := range p.FastMemories {
+		m.Mode = modes[i]
+		memories[i] = m
+	}
+	p.FastMemories = memories
+	return p
+}
+
+// withSolutionModes resolves the auto levels of p to the modes s reports.
+func withSolutionModes(p InputProblem, s OutputSolution) (InputProblem, error) {
+	if s.MemoryModes == nil {
+		return p, nil
+	}
+	modes := memoryModes(p)
+	if len(s.MemoryModes) != len(modes) {
+		return p, fmt.Errorf("memory_modes has %d entries for %d levels of fast memory", len(s.MemoryModes), len(modes))
+	}
+	for i, m := range s.MemoryModes {
+		if m != MemoryScratchpad && m != MemoryCache {
+			return p, fmt.Errorf("memory_modes[%d]: unknown mode %q", i, m)
+		}
+		if modes[i] != MemoryAuto && m != resolvedMode(modes[i]) {
+			return p, fmt.Errorf("memory_modes[%d] is %q but the problem fixes %q", i, m, resolvedMode(modes[i]))
+		}
+	}
+	return withMemoryModes(p, s.MemoryModes), nil
+}
+
+// resolvedMode is the mode a level is modeled with.
+func resolvedMode(mode string) string {
+	if mode == MemoryCache {
+		return MemoryCache
+	}
+	return MemoryScratchpad
+}
+
+// pooledCache reports whether pooled fast memory runs as a cache.
+func pooledCache(p InputProblem) bool {
+	return len(p.FastMemories) == 0 && p.FastMemoryMode == MemoryCache
+}
+
+func memoryIsCache(p InputProblem, i int) bool {
+	return p.FastMemories[i].Mode == MemoryCache
+}
+
+// cacheMissRate is the fraction of a group's footprint at [w, h, k] and
+// the given depth that the levels in cache mode cannot hold.
+func cacheMissRate(p InputProblem, info groupInfo, w, h, k int64, depth int) float64 {
+	extra := int64(max(depth, 1) - 1)
+	if info.memory == nil {
+		if !pooledCache(p) {
+			return 0
+		}
+		need := float64(singleBufferFootprint(p, info, w, h, k) + extra*streamedElements(p, info, w, h, k))
+		if need <= p.FastMemoryCapacity {
+			return 0
+		}
+		return 1 - p.FastMemoryCapacity/need
+	}
+	base, streamed := memoryUsage(p, info, w, h, k)
+	var total, missed float64
+	for i, m := range p.FastMemories {
+		need := float64(base[i] + extra*streamed[i])
+		total += need
+		if memoryIsCache(p, i) && need > m.Capacity {
+			missed += need - m.Capacity
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return missed / total
+}
+
+// withCacheMisses adds to each class of steps the misses of cache-mode
+// levels: their share of what the step keeps resident rather than moves,
+// reused operands, intermediates and partial sums alike.
+func withCacheMisses(p InputProblem, info groupInfo, g [3]int64, classes []stepClass) []stepClass {
+	w, h, k := g[0], g[1], g[2]
+	rate := cacheMissRate(p, info, w, h, k, bufferDepth(p, info, w, h, k))
+	if rate == 0 {
+		return classes
+	}
+	resident := footprintElementsForGroup(p, info, w, h, k)
+	for i := range classes {
+		if reused := resident - classes[i].elements; reused > 0 {
+			classes[i].elements += int64(math.Ceil(float64(reused) * rate))
+		}
+	}
+	return classes
+}
+
+// solveMemoryModes resolves every auto level of fast memory by solving
+// with each combination of modes and keeping the fastest feasible
+// schedule, then returns p with the winning modes. Problems without auto
+// levels are returned unchanged, with nil modes.
+func solveMemoryModes(ctx context.Context, p InputProblem, opts Options) (InputProblem, []string, error) {
+	modes := memoryModes(p)
+	var auto []int
+	for i, m := range modes {
+		if m == MemoryAuto {
+			auto = append(auto, i)
+		}
+	}
+	if len(auto) == 0 {
+		return p, nil, nil
+	}
+	var best []string
+	bestLat := math.Inf(1)
+	for mask := 0; mask < 1<<len(auto); mask++ {
+		try := make([]string, len(modes))
+		for i, m := range modes {
+			try[i] = resolvedMode(m)
+		}
+		for j, i := range auto {
+			if mask&(1<<j) != 0 {
+				try[i] = MemoryCache
+			}
+		}
+		q := withMemoryModes(p, try)
+		plans, err := solvePlans(ctx, q, opts)
+		if err != nil {
+			if ctx.Err() != nil {
+				return p, nil, err
+			}
+			continue
+		}
+		if checkFits(q, plans) != nil {
+			continue
+		}
+		if lat := totalLatency(plans); best == nil || lat < bestLat {
+			best, bestLat = try, lat
+		}
+	}
+	if best == nil {
+		// Nothing fits in any mode; report the failure of the strict one.
+		best = make([]string, len(modes))
+		for i, m := range modes {
+			best[i] = resolvedMode(m)
+		}
+	}
+	return withMemoryModes(p, best), best, nil
+}
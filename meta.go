@@ -0,0 +1,131 @@
+package mlsys
+
+import (
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// SolveMeta describes the run that produced a schedule, so that it can be
+// reproduced. Solve adds it when Options.EmitMeta is set; validation and
+// the checks ignore it.
+type SolveMeta struct {
+	Solver string `json:"solver"`
+	// Version is the module version of the solver, "(devel)" when built
+	// from a checkout, and Revision the VCS revision it was built from,
+	// when known.
+	Version  string      `json:"version"`
+	Revision string      `json:"revision,omitempty"`
+	Options  MetaOptions `json:"options"`
+	// WallSeconds is how long Solve ran.
+	WallSeconds float64 `json:"wall_seconds"`
+	// CandidateGroups is the number of distinct candidate subgraphs the
+	// search planned, over every schedule it built.
+	CandidateGroups int         `json:"candidate_groups"`
+	Machine         MachineInfo `json:"machine"`
+}
+
+// MetaOptions records the Options of a run.
+type MetaOptions struct {
+	UnknownOps        string  `json:"unknown_ops"`
+	Compat            string  `json:"compat"`
+	EmitDependencies  bool    `json:"emit_dependencies,omitempty"`
+	EmitOpOrders      bool    `json:"emit_op_orders,omitempty"`
+	EmitDMAStats      bool    `json:"emit_dma_stats,omitempty"`
+	EmitIntegrity     bool    `json:"emit_integrity,omitempty"`
+	MaxSubgraphs      int     `json:"max_subgraphs,omitempty"`
+	MinOpsPerSubgraph int     `json:"min_ops_per_subgraph,omitempty"`
+	CapacityMargin    float64 `json:"capacity_margin,omitempty"`
+	GroupCache        bool    `json:"group_cache,omitempty"`
+	DedupeBlocks      bool    `json:"dedupe_blocks,omitempty"`
+	TargetLatency     float64 `json:"target_latency,omitempty"`
+	// Stabilized is set when the run kept to a previous schedule, within
+	// MaxChanges changes.
+	Stabilized bool `json:"stabilized,omitempty"`
+	MaxChanges int  `json:"max_changes,omitempty"`
+	// KernelNameFormat is the format the run named kernels by.
+	KernelNameFormat string `json:"kernel_name_format,omitempty"`
+	// Policy is set when a policy made the decisions in place of the
+	// search.
+	Policy bool `json:"policy,omitempty"`
+	// Templates names the schedule templates the run could apply.
+	Templates []string `json:"templates,omitempty"`
+}
+
+// MachineInfo describes the machine and runtime a schedule was solved on.
+type MachineInfo struct {
+	OS        string `json:"os"`
+	Arch      string `json:"arch"`
+	NumCPU    int    `json:"num_cpu"`
+	GoVersion string `json:"go_version"`
+}
+
+const (
+	// solverName is the Solver of every SolveMeta.
+	solverName = "mlsys"
+	// modulePath is the import path of this module.
+	modulePath = "mlsys"
+)
+
+// solveStats counts the work of one Solve call for its SolveMeta.
+type solveStats struct {
+	candidateGroups int
+}
+
+// newSolveMeta describes a run of opts that started at start.
+func newSolveMeta(opts Options, start time.Time) *SolveMeta {
+	m := &SolveMeta{
+		Solver:  solverName,
+		Version: "(devel)",
+		Options: MetaOptions{
+			UnknownOps:        opts.UnknownOps.String(),
+			Compat:            opts.Compat.String(),
+			EmitDependencies:  opts.EmitDependencies,
+			EmitOpOrders:      opts.EmitOpOrders,
+			EmitDMAStats:      opts.EmitDMAStats,
+			EmitIntegrity:     opts.EmitIntegrity,
+			MaxSubgraphs:      opts.MaxSubgraphs,
+			MinOpsPerSubgraph: opts.MinOpsPerSubgraph,
+			CapacityMargin:    opts.CapacityMargin,
+			GroupCache:        opts.GroupCache != nil,
+			DedupeBlocks:      opts.DedupeBlocks,
+			TargetLatency:     opts.TargetLatency,
+			Stabilized:        opts.Stabilize != nil,
+			MaxChanges:        opts.MaxChanges,
+			KernelNameFormat:  opts.KernelNameFormat,
+			Policy:            opts.Policy != nil,
+		},
+		WallSeconds: time.Since(start).Seconds(),
+		Machine: MachineInfo{
+			OS:        runtime.GOOS,
+			Arch:      runtime.GOARCH,
+			NumCPU:    runtime.NumCPU(),
+			GoVersion: runtime.Version(),
+		},
+	}
+	for _, t := range opts.Templates {
+		m.Options.Templates = append(m.Options.Templates, t.Name)
+	}
+	if opts.stats != nil {
+		m.CandidateGroups = opts.stats.candidateGroups
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		mod := &info.Main
+		for _, dep := range info.Deps {
+			if dep.Path == modulePath {
+				mod = dep
+			}
+		}
+		if mod.Path == modulePath && mod.Version != "" {
+			m.Version = mod.Version
+		}
+		if mod == &info.Main && mod.Path == modulePath {
+			for _, s := range info.Settings {
+				if s.Key == "vcs.revision" {
+					m.Revision = s.Value
+				}
+			}
+		}
+	}
+	return m
+}
@@ -0,0 +1,191 @@
+package mlsys
+
+import "sort"
+
+// MinimizeProblem shrinks p to a small problem that is still interesting,
+// for bug reports: it delta-debugs the set of ops, dropping chunks of them
+// while interesting holds for what remains. Tensors go with the last op
+// that reads or writes them. p itself must be interesting.
+//
+// Candidates are only offered to interesting after they pass
+// ValidateProblem, so the result is always a valid problem. Features that
+// refer to dropped ops or tensors (regions, barriers, views, ...) are
+// narrowed to what remains or, when that leaves nothing, dropped.
+func MinimizeProblem(p InputProblem, interesting func(InputProblem) bool) InputProblem {
+	ops := make([]int, len(p.OpTypes))
+	for i := range ops {
+		ops[i] = i
+	}
+	try := func(keep []int) bool {
+		q := subProblem(p, keep)
+		return ValidateProblem(q) == nil && interesting(q)
+	}
+
+	n := 2
+	for len(ops) >= 2 {
+		chunk := (len(ops) + n - 1) / n
+		reduced := false
+		for start := 0; start < len(ops); start += chunk {
+			end := min(start+chunk, len(ops))
+			rest := append(append([]int(nil), ops[:start]...), ops[end:]...)
+			if len(rest) > 0 && try(rest) {
+				ops = rest
+				n = max(n-1, 2)
+				reduced = true
+				break
+			}
+		}
+		if !reduced {
+			if n >= len(ops) {
+				break
+			}
+			n = min(2*n, len(ops))
+		}
+	}
+	return subProblem(p, ops)
+}
+
+// subProblem keeps the given ops of p, in ascending index order, and the
+// tensors they touch, renumbering both.
+func subProblem(p InputProblem, ops []int) InputProblem {
+	ops = append([]int(nil), ops...)
+	sort.Ints(ops)
+	opIndex := make(map[int]int, len(ops))
+	for i, op := range ops {
+		opIndex[op] = i
+	}
+
+	used := make([]bool, len(p.Widths))
+	for _, op := range ops {
+		for _, t := range p.Inputs[op] {
+			used[t] = true
+		}
+		for _, t := range p.Outputs[op] {
+			used[t] = true
+		}
+	}
+	for _, v := range p.Views {
+		if used[v.Tensor] {
+			used[v.Base] = true
+		}
+	}
+	tensorIndex := make(map[int]int)
+	q := p
+	q.Widths, q.Heights, q.TensorRowPitches, q.TensorClasses, q.TensorNames = nil, nil, nil, nil, nil
+	for t, ok := range used {
+		if ok {
+			tensorIndex[t] = len(q.Widths)
+			q.Widths = append(q.Widths, p.Widths[t])
+			q.Heights = append(q.Heights, p.Heights[t])
+			if p.TensorRowPitches != nil {
+				q.TensorRowPitches = append(q.TensorRowPitches, p.TensorRowPitches[t])
+			}
+			if p.TensorClasses != nil {
+				q.TensorClasses = append(q.TensorClasses, p.TensorClasses[t])
+			}
+			if p.TensorNames != nil {
+				q.TensorNames = append(q.TensorNames, p.TensorNames[t])
+			}
+		}
+	}
+	remapTensors := func(ts []int) []int {
+		out := make([]int, 0, len(ts))
+		for _, t := range ts {
+			if i, ok := tensorIndex[t]; ok {
+				out = append(out, i)
+			}
+		}
+		return out
+	}
+	remapOps := func(xs []int) []int {
+		out := make([]int, 0, len(xs))
+		for _, op := range xs {
+			if i, ok := opIndex[op]; ok {
+				out = append(out, i)
+			}
+		}
+		return out
+	}
+
+	q.Inputs, q.Outputs, q.BaseCosts, q.OpTypes = nil, nil, nil, nil
+	q.OpLayers, q.OpHeads, q.OpAttributes = nil, nil, nil
+	for _, op := range ops {
+		q.Inputs = append(q.Inputs, remapTensors(p.Inputs[op]))
+		q.Outputs = append(q.Outputs, remapTensors(p.Outputs[op]))
+		q.BaseCosts = append(q.BaseCosts, p.BaseCosts[op])
+		q.OpTypes = append(q.OpTypes, p.OpTypes[op])
+		if p.OpLayers != nil {
+			q.OpLayers = append(q.OpLayers, p.OpLayers[op])
+		}
+		if p.OpHeads != nil {
+			q.OpHeads = append(q.OpHeads, p.OpHeads[op])
+		}
+		if p.OpAttributes != nil {
+			q.OpAttributes = append(q.OpAttributes, p.OpAttributes[op])
+		}
+	}
+
+	q.Regions = nil
+	for _, r := range p.Regions {
+		r.Ops = remapOps(r.Ops)
+		r.LoopCarried = remapTensors(r.LoopCarried)
+		if len(r.Ops) > 0 {
+			q.Regions = append(q.Regions, r)
+		}
+	}
+	q.Barriers = nil
+	for _, b := range p.Barriers {
+		if i, ok := opIndex[b.AfterOp]; ok {
+			b.AfterOp = i
+			q.Barriers = append(q.Barriers, b)
+		}
+	}
+	q.TileConstraints = nil
+	for _, c := range p.TileConstraints {
+		c.Ops = remapOps(c.Ops)
+		if c.OpType != "" || len(c.Ops) > 0 {
+			q.TileConstraints = append(q.TileConstraints, c)
+		}
+	}
+	q.RegisterFusable = nil
+	for _, pair := range p.RegisterFusable {
+		a, okA := opIndex[pair[0]]
+		c, okC := opIndex[pair[1]]
+		if okA && okC {
+			q.RegisterFusable = append(q.RegisterFusable, [2]int{a, c})
+		}
+	}
+	q.FixedSubgraphs = nil
+	for _, ops := range p.FixedSubgraphs {
+		if ops = remapOps(ops); len(ops) > 0 {
+			q.FixedSubgraphs = append(q.FixedSubgraphs, ops)
+		}
+	}
+	q.Views = nil
+	for _, v := range p.Views {
+		t, okT := tensorIndex[v.Tensor]
+		b, okB := tensorIndex[v.Base]
+		if okT && okB {
+			v.Tensor, v.Base = t, b
+			q.Views = append(q.Views, v)
+		}
+	}
+	if kv := p.KVCache; kv != nil {
+		c := *kv
+		c.Tensors = remapTensors(kv.Tensors)
+		q.KVCache = nil
+		if len(c.Tensors) > 0 {
+			q.KVCache = &c
+		}
+	}
+	if sv := p.Serving; sv != nil {
+		c := *sv
+		c.SequenceTensors = remapTensors(sv.SequenceTensors)
+		c.Weights = remapTensors(sv.Weights)
+		q.Serving = nil
+		if len(c.SequenceTensors) > 0 {
+			q.Serving = &c
+		}
+	}
+	return q
+}
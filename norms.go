@@ -0,0 +1,39 @@
+package mlsys
+
+// Layer normalization needs the mean and variance of a whole row before it
+// can normalize any of it. A tile that spans the full width of its input
+// computes both from the data it already holds, in one pass; a narrower
+// tile runs two: one over the row for the statistics, then one to
+// normalize, reading its input tile from slow memory twice. The re-read
+// costs traffic and descriptors but no fast memory, since the second pass
+// reuses the first pass's buffer. Batch normalization at inference uses
+// running statistics and is an ordinary elementwise op.
+
+var layerNormOp = opTypeInfo{
+	class:            classElementwise,
+	compute:          computePerStep,
+	operandRoles:     []operandRole{roleNormalized},
+	reductionOperand: -1,
+	tileable:         true,
+	fusable:          true,
+}
+
+// inputPasses is how many times a step reads its tile of in at width w.
+func inputPasses(p InputProblem, in boundaryInput, w int64) int64 {
+	if in.role == roleNormalized && w < p.Widths[in.tensor] {
+		return 2
+	}
+	return 1
+}
+
+// rereadElements is what the second passes of a step's normalized inputs
+// read on top of its working set.
+func rereadElements(p InputProblem, info groupInfo, w, h, k int64) int64 {
+	var total int64
+	for _, in := range info.inputs {
+		if n := inputPasses(p, in, w) - 1; n > 0 {
+			total += n * tensorElements(p, info, in.tensor, inputTileElements(p, in, w, h, k, 1))
+		}
+	}
+	return total
+}
@@ -0,0 +1,219 @@
+package mlsys
+
+import (
+	"fmt"
+	"strings"
+)
+
+// opClass groups op types that share a cost and tiling model.
+type opClass int
+
+const (
+	classElementwise opClass = iota
+	classMatMul
+	classOpaque
+)
+
+// computeModel says how an op's base cost turns into compute time.
+type computeModel int
+
+const (
+	// computePerStep pays the base cost on every execution step, as the
+	// hardware pads any tile up to the native granularity.
+	computePerStep computeModel = iota
+	// computeStandalone takes the base cost as the op's whole latency, as
+	// measured by the user; its transfers cannot overlap that compute.
+	computeStandalone
+	// computeFree charges nothing: the op's work folds into the loads and
+	// stores of the ops around it. See quant.go.
+	computeFree
+)
+
+// opTypeInfo is everything the solver needs to know about an op type.
+// Supporting a new operator means adding an entry to opRegistry rather than
+// teaching each pass about another type string.
+type opTypeInfo struct {
+	class   opClass
+	compute computeModel
+	// operandRoles gives how each input position is sliced by [w, h, k];
+	// positions past the end use defaultRole.
+	operandRoles []operandRole
+	defaultRole  operandRole
+	// reductionOperand is the input whose width is the reduction depth, or
+	// -1 when the op does not reduce.
+	reductionOperand int
+	// tileable ops may run on spatial tiles smaller than their output;
+	// others must produce the whole output in a single step.
+	tileable bool
+	// fusable ops may share a subgraph with other ops.
+	fusable bool
+	// lut is the lookup table the op keeps resident, as a bit into
+	// lutTables, or 0. See luts.go.
+	lut uint64
+	// quantizes ops write quantized tensors, and dequantizes ops read
+	// them. See quant.go.
+	quantizes   bool
+	dequantizes bool
+}
+
+var (
+	matMulOp = opTypeInfo{
+		class:            classMatMul,
+		compute:          computePerStep,
+		operandRoles:     []operandRole{roleLHS, roleRHS},
+		reductionOperand: 0,
+		tileable:         true,
+		fusable:          true,
+	}
+	elementwiseOp = opTypeInfo{
+		class:            classElementwise,
+		compute:          computePerStep,
+		reductionOperand: -1,
+		tileable:         true,
+		fusable:          true,
+	}
+	// opaqueOp is a black box with a user-provided latency: it runs alone,
+	// moves every operand whole, and is never tiled or fused.
+	opaqueOp = opTypeInfo{
+		class:            classOpaque,
+		compute:          computeStandalone,
+		defaultRole:      roleWhole,
+		reductionOperand: -1,
+	}
+)
+
+// opRegistry maps canonical (lower-case) op type names to their semantics.
+var opRegistry = map[string]opTypeInfo{
+	"matmul":     matMulOp,
+	"pointwise":  elementwiseOp,
+	"opaque":     opaqueOp,
+	"gelu":       lutOp(0),
+	"exp":        lutOp(1),
+	"layernorm":  layerNormOp,
+	"batchnorm":  elementwiseOp,
+	"gather":     gatherOp,
+	"quantize":   quantizeOp,
+	"dequantize": dequantizeOp,
+}
+
+// opTypesSince gives the compat level that introduced registry entries
+// added after v1.5. Below it, an op of the type is modeled as the
+// unknown-op policy models unknown types, as it was then, but still counts
+// as known, so UnknownOpError accepts it.
+var opTypesSince = map[string]CompatLevel{
+	"gelu":       CompatV1_6,
+	"exp":        CompatV1_6,
+	"layernorm":  CompatV1_6,
+	"batchnorm":  CompatV1_6,
+	"gather":     CompatV1_6,
+	"quantize":   CompatV1_6,
+	"dequantize": CompatV1_6,
+}
+
+// opAliases maps alternative spellings used by exporters to registry keys.
+var opAliases = map[string]string{
+	"gemm":               "matmul",
+	"dense":              "matmul",
+	"linear":             "matmul",
+	"fullyconnected":     "matmul",
+	"elementwise":        "pointwise",
+	"eltwise":            "pointwise",
+	"layer_norm":         "layernorm",
+	"layernormalization": "layernorm",
+	"rmsnorm":            "layernorm",
+	"rms_norm":           "layernorm",
+	"batch_norm":         "batchnorm",
+	"batchnormalization": "batchnorm",
+	"embedding":          "gather",
+	"embedding_lookup":   "gather",
+	"quantizelinear":     "quantize",
+	"dequantizelinear":   "dequantize",
+}
+
+// canonicalOpType lower-cases name and resolves aliases.
+func canonicalOpType(name string) string {
+	key := strings.ToLower(strings.TrimSpace(name))
+	if alias, ok := opAliases[key]; ok {
+		return alias
+	}
+	return key
+}
+
+// UnknownOpPolicy decides how op types missing from the registry are
+// handled.
+type UnknownOpPolicy int
+
+const (
+	// UnknownOpElementwise models unknown ops as elementwise.
+	UnknownOpElementwise UnknownOpPolicy = iota
+	// UnknownOpError rejects problems containing unknown ops.
+	UnknownOpError
+	// UnknownOpOpaque runs unknown ops alone and untiled.
+	UnknownOpOpaque
+)
+
+func (u UnknownOpPolicy) String() string {
+	switch u {
+	case UnknownOpError:
+		return "error"
+	case UnknownOpOpaque:
+		return "opaque"
+	}
+	return "elementwise"
+}
+
+// ParseUnknownOpPolicy accepts "elementwise", "error" or "opaque".
+func ParseUnknownOpPolicy(s string) (UnknownOpPolicy, error) {
+	switch s {
+	case "elementwise":
+		return UnknownOpElementwise, nil
+	case "error":
+		return UnknownOpError, nil
+	case "opaque":
+		return UnknownOpOpaque, nil
+	}
+	return 0, fmt.Errorf("unknown-op policy must be error, elementwise or opaque, got %q", s)
+}
+
+// lookupOpType resolves name through the aliases and the registry. The
+// boolean reports whether the type was known; unknown types, and types
+// registered after compat, get the entry chosen by policy (UnknownOpError
+// is the caller's to enforce).
+func lookupOpType(name string, policy UnknownOpPolicy, compat CompatLevel) (opTypeInfo, bool) {
+	key := canonicalOpType(name)
+	info, ok := opRegistry[key]
+	if since, newer := opTypesSince[key]; ok && (!newer || compat.atLeast(since)) {
+		return info, true
+	}
+	if policy == UnknownOpOpaque {
+		return opaqueOp, ok
+	}
+	return elementwiseOp, ok
+}
+
+// KnownOpType reports whether name, or an alias of it, is in the registry.
+func KnownOpType(name string) bool {
+	_, ok := opRegistry[canonicalOpType(name)]
+	return ok
+}
+
+// checkOpTypes enforces UnknownOpError.
+func checkOpTypes(p InputProblem, policy UnknownOpPolicy) error {
+	if policy != UnknownOpError {
+		return nil
+	}
+	for op, name := range p.OpTypes {
+		if _, ok := lookupOpType(name, policy, CompatLatest); !ok {
+			return fmt.Errorf("op %d has unknown op type %q", op, name)
+		}
+	}
+	return nil
+}
+
+// role returns how input position i of an op of this type is sliced.
+func (t opTypeInfo) role(i int) operandRole {
+	if i < len(t.operandRoles) {
+		return t.operandRoles[i]
+	}
+	return t.defaultRole
+}
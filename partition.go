@@ -0,0 +1,93 @@
+package mlsys
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// TilePartition schedules p with a given grouping of its ops instead of
+// searching for one: partition lists the subgraphs in execution order, and
+// only their granularities and retained tensors are chosen. Every op must
+// appear in exactly one group, and no group may read a tensor written by a
+// later one. Barriers are placed as Solve places them. KV-cache buckets
+// and serving phases are left out, as they are partitioned anew.
+//
+// A group that does not fit in fast memory at any tile is run at its
+// smallest and reported with an error wrapping ErrInfeasible, as in Solve.
+func TilePartition(ctx context.Context, p InputProblem, partition [][]int, opts Options) (OutputSolution, error) {
+	if err := checkOpTypes(p, opts.UnknownOps); err != nil {
+		return OutputSolution{}, err
+	}
+	if err := validatePartition(p, partition); err != nil {
+		return OutputSolution{}, err
+	}
+	p, err := withCapacityMargin(p, opts)
+	if err != nil {
+		return OutputSolution{}, err
+	}
+	pl := newPlanner(p, opts)
+	plans := make([]subgraphPlan, 0, len(partition))
+	for i, ops := range partition {
+		if err := ctx.Err(); err != nil {
+			return OutputSolution{}, err
+		}
+		ops = append([]int(nil), ops...)
+		sort.Ints(ops)
+		plan, _ := pl.plan(ops)
+		if len(ops) > 1 && !plan.info.fusable {
+			return OutputSolution{}, fmt.Errorf("%w: group %d cannot run as one subgraph", ErrInfeasible, i)
+		}
+		plans = append(plans, plan)
+	}
+	err = checkFits(p, plans)
+	return CanonicalizeSolution(finishSolution(p, plans, opts)), err
+}
+
+// validatePartition checks that partition covers every op of p once, in
+// an order that produces each tensor before it is read.
+func validatePartition(p InputProblem, partition [][]int) error {
+	group := make([]int, len(p.OpTypes))
+	for op := range group {
+		group[op] = -1
+	}
+	for i, ops := range partition {
+		if len(ops) == 0 {
+			return fmt.Errorf("group %d has no ops", i)
+		}
+		for _, op := range ops {
+			if op < 0 || op >= len(p.OpTypes) {
+				return fmt.Errorf("group %d: op index out of range: %d", i, op)
+			}
+			if group[op] >= 0 {
+				return fmt.Errorf("group %d: op %d is already in group %d", i, op, group[op])
+			}
+			group[op] = i
+		}
+	}
+	for op, i := range group {
+		if i < 0 {
+			return fmt.Errorf("op %d is in no group", op)
+		}
+	}
+
+	written := make(map[int]int)
+	for op, i := range group {
+		for _, t := range p.Outputs[op] {
+			written[t] = i
+		}
+	}
+	for _, v := range p.Views {
+		if i, ok := written[v.Base]; ok {
+			written[v.Tensor] = i
+		}
+	}
+	for op, i := range group {
+		for _, t := range p.Inputs[op] {
+			if j, ok := written[t]; ok && j > i {
+				return fmt.Errorf("group %d: op %d reads tensor %d, which group %d writes later", i, op, t, j)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package mlsys
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+)
+
+// Systems that deploy schedules can take an update as a JSON Merge Patch
+// (RFC 7396) against the schedule they already hold, rather than a whole
+// new file. A merge patch is an object holding the fields that changed:
+// objects are patched field by field, a null removes a field, and any
+// other value, arrays included, replaces the old one whole. Re-solving
+// after a small edit to the problem usually changes few fields, so the
+// patch is small; per-subgraph arrays that change at all are sent whole.
+
+// SolutionPatch returns the merge patch that turns prev into next.
+func SolutionPatch(prev, next OutputSolution) (json.RawMessage, error) {
+	a, err := genericJSON(prev)
+	if err != nil {
+		return nil, err
+	}
+	b, err := genericJSON(next)
+	if err != nil {
+		return nil, err
+	}
+	patch := mergePatch(a, b)
+	if patch == nil {
+		patch = map[string]any{}
+	}
+	return json.Marshal(patch)
+}
+
+// ApplySolutionPatch applies a merge patch, as made by SolutionPatch, to s.
+func ApplySolutionPatch(s OutputSolution, patch []byte) (OutputSolution, error) {
+	target, err := genericJSON(s)
+	if err != nil {
+		return OutputSolution{}, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(patch))
+	dec.UseNumber()
+	var p any
+	if err := dec.Decode(&p); err != nil {
+		return OutputSolution{}, err
+	}
+	if _, ok := p.(map[string]any); !ok {
+		return OutputSolution{}, errors.New("solution patch is not a JSON object")
+	}
+	data, err := json.Marshal(applyMergePatch(target, p))
+	if err != nil {
+		return OutputSolution{}, err
+	}
+	var out OutputSolution
+	if err := json.Unmarshal(data, &out); err != nil {
+		return OutputSolution{}, err
+	}
+	return out, nil
+}
+
+// genericJSON is v encoded and decoded again into maps, slices and
+// json.Numbers, which keep numbers exactly as encoded.
+func genericJSON(v any) (any, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var out any
+	err = dec.Decode(&out)
+	return out, err
+}
+
+// mergePatch is the merge patch from a to b, or nil when they are equal
+// objects.
+func mergePatch(a, b any) any {
+	ma, okA := a.(map[string]any)
+	mb, okB := b.(map[string]any)
+	if !okA || !okB {
+		return b
+	}
+	patch := make(map[string]any)
+	for k := range ma {
+		if _, ok := mb[k]; !ok {
+			patch[k] = nil
+		}
+	}
+	for k, vb := range mb {
+		va, ok := ma[k]
+		switch {
+		case !ok:
+			patch[k] = vb
+		case !reflect.DeepEqual(va, vb):
+			if sub := mergePatch(va, vb); sub != nil {
+				patch[k] = sub
+			}
+		}
+	}
+	if len(patch) == 0 {
+		return nil
+	}
+	return patch
+}
+
+// applyMergePatch applies patch to target as RFC 7396 specifies.
+func applyMergePatch(target, patch any) any {
+	mp, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	mt, ok := target.(map[string]any)
+	if !ok {
+		mt = make(map[string]any)
+	}
+	for k, v := range mp {
+		if v == nil {
+			delete(mt, k)
+		} else {
+			mt[k] = applyMergePatch(mt[k], v)
+		}
+	}
+	return mt
+}
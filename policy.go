@@ -0,0 +1,222 @@
+package mlsys
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// A Policy makes the grouping and tiling decisions of a schedule in place
+// of the search, so learned schedulers can be trained and run on top of the
+// solver's graph analysis and cost model. With Options.Policy, Solve plays
+// one episode: it walks the ops in order and, for each, asks the policy
+// whether the op joins the subgraph before it and at which tile the
+// resulting subgraph runs. Every state carries the reward of the previous
+// action, the drop in the schedule's estimated latency it caused, and the
+// episode ends with a state marked Done whose reward is that of the last
+// action. Fixed subgraphs are placed as one unit and join nothing.
+// Templates, block deduplication, the target latency and Stabilize are
+// ignored; the subgraph limits are still enforced afterwards, and may
+// change what the policy chose. When fast memory has auto levels, Solve
+// plays one episode per combination of modes it tries.
+type Policy interface {
+	// Act returns the action for state. Its result is ignored for a Done
+	// state.
+	Act(ctx context.Context, state PolicyState) (PolicyAction, error)
+}
+
+// ErrPolicy is returned, wrapped, when a policy fails or takes an action
+// the state does not allow.
+var ErrPolicy = errors.New("policy failed")
+
+// PolicyState is what a Policy sees before placing an op. Latencies are
+// nominal estimates, as in OutputSolution.SubgraphLatencies; tiles are
+// listed largest first, and only those that fit are offered.
+type PolicyState struct {
+	// Step counts the actions taken so far in the episode.
+	Step int `json:"step"`
+	// Ops are the ops to place, in order: one op, or a fixed subgraph.
+	// Remaining counts the ops still to place after them.
+	Ops       []int    `json:"ops"`
+	OpTypes   []string `json:"op_types"`
+	Remaining int      `json:"remaining"`
+
+	// Open is the subgraph before Ops, which they may join, with its tile
+	// and latency; empty at the start.
+	Open            []int    `json:"open,omitempty"`
+	OpenGranularity [3]int64 `json:"open_granularity"`
+	OpenLatency     float64  `json:"open_latency"`
+
+	// Alone describes Ops as a subgraph of their own: the tile the solver
+	// would choose, its latency, and the tiles that fit.
+	AloneGranularity [3]int64   `json:"alone_granularity"`
+	AloneLatency     float64    `json:"alone_latency"`
+	AloneTiles       [][3]int64 `json:"alone_tiles"`
+	// Joinable reports whether Ops may join Open: the two lie in the same
+	// region and between the same barriers, and fit together at some tile.
+	// The Joined fields describe the joined subgraph when they may.
+	Joinable          bool       `json:"joinable"`
+	JoinedGranularity [3]int64   `json:"joined_granularity"`
+	JoinedLatency     float64    `json:"joined_latency"`
+	JoinedTiles       [][3]int64 `json:"joined_tiles,omitempty"`
+
+	// Reward is the drop in TotalLatency the previous action caused,
+	// negative when it added latency, and zero at the first step.
+	// TotalLatency is the estimated latency of the subgraphs so far.
+	Reward       float64 `json:"reward"`
+	TotalLatency float64 `json:"total_latency"`
+	Done         bool    `json:"done"`
+}
+
+// PolicyAction places the ops of a PolicyState.
+type PolicyAction struct {
+	// Join adds the ops to the open subgraph instead of starting a new one
+	// with them. It is only allowed when the state is joinable.
+	Join bool `json:"join"`
+	// Granularity, when set, is the tile of the resulting subgraph, which
+	// must be one of the tiles the state offers for it. Unset keeps the
+	// tile the solver would choose.
+	Granularity *[3]int64 `json:"granularity,omitempty"`
+}
+
+// policyPlans plays one episode of pol over the ops of pl's problem.
+func policyPlans(ctx context.Context, pl *planner, pol Policy) ([]subgraphPlan, error) {
+	p := pl.p
+	fixed := fixedSubgraphAt(p)
+	bw, _ := decisionBandwidth(p)
+	var plans []subgraphPlan
+	var total compensatedSum
+	var step int
+	var reward float64
+	for op := range p.OpTypes {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		group, isFixed := fixed[op]
+		if isFixed && group == nil {
+			continue
+		}
+		if !isFixed {
+			group = []int{op}
+		}
+		alone, _ := pl.plan(group)
+		alone.fixed = isFixed
+		state := PolicyState{
+			Step:             step,
+			Ops:              alone.ops,
+			OpTypes:          make([]string, len(alone.ops)),
+			Remaining:        len(p.OpTypes) - 1 - op,
+			AloneGranularity: alone.granularity,
+			AloneLatency:     alone.latency,
+			AloneTiles:       fittingTiles(p, alone.info),
+			Reward:           reward,
+			TotalLatency:     total.Total(),
+		}
+		for i, o := range alone.ops {
+			state.OpTypes[i] = p.OpTypes[o]
+		}
+		var joined subgraphPlan
+		if n := len(plans); n > 0 {
+			open := plans[n-1]
+			state.Open, state.OpenGranularity, state.OpenLatency = open.ops, open.granularity, open.latency
+			if pl.mayJoin(open, alone) {
+				joined, state.Joinable = pl.planJoined(open, alone)
+			}
+			if state.Joinable {
+				state.JoinedGranularity, state.JoinedLatency = joined.granularity, joined.latency
+				state.JoinedTiles = fittingTiles(p, joined.info)
+			}
+		}
+
+		a, err := pol.Act(ctx, state)
+		if err != nil {
+			return nil, fmt.Errorf("%w at op %d: %w", ErrPolicy, op, err)
+		}
+		next, tiles := alone, state.AloneTiles
+		if a.Join {
+			if !state.Joinable {
+				return nil, fmt.Errorf("%w at op %d: ops %v cannot join the subgraph before them", ErrPolicy, op, alone.ops)
+			}
+			next, tiles = joined, state.JoinedTiles
+			plans = plans[:len(plans)-1]
+			total.Add(-state.OpenLatency)
+		}
+		if g := a.Granularity; g != nil && *g != next.granularity {
+			if !containsTile(tiles, *g) {
+				return nil, fmt.Errorf("%w at op %d: tile %v does not fit ops %v", ErrPolicy, op, *g, next.ops)
+			}
+			next.granularity = *g
+			next.latency = estimateSubgraphLatency(p, next.info, *g)
+			next.objective = estimateGroupLatencyAtBandwidth(p, next.info, *g, bw)
+		}
+		plans = append(plans, next)
+		total.Add(next.latency)
+		reward = state.TotalLatency - total.Total()
+		step++
+	}
+	end := PolicyState{Step: step, Reward: reward, TotalLatency: total.Total(), Done: true}
+	if _, err := pol.Act(ctx, end); err != nil {
+		return nil, fmt.Errorf("%w at the end: %w", ErrPolicy, err)
+	}
+	return plans, nil
+}
+
+// fittingTiles lists the candidate tiles of a group that fit in fast
+// memory and within the DMA limits, largest first.
+func fittingTiles(p InputProblem, info groupInfo) [][3]int64 {
+	ws, hs, k := candidateTiles(p, info)
+	var fit [][3]int64
+	for _, w := range ws {
+		for _, h := range hs {
+			if fitsFastMemory(p, info, w, h, k) && withinDMALimits(p, info, w, h, k) {
+				fit = append(fit, [3]int64{w, h, k})
+			}
+		}
+	}
+	return fit
+}
+
+func containsTile(tiles [][3]int64, g [3]int64) bool {
+	for _, t := range tiles {
+		if t == g {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonPolicy is a Policy served by another process over a stream of JSON
+// values.
+type jsonPolicy struct {
+	enc *json.Encoder
+	dec *json.Decoder
+}
+
+// NewJSONPolicy returns a Policy that writes every state to w as one line
+// of JSON and reads the action for it from r as one JSON value, so a
+// policy can run in any process and language: a trainer, or a bridge to a
+// gRPC service or a WebAssembly module. Done states are written but read
+// no action.
+func NewJSONPolicy(r io.Reader, w io.Writer) Policy {
+	return &jsonPolicy{enc: json.NewEncoder(w), dec: json.NewDecoder(bufio.NewReader(r))}
+}
+
+func (jp *jsonPolicy) Act(ctx context.Context, state PolicyState) (PolicyAction, error) {
+	if err := jp.enc.Encode(state); err != nil {
+		return PolicyAction{}, fmt.Errorf("writing state: %w", err)
+	}
+	var a PolicyAction
+	if state.Done {
+		return a, nil
+	}
+	if err := jp.dec.Decode(&a); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return PolicyAction{}, fmt.Errorf("reading action: %w", err)
+	}
+	return a, nil
+}
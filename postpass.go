@@ -0,0 +1,176 @@
+package mlsys
+
+import (
+	"context"
+	"fmt"
+	"math"
+)
+
+// maxGroupSize capped how many ops the merge pass fused into one subgraph
+// before CompatV1_5. Every accepted merge re-evaluates all neighbouring
+// pairs, and group analysis is linear in group size, so the cap bounded
+// the pass's cost; from CompatV1_5 on, fast-memory fit and cost alone
+// bound merges, so deep fusion chains can form.
+const maxGroupSize = 4
+
+// mergeLimit is the largest group the merge pass may form at compat level
+// c, or 0 for no limit.
+func mergeLimit(c CompatLevel) int {
+	if c.atLeast(CompatV1_5) {
+		return 0
+	}
+	return maxGroupSize
+}
+
+// mergeAdjacentSubgraphs is a peephole pass over a finished schedule. It
+// repeatedly fuses the neighbouring pair whose merged group still fits in
+// fast memory and saves the most modeled latency, until no merge helps.
+// A merge that does not fit only grows more out of reach as either side
+// gains ops, since footprints are monotone in the group, so the pass prunes
+// by fit and gain rather than by size. Merging neighbours never reorders
+// ops, so a topological schedule stays topological. It returns early, with
+// the merges made so far, once ctx is done or the schedule meets the
+// target latency.
+func mergeAdjacentSubgraphs(ctx context.Context, pl *planner, plans []subgraphPlan) []subgraphPlan {
+	limit := mergeLimit(pl.compat)
+	for {
+		if ctx.Err() != nil || pl.metTarget(plans) {
+			return plans
+		}
+		bestIdx := -1
+		bestGain := 0.0
+		var bestPlan subgraphPlan
+		for i := 0; i+1 < len(plans); i++ {
+			a, b := plans[i], plans[i+1]
+			if (limit > 0 && len(a.ops)+len(b.ops) > limit) || !pl.mayJoin(a, b) {
+				continue
+			}
+			merged, ok := pl.planJoined(a, b)
+			if !ok {
+				continue
+			}
+			if gain := a.objective + b.objective - merged.objective; gain > bestGain {
+				bestIdx, bestGain, bestPlan = i, gain, merged
+			}
+		}
+		if bestIdx < 0 {
+			return plans
+		}
+		plans[bestIdx] = bestPlan
+		plans = append(plans[:bestIdx+1], plans[bestIdx+2:]...)
+	}
+}
+
+// splitMemoryBoundSubgraphs is the converse of the merge pass. A
+// memory-bound subgraph whose fused working set forces a coarse tile (few,
+// large steps) leaves no room to overlap transfers with compute; splitting
+// it lets each half stream with a tile it can double-buffer. Every split
+// point is tried and the best one is taken only when the halves' modeled
+// latency beats the original; halves are re-examined in turn. Once ctx is
+// done the remaining subgraphs are passed through unexamined.
+func splitMemoryBoundSubgraphs(ctx context.Context, pl *planner, plans []subgraphPlan) []subgraphPlan {
+	bw, _ := decisionBandwidth(pl.p)
+	out := make([]subgraphPlan, 0, len(plans))
+	work := append([]subgraphPlan(nil), plans...)
+	for len(work) > 0 {
+		if ctx.Err() != nil {
+			return append(out, work...)
+		}
+		plan := work[0]
+		work = work[1:]
+		if len(plan.ops) < 2 || plan.fixed {
+			out = append(out, plan)
+			continue
+		}
+		_, compute, mem := stepCosts(pl.p, plan.info, plan.granularity, bw)
+		if mem <= compute {
+			out = append(out, plan)
+			continue
+		}
+
+		// Heads grow and tails shrink by one op per cut, so both are built
+		// incrementally from their neighbours rather than re-analyzed.
+		n := len(plan.ops)
+		heads := make([]subgraphPlan, n)
+		headOK := make([]bool, n)
+		tails := make([]subgraphPlan, n)
+		tailOK := make([]bool, n)
+		heads[1], headOK[1] = pl.plan(plan.ops[:1])
+		for cut := 2; cut < n; cut++ {
+			single, _ := pl.plan(plan.ops[cut-1 : cut])
+			heads[cut], headOK[cut] = pl.planJoined(heads[cut-1], single)
+		}
+		tails[n-1], tailOK[n-1] = pl.plan(plan.ops[n-1:])
+		for cut := n - 2; cut >= 1; cut-- {
+			single, _ := pl.plan(plan.ops[cut : cut+1])
+			tails[cut], tailOK[cut] = pl.planJoined(single, tails[cut+1])
+		}
+
+		bestCost := plan.objective
+		var bestHead, bestTail subgraphPlan
+		split := false
+		for cut := 1; cut < n; cut++ {
+			head, tail := heads[cut], tails[cut]
+			if !headOK[cut] || !tailOK[cut] {
+				continue
+			}
+			if cost := head.objective + tail.objective; cost < bestCost {
+				bestCost, bestHead, bestTail, split = cost, head, tail, true
+			}
+		}
+		if !split {
+			out = append(out, plan)
+			continue
+		}
+		work = append([]subgraphPlan{bestHead, bestTail}, work...)
+	}
+	return out
+}
+
+// enforceSubgraphLimits merges neighbouring subgraphs until the schedule
+// meets opts.MinOpsPerSubgraph and opts.MaxSubgraphs, each time taking the
+// merge that costs the least modeled latency. Subgraphs below the minimum
+// are merged first. It ignores any merge limit, but it still only fuses
+// groups that fit and may share a subgraph; when no such merge is left, it
+// returns the schedule as it stands and an error wrapping ErrInfeasible.
+func enforceSubgraphLimits(pl *planner, plans []subgraphPlan, opts Options) ([]subgraphPlan, error) {
+	small := func(plan subgraphPlan) bool { return len(plan.ops) < opts.MinOpsPerSubgraph }
+	for {
+		anySmall := false
+		for _, plan := range plans {
+			anySmall = anySmall || small(plan)
+		}
+		tooMany := opts.MaxSubgraphs > 0 && len(plans) > opts.MaxSubgraphs
+		if !anySmall && !tooMany {
+			return plans, nil
+		}
+
+		bestIdx := -1
+		bestCost := math.Inf(1)
+		var bestPlan subgraphPlan
+		for i := 0; i+1 < len(plans); i++ {
+			a, b := plans[i], plans[i+1]
+			if anySmall && !small(a) && !small(b) {
+				continue
+			}
+			if !pl.mayJoin(a, b) {
+				continue
+			}
+			merged, ok := pl.planJoined(a, b)
+			if !ok {
+				continue
+			}
+			if cost := merged.objective - a.objective - b.objective; cost < bestCost {
+				bestIdx, bestCost, bestPlan = i, cost, merged
+			}
+		}
+		if bestIdx < 0 {
+			if anySmall {
+				return plans, fmt.Errorf("%w: cannot give every subgraph at least %d ops", ErrInfeasible, opts.MinOpsPerSubgraph)
+			}
+			return plans, fmt.Errorf("%w: cannot schedule in at most %d subgraphs", ErrInfeasible, opts.MaxSubgraphs)
+		}
+		plans[bestIdx] = bestPlan
+		plans = append(plans[:bestIdx+1], plans[bestIdx+2:]...)
+	}
+}
@@ -0,0 +1,50 @@
+package mlsys
+
+import "context"
+
+// preclusterElementwise fuses runs of neighbouring memory-bound elementwise
+// ops before the merge pass sees them. Such ops do next to no work per
+// element they move, so a chain of them is worth fusing into one streaming
+// subgraph however long it is; the merge pass, taking one pair at a time
+// and before CompatV1_5 capped at maxGroupSize ops, would leave a long
+// chain in pieces. A run grows one op at a time while the fused group fits
+// and its modeled latency beats that of the run and the op apart. Each
+// cluster enters the merge pass as a single plan, so the merge pass has
+// fewer plans to pair up. It returns early, with the clusters built so far,
+// once ctx is done.
+func preclusterElementwise(ctx context.Context, pl *planner, plans []subgraphPlan) []subgraphPlan {
+	bw, _ := decisionBandwidth(pl.p)
+	candidate := func(plan subgraphPlan) bool {
+		if len(plan.ops) != 1 || plan.fixed {
+			return false
+		}
+		t := pl.gi.opTypes[plan.ops[0]]
+		if t.class != classElementwise || t.compute != computePerStep || !t.fusable || !t.tileable {
+			return false
+		}
+		_, compute, mem := stepCosts(pl.p, plan.info, plan.granularity, bw)
+		return mem > compute
+	}
+	out := make([]subgraphPlan, 0, len(plans))
+	for i := 0; i < len(plans); i++ {
+		if ctx.Err() != nil {
+			return append(out, plans[i:]...)
+		}
+		cluster := plans[i]
+		if !candidate(cluster) {
+			out = append(out, cluster)
+			continue
+		}
+		for i+1 < len(plans) && candidate(plans[i+1]) && pl.mayJoin(cluster, plans[i+1]) {
+			next := plans[i+1]
+			joined, ok := pl.planJoined(cluster, next)
+			if !ok || joined.objective >= cluster.objective+next.objective {
+				break
+			}
+			cluster = joined
+			i++
+		}
+		out = append(out, cluster)
+	}
+	return out
+}
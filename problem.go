@@ -0,0 +1,375 @@
+// Package mlsys schedules operator graphs onto a slow/fast memory
+// hierarchy: it groups ops into subgraphs, picks an execution granularity
+// for each, and estimates their latencies under a roofline model.
+package mlsys
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// InputProblem is the contest input: the operator graph as parallel
+// per-tensor and per-op slices, plus the hardware description.
+type InputProblem struct {
+	Widths  []int64 `json:"widths"`
+	Heights []int64 `json:"heights"`
+	// TensorNames optionally names each tensor, parallel to Widths. The
+	// shards of one graph name theirs to link them; see shards.go.
+	TensorNames         []string  `json:"tensor_names,omitempty"`
+	Inputs              [][]int   `json:"inputs"`
+	Outputs             [][]int   `json:"outputs"`
+	BaseCosts           []float64 `json:"base_costs"`
+	OpTypes             []string  `json:"op_types"`
+	FastMemoryCapacity  float64   `json:"fast_memory_capacity"`
+	SlowMemoryBandwidth float64   `json:"slow_memory_bandwidth"`
+	NativeGranularity   [2]int64  `json:"native_granularity"`
+
+	// BandwidthDistribution optionally describes how far the delivered
+	// slow-memory bandwidth strays from the nominal SlowMemoryBandwidth.
+	BandwidthDistribution *BandwidthDistribution `json:"bandwidth_distribution"`
+	// BackgroundDRAMTraffic is slow-memory bandwidth consumed by other
+	// agents sharing the DRAM, in the same units as SlowMemoryBandwidth.
+	BackgroundDRAMTraffic float64 `json:"background_dram_traffic"`
+	// Regions marks conditional and loop bodies. Subgraphs never straddle
+	// a region boundary, so each body is scheduled on its own.
+	Regions []ControlRegion `json:"regions,omitempty"`
+	// KVCache marks the graph as an autoregressive decode step whose
+	// attention cache grows with the sequence length.
+	KVCache *KVCache `json:"kv_cache,omitempty"`
+	// Serving requests linked prefill and decode schedules.
+	Serving *Serving `json:"serving,omitempty"`
+	// CostModelUncertainty is the calibration error of the cost model.
+	CostModelUncertainty *CostModelUncertainty `json:"cost_model_uncertainty,omitempty"`
+	// OpLayers names the model layer or module each op belongs to, e.g.
+	// "block3.attn". It only feeds the layer report.
+	OpLayers []string `json:"op_layers,omitempty"`
+	// OpAttributes optionally attaches opaque metadata to each op,
+	// parallel to OpTypes, which the solution repeats per subgraph. See
+	// attributes.go.
+	OpAttributes []OpAttributes `json:"op_attributes,omitempty"`
+	// Barriers are synchronization points, emitted as zero-op subgraphs.
+	Barriers []Barrier `json:"barriers,omitempty"`
+	// TileConstraints restrict the tile sizes allowed per op type or op.
+	TileConstraints []TileConstraint `json:"tile_constraints,omitempty"`
+	// VectorWidth is the lane count of the vector engine that runs
+	// elementwise ops. When set, their compute scales with the tile instead
+	// of being charged per native tile.
+	VectorWidth int64 `json:"vector_width,omitempty"`
+	// DataDType is the element type of the tensors (default fp16) and
+	// AccumulatorDType that of matmul partial sums. Setting the latter
+	// charges the accumulator tile to fast memory whenever the reduction is
+	// split over several steps, and spares the partial-sum traffic of
+	// those steps.
+	DataDType        string `json:"data_dtype,omitempty"`
+	AccumulatorDType string `json:"accumulator_dtype,omitempty"`
+	// QuantizedDType is the element type Quantize ops write (default
+	// int8). See quant.go.
+	QuantizedDType string `json:"quantized_dtype,omitempty"`
+	// OpHeads gives the head count of multi-head ops, parallel to OpTypes;
+	// 0 or 1 means the op is not split in heads. See heads.go.
+	OpHeads []int64 `json:"op_heads,omitempty"`
+	// Views declare tensors that alias the storage of another tensor.
+	Views []TensorView `json:"views,omitempty"`
+	// OperandReuse charges each operand tile only on the steps where it
+	// changes, instead of reloading the whole working set every step.
+	OperandReuse bool `json:"operand_reuse,omitempty"`
+	// SubgraphDispatchOverhead is a fixed cost, in the units of BaseCosts,
+	// added to the latency of every subgraph for launching it. It makes
+	// the merge and split passes weigh fusion against dispatch count.
+	SubgraphDispatchOverhead float64 `json:"subgraph_dispatch_overhead,omitempty"`
+	// FixedSubgraphs are op groups that must each run as one subgraph,
+	// e.g. vendor-fused kernels. See fixed.go.
+	FixedSubgraphs [][]int `json:"fixed_subgraphs,omitempty"`
+	// TensorRowPitches gives the distance between row starts of each
+	// tensor's storage, parallel to Widths; 0 means its width. Transfers
+	// that are not contiguous pay DMARowOverhead per row. See dma.go.
+	TensorRowPitches []int64 `json:"tensor_row_pitches,omitempty"`
+	DMARowOverhead   float64 `json:"dma_row_overhead,omitempty"`
+	// DMATransferLatency is the fixed latency of every DMA descriptor,
+	// contiguous or not, so small transfers fall short of full bandwidth.
+	DMATransferLatency float64 `json:"dma_transfer_latency,omitempty"`
+	// SlowMemoryLatency is the fixed access latency of slow memory, paid
+	// once by every step that transfers anything.
+	SlowMemoryLatency float64 `json:"slow_memory_latency,omitempty"`
+	// LUTElements overrides the size of the lookup table of an op type
+	// such as gelu or exp. See luts.go.
+	LUTElements map[string]int64 `json:"lut_elements,omitempty"`
+	// GatherLocality is the fraction of the lookups of a gather tile that
+	// repeat a row the tile already fetched. See gather.go.
+	GatherLocality float64 `json:"gather_locality,omitempty"`
+	// FastMemoryBanks is the bank count of fast memory, and
+	// BankConflictCost the compute time of each access round lost to bank
+	// conflicts. See banks.go.
+	FastMemoryBanks  int64   `json:"fast_memory_banks,omitempty"`
+	BankConflictCost float64 `json:"bank_conflict_cost,omitempty"`
+	// MaxBufferDepth lets each subgraph pick how many copies of its
+	// streamed tiles it holds, up to this depth, trading fast memory for
+	// overlap of transfers and compute. See buffering.go.
+	MaxBufferDepth int `json:"max_buffer_depth,omitempty"`
+	// ParallelBranches lets independent ops of a subgraph overlap on the
+	// matrix and vector engines. See branches.go.
+	ParallelBranches bool `json:"parallel_branches,omitempty"`
+	// RegisterFusable lists [producer, consumer] op pairs that pass data
+	// through registers when fused, so the tensor between them needs no
+	// buffer in fast memory.
+	RegisterFusable [][2]int `json:"register_fusable,omitempty"`
+	// FastMemories splits fast memory into separate buffers, each holding
+	// the tensors of some classes; TensorClasses, parallel to Widths,
+	// overrides the default class of a tensor. See memories.go.
+	FastMemories  []FastMemory `json:"fast_memories,omitempty"`
+	TensorClasses []string     `json:"tensor_classes,omitempty"`
+	// FastMemoryMode runs pooled fast memory as a scratchpad (the default),
+	// a cache, or either as the solver sees fit. See memorymodes.go.
+	FastMemoryMode string `json:"fast_memory_mode,omitempty"`
+	// MaxDMADescriptors and MinDMATransferSize bound the descriptors one
+	// step enqueues and the elements each moves. See descriptors.go.
+	MaxDMADescriptors  int64 `json:"max_dma_descriptors,omitempty"`
+	MinDMATransferSize int64 `json:"min_dma_transfer_size,omitempty"`
+}
+
+// BandwidthDistribution models delivered slow-memory bandwidth as either a
+// standard deviation around the nominal value or explicit percentile points
+// keyed by percentile ("5", "50", ...), each in (0, 100). Percentile points
+// take precedence: the 5th percentile, which drives P95 latency, is read
+// from them, or interpolated or extrapolated linearly from the two nearest.
+type BandwidthDistribution struct {
+	Std         float64            `json:"std"`
+	Percentiles map[string]float64 `json:"percentiles"`
+}
+
+// OutputSolution is the contest output: parallel per-subgraph slices in
+// execution order.
+type OutputSolution struct {
+	Subgraphs         [][]int    `json:"subgraphs"`
+	Granularities     [][3]int64 `json:"granularities"`
+	TensorsToRetain   [][]int    `json:"tensors_to_retain"`
+	TraversalOrders   []*[]int64 `json:"traversal_orders"`
+	SubgraphLatencies []float64  `json:"subgraph_latencies"`
+
+	// SubgraphLatenciesP95 is only emitted when the problem carries a
+	// bandwidth distribution.
+	SubgraphLatenciesP95 []float64 `json:"subgraph_latencies_p95,omitempty"`
+	// SubgraphLatenciesInterference is only emitted when the problem declares
+	// background DRAM traffic.
+	SubgraphLatenciesInterference []float64 `json:"subgraph_latencies_interference,omitempty"`
+	// SubgraphExecutionCounts is the expected number of executions of each
+	// subgraph per run, only emitted when the problem declares regions.
+	SubgraphExecutionCounts []float64 `json:"subgraph_execution_counts,omitempty"`
+	// SubgraphLatencyIntervals is only emitted when the problem states the
+	// cost model's uncertainty.
+	SubgraphLatencyIntervals []LatencyInterval `json:"subgraph_latency_intervals,omitempty"`
+	// SubgraphDataflows is the loop order each subgraph runs with, only
+	// emitted when the problem enables operand reuse. Barriers have none.
+	SubgraphDataflows []string `json:"subgraph_dataflows,omitempty"`
+	// SubgraphBufferDepths is the buffer depth each subgraph runs with,
+	// only emitted when the problem sets max_buffer_depth. Barriers have 0.
+	SubgraphBufferDepths []int `json:"subgraph_buffer_depths,omitempty"`
+	// OpOrders lists each subgraph's ops in the order they start, only
+	// emitted when the problem enables parallel branches or on request;
+	// see Options.EmitOpOrders. Barriers have none.
+	OpOrders [][]int `json:"op_orders,omitempty"`
+	// SubgraphOpAttributes holds the attributes of each subgraph's ops,
+	// keyed by op index, only emitted when the problem carries
+	// op_attributes.
+	SubgraphOpAttributes []map[int]OpAttributes `json:"subgraph_op_attributes,omitempty"`
+	// LayerLatencies is only emitted when the problem maps ops to layers.
+	LayerLatencies []LayerLatency `json:"layer_latencies,omitempty"`
+	// SubgraphDependencies is only emitted on request; see
+	// Options.EmitDependencies.
+	SubgraphDependencies []DependencyEdge `json:"subgraph_dependencies,omitempty"`
+	// KVCacheBuckets holds one schedule per cache-length bucket, only
+	// emitted when the problem declares a KV cache.
+	KVCacheBuckets []KVCacheBucket `json:"kv_cache_buckets,omitempty"`
+	// SubgraphDMAStats counts the DMA descriptors of each subgraph, only
+	// emitted when the problem sets DMA limits or on request; see
+	// Options.EmitDMAStats.
+	SubgraphDMAStats []DMAStats `json:"subgraph_dma_stats,omitempty"`
+	// Serving is only emitted when the problem requests serving schedules.
+	Serving *ServingSchedules `json:"serving,omitempty"`
+	// MemoryModes is the mode of each level of fast memory the schedule
+	// assumes, only emitted when the problem leaves one to the solver.
+	MemoryModes []string `json:"memory_modes,omitempty"`
+	// Residuals records the decisions RetainTensors took for tensors read
+	// again beyond the next subgraph. Solve does not emit it.
+	Residuals []ResidualDecision `json:"residuals,omitempty"`
+	// Meta describes the run that produced the schedule, when
+	// Options.EmitMeta is set.
+	Meta *SolveMeta `json:"meta,omitempty"`
+	// RepeatedBlock describes the repeated blocks Solve scheduled once,
+	// when Options.DedupeBlocks is set and it found some.
+	RepeatedBlock *RepeatedBlock `json:"repeated_block,omitempty"`
+	// Integrity hashes the problem and options the schedule was solved
+	// for, when Options.EmitIntegrity is set.
+	Integrity *Integrity `json:"integrity,omitempty"`
+	// KernelNames names the kernel of each subgraph, empty for barriers,
+	// when Options.KernelNameFormat is set.
+	KernelNames []string `json:"kernel_names,omitempty"`
+	// Warnings flags what the schedule does that is legal but suspect.
+	// See warnings.go.
+	Warnings []ScheduleWarning `json:"warnings,omitempty"`
+}
+
+// ValidateProblem checks that p is structurally sound. Solve assumes its
+// input has passed this check.
+func ValidateProblem(p InputProblem) error {
+	for _, c := range problemChecks {
+		if err := c.Check(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ProblemCheck is one of the checks ValidateProblem runs, for callers that
+// run or report them one by one.
+type ProblemCheck struct {
+	Name  string
+	Check func(InputProblem) error
+}
+
+// ProblemChecks lists the checks of ValidateProblem in the order it runs
+// them. Each may assume that p passed the ones before it.
+func ProblemChecks() []ProblemCheck {
+	return append([]ProblemCheck{}, problemChecks...)
+}
+
+var problemChecks = []ProblemCheck{
+	{"operations", validateOperations},
+	{"tensors", validateTensors},
+	{"tensor_sizes", validateTensorSizes},
+	{"memory", validateMemory},
+	{"bandwidth_distribution", validateBandwidthDistribution},
+	{"regions", validateRegions},
+	{"kv_cache", validateKVCache},
+	{"serving", validateServing},
+	{"cost_model_uncertainty", validateUncertainty},
+	{"op_layers", validateOpLayers},
+	{"op_attributes", validateOpAttributes},
+	{"barriers", validateBarriers},
+	{"tile_constraints", validateTileConstraints},
+	{"vector_width", validateVectorWidth},
+	{"subgraph_dispatch_overhead", validateDispatchOverhead},
+	{"dtypes", validateDTypes},
+	{"op_heads", validateOpHeads},
+	{"views", validateViews},
+	{"fixed_subgraphs", validateFixedSubgraphs},
+	{"tensor_row_pitches", validateRowPitches},
+	{"fast_memory_banks", validateBanks},
+	{"max_buffer_depth", validateBufferDepth},
+	{"register_fusable", validateRegisterFusable},
+	{"fast_memories", validateFastMemories},
+	{"fast_memory_mode", validateMemoryModes},
+	{"dma_limits", validateDMALimits},
+	{"lut_elements", validateLUTElements},
+	{"gather_locality", validateGatherLocality},
+	{"tensor_indices", validateTensorIndices},
+}
+
+func validateOperations(p InputProblem) error {
+	nOps := len(p.OpTypes)
+	if nOps == 0 {
+		return errors.New("problem has no operations")
+	}
+	if len(p.Inputs) != nOps || len(p.Outputs) != nOps || len(p.BaseCosts) != nOps {
+		return errors.New("inputs/outputs/base_costs/op_types length mismatch")
+	}
+	return nil
+}
+
+func validateTensors(p InputProblem) error {
+	if len(p.Widths) != len(p.Heights) {
+		return errors.New("widths/heights length mismatch")
+	}
+	if p.TensorNames != nil && len(p.TensorNames) != len(p.Widths) {
+		return errors.New("tensor_names/widths length mismatch")
+	}
+	return nil
+}
+
+func validateMemory(p InputProblem) error {
+	if p.SlowMemoryBandwidth <= 0 {
+		return errors.New("slow_memory_bandwidth must be > 0")
+	}
+	if p.SlowMemoryLatency < 0 {
+		return errors.New("slow_memory_latency must be >= 0")
+	}
+	if p.FastMemoryCapacity <= 0 {
+		return errors.New("fast_memory_capacity must be > 0")
+	}
+	if p.NativeGranularity[0] <= 0 || p.NativeGranularity[1] <= 0 {
+		return errors.New("native_granularity entries must be > 0")
+	}
+	if p.BackgroundDRAMTraffic < 0 || p.BackgroundDRAMTraffic >= p.SlowMemoryBandwidth {
+		return errors.New("background_dram_traffic must be >= 0 and below slow_memory_bandwidth")
+	}
+	return nil
+}
+
+func validateBandwidthDistribution(p InputProblem) error {
+	d := p.BandwidthDistribution
+	if d == nil {
+		return nil
+	}
+	if d.Std < 0 {
+		return errors.New("bandwidth_distribution.std must be >= 0")
+	}
+	keys := make([]string, 0, len(d.Percentiles))
+	for key := range d.Percentiles {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	seen := make(map[float64]string, len(keys))
+	for _, key := range keys {
+		bw := d.Percentiles[key]
+		pct, err := strconv.ParseFloat(key, 64)
+		if err != nil || !(pct > 0 && pct < 100) {
+			return fmt.Errorf("bandwidth_distribution percentile %q is not a percentile in (0, 100)", key)
+		}
+		if other, ok := seen[pct]; ok {
+			return fmt.Errorf("bandwidth_distribution percentiles %q and %q are the same point", other, key)
+		}
+		seen[pct] = key
+		if bw <= 0 {
+			return fmt.Errorf("bandwidth_distribution percentile %q must be > 0", key)
+		}
+	}
+	if _, ok := seen[5]; !ok && len(seen) == 1 {
+		return errors.New("bandwidth_distribution needs the 5th percentile, or two points to extrapolate it from")
+	}
+	if p95Bandwidth(p) <= 0 {
+		return errors.New("bandwidth_distribution implies a non-positive P95 bandwidth")
+	}
+	return nil
+}
+
+func validateVectorWidth(p InputProblem) error {
+	if p.VectorWidth < 0 {
+		return errors.New("vector_width must be >= 0")
+	}
+	return nil
+}
+
+func validateDispatchOverhead(p InputProblem) error {
+	if p.SubgraphDispatchOverhead < 0 {
+		return errors.New("subgraph_dispatch_overhead must be >= 0")
+	}
+	return nil
+}
+
+func validateTensorIndices(p InputProblem) error {
+	for op := range p.OpTypes {
+		for _, t := range p.Inputs[op] {
+			if t < 0 || t >= len(p.Widths) {
+				return fmt.Errorf("op %d input tensor index out of range: %d", op, t)
+			}
+		}
+		for _, t := range p.Outputs[op] {
+			if t < 0 || t >= len(p.Widths) {
+				return fmt.Errorf("op %d output tensor index out of range: %d", op, t)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package mlsys
+
+// SolveProgress records how far a Solve call has got, so that a caller
+// recovering from a panic in the search can report where it happened.
+// Solve keeps the one in Options.Progress up to date as it goes; the
+// nested searches of memory modes, KV-cache buckets and serving phases
+// update the same record.
+type SolveProgress struct {
+	// Stage names the step running, such as "merging subgraphs".
+	Stage string `json:"stage"`
+	// Group is the last group of ops the planner evaluated, nil before the
+	// first.
+	Group []int `json:"group,omitempty"`
+	// Schedule is the schedule as the last finished stage left it, the
+	// best of the search so far, without its reports; nil until the ops
+	// have all been planned once. With DedupeBlocks it covers only the
+	// first of the repeated blocks until they are stamped.
+	Schedule *OutputSolution `json:"schedule,omitempty"`
+}
+
+// enter records the start of stage. It does nothing on a nil record, as
+// do the other methods.
+func (sp *SolveProgress) enter(stage string) {
+	if sp != nil {
+		sp.Stage = stage
+	}
+}
+
+// evaluate records that the planner is evaluating ops, which the caller
+// no longer modifies.
+func (sp *SolveProgress) evaluate(ops []int) {
+	if sp != nil {
+		sp.Group = ops
+	}
+}
+
+// finish records plans as the schedule a stage left.
+func (sp *SolveProgress) finish(p InputProblem, plans []subgraphPlan) {
+	if sp != nil {
+		s := assembleSolution(p, plans)
+		sp.Schedule = &s
+	}
+}
@@ -0,0 +1,118 @@
+package mlsys
+
+import "math"
+
+// Graphs exported with explicit quantization carry Quantize and Dequantize
+// ops around every quantized operator. Their arithmetic, a scale and a
+// rounding per element, is negligible next to the transfers around them
+// and is folded into the loads and stores of the ops they feed, so:
+//
+//   - their base cost is not charged (computeFree);
+//   - they hand their results to their neighbours through registers, so
+//     fusing a subgraph through a Q/DQ pair stages no intermediate tiles;
+//   - what a Quantize op writes and a Dequantize op reads holds
+//     QuantizedDType elements (int8 by default) rather than DataDType
+//     ones, and moves and occupies fast memory in proportion wherever it
+//     crosses a subgraph boundary.
+
+// defaultQuantizedDType is the element type of quantized tensors when
+// quantized_dtype is not given.
+const defaultQuantizedDType = "int8"
+
+var (
+	quantizeOp = opTypeInfo{
+		class:            classElementwise,
+		compute:          computeFree,
+		reductionOperand: -1,
+		tileable:         true,
+		fusable:          true,
+		quantizes:        true,
+	}
+	dequantizeOp = opTypeInfo{
+		class:            classElementwise,
+		compute:          computeFree,
+		reductionOperand: -1,
+		tileable:         true,
+		fusable:          true,
+		dequantizes:      true,
+	}
+)
+
+// quantizedRatio is the size of a quantized element in tensor elements,
+// e.g. 0.5 for int8 over fp16 data.
+func quantizedRatio(p InputProblem) float64 {
+	data, quant := p.DataDType, p.QuantizedDType
+	if data == "" {
+		data = defaultDataDType
+	}
+	if quant == "" {
+		quant = defaultQuantizedDType
+	}
+	dataSize, _ := dtypeSize(data)
+	quantSize, _ := dtypeSize(quant)
+	return float64(quantSize) / float64(dataSize)
+}
+
+// quantizedTensors marks the tensors Quantize ops write and Dequantize ops
+// read, and their views, or returns nil when the graph has none.
+func quantizedTensors(p InputProblem, gi graphIndex) []bool {
+	var quantized []bool
+	mark := func(ts []int) {
+		if quantized == nil {
+			quantized = make([]bool, len(p.Widths))
+		}
+		for _, t := range ts {
+			quantized[gi.root[t]] = true
+		}
+	}
+	for op, ti := range gi.opTypes {
+		if ti.quantizes {
+			mark(p.Outputs[op])
+		}
+		if ti.dequantizes {
+			mark(p.Inputs[op])
+		}
+	}
+	if quantized != nil {
+		for _, v := range p.Views {
+			quantized[v.Tensor] = quantized[gi.root[v.Tensor]]
+		}
+	}
+	return quantized
+}
+
+// tensorElements converts n elements of tensor t into elements of the data
+// type, the unit of capacity and bandwidth.
+func tensorElements(p InputProblem, info groupInfo, t int, n int64) int64 {
+	if info.quantized == nil || !info.quantized[t] {
+		return n
+	}
+	return int64(math.Ceil(float64(n) * quantizedRatio(p)))
+}
+
+// outputTileElements is what writing back one tile of every output of a
+// group at [w, h] moves, in data-type elements.
+func outputTileElements(p InputProblem, info groupInfo, w, h int64) int64 {
+	if len(info.outputs) == 0 {
+		return w * h
+	}
+	var total int64
+	for _, t := range info.outputs {
+		total += tensorElements(p, info, t, w*h)
+	}
+	return total
+}
+
+// opCost is the base cost op is charged under its compute model.
+func (gi graphIndex) opCost(p InputProblem, op int) float64 {
+	if gi.opTypes[op].compute == computeFree {
+		return 0
+	}
+	return p.BaseCosts[op]
+}
+
+// conversionEdge reports whether a producer and consumer pair hands data
+// over through registers because either end is a Q/DQ op.
+func (gi graphIndex) conversionEdge(producer, consumer int) bool {
+	return gi.opTypes[producer].compute == computeFree || gi.opTypes[consumer].compute == computeFree
+}
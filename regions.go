@@ -0,0 +1,186 @@
+package mlsys
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Region kinds accepted in ControlRegion.Kind.
+const (
+	RegionIf    = "if"
+	RegionWhile = "while"
+)
+
+// ControlRegion marks a contiguous range of ops as the body of a
+// conditional or a loop. Regions may nest but must not partially overlap.
+// The body executes TripCount times per entry for a while region and with
+// probability Probability for an if region.
+type ControlRegion struct {
+	Kind        string  `json:"kind"`
+	Ops         []int   `json:"ops"`
+	TripCount   float64 `json:"trip_count,omitempty"`
+	Probability float64 `json:"probability,omitempty"`
+	// LoopCarried lists tensors a while body reads on iteration i+1 that it
+	// wrote on iteration i.
+	LoopCarried []int `json:"loop_carried,omitempty"`
+}
+
+// span returns the half-open op range [first, last+1) covered by r.
+func (r ControlRegion) span() [2]int {
+	lo, hi := r.Ops[0], r.Ops[0]
+	for _, op := range r.Ops {
+		lo = min(lo, op)
+		hi = max(hi, op)
+	}
+	return [2]int{lo, hi + 1}
+}
+
+// weight is the expected number of body executions per entry into r.
+func (r ControlRegion) weight() float64 {
+	if r.Kind == RegionWhile {
+		return r.TripCount
+	}
+	return r.Probability
+}
+
+func validateRegions(p InputProblem) error {
+	nOps := len(p.OpTypes)
+	spans := make([][2]int, len(p.Regions))
+	for i, r := range p.Regions {
+		switch r.Kind {
+		case RegionWhile:
+			if r.TripCount <= 0 {
+				return fmt.Errorf("region %d: trip_count must be > 0", i)
+			}
+		case RegionIf:
+			if r.Probability < 0 || r.Probability > 1 {
+				return fmt.Errorf("region %d: probability must be within [0, 1]", i)
+			}
+			if len(r.LoopCarried) > 0 {
+				return fmt.Errorf("region %d: loop_carried is only valid on while regions", i)
+			}
+		default:
+			return fmt.Errorf("region %d: unknown kind %q (want %q or %q)", i, r.Kind, RegionIf, RegionWhile)
+		}
+		if len(r.Ops) == 0 {
+			return fmt.Errorf("region %d has no ops", i)
+		}
+		seen := make(map[int]bool, len(r.Ops))
+		for _, op := range r.Ops {
+			if op < 0 || op >= nOps {
+				return fmt.Errorf("region %d: op index out of range: %d", i, op)
+			}
+			if seen[op] {
+				return fmt.Errorf("region %d: op %d listed twice", i, op)
+			}
+			seen[op] = true
+		}
+		spans[i] = r.span()
+		if spans[i][1]-spans[i][0] != len(r.Ops) {
+			return fmt.Errorf("region %d: ops must form a contiguous index range", i)
+		}
+		for _, t := range r.LoopCarried {
+			if t < 0 || t >= len(p.Widths) {
+				return fmt.Errorf("region %d: loop_carried tensor index out of range: %d", i, t)
+			}
+		}
+	}
+	for i := range spans {
+		for j := i + 1; j < len(spans); j++ {
+			a, b := spans[i], spans[j]
+			disjoint := a[1] <= b[0] || b[1] <= a[0]
+			nested := (a[0] <= b[0] && b[1] <= a[1]) || (b[0] <= a[0] && a[1] <= b[1])
+			if !disjoint && !nested {
+				return errors.New("regions must be disjoint or nested")
+			}
+		}
+	}
+	return nil
+}
+
+// regionIndex maps each op to its innermost enclosing region, or -1 for
+// ops at the top level, and to the expected number of times it executes
+// per run of the whole graph.
+func regionIndex(p InputProblem) (innermost []int, execCount []float64) {
+	innermost = make([]int, len(p.OpTypes))
+	execCount = make([]float64, len(p.OpTypes))
+	size := make([]int, len(p.OpTypes))
+	for op := range innermost {
+		innermost[op] = -1
+		execCount[op] = 1
+	}
+	for i, r := range p.Regions {
+		w := r.weight()
+		for _, op := range r.Ops {
+			execCount[op] *= w
+			if innermost[op] < 0 || len(r.Ops) < size[op] {
+				innermost[op], size[op] = i, len(r.Ops)
+			}
+		}
+	}
+	return innermost, execCount
+}
+
+// loopCarriedRetention keeps each loop-carried tensor resident across the
+// back edge of its loop: the tensor is retained after the last subgraph of
+// the body when that subgraph holds it, and it fits in fast memory
+// alongside both the body's first subgraph (the next iteration) and
+// whatever follows the loop in the flat schedule. It returns the retained
+// tensors of every plan.
+func loopCarriedRetention(p InputProblem, plans []subgraphPlan) [][]int {
+	retain := make([][]int, len(plans))
+	for i := range retain {
+		retain[i] = []int{}
+	}
+	at := make([]int, len(p.OpTypes))
+	for i, plan := range plans {
+		for _, op := range plan.ops {
+			at[op] = i
+		}
+	}
+	for _, r := range p.Regions {
+		if r.Kind != RegionWhile || len(r.LoopCarried) == 0 {
+			continue
+		}
+		sp := r.span()
+		first, last := at[sp[0]], at[sp[1]-1]
+		budget := p.FastMemoryCapacity - float64(footprintOf(p, plans[first]))
+		if last+1 < len(plans) {
+			budget = min(budget, p.FastMemoryCapacity-float64(footprintOf(p, plans[last+1])))
+		}
+		for _, t := range r.LoopCarried {
+			if !holdsTensor(plans[last].info, t) {
+				continue
+			}
+			size := float64(p.Widths[t] * p.Heights[t])
+			if size > budget {
+				continue
+			}
+			budget -= size
+			retain[last] = append(retain[last], t)
+		}
+	}
+	return retain
+}
+
+// footprintOf is the fast-memory footprint of a planned subgraph.
+func footprintOf(p InputProblem, plan subgraphPlan) int64 {
+	g := plan.granularity
+	return footprintElementsForGroup(p, plan.info, g[0], g[1], g[2])
+}
+
+// holdsTensor reports whether t is a boundary input or output of the group,
+// i.e. whether it is in fast memory at the end of the subgraph.
+func holdsTensor(info groupInfo, t int) bool {
+	for _, out := range info.outputs {
+		if out == t {
+			return true
+		}
+	}
+	for _, in := range info.inputs {
+		if in.tensor == t {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,239 @@
+package mlsys
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// HardwareRequirements answers how much fast memory and bandwidth a model
+// needs to run within a target latency. Each minimum is searched for with
+// the other parameter left as the problem gives it, by solving the problem
+// again at every candidate value. The search assumes more of either never
+// makes the solver's schedule slower; where its heuristics break that, the
+// minimum found meets the target but a smaller value might too.
+type HardwareRequirements struct {
+	TargetLatency float64 `json:"target_latency"`
+	// Latency is that of the schedule on the problem's own hardware, and
+	// Feasible whether every op fits its fast memory there.
+	Latency  float64 `json:"latency"`
+	Feasible bool    `json:"feasible"`
+	// MinFastMemoryCapacity is the least fast_memory_capacity meeting the
+	// target, 0 when no capacity does. With several fast memories, all are
+	// scaled in proportion and this is their total.
+	MinFastMemoryCapacity float64 `json:"min_fast_memory_capacity"`
+	// MinSlowMemoryBandwidth is the least slow_memory_bandwidth meeting
+	// the target, to within a relative 1e-6, 0 when no bandwidth does. A
+	// bandwidth distribution is scaled with it; background traffic is not.
+	MinSlowMemoryBandwidth float64 `json:"min_slow_memory_bandwidth"`
+	// Bottlenecks lists the subgraphs of the schedule at
+	// MinFastMemoryCapacity, or on the problem's hardware when no capacity
+	// meets the target, slowest first.
+	Bottlenecks []Bottleneck `json:"bottlenecks"`
+}
+
+// Bottleneck is one subgraph of a schedule and what it asks of the
+// hardware.
+type Bottleneck struct {
+	Subgraph    int     `json:"subgraph"`
+	Ops         []int   `json:"ops"`
+	Latency     float64 `json:"latency"`
+	Share       float64 `json:"share"`
+	ComputeTime float64 `json:"compute_time"`
+	MemoryTime  float64 `json:"memory_time"`
+	MemoryBound bool    `json:"memory_bound"`
+	// Footprint is the fast memory the subgraph occupies at its tile.
+	Footprint int64 `json:"footprint"`
+	// BalancedBandwidth is the bandwidth at which the subgraph's
+	// transfers take as long as its compute, beyond which more bandwidth
+	// does not speed it up; 0 when it moves nothing or its transfer
+	// overheads alone outlast its compute.
+	BalancedBandwidth float64 `json:"balanced_bandwidth"`
+}
+
+// maxBandwidthScale bounds the bandwidth search: a target the solver
+// misses at this multiple of the problem's bandwidth is out of reach of
+// bandwidth alone.
+const maxBandwidthScale = 1e9
+
+// FindHardwareRequirements searches for the least fast-memory capacity and
+// the least bandwidth at which Solve meets target on p, which must have
+// passed ValidateProblem.
+func FindHardwareRequirements(ctx context.Context, p InputProblem, opts Options, target float64) (HardwareRequirements, error) {
+	if !(target > 0) || math.IsInf(target, 0) {
+		return HardwareRequirements{}, fmt.Errorf("target latency must be positive and finite, got %g", target)
+	}
+	r := HardwareRequirements{TargetLatency: target}
+	// meets reports whether the schedule of q meets the target, with the
+	// schedule.
+	meets := func(q InputProblem) (bool, OutputSolution, error) {
+		s, err := Solve(ctx, q, opts)
+		if ctx.Err() != nil {
+			return false, s, ctx.Err()
+		}
+		return err == nil && s.TotalLatency() <= target, s, nil
+	}
+
+	s, err := Solve(ctx, p, opts)
+	if ctx.Err() != nil {
+		return HardwareRequirements{}, ctx.Err()
+	}
+	r.Latency = s.TotalLatency()
+	r.Feasible = !errors.Is(err, ErrInfeasible)
+	metHere := err == nil && r.Latency <= target
+	bottleneckProblem, bottleneckSchedule := p, s
+
+	// Capacities are searched in whole elements, between 0, which never
+	// fits, and one that meets the target.
+	hi, ok := p.FastMemoryCapacity, metHere
+	if !ok {
+		hi = math.Max(hi, capacityCeiling(p))
+		if ok, _, err = meets(withCapacity(p, hi)); err != nil {
+			return HardwareRequirements{}, err
+		}
+	}
+	if ok {
+		lo := 0.0
+		for hi-lo > 1 {
+			mid := math.Floor((lo + hi) / 2)
+			met, _, err := meets(withCapacity(p, mid))
+			if err != nil {
+				return HardwareRequirements{}, err
+			}
+			if met {
+				hi = mid
+			} else {
+				lo = mid
+			}
+		}
+		r.MinFastMemoryCapacity = hi
+		bottleneckProblem = withCapacity(p, hi)
+		if _, bottleneckSchedule, err = meets(bottleneckProblem); err != nil {
+			return HardwareRequirements{}, err
+		}
+	}
+
+	// Bandwidths are searched geometrically, above the background traffic
+	// the problem shares the bus with.
+	bhi, ok := p.SlowMemoryBandwidth, metHere
+	if !ok {
+		bhi *= maxBandwidthScale
+		if ok, _, err = meets(withBandwidth(p, bhi)); err != nil {
+			return HardwareRequirements{}, err
+		}
+	}
+	if ok {
+		blo := math.Max(p.BackgroundDRAMTraffic, p.SlowMemoryBandwidth/maxBandwidthScale)
+		for bhi/blo > 1+1e-6 {
+			mid := math.Sqrt(blo * bhi)
+			met, _, err := meets(withBandwidth(p, mid))
+			if err != nil {
+				return HardwareRequirements{}, err
+			}
+			if met {
+				bhi = mid
+			} else {
+				blo = mid
+			}
+		}
+		r.MinSlowMemoryBandwidth = bhi
+	}
+
+	r.Bottlenecks, err = bottlenecks(bottleneckProblem, bottleneckSchedule, opts)
+	if err != nil {
+		return HardwareRequirements{}, err
+	}
+	return r, nil
+}
+
+// bottlenecks describes the subgraphs of s, slowest first, barriers left
+// out.
+func bottlenecks(p InputProblem, s OutputSolution, opts Options) ([]Bottleneck, error) {
+	stats, err := AnalyzeSolution(p, s, opts)
+	if err != nil {
+		return nil, err
+	}
+	var sum compensatedSum
+	for _, st := range stats {
+		sum.Add(st.Latency)
+	}
+	total := sum.Total()
+	var out []Bottleneck
+	for i, st := range stats {
+		if len(st.Ops) == 0 {
+			continue
+		}
+		c, err := CostGroup(p, st.Ops, st.Granularity, opts)
+		if err != nil {
+			return nil, fmt.Errorf("subgraph %d: %w", i, err)
+		}
+		b := Bottleneck{
+			Subgraph:    i,
+			Ops:         st.Ops,
+			Latency:     st.Latency,
+			ComputeTime: st.ComputeTime,
+			MemoryTime:  st.MemoryTime,
+			MemoryBound: st.MemoryTime > st.ComputeTime,
+			Footprint:   c.Footprint,
+		}
+		if total > 0 {
+			b.Share = st.Latency / total
+		}
+		// Transfer time is the traffic over the bandwidth plus overheads
+		// that do not shrink with it.
+		overhead := st.MemoryTime - float64(st.Traffic)/p.SlowMemoryBandwidth
+		if st.Traffic > 0 && st.ComputeTime > overhead {
+			b.BalancedBandwidth = float64(st.Traffic) / (st.ComputeTime - overhead)
+		}
+		out = append(out, b)
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Latency > out[j].Latency })
+	return out, nil
+}
+
+// capacityCeiling is a fast-memory capacity past which more cannot help:
+// room for every tensor and lookup table at once, at the widest
+// accumulator ratio and the deepest buffering.
+func capacityCeiling(p InputProblem) float64 {
+	var elements int64
+	for t := range p.Widths {
+		elements += p.Widths[t] * p.Heights[t]
+	}
+	for _, n := range p.LUTElements {
+		elements += n
+	}
+	return float64(8 * elements * maxI64(1, int64(p.MaxBufferDepth)))
+}
+
+// withCapacity returns p with capacity c of fast memory, its fast
+// memories, if any, scaled in proportion.
+func withCapacity(p InputProblem, c float64) InputProblem {
+	if len(p.FastMemories) > 0 {
+		scale := c / p.FastMemoryCapacity
+		mems := make([]FastMemory, len(p.FastMemories))
+		for i, m := range p.FastMemories {
+			m.Capacity *= scale
+			mems[i] = m
+		}
+		p.FastMemories = mems
+	}
+	p.FastMemoryCapacity = c
+	return p
+}
+
+// withBandwidth returns p with slow-memory bandwidth b, its bandwidth
+// distribution, if any, scaled in proportion.
+func withBandwidth(p InputProblem, b float64) InputProblem {
+	if d := p.BandwidthDistribution; d != nil {
+		scale := b / p.SlowMemoryBandwidth
+		scaled := &BandwidthDistribution{Std: d.Std * scale, Percentiles: make(map[string]float64, len(d.Percentiles))}
+		for pct, bw := range d.Percentiles {
+			scaled.Percentiles[pct] = bw * scale
+		}
+		p.BandwidthDistribution = scaled
+	}
+	p.SlowMemoryBandwidth = b
+	return p
+}
@@ -0,0 +1,110 @@
+package mlsys
+
+import "sort"
+
+// Residual (skip) connections keep a tensor alive across several
+// subgraphs: a transformer block's input is read again by the add that
+// closes its attention or MLP branch, many subgraphs later. RetainTensors
+// weighs such residual spans, a subgraph holding a tensor that the next
+// subgraph to read it is not the next one, against the other spans it may
+// retain, and reports the decision for each:
+//
+//   - retain: the tensor stays in fast memory through every subgraph in
+//     between, all of which still fit with it.
+//   - recompute: where it does not stay, the consumer could rerun the
+//     tensor's producer instead of reloading it, when that is faster. Ops
+//     run once in a schedule, so this is advice: the tensor is reloaded.
+//   - spill: otherwise it goes back to slow memory and is reloaded.
+//
+// No span crosses a barrier.
+
+// Residual actions.
+const (
+	ResidualRetain    = "retain"
+	ResidualSpill     = "spill"
+	ResidualRecompute = "recompute"
+)
+
+// ResidualDecision is what RetainTensors decided for one residual span.
+type ResidualDecision struct {
+	Tensor int `json:"tensor"`
+	// From is the subgraph that holds the tensor and To the next one that
+	// reads it, indices into the schedule's subgraphs.
+	From   int    `json:"from"`
+	To     int    `json:"to"`
+	Action string `json:"action"`
+	// ReloadCost is the time To spends reading the tensor back from slow
+	// memory, and RecomputeCost that of rerunning its producer there,
+	// omitted for graph inputs.
+	ReloadCost    float64  `json:"reload_cost"`
+	RecomputeCost *float64 `json:"recompute_cost,omitempty"`
+}
+
+// residualDecisions reports the residual spans among spans, given which
+// were chosen and the resulting retention, shorter spans first and larger
+// tensors before smaller.
+func residualDecisions(p InputProblem, gi graphIndex, plans []subgraphPlan, index []int, spans []retainSpan, chosen []bool, retain [][]int) []ResidualDecision {
+	var residual []int
+	for i, sp := range spans {
+		if sp.to > sp.from+1 {
+			residual = append(residual, i)
+		}
+	}
+	size := func(t int) int64 { return p.Widths[t] * p.Heights[t] }
+	sort.SliceStable(residual, func(i, j int) bool {
+		a, b := spans[residual[i]], spans[residual[j]]
+		if la, lb := a.to-a.from, b.to-b.from; la != lb {
+			return la < lb
+		}
+		return size(a.tensor) > size(b.tensor)
+	})
+	sc := newGroupScratch(p)
+	var decisions []ResidualDecision
+	for _, i := range residual {
+		sp := spans[i]
+		t := sp.tensor
+		d := ResidualDecision{
+			Tensor:     t,
+			From:       index[sp.from],
+			To:         index[sp.to],
+			Action:     ResidualSpill,
+			ReloadCost: float64(size(t)) / p.SlowMemoryBandwidth,
+		}
+		if chosen[i] {
+			d.Action = ResidualRetain
+		}
+		if producers := gi.producers[t]; len(producers) > 0 {
+			cost := recomputeCost(p, gi, sortedUnique(producers), plans, sp.to, retain, sc)
+			d.RecomputeCost = &cost
+			if !chosen[i] && cost < d.ReloadCost {
+				d.Action = ResidualRecompute
+			}
+		}
+		decisions = append(decisions, d)
+	}
+	return decisions
+}
+
+// recomputeCost is the time plan to would take to rerun ops at its own
+// tile: their compute, and reading the inputs it does not already hold or
+// have retained into it.
+func recomputeCost(p InputProblem, gi graphIndex, ops []int, plans []subgraphPlan, to int, retain [][]int, sc *groupScratch) float64 {
+	info := analyzeGroup(p, gi, ops, sc)
+	g := plans[to].granularity
+	steps, computePerStep, _ := stepCosts(p, info, g, p.SlowMemoryBandwidth)
+	resident := make(map[int]bool)
+	if to > 0 {
+		for _, t := range retain[to-1] {
+			resident[t] = true
+		}
+	}
+	var reads int64
+	for _, in := range info.inputs {
+		t := in.tensor
+		if !resident[t] && !holdsTensor(plans[to].info, t) {
+			resident[t] = true
+			reads += p.Widths[t] * p.Heights[t]
+		}
+	}
+	return float64(steps)*computePerStep + float64(reads)/p.SlowMemoryBandwidth
+}
@@ -0,0 +1,505 @@
+package mlsys
+
+import (
+	"context"
+	"sort"
+)
+
+// maxRetainStates bounds the retained sets RetainTensors keeps per cut
+// between subgraphs. Below it the search is exact; above it, the sets that
+// save the least are dropped.
+const maxRetainStates = 4096
+
+// RetainTensors recomputes the tensors_to_retain of s for its partition
+// and granularities, which are kept as they are, e.g. after the groups
+// were edited by hand. Loop-carried tensors are retained as Solve retains
+// them. Every other tensor a subgraph holds and a later one reads again
+// may stay in fast memory until then, as long as every subgraph in between
+// still fits with it; which of them stay is decided jointly, by dynamic
+// programming over the cuts between subgraphs with the set of tensors
+// retained across each cut as state, to save the most reloaded elements.
+// Residual spans, those reaching past the next subgraph, are reported in
+// Residuals; see residuals.go. Nothing is retained into or out of a
+// barrier. From CompatV1_7, which credits retained inputs, the subgraph
+// latencies are estimated again with the new retained sets; below it
+// they are left as they are.
+func RetainTensors(p InputProblem, s OutputSolution, opts Options) (OutputSolution, error) {
+	if err := checkOpTypes(p, opts.UnknownOps); err != nil {
+		return OutputSolution{}, err
+	}
+	if err := ValidateSolution(p, s, opts); err != nil {
+		return OutputSolution{}, err
+	}
+	p, err := withCapacityMargin(p, opts)
+	if err != nil {
+		return OutputSolution{}, err
+	}
+	if p, err = withSolutionModes(p, s); err != nil {
+		return OutputSolution{}, err
+	}
+	pl := newPlanner(p, opts)
+	var plans []subgraphPlan
+	var index []int
+	for i, ops := range s.Subgraphs {
+		if len(ops) == 0 {
+			continue
+		}
+		plan, _ := pl.plan(sortedUnique(ops))
+		plan.granularity = s.Granularities[i]
+		plans = append(plans, plan)
+		index = append(index, i)
+	}
+
+	retain := loopCarriedRetention(p, plans)
+	spans := retainSpans(p, plans, index, retain)
+	chosen := chooseRetention(p, plans, spans, retain)
+	for i, sp := range spans {
+		if chosen[i] {
+			for k := sp.from; k < sp.to; k++ {
+				retain[k] = append(retain[k], sp.tensor)
+			}
+		}
+	}
+	residuals := residualDecisions(p, pl.gi, plans, index, spans, chosen, retain)
+
+	c := s
+	c.TensorsToRetain = make([][]int, len(s.Subgraphs))
+	for i := range c.TensorsToRetain {
+		c.TensorsToRetain[i] = []int{}
+	}
+	for k, i := range index {
+		c.TensorsToRetain[i] = retain[k]
+	}
+	c.Residuals = residuals
+	if pl.gi.creditsRetention {
+		c.SubgraphLatencies = append([]float64(nil), s.SubgraphLatencies...)
+		for k, i := range index {
+			info := plans[k].info
+			if k > 0 && index[k-1] == i-1 {
+				_, info = withRetained(p, info, retain[k-1])
+			}
+			c.SubgraphLatencies[i] = estimateSubgraphLatency(p, info, plans[k].granularity)
+		}
+	}
+	return CanonicalizeSolution(c), nil
+}
+
+// retainSpan is a tensor held by plan from and next held by plan to, which
+// retaining it across the cuts in between saves reloading.
+type retainSpan struct {
+	tensor   int
+	from, to int
+}
+
+// retainSpans lists the spans of plans in order of their first plan,
+// larger tensors first within one. index maps plans to schedule subgraphs;
+// a gap in it is a barrier, which no span crosses. Tensors retained over
+// part of a span already, as loop-carried ones are, are left out.
+func retainSpans(p InputProblem, plans []subgraphPlan, index []int, retain [][]int) []retainSpan {
+	retained := make([]map[int]bool, len(plans))
+	for k, ts := range retain {
+		retained[k] = make(map[int]bool)
+		for _, t := range ts {
+			retained[k][t] = true
+		}
+	}
+	var spans []retainSpan
+	for t := range p.Widths {
+		last := -1
+		for k, plan := range plans {
+			if last >= 0 && k > 0 && index[k] != index[k-1]+1 {
+				last = -1
+			}
+			if !holdsTensor(plan.info, t) {
+				continue
+			}
+			free := last >= 0
+			for j := last; free && j < k; j++ {
+				free = !retained[j][t]
+			}
+			if free {
+				spans = append(spans, retainSpan{tensor: t, from: last, to: k})
+			}
+			last = k
+		}
+	}
+	size := func(t int) int64 { return p.Widths[t] * p.Heights[t] }
+	sort.SliceStable(spans, func(i, j int) bool {
+		a, b := spans[i], spans[j]
+		if a.from != b.from {
+			return a.from < b.from
+		}
+		return size(a.tensor) > size(b.tensor)
+	})
+	return spans
+}
+
+// retainState is one choice of spans up to a cut: the spans still open
+// across it, as indices into the span list, the elements the choice saves,
+// and the elements it keeps in fast memory summed over the cuts they are
+// retained across.
+type retainState struct {
+	open  []int
+	saved int64
+	held  int64
+	picks *retainPick
+}
+
+// better reports whether st saves more than o, or as much while tying up
+// less fast memory.
+func (st retainState) better(o retainState) bool {
+	if st.saved != o.saved {
+		return st.saved > o.saved
+	}
+	return st.held < o.held
+}
+
+// retainPick links the spans a state chose, latest first.
+type retainPick struct {
+	span int
+	prev *retainPick
+}
+
+// chooseRetention picks the spans to retain, which must be in the order
+// retainSpans gives, to save the most reloaded elements while every plan
+// fits with what is retained into it, and of the choices that do, the one
+// that holds the fewest elements for the fewest cuts. It sweeps the cuts
+// in order; at each it closes the spans ending there, merges the states
+// left with the same open spans, which have the same future, and then
+// branches on each span starting there.
+func chooseRetention(p InputProblem, plans []subgraphPlan, spans []retainSpan, retain [][]int) []bool {
+	size := func(t int) int64 { return p.Widths[t] * p.Heights[t] }
+	// budget[k] is what fast memory has left while plan k runs.
+	budget := make([]float64, len(plans))
+	for k, plan := range plans {
+		budget[k] = p.FastMemoryCapacity - float64(footprintOf(p, plan))
+		if k > 0 {
+			for _, t := range retain[k-1] {
+				budget[k] -= float64(size(t))
+			}
+		}
+	}
+	// fits reports whether span i can be retained alongside the spans st
+	// keeps open: every plan it crosses into must have room for both.
+	fits := func(st retainState, i int) bool {
+		sp := spans[i]
+		for k := sp.from + 1; k <= sp.to; k++ {
+			need := size(sp.tensor)
+			for _, j := range st.open {
+				if spans[j].to >= k {
+					need += size(spans[j].tensor)
+				}
+			}
+			if float64(need) > budget[k] {
+				return false
+			}
+		}
+		return true
+	}
+
+	states := []retainState{{}}
+	next := 0
+	for k := range plans {
+		for i := range states {
+			var open []int
+			for _, j := range states[i].open {
+				if spans[j].to > k {
+					open = append(open, j)
+				}
+			}
+			states[i].open = open
+		}
+		states = mergeRetainStates(states)
+		for ; next < len(spans) && spans[next].from == k; next++ {
+			branched := make([]retainState, 0, 2*len(states))
+			for _, st := range states {
+				branched = append(branched, st)
+				if fits(st, next) {
+					branched = append(branched, retainState{
+						open:  append(append([]int{}, st.open...), next),
+						saved: st.saved + size(spans[next].tensor),
+						held:  st.held + size(spans[next].tensor)*int64(spans[next].to-spans[next].from),
+						picks: &retainPick{span: next, prev: st.picks},
+					})
+				}
+			}
+			states = mergeRetainStates(branched)
+		}
+	}
+
+	best := states[0]
+	for _, st := range states[1:] {
+		if st.better(best) {
+			best = st
+		}
+	}
+	chosen := make([]bool, len(spans))
+	for pick := best.picks; pick != nil; pick = pick.prev {
+		chosen[pick.span] = true
+	}
+	return chosen
+}
+
+// mergeRetainStates keeps the best state among those with the same open
+// spans, the earlier one on ties, then at most maxRetainStates of them.
+func mergeRetainStates(states []retainState) []retainState {
+	at := make(map[string]int)
+	var out []retainState
+	var key []byte
+	for _, st := range states {
+		key = appendGroupKey(key[:0], st.open)
+		if i, ok := at[string(key)]; ok {
+			if st.better(out[i]) {
+				out[i] = st
+			}
+			continue
+		}
+		at[string(key)] = len(out)
+		out = append(out, st)
+	}
+	if len(out) > maxRetainStates {
+		sort.SliceStable(out, func(i, j int) bool { return out[i].better(out[j]) })
+		out = out[:maxRetainStates]
+	}
+	return out
+}
+
+// retainedInto lists the tensors s keeps in fast memory into subgraph i
+// from the one before it, nil when gi does not credit retention.
+func retainedInto(gi graphIndex, s OutputSolution, i int) []int {
+	if !gi.creditsRetention || i == 0 || i > len(s.TensorsToRetain) {
+		return nil
+	}
+	return s.TensorsToRetain[i-1]
+}
+
+// withRetained models a subgraph that finds the retained tensors in fast
+// memory: info without the inputs it no longer loads, and p with the
+// capacity the tensors take deducted.
+func withRetained(p InputProblem, info groupInfo, retained []int) (InputProblem, groupInfo) {
+	if len(retained) == 0 {
+		return p, info
+	}
+	resident := intSet(retained)
+	inputs := make([]boundaryInput, 0, len(info.inputs))
+	for _, in := range info.inputs {
+		if !resident[in.tensor] {
+			inputs = append(inputs, in)
+		}
+	}
+	info.inputs = inputs
+	for t := range resident {
+		if t >= 0 && t < len(p.Widths) {
+			p.FastMemoryCapacity -= float64(p.Widths[t] * p.Heights[t])
+		}
+	}
+	return p, info
+}
+
+// maxRetainRun caps how many neighbouring subgraphs of the passes'
+// schedule searchRetention joins into one.
+const maxRetainRun = 4
+
+// maxSearchRetainStates bounds the retained sets searchRetention keeps per
+// cut, the best first.
+const maxSearchRetainStates = 64
+
+// retainChoice is one schedule of the ops before a cut: the tensors it
+// retains across the cut, sorted, its summed objective, the elements it
+// retains summed over the cuts they cross, and its last subgraph.
+type retainChoice struct {
+	retained  []int
+	objective float64
+	held      int64
+	last      *retainLink
+}
+
+// better reports whether c is faster than o, or as fast while tying up
+// less fast memory.
+func (c retainChoice) better(o retainChoice) bool {
+	if c.objective != o.objective {
+		return c.objective < o.objective
+	}
+	return c.held < o.held
+}
+
+// retainLink links the subgraphs of a choice, latest first.
+type retainLink struct {
+	plan subgraphPlan
+	prev *retainLink
+}
+
+// searchRetention searches the partition and the retained tensors of a
+// schedule together, where the planner credits retention. The partitions
+// searched are those that join runs of up to maxRetainRun neighbouring
+// plans, or, unless join is set, plans alone; at every cut between them
+// any tensor the subgraph before holds and a later one reads may stay in
+// fast memory, until the next subgraph that holds it. Dynamic programming
+// sweeps the cuts with the set of tensors retained across each as state,
+// minimizing the summed objective of the subgraphs, each credited with
+// what is retained into it, while every subgraph fits with everything
+// retained into it. Keeping plans as they are is one of the choices, so
+// the result is never worse. Nothing is retained across a barrier, and
+// problems with control-flow regions, whose loop-carried tensors are
+// retained apart, are left as they are.
+func searchRetention(ctx context.Context, pl *planner, plans []subgraphPlan, join bool) []subgraphPlan {
+	p, gi := pl.p, pl.gi
+	if !gi.creditsRetention || len(p.Regions) > 0 || len(plans) == 0 {
+		return plans
+	}
+	bw, _ := decisionBandwidth(p)
+	size := func(t int) int64 { return p.Widths[t] * p.Heights[t] }
+	// lastRead[t] is the last plan that reads t, -1 if none.
+	lastRead := make([]int, len(p.Widths))
+	for t := range lastRead {
+		lastRead[t] = -1
+	}
+	for k, plan := range plans {
+		for _, op := range plan.ops {
+			for _, t := range p.Inputs[op] {
+				lastRead[t] = k
+			}
+		}
+	}
+	// closed[k] is set when nothing may be retained into plans[k].
+	closed := make([]bool, len(plans)+1)
+	closed[len(plans)] = true
+	for k := 1; k < len(plans); k++ {
+		closed[k] = pl.segment[plans[k].ops[0]] != pl.segment[plans[k-1].ops[0]]
+	}
+	// runs[i][r] is plans[i : i+r+1] as one subgraph.
+	runs := make([][]subgraphPlan, len(plans))
+	for i := range plans {
+		runs[i] = []subgraphPlan{plans[i]}
+		for acc := plans[i]; join && len(runs[i]) < maxRetainRun && i+len(runs[i]) < len(plans); {
+			next := plans[i+len(runs[i])]
+			if !pl.mayJoin(acc, next) {
+				break
+			}
+			var ok bool
+			if acc, ok = pl.planJoined(acc, next); !ok {
+				break
+			}
+			runs[i] = append(runs[i], acc)
+		}
+	}
+
+	states := make([][]retainChoice, len(plans)+1)
+	states[0] = []retainChoice{{}}
+	for i := range plans {
+		if ctx.Err() != nil {
+			return plans
+		}
+		for _, st := range mergeRetainChoices(states[i]) {
+			for r, run := range runs[i] {
+				j := i + r + 1
+				q, info := withRetained(p, run.info, st.retained)
+				if checkGroupFits(q, info, run.granularity) != nil {
+					continue
+				}
+				var carry []int
+				for _, t := range st.retained {
+					if !holdsTensor(run.info, t) {
+						carry = append(carry, t)
+					}
+				}
+				if closed[j] && len(carry) > 0 {
+					continue
+				}
+				next := run
+				next.info = info
+				next.latency = estimateSubgraphLatency(p, info, run.granularity)
+				next.objective = estimateGroupLatencyAtBandwidth(p, info, run.granularity, bw)
+				choices := [][]int{carry}
+				if !closed[j] {
+					for _, t := range retainCandidates(gi, run.info, carry, lastRead, j, size) {
+						for _, c := range choices {
+							if len(choices) >= maxSearchRetainStates {
+								break
+							}
+							choices = append(choices, append(append([]int{}, c...), t))
+						}
+					}
+				}
+				for _, retained := range choices {
+					c := retainChoice{retained: sortedUnique(retained), objective: st.objective + next.objective, held: st.held}
+					for _, t := range c.retained {
+						c.held += size(t)
+					}
+					next.retain = c.retained
+					c.last = &retainLink{plan: next, prev: st.last}
+					states[j] = append(states[j], c)
+				}
+			}
+		}
+		states[i] = nil
+	}
+
+	final := states[len(plans)]
+	if len(final) == 0 {
+		return plans
+	}
+	best := final[0]
+	for _, c := range final[1:] {
+		if c.better(best) {
+			best = c
+		}
+	}
+	var out []subgraphPlan
+	for link := best.last; link != nil; link = link.prev {
+		out = append(out, link.plan)
+	}
+	for a, b := 0, len(out)-1; a < b; a, b = a+1, b-1 {
+		out[a], out[b] = out[b], out[a]
+	}
+	return out
+}
+
+// retainCandidates lists the tensors a subgraph with boundary info, ending
+// before plan j, may newly retain: those it holds that a plan from j on
+// reads, other than carry, which stay retained anyway, and other than
+// views and their bases, largest first.
+func retainCandidates(gi graphIndex, info groupInfo, carry []int, lastRead []int, j int, size func(int) int64) []int {
+	seen := intSet(carry)
+	var out []int
+	consider := func(t int) {
+		if seen[t] || lastRead[t] < j || gi.root[t] != t || len(gi.views[t]) > 0 {
+			return
+		}
+		seen[t] = true
+		out = append(out, t)
+	}
+	for _, in := range info.inputs {
+		consider(in.tensor)
+	}
+	for _, t := range info.outputs {
+		consider(t)
+	}
+	sort.SliceStable(out, func(a, b int) bool { return size(out[a]) > size(out[b]) })
+	return out
+}
+
+// mergeRetainChoices keeps the best choice among those retaining the same
+// tensors, the earlier one on ties, then at most maxSearchRetainStates of
+// them.
+func mergeRetainChoices(choices []retainChoice) []retainChoice {
+	at := make(map[string]int)
+	var out []retainChoice
+	var key []byte
+	for _, c := range choices {
+		key = appendGroupKey(key[:0], c.retained)
+		if i, ok := at[string(key)]; ok {
+			if c.better(out[i]) {
+				out[i] = c
+			}
+			continue
+		}
+		at[string(key)] = len(out)
+		out = append(out, c)
+	}
+	if len(out) > maxSearchRetainStates {
+		sort.SliceStable(out, func(i, j int) bool { return out[i].better(out[j]) })
+		out = out[:maxSearchRetainStates]
+	}
+	return out
+}
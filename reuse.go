@@ -0,0 +1,261 @@
+package mlsys
+
+import "math"
+
+// The tile loops of a subgraph: one over rows of output tiles, one over
+// columns and one over the slices of a split reduction.
+const (
+	loopM = iota
+	loopN
+	loopK
+	numLoops
+)
+
+// loopOrder lists the tile loops outermost first.
+type loopOrder [numLoops]int
+
+// Dataflow names the loop order of a subgraph by the operand it keeps
+// resident in fast memory the longest.
+const (
+	// DataflowOutputStationary runs the reduction innermost, so each
+	// output tile stays resident until it is complete.
+	DataflowOutputStationary = "output_stationary"
+	// DataflowWeightStationary walks the output rows innermost, under one
+	// tile of the right-hand (weight) operand.
+	DataflowWeightStationary = "weight_stationary"
+	// DataflowInputStationary walks the output columns innermost, under one
+	// tile of the left-hand (input) operand.
+	DataflowInputStationary = "input_stationary"
+)
+
+// dataflows lists the loop orders tried per subgraph, in order of
+// preference on ties.
+var dataflows = []struct {
+	name  string
+	order loopOrder
+}{
+	{DataflowOutputStationary, loopOrder{loopM, loopN, loopK}},
+	{DataflowWeightStationary, loopOrder{loopN, loopK, loopM}},
+	{DataflowInputStationary, loopOrder{loopM, loopK, loopN}},
+}
+
+func knownDataflow(name string) bool {
+	for _, d := range dataflows {
+		if d.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// chooseDataflow picks the loop order with the lowest modeled latency at
+// the given bandwidth. Only reused operands make the order matter; without
+// reuse, and for untiled groups, it is always the first.
+func chooseDataflow(p InputProblem, info groupInfo, g [3]int64, bandwidth float64) int {
+	if !p.OperandReuse || !info.tileable {
+		return 0
+	}
+	_, compute, _ := stepCosts(p, info, g, bandwidth)
+	depth := bufferDepth(p, info, g[0], g[1], g[2])
+	best, bestLat := 0, math.Inf(1)
+	for i, d := range dataflows {
+		if lat := classLatency(info, depth, stepClassesInOrder(p, info, g, d.order), compute, bandwidth); lat < bestLat {
+			best, bestLat = i, lat
+		}
+	}
+	return best
+}
+
+// classLatency applies the roofline for the given buffer depth to every
+// class of steps.
+func classLatency(info groupInfo, depth int, classes []stepClass, computePerStep, bandwidth float64) float64 {
+	return rooflineLatency(info, depth, classes, computePerStep, func(c stepClass) float64 {
+		return float64(c.elements)/bandwidth + c.overhead
+	})
+}
+
+// stepClass is a run of execution steps that all move the same number of
+// elements between slow and fast memory, paying the same DMA descriptor
+// overhead per step (see dma.go) and, when they move anything, the access
+// latency of slow memory.
+type stepClass struct {
+	steps    int64
+	elements int64
+	overhead float64
+	// changed is the set of tile loops, as a bitmask, whose index differs
+	// from the previous step's; first marks the step that loads whole
+	// inputs.
+	changed int
+	first   bool
+	// lut is the size of the lookup tables the steps load, part of
+	// elements.
+	lut int64
+}
+
+// moves reports whether the steps of c transfer an operand in the given
+// role.
+func (c stepClass) moves(role operandRole) bool {
+	return roleLoops(role)&c.changed != 0 || (c.first && role == roleWhole)
+}
+
+// tileLoops returns the trip count of each tile loop of a subgraph.
+func tileLoops(p InputProblem, info groupInfo, g [3]int64) [numLoops]int64 {
+	var n [numLoops]int64
+	n[loopN] = ceilDiv(p.Widths[info.gridTensor], g[0])
+	n[loopM] = headedRows(p, info, g[1])
+	n[loopK] = 1
+	if info.reduction > 0 {
+		n[loopK] = ceilDiv(info.reduction, maxI64(1, g[2]))
+	}
+	return n
+}
+
+// roleLoops is the set of tile loops, as a bitmask, whose index selects
+// the tile of an operand in the given role.
+func roleLoops(role operandRole) int {
+	switch role {
+	case roleLHS:
+		return 1<<loopM | 1<<loopK
+	case roleRHS:
+		return 1<<loopK | 1<<loopN
+	case roleHeadRHS:
+		// The head changes with the row loop; it is charged on every row
+		// change, which over-counts rows within one head.
+		return 1<<loopM | 1<<loopK | 1<<loopN
+	case roleWhole:
+		return 0
+	case roleIndices:
+		return 1 << loopM
+	default:
+		return 1<<loopM | 1<<loopN
+	}
+}
+
+// stepClasses splits the steps of a subgraph by how much they transfer,
+// under the dataflow the subgraph runs with at the given bandwidth.
+func stepClasses(p InputProblem, info groupInfo, g [3]int64, bandwidth float64) []stepClass {
+	return stepClassesInOrder(p, info, g, dataflows[chooseDataflow(p, info, g, bandwidth)].order)
+}
+
+// stepClassesInOrder splits the steps of a subgraph by how much they
+// transfer. Without operand reuse every step moves the full working set.
+// With it, a step only moves the operands whose tile differs from the
+// previous step's under the loop order: the first step loads everything,
+// and each later one reloads the operands indexed by a loop that advanced
+// or wrapped around. Output tiles are written back whenever they change.
+func stepClassesInOrder(p InputProblem, info groupInfo, g [3]int64, order loopOrder) []stepClass {
+	w, h, k := g[0], g[1], g[2]
+	loops := tileLoops(p, info, g)
+	partial := info.partialSums && info.tileable && loops[loopK] > 1
+	if !p.OperandReuse || !info.tileable {
+		all := stepClass{
+			steps:    stepCount(loops),
+			elements: workingSetElementsForGroup(p, info, w, h, k) + rereadElements(p, info, w, h, k),
+			overhead: workingSetRowOverhead(p, info, w, h, k) + p.SlowMemoryLatency,
+			changed:  1<<numLoops - 1,
+			first:    true,
+		}
+		classes := []stepClass{all}
+		if partial {
+			classes = splitPartialSums(p, info, g, all, loops[loopK])
+		}
+		return withCacheMisses(p, info, g, withLUTLoad(p, info, classes))
+	}
+
+	// Steps are classed by the outermost loop that advances: the first step
+	// (level -1) and then one class per loop level.
+	classes := make([]stepClass, 0, numLoops+1)
+	outer := int64(1)
+	for level := -1; level < numLoops; level++ {
+		steps := int64(1)
+		changed := 1<<numLoops - 1
+		if level >= 0 {
+			steps = mulSat(outer, loops[order[level]]-1)
+			outer = mulSat(outer, loops[order[level]])
+			changed = 1 << order[level]
+			for _, inner := range order[level+1:] {
+				if loops[inner] > 1 {
+					changed |= 1 << inner
+				}
+			}
+		}
+		if steps == 0 {
+			continue
+		}
+		c := stepClass{steps: steps, changed: changed, first: level < 0}
+		for _, in := range info.inputs {
+			if c.moves(in.role) {
+				c.elements += inputPasses(p, in, w) * tensorElements(p, info, in.tensor, inputTileElements(p, in, w, h, k, tileHeads(p, info, h)))
+				c.overhead += inputTileOverhead(p, in, w, h, k, tileHeads(p, info, h))
+			}
+		}
+		if c.moves(rolePointwise) {
+			c.elements += outputTileElements(p, info, w, h)
+			c.overhead += outputTileOverhead(p, info, w, h)
+		}
+		// A step that moves to an output tile at a later slice of the
+		// reduction resumes its partial sum, which the tile's last step
+		// wrote back, and so reads it again.
+		var resumed stepClass
+		if partial && level >= 0 && c.moves(rolePointwise) {
+			switch at := kLevel(order); {
+			case at == level:
+				resumed, c.steps = c, 0
+			case at < level:
+				resumed = c
+				resumed.steps = steps - steps/loops[loopK]
+				c.steps -= resumed.steps
+			}
+			if resumed.steps > 0 {
+				resumed.elements += outputTileElements(p, info, w, h)
+				resumed.overhead += outputTileOverhead(p, info, w, h)
+			}
+		}
+		for _, c := range []stepClass{c, resumed} {
+			if c.steps == 0 {
+				continue
+			}
+			if c.elements > 0 {
+				c.overhead += p.SlowMemoryLatency
+			}
+			classes = append(classes, c)
+		}
+	}
+	return withCacheMisses(p, info, g, withLUTLoad(p, info, classes))
+}
+
+// kLevel is the position of the reduction loop in order.
+func kLevel(order loopOrder) int {
+	for level, l := range order {
+		if l == loopK {
+			return level
+		}
+	}
+	return -1
+}
+
+// splitPartialSums charges the partial sums of a reduction split over
+// kSteps slices to all, the steps of a group that moves its whole working
+// set, output tile included, on every step. With an accumulator
+// (AccumulatorDType) the partials stay in fast memory, and only the last
+// slice of each output tile writes it. Without one, every slice writes its
+// partial sum back and every slice after the first reads it again.
+func splitPartialSums(p InputProblem, info groupInfo, g [3]int64, all stepClass, kSteps int64) []stepClass {
+	w, h := g[0], g[1]
+	tiles := all.steps / kSteps
+	rest := all
+	rest.steps = all.steps - tiles
+	rest.first = false
+	once := all
+	once.steps = tiles
+	if p.AccumulatorDType != "" {
+		// The earlier slices, first among them, write nothing.
+		rest.elements -= outputTileElements(p, info, w, h)
+		rest.overhead -= outputTileOverhead(p, info, w, h)
+		rest.first, once.first = true, false
+		return []stepClass{rest, once}
+	}
+	rest.elements += outputTileElements(p, info, w, h)
+	rest.overhead += outputTileOverhead(p, info, w, h)
+	return []stepClass{once, rest}
+}
@@ -0,0 +1,159 @@
+package mlsys
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"sort"
+)
+
+// SampleSchedules measures what the solver buys over naive choices. It
+// draws random valid schedules, each partitioning the ops into random runs
+// of neighbours that may share a subgraph and giving every subgraph a
+// random tile among those that fit, and compares the latency distribution
+// of the samples with the solver's schedule. Every sample first draws how
+// eagerly it joins ops, so the samples span everything from one op per
+// subgraph to as few subgraphs as fit, rather than clustering around a
+// single subgraph size.
+
+// SampleReport is the latency distribution of random schedules for a
+// problem, next to the solver's.
+type SampleReport struct {
+	// Samples counts the valid samples the statistics cover, and Rejected
+	// the draws ValidateSolution turned down.
+	Samples  int `json:"samples"`
+	Rejected int `json:"rejected"`
+
+	Min    float64 `json:"min_latency"`
+	P5     float64 `json:"p5_latency"`
+	Median float64 `json:"median_latency"`
+	Mean   float64 `json:"mean_latency"`
+	P95    float64 `json:"p95_latency"`
+	Max    float64 `json:"max_latency"`
+
+	SolverLatency float64 `json:"solver_latency"`
+	// AtLeastAsFast is the share of samples no slower than the solver's
+	// schedule, and MedianSpeedup the median sample's latency over the
+	// solver's.
+	AtLeastAsFast float64 `json:"at_least_as_fast"`
+	MedianSpeedup float64 `json:"median_speedup"`
+}
+
+// SampleSchedules draws n random schedules for p from the random source
+// seeded with seed and reports their latencies next to that of solved, a
+// schedule Solve returned for p with opts. All latencies are priced the
+// same way, by AnalyzeSolution. Fixed subgraphs are kept; templates,
+// repeated blocks, cache buckets and serving phases are ignored.
+func SampleSchedules(ctx context.Context, p InputProblem, opts Options, solved OutputSolution, n int, seed uint64) (SampleReport, error) {
+	if n < 1 {
+		return SampleReport{}, fmt.Errorf("sample count %d is below 1", n)
+	}
+	p.KVCache, p.Serving = nil, nil
+	var report SampleReport
+	var err error
+	if report.SolverLatency, err = scheduleLatency(p, solved, opts); err != nil {
+		return SampleReport{}, fmt.Errorf("solver schedule: %w", err)
+	}
+	if err := checkOpTypes(p, opts.UnknownOps); err != nil {
+		return SampleReport{}, err
+	}
+	q, err := withCapacityMargin(p, opts)
+	if err != nil {
+		return SampleReport{}, err
+	}
+	if solved.MemoryModes != nil {
+		q = withMemoryModes(q, solved.MemoryModes)
+	}
+	pl := newPlanner(q, opts)
+	rng := rand.New(rand.NewPCG(seed, 0))
+	lat := make([]float64, 0, n)
+	for range n {
+		if err := ctx.Err(); err != nil {
+			return SampleReport{}, err
+		}
+		s := assembleSolution(q, samplePlans(pl, rng))
+		s.MemoryModes = solved.MemoryModes
+		if ValidateSolution(p, s, opts) != nil {
+			report.Rejected++
+			continue
+		}
+		l, err := scheduleLatency(p, s, opts)
+		if err != nil {
+			return SampleReport{}, err
+		}
+		lat = append(lat, l)
+	}
+	if len(lat) == 0 {
+		return report, errors.New("no sampled schedule was valid")
+	}
+
+	sort.Float64s(lat)
+	report.Samples = len(lat)
+	report.Min, report.Max = lat[0], lat[len(lat)-1]
+	report.P5, report.P95 = nearestRank(lat, 5), nearestRank(lat, 95)
+	report.Median = median(lat)
+	report.Mean = SumLatencies(lat) / float64(len(lat))
+	faster := sort.SearchFloat64s(lat, math.Nextafter(report.SolverLatency, math.Inf(1)))
+	report.AtLeastAsFast = float64(faster) / float64(len(lat))
+	if report.SolverLatency > 0 {
+		report.MedianSpeedup = report.Median / report.SolverLatency
+	}
+	return report, nil
+}
+
+// samplePlans draws one random schedule: walking the ops in order, each op
+// joins the subgraph before it with a probability drawn once per schedule,
+// when the two may share a subgraph and the joined one fits, and every
+// subgraph gets a random fitting tile.
+func samplePlans(pl *planner, rng *rand.Rand) []subgraphPlan {
+	p := pl.p
+	join := rng.Float64()
+	fixed := fixedSubgraphAt(p)
+	var plans []subgraphPlan
+	for op := range p.OpTypes {
+		group, isFixed := fixed[op]
+		if isFixed && group == nil {
+			continue
+		}
+		if !isFixed {
+			group = []int{op}
+		}
+		plan, _ := pl.plan(group)
+		plan.fixed = isFixed
+		if n := len(plans); n > 0 && rng.Float64() < join && pl.mayJoin(plans[n-1], plan) {
+			if joined, ok := pl.planJoined(plans[n-1], plan); ok {
+				plans[n-1] = joined
+				continue
+			}
+		}
+		plans = append(plans, plan)
+	}
+	for i := range plans {
+		plans[i] = randomTile(pl, plans[i], rng)
+	}
+	return plans
+}
+
+// randomTile returns plan with a tile drawn uniformly from the candidates
+// that fit, or unchanged when none does.
+func randomTile(pl *planner, plan subgraphPlan, rng *rand.Rand) subgraphPlan {
+	p := pl.p
+	fit := fittingTiles(p, plan.info)
+	if len(fit) == 0 {
+		return plan
+	}
+	g := fit[rng.IntN(len(fit))]
+	bw, _ := decisionBandwidth(p)
+	plan.granularity = g
+	plan.latency = estimateSubgraphLatency(p, plan.info, g)
+	plan.objective = estimateGroupLatencyAtBandwidth(p, plan.info, g, bw)
+	return plan
+}
+
+// nearestRank is the nearest-rank percentile of sorted.
+func nearestRank(sorted []float64, pct float64) float64 {
+	rank := int(math.Ceil(pct / 100 * float64(len(sorted))))
+	return sorted[max(rank, 1)-1]
+}
@@ -0,0 +1,30 @@
+package mlsys
+
+// bitset is a fixed-size set of small non-negative integers, used instead
+// of map[int]bool on the solver's hot paths.
+type bitset []uint64
+
+func newBitset(n int) bitset {
+	return make(bitset, (n+63)/64)
+}
+
+func (b bitset) set(i int)      { b[i/64] |= 1 << (uint(i) % 64) }
+func (b bitset) clear(i int)    { b[i/64] &^= 1 << (uint(i) % 64) }
+func (b bitset) has(i int) bool { return b[i/64]&(1<<(uint(i)%64)) != 0 }
+
+// groupScratch holds the sets analyzeGroup needs, sized for one problem.
+// Users clear exactly the bits they set, so resetting costs O(group size)
+// rather than O(problem size).
+type groupScratch struct {
+	inGroup  bitset // ops
+	produced bitset // tensors
+	loaded   bitset // boundaryInput slots
+}
+
+func newGroupScratch(p InputProblem) *groupScratch {
+	return &groupScratch{
+		inGroup:  newBitset(len(p.OpTypes)),
+		produced: newBitset(len(p.Widths)),
+		loaded:   newBitset(len(p.Widths) * numOperandRoles),
+	}
+}
@@ -0,0 +1,129 @@
+package mlsys
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Serving asks for a linked pair of schedules for LLM serving: prefill,
+// which processes the whole prompt at once, and decode, which processes one
+// token per step. The two share which weights stay in fast memory.
+type Serving struct {
+	// SequenceTensors are the activations whose Dim extent is the number of
+	// tokens processed in one step.
+	SequenceTensors []int `json:"sequence_tensors"`
+	// Dim is the token extent: "height" (the default) or "width".
+	Dim           string `json:"dim,omitempty"`
+	PrefillLength int64  `json:"prefill_length"`
+	// Weights are candidates for fast-memory residency.
+	Weights []int `json:"weights"`
+	// DecodeTokens is how many decode steps follow one prefill, used to
+	// weigh the two phases when placing weights. Defaults to 1.
+	DecodeTokens float64 `json:"decode_tokens,omitempty"`
+}
+
+// ServingSchedules is the prefill/decode pair. Both schedules assume the
+// PinnedWeights are already resident and never load them.
+type ServingSchedules struct {
+	PinnedWeights         []int          `json:"pinned_weights"`
+	PrefillLatency        float64        `json:"prefill_latency"`
+	DecodeLatencyPerToken float64        `json:"decode_latency_per_token"`
+	Prefill               OutputSolution `json:"prefill"`
+	Decode                OutputSolution `json:"decode"`
+}
+
+func validateServing(p InputProblem) error {
+	sv := p.Serving
+	if sv == nil {
+		return nil
+	}
+	if len(sv.SequenceTensors) == 0 {
+		return errors.New("serving.sequence_tensors must not be empty")
+	}
+	for _, t := range append(append([]int(nil), sv.SequenceTensors...), sv.Weights...) {
+		if t < 0 || t >= len(p.Widths) {
+			return fmt.Errorf("serving tensor index out of range: %d", t)
+		}
+	}
+	if sv.Dim != "" && sv.Dim != "width" && sv.Dim != "height" {
+		return fmt.Errorf("serving.dim must be \"width\" or \"height\", got %q", sv.Dim)
+	}
+	if sv.PrefillLength < 1 {
+		return errors.New("serving.prefill_length must be >= 1")
+	}
+	if sv.DecodeTokens < 0 {
+		return errors.New("serving.decode_tokens must be >= 0")
+	}
+	return nil
+}
+
+// solveServing plans prefill and decode together. Weights are considered
+// smallest first and each is pinned if that still fits both phases and
+// lowers prefill latency plus DecodeTokens decode steps.
+func solveServing(ctx context.Context, p InputProblem, opts Options) (*ServingSchedules, error) {
+	sv := p.Serving
+	dim := sv.Dim
+	if dim == "" {
+		dim = "height"
+	}
+	tokens := sv.DecodeTokens
+	if tokens == 0 {
+		tokens = 1
+	}
+	prefill := withExtent(p, sv.SequenceTensors, dim, sv.PrefillLength)
+	decode := withExtent(p, sv.SequenceTensors, dim, 1)
+
+	type phasePlans struct {
+		prefill, decode []subgraphPlan
+		cost            float64
+	}
+	evaluate := func(pinned []int) (phasePlans, bool, error) {
+		var pp phasePlans
+		pq, popts := pinTensors(prefill, opts, pinned)
+		dq, dopts := pinTensors(decode, opts, pinned)
+		if pq.FastMemoryCapacity <= 0 || dq.FastMemoryCapacity <= 0 {
+			return pp, false, nil
+		}
+		var err error
+		if pp.prefill, err = solvePlans(ctx, pq, popts); err != nil {
+			return pp, false, err
+		}
+		if pp.decode, err = solvePlans(ctx, dq, dopts); err != nil {
+			return pp, false, err
+		}
+		pp.cost = totalLatency(pp.prefill) + tokens*totalLatency(pp.decode)
+		return pp, allFit(pq, pp.prefill) && allFit(dq, pp.decode), nil
+	}
+
+	best, _, err := evaluate(nil)
+	if err != nil {
+		return nil, err
+	}
+	var pinned []int
+	weights := append([]int(nil), sv.Weights...)
+	sort.SliceStable(weights, func(i, j int) bool {
+		return p.Widths[weights[i]]*p.Heights[weights[i]] < p.Widths[weights[j]]*p.Heights[weights[j]]
+	})
+	for _, w := range weights {
+		try := append(append([]int(nil), pinned...), w)
+		cand, fits, err := evaluate(try)
+		if err != nil {
+			return nil, err
+		}
+		if fits && cand.cost < best.cost {
+			best, pinned = cand, try
+		}
+	}
+
+	pq, _ := pinTensors(prefill, opts, pinned)
+	dq, _ := pinTensors(decode, opts, pinned)
+	return &ServingSchedules{
+		PinnedWeights:         append([]int{}, pinned...),
+		PrefillLatency:        totalLatency(best.prefill),
+		DecodeLatencyPerToken: totalLatency(best.decode),
+		Prefill:               assembleSolution(pq, best.prefill),
+		Decode:                assembleSolution(dq, best.decode),
+	}, nil
+}
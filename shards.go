@@ -0,0 +1,273 @@
+package mlsys
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// Exporters of very large models write their graph in shards: several
+// problem files, each holding a run of ops in execution order. Tensors
+// cross shards by name. Every shard names its own tensors in tensor_names,
+// and a manifest lists the shared namespace. MergeShards joins the shards
+// into one problem whose tensors are numbered as in the manifest and whose
+// ops are those of each shard in turn.
+//
+// Op and tensor indices inside a shard, in inputs, views, regions,
+// barriers and the like, are local to the shard and are renumbered. The
+// other fields, hardware and options alike, may be given by any shard,
+// but shards that both give one must agree on it.
+
+// ShardManifest describes a graph split into shards.
+type ShardManifest struct {
+	// Tensors names the tensors of the merged problem, in order.
+	Tensors []string `json:"tensors"`
+	// Shards lists the shard files in execution order, relative to the
+	// manifest. Callers that pass shards directly may leave it out.
+	Shards []string `json:"shards,omitempty"`
+}
+
+// ReadShardManifest reads a manifest written as JSON.
+func ReadShardManifest(r io.Reader) (ShardManifest, error) {
+	var m ShardManifest
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&m); err != nil {
+		return ShardManifest{}, fmt.Errorf("shard manifest: %w", err)
+	}
+	if len(m.Tensors) == 0 {
+		return ShardManifest{}, errors.New("shard manifest: no tensors")
+	}
+	return m, nil
+}
+
+// shardFields are the fields MergeShards renumbers or concatenates; all
+// others are shared.
+var shardFields = map[string]bool{
+	"widths": true, "heights": true, "inputs": true, "outputs": true,
+	"base_costs": true, "op_types": true, "tensor_names": true,
+	"op_heads": true, "op_layers": true, "op_attributes": true,
+	"tensor_row_pitches": true, "tensor_classes": true, "views": true,
+	"regions": true, "barriers": true, "tile_constraints": true,
+	"fixed_subgraphs": true, "register_fusable": true, "kv_cache": true,
+	"serving": true,
+}
+
+// MergeShards joins the shards of one graph into a single problem. Each
+// shard must name its tensors in TensorNames, in the namespace of m.
+func MergeShards(m ShardManifest, shards []InputProblem) (InputProblem, error) {
+	if len(shards) == 0 {
+		return InputProblem{}, errors.New("no shards to merge")
+	}
+	global := make(map[string]int, len(m.Tensors))
+	for i, name := range m.Tensors {
+		if _, dup := global[name]; dup {
+			return InputProblem{}, fmt.Errorf("shard manifest names tensor %q twice", name)
+		}
+		global[name] = i
+	}
+	n := len(m.Tensors)
+	merged := InputProblem{
+		Widths:      make([]int64, n),
+		Heights:     make([]int64, n),
+		TensorNames: append([]string(nil), m.Tensors...),
+	}
+	seen := make([]bool, n)
+	var hasHeads, hasLayers, hasAttributes, hasPitches, hasClasses bool
+	for _, sh := range shards {
+		hasHeads = hasHeads || sh.OpHeads != nil
+		hasLayers = hasLayers || sh.OpLayers != nil
+		hasAttributes = hasAttributes || sh.OpAttributes != nil
+		hasPitches = hasPitches || sh.TensorRowPitches != nil
+		hasClasses = hasClasses || sh.TensorClasses != nil
+	}
+	if hasPitches {
+		merged.TensorRowPitches = make([]int64, n)
+	}
+	if hasClasses {
+		merged.TensorClasses = make([]string, n)
+	}
+
+	for s, sh := range shards {
+		if err := mergeShard(&merged, global, seen, sh); err != nil {
+			return InputProblem{}, fmt.Errorf("shard %d: %w", s, err)
+		}
+		if hasHeads && sh.OpHeads == nil {
+			merged.OpHeads = append(merged.OpHeads, make([]int64, len(sh.OpTypes))...)
+		}
+		if hasLayers && sh.OpLayers == nil {
+			merged.OpLayers = append(merged.OpLayers, make([]string, len(sh.OpTypes))...)
+		}
+		if hasAttributes && sh.OpAttributes == nil {
+			merged.OpAttributes = append(merged.OpAttributes, make([]OpAttributes, len(sh.OpTypes))...)
+		}
+	}
+	for i, ok := range seen {
+		if !ok {
+			return InputProblem{}, fmt.Errorf("tensor %q is in no shard", m.Tensors[i])
+		}
+	}
+	if err := mergeSharedFields(&merged, shards); err != nil {
+		return InputProblem{}, err
+	}
+	return merged, nil
+}
+
+// mergeShard appends the ops of sh to merged, renumbering its tensors
+// through global and its ops by the ops merged so far.
+func mergeShard(merged *InputProblem, global map[string]int, seen []bool, sh InputProblem) error {
+	if len(sh.TensorNames) != len(sh.Widths) {
+		return fmt.Errorf("tensor_names has %d entries for %d tensors", len(sh.TensorNames), len(sh.Widths))
+	}
+	tensor := make([]int, len(sh.Widths))
+	for t, name := range sh.TensorNames {
+		g, ok := global[name]
+		if !ok {
+			return fmt.Errorf("tensor %q is not in the manifest", name)
+		}
+		tensor[t] = g
+		if seen[g] && (merged.Widths[g] != sh.Widths[t] || merged.Heights[g] != sh.Heights[t]) {
+			return fmt.Errorf("tensor %q is %dx%d here but %dx%d in an earlier shard",
+				name, sh.Widths[t], sh.Heights[t], merged.Widths[g], merged.Heights[g])
+		}
+		merged.Widths[g], merged.Heights[g] = sh.Widths[t], sh.Heights[t]
+		if sh.TensorRowPitches != nil && sh.TensorRowPitches[t] != 0 {
+			merged.TensorRowPitches[g] = sh.TensorRowPitches[t]
+		}
+		if sh.TensorClasses != nil && sh.TensorClasses[t] != "" {
+			merged.TensorClasses[g] = sh.TensorClasses[t]
+		}
+		seen[g] = true
+	}
+	tensors := func(ts []int) ([]int, error) {
+		out := make([]int, len(ts))
+		for i, t := range ts {
+			if t < 0 || t >= len(tensor) {
+				return nil, fmt.Errorf("tensor index out of range: %d", t)
+			}
+			out[i] = tensor[t]
+		}
+		return out, nil
+	}
+	base := len(merged.OpTypes)
+	ops := func(xs []int) []int {
+		out := make([]int, len(xs))
+		for i, op := range xs {
+			out[i] = op + base
+		}
+		return out
+	}
+
+	if len(sh.Inputs) != len(sh.OpTypes) || len(sh.Outputs) != len(sh.OpTypes) || len(sh.BaseCosts) != len(sh.OpTypes) {
+		return errors.New("inputs/outputs/base_costs/op_types length mismatch")
+	}
+	for op := range sh.OpTypes {
+		in, err := tensors(sh.Inputs[op])
+		if err != nil {
+			return fmt.Errorf("op %d: %w", op, err)
+		}
+		out, err := tensors(sh.Outputs[op])
+		if err != nil {
+			return fmt.Errorf("op %d: %w", op, err)
+		}
+		merged.Inputs = append(merged.Inputs, in)
+		merged.Outputs = append(merged.Outputs, out)
+	}
+	merged.OpTypes = append(merged.OpTypes, sh.OpTypes...)
+	merged.BaseCosts = append(merged.BaseCosts, sh.BaseCosts...)
+	merged.OpHeads = append(merged.OpHeads, sh.OpHeads...)
+	merged.OpLayers = append(merged.OpLayers, sh.OpLayers...)
+	merged.OpAttributes = append(merged.OpAttributes, sh.OpAttributes...)
+
+	for _, v := range sh.Views {
+		ts, err := tensors([]int{v.Tensor, v.Base})
+		if err != nil {
+			return fmt.Errorf("views: %w", err)
+		}
+		v.Tensor, v.Base = ts[0], ts[1]
+		merged.Views = append(merged.Views, v)
+	}
+	for _, r := range sh.Regions {
+		carried, err := tensors(r.LoopCarried)
+		if err != nil {
+			return fmt.Errorf("regions: %w", err)
+		}
+		r.Ops = ops(r.Ops)
+		if r.LoopCarried != nil {
+			r.LoopCarried = carried
+		}
+		merged.Regions = append(merged.Regions, r)
+	}
+	for _, b := range sh.Barriers {
+		b.AfterOp += base
+		merged.Barriers = append(merged.Barriers, b)
+	}
+	for _, c := range sh.TileConstraints {
+		if c.Ops != nil {
+			c.Ops = ops(c.Ops)
+		}
+		merged.TileConstraints = append(merged.TileConstraints, c)
+	}
+	for _, group := range sh.FixedSubgraphs {
+		merged.FixedSubgraphs = append(merged.FixedSubgraphs, ops(group))
+	}
+	for _, pair := range sh.RegisterFusable {
+		merged.RegisterFusable = append(merged.RegisterFusable, [2]int{pair[0] + base, pair[1] + base})
+	}
+	if sh.KVCache != nil {
+		if merged.KVCache != nil {
+			return errors.New("kv_cache is given by more than one shard")
+		}
+		kv := *sh.KVCache
+		var err error
+		if kv.Tensors, err = tensors(kv.Tensors); err != nil {
+			return fmt.Errorf("kv_cache: %w", err)
+		}
+		merged.KVCache = &kv
+	}
+	if sh.Serving != nil {
+		if merged.Serving != nil {
+			return errors.New("serving is given by more than one shard")
+		}
+		sv := *sh.Serving
+		var err error
+		if sv.SequenceTensors, err = tensors(sv.SequenceTensors); err != nil {
+			return fmt.Errorf("serving: %w", err)
+		}
+		if sv.Weights, err = tensors(sv.Weights); err != nil {
+			return fmt.Errorf("serving: %w", err)
+		}
+		merged.Serving = &sv
+	}
+	return nil
+}
+
+// mergeSharedFields sets every field of merged outside shardFields from
+// the shards that give it, which must agree.
+func mergeSharedFields(merged *InputProblem, shards []InputProblem) error {
+	dst := reflect.ValueOf(merged).Elem()
+	t := dst.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := jsonFieldName(t.Field(i))
+		if name == "" || shardFields[name] {
+			continue
+		}
+		from := -1
+		for s := range shards {
+			v := reflect.ValueOf(shards[s]).Field(i)
+			if v.IsZero() {
+				continue
+			}
+			if from >= 0 && !reflect.DeepEqual(v.Interface(), dst.Field(i).Interface()) {
+				return fmt.Errorf("shards %d and %d disagree on %s", from, s, name)
+			}
+			if from < 0 {
+				dst.Field(i).Set(v)
+				from = s
+			}
+		}
+	}
+	return nil
+}
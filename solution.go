@@ -0,0 +1,230 @@
+package mlsys
+
+import "fmt"
+
+// ValidateSolution checks that s is a structurally sound schedule for p:
+// the per-subgraph lists line up, every op runs exactly once, granularities
+// are positive and respect the problem's tile constraints, every subgraph
+// fits in fast memory less the margin in opts, barrier entries retain
+// nothing, fixed subgraphs are kept whole, and the subgraph limits in opts
+// hold. It does not check data availability; see CheckExecution
+// for that.
+func ValidateSolution(p InputProblem, s OutputSolution, opts Options) error {
+	for _, c := range solutionChecks {
+		if err := c.Check(p, s, opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SolutionCheck is one of the checks ValidateSolution runs.
+type SolutionCheck struct {
+	Name  string
+	Check func(InputProblem, OutputSolution, Options) error
+}
+
+// SolutionChecks lists the checks of ValidateSolution in the order it runs
+// them. Each may assume that s passed the ones before it.
+func SolutionChecks() []SolutionCheck {
+	return append([]SolutionCheck{}, solutionChecks...)
+}
+
+var solutionChecks = []SolutionCheck{
+	{"lengths", validateSolutionLengths},
+	{"subgraphs", validateSubgraphs},
+	{"fits", validateSubgraphFits},
+	{"op_orders", func(p InputProblem, s OutputSolution, opts Options) error {
+		p, gi, err := solutionModel(p, s, opts)
+		if err != nil {
+			return err
+		}
+		return validateOpOrders(p, gi, s)
+	}},
+	{"fixed_subgraphs", func(p InputProblem, s OutputSolution, _ Options) error {
+		return checkFixedSubgraphs(p, s)
+	}},
+	{"subgraph_limits", validateSubgraphLimits},
+}
+
+// solutionModel is p as s runs it, with the capacity margin of opts and the
+// memory modes of s applied, and its graph index.
+func solutionModel(p InputProblem, s OutputSolution, opts Options) (InputProblem, graphIndex, error) {
+	p, err := withCapacityMargin(p, opts)
+	if err != nil {
+		return InputProblem{}, graphIndex{}, err
+	}
+	if p, err = withSolutionModes(p, s); err != nil {
+		return InputProblem{}, graphIndex{}, err
+	}
+	return p, buildGraphIndex(p, opts), nil
+}
+
+// validateSolutionLengths checks that the per-subgraph lists of s line up.
+func validateSolutionLengths(_ InputProblem, s OutputSolution, _ Options) error {
+	n := len(s.Subgraphs)
+	if len(s.Granularities) != n {
+		return fmt.Errorf("granularities has %d entries for %d subgraphs", len(s.Granularities), n)
+	}
+	if s.TensorsToRetain != nil && len(s.TensorsToRetain) != n {
+		return fmt.Errorf("tensors_to_retain has %d entries for %d subgraphs", len(s.TensorsToRetain), n)
+	}
+	if s.TraversalOrders != nil && len(s.TraversalOrders) != n {
+		return fmt.Errorf("traversal_orders has %d entries for %d subgraphs", len(s.TraversalOrders), n)
+	}
+	if s.SubgraphLatencies != nil && len(s.SubgraphLatencies) != n {
+		return fmt.Errorf("subgraph_latencies has %d entries for %d subgraphs", len(s.SubgraphLatencies), n)
+	}
+	if s.SubgraphDataflows != nil && len(s.SubgraphDataflows) != n {
+		return fmt.Errorf("subgraph_dataflows has %d entries for %d subgraphs", len(s.SubgraphDataflows), n)
+	}
+	for i, d := range s.SubgraphDataflows {
+		if d != "" && !knownDataflow(d) {
+			return fmt.Errorf("subgraph %d: unknown dataflow %q", i, d)
+		}
+	}
+	if s.SubgraphDMAStats != nil && len(s.SubgraphDMAStats) != n {
+		return fmt.Errorf("subgraph_dma_stats has %d entries for %d subgraphs", len(s.SubgraphDMAStats), n)
+	}
+	if s.SubgraphBufferDepths != nil && len(s.SubgraphBufferDepths) != n {
+		return fmt.Errorf("subgraph_buffer_depths has %d entries for %d subgraphs", len(s.SubgraphBufferDepths), n)
+	}
+	for i, d := range s.SubgraphBufferDepths {
+		if d < 0 || d > maxBufferDepth || (d == 0) != (len(s.Subgraphs[i]) == 0) {
+			return fmt.Errorf("subgraph %d: invalid buffer depth %d", i, d)
+		}
+	}
+	return nil
+}
+
+// validateSubgraphs checks that every op runs exactly once, at a positive
+// granularity its tile constraints and heads allow, and that barriers
+// retain nothing.
+func validateSubgraphs(p InputProblem, s OutputSolution, opts Options) error {
+	p, gi, err := solutionModel(p, s, opts)
+	if err != nil {
+		return err
+	}
+	ran := make([]bool, len(p.OpTypes))
+	for i, ops := range s.Subgraphs {
+		g := s.Granularities[i]
+		if g[0] <= 0 || g[1] <= 0 || g[2] <= 0 {
+			return fmt.Errorf("subgraph %d: granularity entries must be > 0", i)
+		}
+		if s.TensorsToRetain != nil {
+			for _, t := range s.TensorsToRetain[i] {
+				if t < 0 || t >= len(p.Widths) {
+					return fmt.Errorf("subgraph %d: retained tensor index out of range: %d", i, t)
+				}
+			}
+			if len(ops) == 0 && len(s.TensorsToRetain[i]) > 0 {
+				return fmt.Errorf("subgraph %d is a barrier but retains tensors", i)
+			}
+		}
+		var rule tileRule
+		tileable := true
+		for _, op := range ops {
+			if op < 0 || op >= len(p.OpTypes) {
+				return fmt.Errorf("subgraph %d: op index out of range: %d", i, op)
+			}
+			if ran[op] {
+				return fmt.Errorf("subgraph %d: op %d is already scheduled", i, op)
+			}
+			ran[op] = true
+			rule = rule.intersect(gi.tiles[op])
+			tileable = tileable && gi.opTypes[op].tileable
+		}
+		if len(ops) > 0 && tileable && !rule.allows(g[0], g[1]) {
+			return fmt.Errorf("subgraph %d: tile %dx%d violates the tile constraints of its ops", i, g[0], g[1])
+		}
+		for _, op := range ops {
+			heads := opHeads(p, op)
+			if heads <= 1 || !tileable || len(p.Outputs[op]) == 0 {
+				continue
+			}
+			if headH := p.Heights[p.Outputs[op][0]] / heads; !headAligned(g[1], headH) {
+				return fmt.Errorf("subgraph %d: tile height %d straddles the heads of op %d", i, g[1], op)
+			}
+		}
+	}
+	for op, ok := range ran {
+		if !ok {
+			return fmt.Errorf("op %d is not in any subgraph", op)
+		}
+	}
+	return nil
+}
+
+func validateSubgraphFits(p InputProblem, s OutputSolution, opts Options) error {
+	p, gi, err := solutionModel(p, s, opts)
+	if err != nil {
+		return err
+	}
+	return checkSubgraphFits(p, gi, s)
+}
+
+// validateSubgraphLimits enforces the subgraph limits of opts.
+func validateSubgraphLimits(_ InputProblem, s OutputSolution, opts Options) error {
+	subgraphs := 0
+	for i, ops := range s.Subgraphs {
+		if len(ops) == 0 {
+			continue
+		}
+		subgraphs++
+		if len(ops) < opts.MinOpsPerSubgraph {
+			return fmt.Errorf("subgraph %d has %d ops, below the minimum of %d", i, len(ops), opts.MinOpsPerSubgraph)
+		}
+	}
+	if opts.MaxSubgraphs > 0 && subgraphs > opts.MaxSubgraphs {
+		return fmt.Errorf("schedule has %d subgraphs, above the maximum of %d", subgraphs, opts.MaxSubgraphs)
+	}
+	return nil
+}
+
+// TotalLatency is the schedule's total estimated latency, the sum of its
+// subgraph latencies.
+func (s OutputSolution) TotalLatency() float64 {
+	return SumLatencies(s.SubgraphLatencies)
+}
+
+// SubgraphForOp returns the index of the subgraph that runs op, or -1 if
+// none does.
+func (s OutputSolution) SubgraphForOp(op int) int {
+	for i, ops := range s.Subgraphs {
+		for _, o := range ops {
+			if o == op {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// BoundaryTensors returns the tensors subgraph i of s reads from and writes
+// to outside itself, in the order the cost model charges them; both are
+// empty for a barrier. s must only reference ops of p.
+func (s OutputSolution) BoundaryTensors(p InputProblem, i int) (inputs, outputs []int) {
+	ops := s.Subgraphs[i]
+	if len(ops) == 0 {
+		return nil, nil
+	}
+	info := analyzeGroup(p, buildGraphIndex(p, Options{}), ops, newGroupScratch(p))
+	for _, in := range info.inputs {
+		inputs = append(inputs, in.tensor)
+	}
+	return inputs, append([]int{}, info.outputs...)
+}
+
+// TrafficBytes is the number of bytes s moves between slow and fast memory
+// under the cost model, as AnalyzeSolution counts it.
+func (s OutputSolution) TrafficBytes(p InputProblem, opts Options) (int64, error) {
+	stats, err := AnalyzeSolution(p, s, opts)
+	if err != nil {
+		return 0, err
+	}
+	var bytes int64
+	for _, st := range stats {
+		bytes = addSat(bytes, st.TrafficBytes)
+	}
+	return bytes, nil
+}
@@ -0,0 +1,586 @@
+package mlsys
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Options tunes how Solve models and searches a problem. The zero value is
+// the default behaviour.
+type Options struct {
+	// UnknownOps decides how op types missing from the registry are
+	// handled.
+	UnknownOps UnknownOpPolicy
+	// Compat pins heuristic decisions to an earlier release.
+	Compat CompatLevel
+	// EmitDependencies adds the subgraph dependency edges to the solution.
+	EmitDependencies bool
+	// EmitOpOrders adds an order of the ops of every subgraph to the
+	// solution. Unless the problem models parallel branches, which order
+	// ops by engine schedule, the order minimizes the peak size of live
+	// intermediate tensors.
+	EmitOpOrders bool
+	// EmitDMAStats adds the DMA descriptor counts of every subgraph to the
+	// solution, as it does unconditionally when the problem sets DMA
+	// limits.
+	EmitDMAStats bool
+	// MaxSubgraphs caps the number of subgraphs and MinOpsPerSubgraph sets
+	// the fewest ops each may hold, e.g. for runtimes with a fixed
+	// descriptor table or a per-subgraph dispatch overhead. Barrier entries
+	// count toward neither. Zero means no limit.
+	MaxSubgraphs      int
+	MinOpsPerSubgraph int
+	// GroupCache, when set, reuses tile choices from earlier solves of
+	// this or similar problems and records new ones. See groupcache.go.
+	GroupCache *GroupCache
+	// CapacityMargin is the fraction of fast memory schedules may fill, in
+	// (0, 1]; zero means all of it. See margin.go.
+	CapacityMargin float64
+	// EmitMeta adds a description of the run to the solution. See
+	// meta.go.
+	EmitMeta bool
+	// Templates lists schedule templates to apply where their patterns
+	// match. See templates.go.
+	Templates []ScheduleTemplate
+	// DedupeBlocks solves a run of repeated blocks of ops once and copies
+	// the schedule of the first block to the others. See dedupe.go.
+	DedupeBlocks bool
+	// EmitIntegrity adds hashes of the problem and options to the
+	// solution. See integrity.go.
+	EmitIntegrity bool
+	// TargetLatency, when positive, is a total latency good enough to stop
+	// at: the search passes are skipped, and the merge pass stopped, as
+	// soon as the schedule meets it. Subgraph limits are still enforced
+	// afterwards and may cost some of the margin.
+	TargetLatency float64
+	// Stabilize, when set, is a previous schedule of this problem, or of
+	// an earlier revision of it, to change in at most MaxChanges
+	// subgraphs. See stability.go.
+	Stabilize  *OutputSolution
+	MaxChanges int
+	// KernelNameFormat, when set, names the kernel of every subgraph for
+	// code generators. See kernels.go.
+	KernelNameFormat string
+	// Policy, when set, makes the grouping and tiling decisions in place
+	// of the search. See policy.go.
+	Policy Policy
+	// Progress, when set, is kept up to date with how far the search has
+	// got, for reports after a panic. See progress.go.
+	Progress *SolveProgress
+
+	// pinned lists tensors kept in fast memory for the whole run. Their
+	// footprint must already be deducted from the problem's capacity; the
+	// groups that read them neither load nor hold a slice of them.
+	pinned []int
+	// stats, when set, counts the work of the search for EmitMeta.
+	stats *solveStats
+	// serialized models every subgraph's transfers as never overlapping
+	// its compute, for Ablate.
+	serialized bool
+}
+
+// ErrInfeasible is returned, wrapped, when some op does not fit in fast
+// memory even at its smallest tile. The schedule returned with it still
+// covers every op, running those at that tile.
+var ErrInfeasible = errors.New("problem is infeasible")
+
+// Solve builds a schedule for p, which must have passed ValidateProblem.
+// It keeps no state between calls and is safe for concurrent use. If ctx is
+// done mid-search, Solve stops early and returns the best schedule found so
+// far together with ctx.Err(); that schedule is empty when cancellation
+// arrives before every op has been planned.
+func Solve(ctx context.Context, p InputProblem, opts Options) (OutputSolution, error) {
+	start := time.Now()
+	problem := p
+	var integrity Integrity
+	if opts.EmitIntegrity {
+		var err error
+		if integrity, err = ComputeIntegrity(p, opts); err != nil {
+			return OutputSolution{}, err
+		}
+	}
+	if opts.KernelNameFormat != "" {
+		if err := CheckKernelNameFormat(opts.KernelNameFormat); err != nil {
+			return OutputSolution{}, err
+		}
+	}
+	if opts.EmitMeta {
+		opts.stats = &solveStats{}
+	}
+	if err := checkOpTypes(p, opts.UnknownOps); err != nil {
+		return OutputSolution{}, err
+	}
+	p, err := withCapacityMargin(p, opts)
+	if err != nil {
+		return OutputSolution{}, err
+	}
+	opts.Progress.enter("choosing memory modes")
+	p, modes, err := solveMemoryModes(ctx, p, opts)
+	if err != nil {
+		return OutputSolution{}, err
+	}
+	plans, err := solvePlans(ctx, p, opts)
+	if err != nil && plans == nil {
+		return OutputSolution{}, err
+	}
+	if err == nil {
+		err = checkFits(p, plans)
+	}
+	opts.Progress.enter("assembling the schedule")
+	s := finishSolution(p, plans, opts)
+	s.MemoryModes = modes
+	if opts.KernelNameFormat != "" {
+		// The format was checked above and the schedule covers p, so
+		// naming cannot fail.
+		s.KernelNames, _ = KernelNames(p, s, opts, opts.KernelNameFormat)
+	}
+	if err == nil {
+		// Warnings measure fill against the problem's own capacity, not
+		// the margin the schedule was solved within.
+		s.Warnings, _ = ScheduleWarnings(problem, s, opts)
+	}
+	// The target and the previous schedule are for the main schedule;
+	// cache buckets and serving phases are searched in full.
+	full := opts
+	full.TargetLatency, full.Stabilize = 0, nil
+	if p.KVCache != nil && err == nil {
+		opts.Progress.enter("solving KV-cache buckets")
+		s.KVCacheBuckets, err = solveKVCacheBuckets(ctx, p, full)
+	}
+	if p.Serving != nil && err == nil {
+		opts.Progress.enter("solving serving phases")
+		s.Serving, err = solveServing(ctx, p, full)
+	}
+	if opts.DedupeBlocks {
+		s.RepeatedBlock = reportRepeatedBlock(s, findRepeatedBlock(p, buildGraphIndex(p, opts)))
+	}
+	if opts.EmitMeta {
+		s.Meta = newSolveMeta(opts, start)
+	}
+	if opts.EmitIntegrity {
+		s.Integrity = &integrity
+	}
+	return CanonicalizeSolution(s), err
+}
+
+// finishSolution turns plans into a schedule with its reports.
+func finishSolution(p InputProblem, plans []subgraphPlan, opts Options) OutputSolution {
+	s := assembleSolution(p, plans)
+	s.LayerLatencies = LayerReport(p, s)
+	s.SubgraphOpAttributes = SubgraphOpAttributes(p, s)
+	if opts.EmitDependencies {
+		s.SubgraphDependencies = SubgraphDependencies(p, s)
+	}
+	if opts.EmitDMAStats || p.MaxDMADescriptors > 0 || p.MinDMATransferSize > 0 {
+		s.SubgraphDMAStats = DMAReport(p, s, opts)
+	}
+	if opts.EmitOpOrders && s.OpOrders == nil {
+		gi := buildGraphIndex(p, opts)
+		s.OpOrders = make([][]int, len(s.Subgraphs))
+		for i, ops := range s.Subgraphs {
+			s.OpOrders[i] = livenessOrder(p, gi, ops)
+		}
+	}
+	return s
+}
+
+// checkFits reports the first subgraph that overflows fast memory or
+// breaks the DMA limits.
+func checkFits(p InputProblem, plans []subgraphPlan) error {
+	for _, plan := range plans {
+		g := plan.granularity
+		if err := checkDMALimits(p, plan.info, g, stepClasses(p, plan.info, g, p.SlowMemoryBandwidth)); err != nil {
+			return fmt.Errorf("%w: op %d %v", ErrInfeasible, plan.ops[0], err)
+		}
+		if plan.info.memory != nil || pooledCache(p) {
+			if err := checkGroupFits(p, plan.info, plan.granularity); err != nil {
+				return fmt.Errorf("%w: op %d %v", ErrInfeasible, plan.ops[0], err)
+			}
+			continue
+		}
+		if need := footprintOf(p, plan); float64(need) > p.FastMemoryCapacity {
+			return fmt.Errorf("%w: op %d needs %d elements of fast memory at its smallest tile, above the capacity of %g",
+				ErrInfeasible, plan.ops[0], need, p.FastMemoryCapacity)
+		}
+	}
+	return nil
+}
+
+// solvePlans runs the search pipeline. On cancellation it returns whatever
+// plans it has along with ctx.Err(), or nil plans if not every op has been
+// planned yet.
+func solvePlans(ctx context.Context, p InputProblem, opts Options) ([]subgraphPlan, error) {
+	progress := opts.Progress
+	pl := newPlanner(p, opts)
+	if opts.stats != nil {
+		defer func() { opts.stats.candidateGroups += len(pl.cache) }()
+	}
+	var repeat repeatedBlock
+	if opts.DedupeBlocks {
+		if repeat = findRepeatedBlock(p, pl.gi); repeat.count >= 2 {
+			pl.separateBlocks(repeat)
+			pl.repeat = repeat
+		}
+	}
+	if opts.Policy != nil {
+		progress.enter("playing the policy")
+		plans, err := policyPlans(ctx, pl, opts.Policy)
+		if err != nil {
+			return nil, err
+		}
+		progress.finish(p, plans)
+		progress.enter("enforcing subgraph limits")
+		return enforceSubgraphLimits(pl, plans, opts)
+	}
+	progress.enter("planning ops")
+	fixed := fixedSubgraphAt(p)
+	matches := matchTemplates(pl, opts.Templates, fixed)
+	templated := make([]bool, len(p.OpTypes))
+	plans := make([]subgraphPlan, 0, len(p.OpTypes))
+	for op := range p.OpTypes {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if blk := repeat.block(op); repeat.count >= 2 && blk > 1 && blk <= repeat.count {
+			continue
+		}
+		if templated[op] {
+			continue
+		}
+		if m, ok := matches[op]; ok {
+			if seeded, ok := planTemplate(pl, m); ok {
+				plans = append(plans, seeded...)
+				for i := range m.template.Pattern {
+					templated[op+i] = true
+				}
+				continue
+			}
+		}
+		group, isFixed := fixed[op]
+		if isFixed && group == nil {
+			continue
+		}
+		if !isFixed {
+			group = []int{op}
+		}
+		// A single op always gets a plan; if nothing fits, the smallest
+		// tile is used and validation downstream reports the overflow.
+		// Fixed subgraphs are treated the same way.
+		plan, _ := pl.plan(group)
+		if isFixed && len(group) > 1 && !plan.info.fusable {
+			return nil, fmt.Errorf("%w: fixed subgraph with ops %v cannot run as one subgraph", ErrInfeasible, group)
+		}
+		plan.fixed = isFixed
+		plans = append(plans, plan)
+	}
+	progress.finish(p, plans)
+	// The passes run cheapest first, each only while the target, if any,
+	// is still missed.
+	if opts.Compat.atLeast(CompatV1_4) && !pl.metTarget(plans) {
+		progress.enter("pre-clustering elementwise ops")
+		plans = preclusterElementwise(ctx, pl, plans)
+		progress.finish(p, plans)
+	}
+	if opts.Compat.atLeast(CompatV1_1) && !pl.metTarget(plans) {
+		progress.enter("merging subgraphs")
+		plans = mergeAdjacentSubgraphs(ctx, pl, plans)
+		progress.finish(p, plans)
+		if !pl.metTarget(plans) {
+			progress.enter("splitting subgraphs")
+			plans = splitMemoryBoundSubgraphs(ctx, pl, plans)
+			progress.finish(p, plans)
+		}
+	}
+	if repeat.count >= 2 {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		progress.enter("stamping repeated blocks")
+		var ok bool
+		if plans, ok = stampRepeatedBlock(pl, plans, repeat); !ok {
+			opts.DedupeBlocks = false
+			return solvePlans(ctx, p, opts)
+		}
+		// The subgraph limits may fuse across blocks.
+		pl.segment = barrierSegments(p)
+		pl.repeat = repeatedBlock{}
+		progress.finish(p, plans)
+	}
+	if opts.Stabilize != nil {
+		progress.enter("stabilizing")
+		plans = stabilize(pl, plans, *opts.Stabilize, opts.MaxChanges)
+		progress.finish(p, plans)
+	}
+	progress.enter("enforcing subgraph limits")
+	plans, err := enforceSubgraphLimits(pl, plans, opts)
+	if err != nil {
+		return plans, err
+	}
+	if opts.Compat.atLeast(CompatV1_7) && !pl.metTarget(plans) {
+		progress.enter("choosing retained tensors")
+		// Joining subgraphs would undo a stabilized schedule's kept groups
+		// and the copies of a deduplicated block.
+		plans = searchRetention(ctx, pl, plans, opts.Stabilize == nil && !opts.DedupeBlocks)
+		progress.finish(p, plans)
+	}
+	return plans, ctx.Err()
+}
+
+// subgraphPlan is one schedule entry while the solver is still working on
+// it: the member ops, their boundary analysis, and the chosen tile.
+type subgraphPlan struct {
+	ops         []int
+	info        groupInfo
+	granularity [3]int64
+	// latency is the nominal estimate; objective is the estimate at the
+	// decision bandwidth, which is what the passes minimize.
+	latency   float64
+	objective float64
+	// fixed marks a fixed subgraph, which the passes leave as it is.
+	fixed bool
+	// retain lists the tensors searchRetention keeps in fast memory after
+	// the subgraph, besides loop-carried ones.
+	retain []int
+}
+
+// planner evaluates candidate groups for one problem. The passes revisit
+// the same op ranges many times (every merge re-scores all neighbouring
+// pairs), so results are memoized by the group's op list, and the sets used
+// by boundary analysis come from a pool instead of fresh maps per call.
+type planner struct {
+	p       InputProblem
+	gi      graphIndex
+	cache   map[string]plannedGroup
+	scratch sync.Pool
+	keyBuf  []byte
+	// region is each op's innermost control-flow region, -1 at top level.
+	region []int
+	// segment numbers the stretches of ops between barriers.
+	segment []int
+	compat  CompatLevel
+	// tiles, when set, persists tile choices across solves; tilesHeader
+	// is the problem-wide part of its keys.
+	tiles       *GroupCache
+	tilesHeader []byte
+	// target is Options.TargetLatency, and repeat the repeated blocks the
+	// plans hold the first of, if any.
+	target float64
+	repeat repeatedBlock
+	// progress is Options.Progress.
+	progress *SolveProgress
+}
+
+type plannedGroup struct {
+	plan subgraphPlan
+	ok   bool
+}
+
+func newPlanner(p InputProblem, opts Options) *planner {
+	pl := &planner{
+		p:        p,
+		gi:       buildGraphIndex(p, opts),
+		cache:    make(map[string]plannedGroup),
+		compat:   opts.Compat,
+		tiles:    opts.GroupCache,
+		target:   opts.TargetLatency,
+		progress: opts.Progress,
+	}
+	if pl.tiles != nil {
+		pl.tilesHeader = groupCacheHeader(p, opts.Compat)
+	}
+	pl.region, _ = regionIndex(p)
+	pl.segment = barrierSegments(p)
+	pl.scratch.New = func() any { return newGroupScratch(p) }
+	return pl
+}
+
+// plan returns the subgraph plan for ops, evaluating it at most once. ops
+// may be a caller-owned scratch buffer: it is copied before being stored.
+func (pl *planner) plan(ops []int) (subgraphPlan, bool) {
+	pl.keyBuf = appendGroupKey(pl.keyBuf[:0], ops)
+	if hit, ok := pl.cache[string(pl.keyBuf)]; ok {
+		return hit.plan, hit.ok
+	}
+	ops = append([]int(nil), ops...)
+	pl.progress.evaluate(ops)
+	sc := pl.scratch.Get().(*groupScratch)
+	info := analyzeGroup(pl.p, pl.gi, ops, sc)
+	pl.scratch.Put(sc)
+	plan, ok := pl.planFromInfo(ops, info)
+	pl.cache[string(pl.keyBuf)] = plannedGroup{plan: plan, ok: ok}
+	return plan, ok
+}
+
+// metTarget reports whether plans, with the first of any repeated blocks
+// counted once per block, meet the target latency.
+func (pl *planner) metTarget(plans []subgraphPlan) bool {
+	if pl.target <= 0 {
+		return false
+	}
+	var total compensatedSum
+	for _, plan := range plans {
+		if pl.repeat.count >= 2 && pl.repeat.block(plan.ops[0]) == 1 {
+			total.Add(float64(pl.repeat.count) * plan.latency)
+		} else {
+			total.Add(plan.latency)
+		}
+	}
+	return total.Total() <= pl.target
+}
+
+// mayJoin reports whether a and b lie in the same innermost control-flow
+// region and between the same barriers, and so may share a subgraph.
+// Fixed subgraphs join nothing.
+func (pl *planner) mayJoin(a, b subgraphPlan) bool {
+	if a.fixed || b.fixed {
+		return false
+	}
+	x, y := a.ops[0], b.ops[0]
+	return pl.region[x] == pl.region[y] && pl.segment[x] == pl.segment[y]
+}
+
+// planJoined plans a followed by b as one subgraph. When both are
+// neighbouring op ranges, the boundary is derived from theirs in time
+// proportional to their boundary sizes instead of re-analyzing every op.
+func (pl *planner) planJoined(a, b subgraphPlan) (subgraphPlan, bool) {
+	pl.keyBuf = appendGroupKey(appendGroupKey(pl.keyBuf[:0], a.ops), b.ops)
+	if hit, ok := pl.cache[string(pl.keyBuf)]; ok {
+		return hit.plan, hit.ok
+	}
+	ops := make([]int, 0, len(a.ops)+len(b.ops))
+	ops = append(append(ops, a.ops...), b.ops...)
+	pl.progress.evaluate(ops)
+	sc := pl.scratch.Get().(*groupScratch)
+	var info groupInfo
+	if a.info.contiguous && b.info.contiguous && a.info.span[1] == b.info.span[0] {
+		info = joinGroups(pl.p, pl.gi, a.info, b.info, sc)
+	} else {
+		info = analyzeGroup(pl.p, pl.gi, ops, sc)
+	}
+	pl.scratch.Put(sc)
+	plan, ok := pl.planFromInfo(ops, info)
+	pl.cache[string(pl.keyBuf)] = plannedGroup{plan: plan, ok: ok}
+	return plan, ok
+}
+
+// appendGroupKey fingerprints an op list; the order of ops is significant.
+func appendGroupKey(buf []byte, ops []int) []byte {
+	for _, op := range ops {
+		buf = binary.AppendUvarint(buf, uint64(op))
+	}
+	return buf
+}
+
+// planFromInfo picks the granularity for an analyzed group. The boolean is
+// false when no candidate tile fits in fast memory.
+func (pl *planner) planFromInfo(ops []int, info groupInfo) (subgraphPlan, bool) {
+	p := pl.p
+	g, ok := pl.chooseGranularity(info)
+	if len(ops) > 1 && !info.fusable {
+		ok = false
+	}
+	bw, _ := decisionBandwidth(p)
+	return subgraphPlan{
+		ops:         ops,
+		info:        info,
+		granularity: g,
+		latency:     estimateSubgraphLatency(p, info, g),
+		objective:   estimateGroupLatencyAtBandwidth(p, info, g, bw),
+	}, ok
+}
+
+// chooseGranularity picks the tile for a group, through the persistent
+// cache when there is one.
+func (pl *planner) chooseGranularity(info groupInfo) ([3]int64, bool) {
+	if pl.tiles == nil {
+		return chooseGranularityForGroup(pl.p, info, pl.compat)
+	}
+	key := groupCacheKey(pl.p, pl.tilesHeader, info)
+	if e, ok := pl.tiles.lookup(key); ok {
+		return e.Granularity, e.Fits
+	}
+	g, ok := chooseGranularityForGroup(pl.p, info, pl.compat)
+	pl.tiles.store(key, cachedTile{Granularity: g, Fits: ok})
+	return g, ok
+}
+
+func assembleSolution(p InputProblem, plans []subgraphPlan) OutputSolution {
+	n := len(plans) + len(p.Barriers)
+	s := OutputSolution{
+		Subgraphs:         make([][]int, 0, n),
+		Granularities:     make([][3]int64, 0, n),
+		TensorsToRetain:   make([][]int, 0, n),
+		TraversalOrders:   make([]*[]int64, 0, n),
+		SubgraphLatencies: make([]float64, 0, n),
+	}
+	riskAware := p.BandwidthDistribution != nil
+	interference := p.BackgroundDRAMTraffic > 0
+	_, execCount := regionIndex(p)
+	retain := loopCarriedRetention(p, plans)
+	barriers := barriersAfter(p, plans)
+
+	for i, plan := range plans {
+		g := plan.granularity
+		s.Subgraphs = append(s.Subgraphs, plan.ops)
+		s.Granularities = append(s.Granularities, g)
+		s.TensorsToRetain = append(s.TensorsToRetain, sortedUnique(append(retain[i], plan.retain...)))
+		s.TraversalOrders = append(s.TraversalOrders, nil)
+		s.SubgraphLatencies = append(s.SubgraphLatencies, plan.latency)
+		if riskAware {
+			s.SubgraphLatenciesP95 = append(s.SubgraphLatenciesP95, estimateGroupLatencyAtBandwidth(p, plan.info, g, p95Bandwidth(p)))
+		}
+		if interference {
+			s.SubgraphLatenciesInterference = append(s.SubgraphLatenciesInterference, estimateGroupLatencyAtBandwidth(p, plan.info, g, interferenceBandwidth(p)))
+		}
+		if p.CostModelUncertainty != nil {
+			s.SubgraphLatencyIntervals = append(s.SubgraphLatencyIntervals, latencyInterval(p, plan.info, g, plan.latency))
+		}
+		if len(p.Regions) > 0 {
+			s.SubgraphExecutionCounts = append(s.SubgraphExecutionCounts, execCount[plan.ops[0]])
+		}
+		if p.OperandReuse {
+			d := chooseDataflow(p, plan.info, g, p.SlowMemoryBandwidth)
+			s.SubgraphDataflows = append(s.SubgraphDataflows, dataflows[d].name)
+		}
+		if p.MaxBufferDepth > 0 {
+			s.SubgraphBufferDepths = append(s.SubgraphBufferDepths, bufferDepth(p, plan.info, g[0], g[1], g[2]))
+		}
+		if p.ParallelBranches {
+			s.OpOrders = append(s.OpOrders, opOrder(p, plan))
+		}
+
+		for _, b := range barriers[i] {
+			s.Subgraphs = append(s.Subgraphs, []int{})
+			s.Granularities = append(s.Granularities, barrierGranularity)
+			s.TensorsToRetain = append(s.TensorsToRetain, []int{})
+			s.TraversalOrders = append(s.TraversalOrders, nil)
+			s.SubgraphLatencies = append(s.SubgraphLatencies, b.Cost)
+			if riskAware {
+				s.SubgraphLatenciesP95 = append(s.SubgraphLatenciesP95, b.Cost)
+			}
+			if interference {
+				s.SubgraphLatenciesInterference = append(s.SubgraphLatenciesInterference, b.Cost)
+			}
+			if p.CostModelUncertainty != nil {
+				s.SubgraphLatencyIntervals = append(s.SubgraphLatencyIntervals, LatencyInterval{Low: b.Cost, Expected: b.Cost, High: b.Cost})
+			}
+			if len(p.Regions) > 0 {
+				s.SubgraphExecutionCounts = append(s.SubgraphExecutionCounts, execCount[b.AfterOp])
+			}
+			if p.OperandReuse {
+				s.SubgraphDataflows = append(s.SubgraphDataflows, "")
+			}
+			if p.MaxBufferDepth > 0 {
+				s.SubgraphBufferDepths = append(s.SubgraphBufferDepths, 0)
+			}
+			if p.ParallelBranches {
+				s.OpOrders = append(s.OpOrders, []int{})
+			}
+		}
+	}
+	return s
+}
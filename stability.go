@@ -0,0 +1,252 @@
+package mlsys
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// A schedule recomputed after a small model update may reshuffle subgraph
+// boundaries the update never touched, invalidating every kernel compiled
+// for the old ones. With Options.Stabilize, Solve keeps the previous
+// schedule wherever the search gains too little to be worth a change, and
+// changes at most Options.MaxChanges subgraphs. A subgraph is changed when
+// the previous schedule has none of the same ops and granularity. Ops are
+// matched by index, so updates should edit or append ops rather than
+// renumber them.
+//
+// Both schedules are cut wherever they have run exactly the ops before
+// some op, and between two cuts they share, a stretch is taken whole from
+// one or the other. The previous stretch is usable when each of its
+// subgraphs can still run as one, at its old tile or, as a change, at a
+// new one, and it still writes every tensor before reading it. Which
+// stretches to take from the new schedule is chosen by dynamic programming
+// over the change budget, for the least modeled latency. When even the
+// fewest changes possible exceed the budget, those are made.
+
+// ScheduleChanges counts the subgraphs of s that prev has no subgraph of
+// the same ops and granularity for. Barriers are not counted.
+func ScheduleChanges(prev, s OutputSolution) int {
+	keys := previousKeys(prev)
+	n := 0
+	for i, ops := range s.Subgraphs {
+		if len(ops) > 0 && i < len(s.Granularities) && !keys[subgraphKey(ops, s.Granularities[i])] {
+			n++
+		}
+	}
+	return n
+}
+
+func previousKeys(prev OutputSolution) map[string]bool {
+	keys := make(map[string]bool)
+	for i, ops := range prev.Subgraphs {
+		if len(ops) > 0 && i < len(prev.Granularities) {
+			keys[subgraphKey(ops, prev.Granularities[i])] = true
+		}
+	}
+	return keys
+}
+
+func subgraphKey(ops []int, g [3]int64) string {
+	return fmt.Sprint(sortedUnique(ops), g)
+}
+
+// stretchOption is one way to schedule a stretch of ops between two shared
+// cuts.
+type stretchOption struct {
+	plans   []subgraphPlan
+	cost    float64
+	changes int
+	ok      bool
+}
+
+// stabilize restores stretches of prev in plans, which must cover every
+// op, keeping within maxChanges changes where it can.
+func stabilize(pl *planner, plans []subgraphPlan, prev OutputSolution, maxChanges int) []subgraphPlan {
+	var old [][]int
+	var oldTiles [][3]int64
+	for i, ops := range prev.Subgraphs {
+		if len(ops) > 0 && i < len(prev.Granularities) {
+			old = append(old, sortedUnique(ops))
+			oldTiles = append(oldTiles, prev.Granularities[i])
+		}
+	}
+	current := make([][]int, len(plans))
+	for i, plan := range plans {
+		current[i] = plan.ops
+	}
+	newCuts, oldCuts := scheduleCuts(current), scheduleCuts(old)
+	var shared []int
+	for c := range newCuts {
+		if _, ok := oldCuts[c]; ok {
+			shared = append(shared, c)
+		}
+	}
+	sort.Ints(shared)
+	n := len(pl.p.OpTypes)
+	if shared[len(shared)-1] != n {
+		// The previous schedule does not end where this one does; its tail
+		// is never usable.
+		shared = append(shared, n)
+		oldCuts[n] = -1
+	}
+
+	keys := previousKeys(prev)
+	fixed := make(map[string]bool)
+	fixedOp := make(map[int]bool)
+	for _, ops := range pl.p.FixedSubgraphs {
+		fixed[fmt.Sprint(sortedUnique(ops))] = true
+		for _, op := range ops {
+			fixedOp[op] = true
+		}
+	}
+	sc := newGroupScratch(pl.p)
+	bw, _ := decisionBandwidth(pl.p)
+	type stretch struct{ cur, prev stretchOption }
+	stretches := make([]stretch, 0, len(shared)-1)
+	fewest, most := 0, 0
+	for i := 0; i+1 < len(shared); i++ {
+		a, b := shared[i], shared[i+1]
+		st := stretch{cur: stretchOption{plans: plans[newCuts[a]:newCuts[b]], ok: true}}
+		for _, plan := range st.cur.plans {
+			st.cur.cost += plan.objective
+			if !keys[subgraphKey(plan.ops, plan.granularity)] {
+				st.cur.changes++
+			}
+		}
+		if oldCuts[b] >= 0 {
+			st.prev = previousStretch(pl, sc, bw, old[oldCuts[a]:oldCuts[b]], oldTiles[oldCuts[a]:oldCuts[b]], a, b, fixed, fixedOp)
+		}
+		least := st.cur.changes
+		if st.prev.ok {
+			least = min(least, st.prev.changes)
+		}
+		fewest += least
+		most += max(st.cur.changes, st.prev.changes)
+		stretches = append(stretches, st)
+	}
+
+	// cost[c] is the least cost of the stretches so far with at most c
+	// changes, and takeNew[i][c] whether stretch i is taken from the new
+	// schedule to reach it.
+	budget := min(max(maxChanges, fewest), most)
+	cost := make([]float64, budget+1)
+	takeNew := make([][]bool, len(stretches))
+	for i, st := range stretches {
+		next := make([]float64, budget+1)
+		takeNew[i] = make([]bool, budget+1)
+		for c := range next {
+			next[c] = math.Inf(1)
+			if st.prev.ok && st.prev.changes <= c {
+				next[c] = cost[c-st.prev.changes] + st.prev.cost
+			}
+			if st.cur.changes <= c {
+				// Change only for a strict gain.
+				if v := cost[c-st.cur.changes] + st.cur.cost; v < next[c] {
+					next[c] = v
+					takeNew[i][c] = true
+				}
+			}
+		}
+		cost = next
+	}
+	chosen := make([][]subgraphPlan, len(stretches))
+	c := budget
+	for i := len(stretches) - 1; i >= 0; i-- {
+		st := stretches[i]
+		if takeNew[i][c] {
+			chosen[i], c = st.cur.plans, c-st.cur.changes
+		} else {
+			chosen[i], c = st.prev.plans, c-st.prev.changes
+		}
+	}
+	out := make([]subgraphPlan, 0, len(plans))
+	for _, ps := range chosen {
+		out = append(out, ps...)
+	}
+	return out
+}
+
+// previousStretch plans the subgraphs of the previous schedule that cover
+// ops a through b-1, at their old tiles where those still fit.
+func previousStretch(pl *planner, sc *groupScratch, bw float64, groups [][]int, tiles [][3]int64, a, b int, fixed map[string]bool, fixedOp map[int]bool) stretchOption {
+	p := pl.p
+	var opt stretchOption
+	ran := make(map[int]bool)
+	for i, ops := range groups {
+		for _, op := range ops {
+			if op < a || op >= b || pl.region[op] != pl.region[ops[0]] || pl.segment[op] != pl.segment[ops[0]] {
+				return stretchOption{}
+			}
+		}
+		isFixed := fixed[fmt.Sprint(ops)]
+		for _, op := range ops {
+			if ran[op] || (fixedOp[op] && !isFixed) {
+				return stretchOption{}
+			}
+		}
+		// Every tensor read must come from before the stretch, an earlier
+		// subgraph, or this one.
+		in := make(map[int]bool, len(ops))
+		for _, op := range ops {
+			in[op] = true
+		}
+		for _, op := range ops {
+			for _, t := range p.Inputs[op] {
+				for _, w := range pl.gi.producers[t] {
+					if w >= a && w < b && !ran[w] && !in[w] {
+						return stretchOption{}
+					}
+				}
+			}
+		}
+		for _, op := range ops {
+			ran[op] = true
+		}
+
+		info := analyzeGroup(p, pl.gi, ops, sc)
+		if len(ops) > 1 && !info.fusable {
+			return stretchOption{}
+		}
+		g := tiles[i]
+		var plan subgraphPlan
+		if g[0] > 0 && g[1] > 0 && g[2] > 0 && checkGroupFits(p, info, g) == nil && withinDMALimits(p, info, g[0], g[1], g[2]) {
+			plan = subgraphPlan{
+				ops:         ops,
+				info:        info,
+				granularity: g,
+				latency:     estimateSubgraphLatency(p, info, g),
+				objective:   estimateGroupLatencyAtBandwidth(p, info, g, bw),
+			}
+		} else {
+			var ok bool
+			if plan, ok = pl.plan(ops); !ok {
+				return stretchOption{}
+			}
+			opt.changes++
+		}
+		plan.fixed = isFixed
+		opt.plans = append(opt.plans, plan)
+		opt.cost += plan.objective
+	}
+	opt.ok = true
+	return opt
+}
+
+// scheduleCuts maps each op index c at which the subgraphs of groups, in
+// order, have run exactly ops 0 through c-1 to the number of subgraphs run.
+func scheduleCuts(groups [][]int) map[int]int {
+	cuts := map[int]int{0: 0}
+	seen := make(map[int]bool)
+	last := -1
+	for i, ops := range groups {
+		for _, op := range ops {
+			seen[op] = true
+			last = max(last, op)
+		}
+		if len(seen) == last+1 {
+			cuts[last+1] = i + 1
+		}
+	}
+	return cuts
+}
@@ -0,0 +1,118 @@
+package mlsys
+
+import "sort"
+
+// ProblemStats extends ProblemSummary with the shape of the graph and a
+// roofline view of every op, to judge a problem before solving it.
+type ProblemStats struct {
+	ProblemSummary
+	// FanOut counts tensors by their number of consumer ops, in order of
+	// that number; MaxFanOut and MeanFanOut are over all tensors.
+	FanOut     []FanOutBucket
+	MaxFanOut  int
+	MeanFanOut float64
+	// OpStats has the roofline view of every op, in op order.
+	OpStats []OpStats
+	// MemoryBoundOps is the number of memory-bound ops, and
+	// MemoryBoundFraction their share of the summed latency of all ops
+	// each run alone.
+	MemoryBoundOps      int
+	MemoryBoundFraction float64
+}
+
+// FanOutBucket counts the tensors read by Consumers ops.
+type FanOutBucket struct {
+	Consumers int
+	Tensors   int
+}
+
+// OpStats is the cost model's view of one op run alone as a subgraph, at
+// the tile the solver would choose for it.
+type OpStats struct {
+	Op          int
+	Type        string
+	Granularity [3]int64
+	// ComputeTime and MemoryTime are summed over all steps, and Traffic is
+	// the number of elements the op moves between slow and fast memory,
+	// TrafficBytes their size.
+	ComputeTime  float64
+	MemoryTime   float64
+	Traffic      int64
+	TrafficBytes int64
+	Latency      float64
+	// Intensity is the compute time per element moved, ComputeTime over
+	// Traffic. Ops with Intensity below 1/SlowMemoryBandwidth wait on
+	// memory rather than compute, but transfer overheads count too:
+	// MemoryBound compares the two times themselves.
+	Intensity   float64
+	MemoryBound bool
+}
+
+// ProblemStatistics summarizes p as SummarizeProblem does and adds fan-out
+// and per-op roofline statistics. It plans every op alone, so it costs a
+// small fraction of a solve; p must have passed ValidateProblem.
+func ProblemStatistics(p InputProblem, opts Options) (ProblemStats, error) {
+	summary, err := SummarizeProblem(p, opts)
+	if err != nil {
+		return ProblemStats{}, err
+	}
+	st := ProblemStats{ProblemSummary: summary}
+	p, err = withCapacityMargin(p, opts)
+	if err != nil {
+		return ProblemStats{}, err
+	}
+	pl := newPlanner(p, opts)
+
+	fanOut := make(map[int]int)
+	consumers := 0
+	for t := range p.Widths {
+		n := len(sortedUnique(pl.gi.consumers[t]))
+		fanOut[n]++
+		consumers += n
+		st.MaxFanOut = max(st.MaxFanOut, n)
+	}
+	for n, count := range fanOut {
+		st.FanOut = append(st.FanOut, FanOutBucket{Consumers: n, Tensors: count})
+	}
+	sort.Slice(st.FanOut, func(i, j int) bool { return st.FanOut[i].Consumers < st.FanOut[j].Consumers })
+	if len(p.Widths) > 0 {
+		st.MeanFanOut = float64(consumers) / float64(len(p.Widths))
+	}
+
+	var total, memoryBound compensatedSum
+	for op := range p.OpTypes {
+		// An op that fits at no tile still gets a plan at the smallest one,
+		// which is what Solve reports as infeasible.
+		plan, _ := pl.plan([]int{op})
+		g := plan.granularity
+		steps, compute, _ := stepCosts(p, plan.info, g, p.SlowMemoryBandwidth)
+		os := OpStats{
+			Op:          op,
+			Type:        canonicalOpType(p.OpTypes[op]),
+			Granularity: g,
+			ComputeTime: float64(steps) * compute,
+			Latency:     plan.latency,
+		}
+		var overhead float64
+		for _, c := range stepClasses(p, plan.info, g, p.SlowMemoryBandwidth) {
+			os.Traffic = addSat(os.Traffic, mulSat(c.steps, c.elements))
+			overhead += float64(c.steps) * c.overhead
+		}
+		os.TrafficBytes = mulSat(os.Traffic, elementBytes(p))
+		os.MemoryTime = float64(os.Traffic)/p.SlowMemoryBandwidth + overhead
+		if os.Traffic > 0 {
+			os.Intensity = os.ComputeTime / float64(os.Traffic)
+		}
+		os.MemoryBound = os.MemoryTime > os.ComputeTime
+		total.Add(os.Latency)
+		if os.MemoryBound {
+			st.MemoryBoundOps++
+			memoryBound.Add(os.Latency)
+		}
+		st.OpStats = append(st.OpStats, os)
+	}
+	if total.Total() > 0 {
+		st.MemoryBoundFraction = memoryBound.Total() / total.Total()
+	}
+	return st, nil
+}
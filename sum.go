@@ -0,0 +1,52 @@
+package mlsys
+
+import "math"
+
+// Latencies and times are floats, and a plain running sum over thousands
+// of subgraphs, each costing millions of steps, loses a rounding error per
+// addition, which shows up as totals that depend on the order subgraphs are
+// listed in. Totals therefore go through a compensated sum, and traffic is
+// counted in whole elements and bytes, converted to time only at the end.
+
+// compensatedSum adds floats with Neumaier's variant of Kahan summation:
+// the rounding error of every addition is carried separately and added
+// back once, so the total is as precise as if its terms were summed
+// exactly and then rounded.
+type compensatedSum struct {
+	sum, c float64
+}
+
+func (s *compensatedSum) Add(x float64) {
+	t := s.sum + x
+	if math.Abs(s.sum) >= math.Abs(x) {
+		s.c += (s.sum - t) + x
+	} else {
+		s.c += (x - t) + s.sum
+	}
+	s.sum = t
+}
+
+// Total returns the sum of every value added.
+func (s compensatedSum) Total() float64 {
+	return s.sum + s.c
+}
+
+// SumLatencies adds up xs with compensated summation.
+func SumLatencies(xs []float64) float64 {
+	var s compensatedSum
+	for _, x := range xs {
+		s.Add(x)
+	}
+	return s.Total()
+}
+
+// elementBytes is the size in bytes of an element of p's data type, the
+// unit traffic is counted in.
+func elementBytes(p InputProblem) int64 {
+	data := p.DataDType
+	if data == "" {
+		data = defaultDataDType
+	}
+	size, _ := dtypeSize(data)
+	return int64(size)
+}
@@ -0,0 +1,118 @@
+package mlsys
+
+import "math/bits"
+
+// ProblemSummary describes a problem, and what solving it will cost,
+// without running the search.
+type ProblemSummary struct {
+	Ops     int
+	Tensors int
+	// OpsByType counts ops per canonical type name. Types missing from the
+	// registry are counted under their lower-cased name and also in
+	// UnknownOps.
+	OpsByType  map[string]int
+	UnknownOps int
+	// GraphInputs have no producer and GraphOutputs no consumer.
+	GraphInputs   int
+	GraphOutputs  int
+	TotalElements int64
+	LargestTensor int64
+	// TensorSizes is a histogram of tensor sizes in elements, by power of
+	// two. Empty buckets are left out.
+	TensorSizes []SizeBucket
+	// CandidateGroups bounds the number of candidate subgraphs the search
+	// analyzes, and TileEvaluations the cost-model evaluations it runs on
+	// them.
+	CandidateGroups int64
+	TileEvaluations int64
+}
+
+// SizeBucket counts the tensors with more than MaxElements/2 and at most
+// MaxElements elements.
+type SizeBucket struct {
+	MaxElements int64
+	Tensors     int
+}
+
+// SummarizeProblem runs the graph analysis Solve starts with and reports
+// on it. It is cheap even for problems too large to solve quickly; p must
+// have passed ValidateProblem.
+func SummarizeProblem(p InputProblem, opts Options) (ProblemSummary, error) {
+	if err := checkOpTypes(p, opts.UnknownOps); err != nil {
+		return ProblemSummary{}, err
+	}
+	gi := buildGraphIndex(p, opts)
+	s := ProblemSummary{
+		Ops:       len(p.OpTypes),
+		Tensors:   len(p.Widths),
+		OpsByType: make(map[string]int),
+	}
+	for _, name := range p.OpTypes {
+		s.OpsByType[canonicalOpType(name)]++
+		if _, ok := lookupOpType(name, opts.UnknownOps, opts.Compat); !ok {
+			s.UnknownOps++
+		}
+	}
+
+	var buckets [64]int
+	for t := range p.Widths {
+		if len(gi.producers[t]) == 0 {
+			s.GraphInputs++
+		}
+		if len(gi.consumers[t]) == 0 {
+			s.GraphOutputs++
+		}
+		n := p.Widths[t] * p.Heights[t]
+		s.TotalElements += n
+		s.LargestTensor = max(s.LargestTensor, n)
+		buckets[bits.Len64(uint64(max(n-1, 0)))]++
+	}
+	for i, count := range buckets {
+		if count > 0 {
+			s.TensorSizes = append(s.TensorSizes, SizeBucket{MaxElements: 1 << i, Tensors: count})
+		}
+	}
+
+	// Every op is planned alone; with the merge and split passes, every
+	// window of neighbouring ops up to the merge limit may be planned too,
+	// each at most once thanks to the planner's memo. Pre-clustering
+	// plans one more group for every op it adds to a cluster.
+	n := int64(len(p.OpTypes))
+	s.CandidateGroups = n
+	if opts.Compat.atLeast(CompatV1_1) {
+		limit := int64(mergeLimit(opts.Compat))
+		if limit == 0 {
+			limit = n
+		}
+		for size := int64(2); size <= limit; size++ {
+			s.CandidateGroups += max(n-size+1, 0)
+		}
+	}
+	if opts.Compat.atLeast(CompatV1_4) {
+		s.CandidateGroups += max(n-1, 0)
+	}
+	sc := newGroupScratch(p)
+	var tiles int64
+	for op := range p.OpTypes {
+		info := analyzeGroup(p, gi, []int{op}, sc)
+		tiles += tileCandidates(p, info)
+	}
+	if n > 0 {
+		s.TileEvaluations = s.CandidateGroups * tiles / n
+	}
+	return s, nil
+}
+
+// tileCandidates is the number of tiles chooseGranularityForGroup weighs
+// for a group.
+func tileCandidates(p InputProblem, info groupInfo) int64 {
+	if !info.tileable {
+		return 1
+	}
+	out := info.gridTensor
+	maxW := max(minI64(p.NativeGranularity[0], p.Widths[out]), 1)
+	maxH := max(minI64(p.NativeGranularity[1], p.Heights[out]), 1)
+	w := len(candidateSides(maxW, info.tiles.widths, info.tiles.wMul))
+	h := len(candidateSides(maxH, info.tiles.heights, info.tiles.hMul))
+	return int64(w * h)
+}
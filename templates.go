@@ -0,0 +1,201 @@
+package mlsys
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// A ScheduleTemplate is a known-good way to schedule a common pattern of
+// ops, such as a matmul followed by its bias add and activation. With
+// Options.Templates, Solve looks for each pattern in the problem and plans
+// the matched ops as the template groups them: a seed template is where
+// the search starts from for those ops, which the merge and split passes
+// may still improve on, and an override template is kept as it is, like a
+// fixed subgraph. Tiles are chosen by the cost model either way.
+type ScheduleTemplate struct {
+	Name string `json:"name"`
+	// Pattern lists the op types of consecutive ops, each reading a tensor
+	// written by an op before it in the match. Types are matched as the
+	// registry matches them, aliases included.
+	Pattern []string `json:"pattern"`
+	// Groups splits the pattern, by position, into consecutive runs
+	// scheduled as one subgraph each. Empty means one group of the whole
+	// pattern.
+	Groups [][]int `json:"groups,omitempty"`
+	// Mode is TemplateSeed, the default, or TemplateOverride.
+	Mode string `json:"mode,omitempty"`
+}
+
+// Template modes.
+const (
+	TemplateSeed     = "seed"
+	TemplateOverride = "override"
+)
+
+// BuiltinTemplates returns the template library the solver ships with.
+func BuiltinTemplates() []ScheduleTemplate {
+	return []ScheduleTemplate{
+		{Name: "attention", Pattern: []string{"matmul", "pointwise", "exp", "pointwise", "matmul"}},
+		{Name: "matmul_bias_gelu", Pattern: []string{"matmul", "pointwise", "gelu"}},
+		{Name: "matmul_bias", Pattern: []string{"matmul", "pointwise"}},
+	}
+}
+
+type templateFile struct {
+	Templates []ScheduleTemplate `json:"templates"`
+}
+
+// ReadTemplates reads a template file, a JSON object whose "templates"
+// lists ScheduleTemplates, and checks every template in it.
+func ReadTemplates(r io.Reader) ([]ScheduleTemplate, error) {
+	var f templateFile
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&f); err != nil {
+		return nil, fmt.Errorf("templates: %w", err)
+	}
+	for i, t := range f.Templates {
+		if err := t.validate(); err != nil {
+			return nil, fmt.Errorf("templates: template %d: %w", i, err)
+		}
+	}
+	return f.Templates, nil
+}
+
+func (t ScheduleTemplate) validate() error {
+	if t.Name == "" {
+		return errors.New("missing name")
+	}
+	if len(t.Pattern) == 0 {
+		return fmt.Errorf("%s: empty pattern", t.Name)
+	}
+	if t.Mode != "" && t.Mode != TemplateSeed && t.Mode != TemplateOverride {
+		return fmt.Errorf("%s: mode must be %s or %s, got %q", t.Name, TemplateSeed, TemplateOverride, t.Mode)
+	}
+	next := 0
+	for i, g := range t.Groups {
+		for _, pos := range g {
+			if pos != next {
+				return fmt.Errorf("%s: group %d must continue the pattern at position %d", t.Name, i, next)
+			}
+			next++
+		}
+		if len(g) == 0 {
+			return fmt.Errorf("%s: group %d is empty", t.Name, i)
+		}
+	}
+	if len(t.Groups) > 0 && next != len(t.Pattern) {
+		return fmt.Errorf("%s: groups cover %d of %d pattern positions", t.Name, next, len(t.Pattern))
+	}
+	return nil
+}
+
+// templateMatch is a template matched at ops first through first+len-1.
+type templateMatch struct {
+	template ScheduleTemplate
+	first    int
+}
+
+// groups returns the op groups of the match.
+func (m templateMatch) groups() [][]int {
+	if len(m.template.Groups) == 0 {
+		ops := make([]int, len(m.template.Pattern))
+		for i := range ops {
+			ops[i] = m.first + i
+		}
+		return [][]int{ops}
+	}
+	groups := make([][]int, len(m.template.Groups))
+	for i, g := range m.template.Groups {
+		for _, pos := range g {
+			groups[i] = append(groups[i], m.first+pos)
+		}
+	}
+	return groups
+}
+
+// matchTemplates finds non-overlapping matches of templates in op order,
+// trying longer patterns first and, among equally long ones, earlier
+// templates. Matches never include ops of fixed subgraphs or span ops
+// that may not share a subgraph. It returns them keyed by first op.
+func matchTemplates(pl *planner, templates []ScheduleTemplate, fixed map[int][]int) map[int]templateMatch {
+	if len(templates) == 0 {
+		return nil
+	}
+	p := pl.p
+	byLength := append([]ScheduleTemplate{}, templates...)
+	sort.SliceStable(byLength, func(i, j int) bool { return len(byLength[i].Pattern) > len(byLength[j].Pattern) })
+	matches := make(map[int]templateMatch)
+	for op := 0; op < len(p.OpTypes); op++ {
+		for _, t := range byLength {
+			if matchesAt(pl, t, op, fixed) {
+				matches[op] = templateMatch{template: t, first: op}
+				op += len(t.Pattern) - 1
+				break
+			}
+		}
+	}
+	return matches
+}
+
+// planTemplate plans the groups of m, reporting false when one does not
+// fit in fast memory or cannot run as one subgraph, or, for a seed, when
+// the groups are slower than running the ops one by one.
+func planTemplate(pl *planner, m templateMatch) ([]subgraphPlan, bool) {
+	override := m.template.Mode == TemplateOverride
+	var plans []subgraphPlan
+	cost := 0.0
+	for _, ops := range m.groups() {
+		plan, ok := pl.plan(ops)
+		if !ok || (len(ops) > 1 && !plan.info.fusable) {
+			return nil, false
+		}
+		plan.fixed = override
+		plans = append(plans, plan)
+		cost += plan.objective
+	}
+	if !override {
+		for i := range m.template.Pattern {
+			single, _ := pl.plan([]int{m.first + i})
+			cost -= single.objective
+		}
+		if cost > 0 {
+			return nil, false
+		}
+	}
+	return plans, true
+}
+
+// matchesAt reports whether t matches the ops starting at first.
+func matchesAt(pl *planner, t ScheduleTemplate, first int, fixed map[int][]int) bool {
+	p := pl.p
+	if first+len(t.Pattern) > len(p.OpTypes) {
+		return false
+	}
+	written := make(map[int]bool)
+	for i, want := range t.Pattern {
+		op := first + i
+		if _, ok := fixed[op]; ok || canonicalOpType(p.OpTypes[op]) != canonicalOpType(want) {
+			return false
+		}
+		if pl.region[op] != pl.region[first] || pl.segment[op] != pl.segment[first] {
+			return false
+		}
+		if i > 0 {
+			reads := false
+			for _, in := range p.Inputs[op] {
+				reads = reads || written[in]
+			}
+			if !reads {
+				return false
+			}
+		}
+		for _, out := range p.Outputs[op] {
+			written[out] = true
+		}
+	}
+	return true
+}
@@ -0,0 +1,187 @@
+package mlsys
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// TileConstraint restricts the spatial tile sizes the hardware supports for
+// the ops it selects: every op whose type is OpType (aliases resolve) and
+// every op listed in Ops. Widths and Heights, when set, are the allowed
+// values; WidthMultiple and HeightMultiple, when set, require a multiple.
+// An op under several constraints must satisfy them all, and so must every
+// op of a subgraph. Untiled (opaque) ops always run at their full extent
+// and are not constrained.
+type TileConstraint struct {
+	OpType         string  `json:"op_type,omitempty"`
+	Ops            []int   `json:"ops,omitempty"`
+	Widths         []int64 `json:"widths,omitempty"`
+	Heights        []int64 `json:"heights,omitempty"`
+	WidthMultiple  int64   `json:"width_multiple,omitempty"`
+	HeightMultiple int64   `json:"height_multiple,omitempty"`
+}
+
+// tileRule is the combined constraint on one op or group. A nil list means
+// any value; a non-nil empty list means none. A multiple of 0 means any.
+type tileRule struct {
+	widths, heights []int64
+	wMul, hMul      int64
+}
+
+func (r tileRule) constrained() bool {
+	return r.widths != nil || r.heights != nil || r.wMul > 0 || r.hMul > 0
+}
+
+func (r tileRule) intersect(o tileRule) tileRule {
+	return tileRule{
+		widths:  intersectSides(r.widths, o.widths),
+		heights: intersectSides(r.heights, o.heights),
+		wMul:    lcm(r.wMul, o.wMul),
+		hMul:    lcm(r.hMul, o.hMul),
+	}
+}
+
+func (r tileRule) allows(w, h int64) bool {
+	return sideAllowed(w, r.widths, r.wMul) && sideAllowed(h, r.heights, r.hMul)
+}
+
+func sideAllowed(v int64, allowed []int64, mul int64) bool {
+	if mul > 0 && v%mul != 0 {
+		return false
+	}
+	if allowed == nil {
+		return true
+	}
+	for _, a := range allowed {
+		if a == v {
+			return true
+		}
+	}
+	return false
+}
+
+func intersectSides(a, b []int64) []int64 {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	out := []int64{}
+	for _, v := range a {
+		if sideAllowed(v, b, 0) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func lcm(a, b int64) int64 {
+	if a == 0 {
+		return b
+	}
+	if b == 0 {
+		return a
+	}
+	x, y := a, b
+	for y != 0 {
+		x, y = y, x%y
+	}
+	return a / x * b
+}
+
+// maxMultipleCandidates bounds how many multiples of a required step are
+// tried per side; beyond it only power-of-two multiples are.
+const maxMultipleCandidates = 64
+
+// candidateSides lists, in descending order, the tile sides up to max that
+// rule permits: powers of two, explicitly allowed values and multiples of
+// the required step. It is empty only if the rule permits no side at all.
+func candidateSides(max int64, allowed []int64, mul int64) []int64 {
+	set := make(map[int64]bool)
+	for _, v := range descendingPowersOfTwo(max) {
+		set[v] = true
+	}
+	for _, v := range allowed {
+		if v <= max {
+			set[v] = true
+		}
+	}
+	if mul > 0 {
+		if max/mul <= maxMultipleCandidates {
+			for v := mul; v <= max; v += mul {
+				set[v] = true
+			}
+		} else {
+			for v := mul; v <= max; v *= 2 {
+				set[v] = true
+			}
+		}
+	}
+	var out []int64
+	for v := range set {
+		if sideAllowed(v, allowed, mul) {
+			out = append(out, v)
+		}
+	}
+	if len(out) == 0 {
+		// Nothing permitted fits within max: use the smallest permitted
+		// side, padding the edge tile.
+		smallest := int64(0)
+		for _, v := range allowed {
+			if sideAllowed(v, nil, mul) && (smallest == 0 || v < smallest) {
+				smallest = v
+			}
+		}
+		if allowed == nil {
+			smallest = mul
+		}
+		if smallest > 0 {
+			out = append(out, smallest)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] > out[j] })
+	return out
+}
+
+func validateTileConstraints(p InputProblem) error {
+	for i, c := range p.TileConstraints {
+		if c.OpType == "" && len(c.Ops) == 0 {
+			return fmt.Errorf("tile constraint %d selects no ops: set op_type or ops", i)
+		}
+		for _, op := range c.Ops {
+			if op < 0 || op >= len(p.OpTypes) {
+				return fmt.Errorf("tile constraint %d: op index out of range: %d", i, op)
+			}
+		}
+		for _, v := range append(append([]int64(nil), c.Widths...), c.Heights...) {
+			if v <= 0 {
+				return fmt.Errorf("tile constraint %d: tile sizes must be > 0", i)
+			}
+		}
+		if c.WidthMultiple < 0 || c.HeightMultiple < 0 {
+			return errors.New("tile constraint multiples must be >= 0")
+		}
+	}
+	return nil
+}
+
+// opTileRules resolves the tile constraints of every op.
+func opTileRules(p InputProblem) []tileRule {
+	rules := make([]tileRule, len(p.OpTypes))
+	for _, c := range p.TileConstraints {
+		r := tileRule{widths: c.Widths, heights: c.Heights, wMul: c.WidthMultiple, hMul: c.HeightMultiple}
+		selected := make(map[int]bool, len(c.Ops))
+		for _, op := range c.Ops {
+			selected[op] = true
+		}
+		want := canonicalOpType(c.OpType)
+		for op, name := range p.OpTypes {
+			if selected[op] || (c.OpType != "" && canonicalOpType(name) == want) {
+				rules[op] = rules[op].intersect(r)
+			}
+		}
+	}
+	return rules
+}
@@ -0,0 +1,48 @@
+package mlsys
+
+import "errors"
+
+// CostModelUncertainty is the calibration error of the cost model, as the
+// relative half-width of the band the true value falls in: 0.1 means the
+// real compute (or memory) time is within ±10% of the modeled one.
+type CostModelUncertainty struct {
+	Compute float64 `json:"compute"`
+	Memory  float64 `json:"memory"`
+}
+
+// LatencyInterval brackets a latency estimate. Wide intervals flag
+// subgraphs whose estimate leans on the poorly calibrated part of the
+// model.
+type LatencyInterval struct {
+	Low      float64 `json:"low"`
+	Expected float64 `json:"expected"`
+	High     float64 `json:"high"`
+}
+
+func validateUncertainty(p InputProblem) error {
+	u := p.CostModelUncertainty
+	if u == nil {
+		return nil
+	}
+	if u.Compute < 0 || u.Compute >= 1 || u.Memory < 0 || u.Memory >= 1 {
+		return errors.New("cost_model_uncertainty entries must be within [0, 1)")
+	}
+	return nil
+}
+
+// latencyInterval propagates the model's uncertainty through the roofline
+// of one subgraph. Both bounds move compute and memory time the same way,
+// which is where the maximum of the two is most and least favourable.
+func latencyInterval(p InputProblem, info groupInfo, g [3]int64, expected float64) LatencyInterval {
+	u := p.CostModelUncertainty
+	_, compute, _ := stepCosts(p, info, g, p.SlowMemoryBandwidth)
+	classes := stepClasses(p, info, g, p.SlowMemoryBandwidth)
+	depth := bufferDepth(p, info, g[0], g[1], g[2])
+	at := func(sign float64) float64 {
+		mem := func(c stepClass) float64 {
+			return (float64(c.elements)/p.SlowMemoryBandwidth + c.overhead) * (1 + sign*u.Memory)
+		}
+		return p.SubgraphDispatchOverhead + rooflineLatency(info, depth, classes, compute*(1+sign*u.Compute), mem)
+	}
+	return LatencyInterval{Low: at(-1), Expected: expected, High: at(1)}
+}
@@ -0,0 +1,230 @@
+// Package validate runs the checks mlsys applies to problems and schedules
+// one by one, and reports what each finds with a severity, for tools that
+// build or edit schedules of their own. Errors are what mlsys.ValidateProblem
+// and mlsys.ValidateSolution reject; warnings flag inputs the solver accepts
+// but that are likely mistakes.
+package validate
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"mlsys"
+)
+
+// Severity says whether a finding makes the input unusable.
+type Severity int
+
+const (
+	// Error findings are rejected by the solver.
+	Error Severity = iota
+	// Warning findings are accepted but likely mistakes.
+	Warning
+)
+
+func (s Severity) String() string {
+	if s == Warning {
+		return "warning"
+	}
+	return "error"
+}
+
+// Finding is what one check reported.
+type Finding struct {
+	Check    string
+	Severity Severity
+	Err      error
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s: %s: %v", f.Severity, f.Check, f.Err)
+}
+
+// Report is the findings of a run, in check order.
+type Report []Finding
+
+// Err returns the error of the first Error finding, or nil if there is
+// none.
+func (r Report) Err() error {
+	for _, f := range r {
+		if f.Severity == Error {
+			return f.Err
+		}
+	}
+	return nil
+}
+
+// Warnings returns the Warning findings.
+func (r Report) Warnings() []Finding {
+	var out []Finding
+	for _, f := range r {
+		if f.Severity == Warning {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// ProblemCheck is one check of a problem.
+type ProblemCheck struct {
+	Name     string
+	Severity Severity
+	Check    func(mlsys.InputProblem) error
+}
+
+// SolutionCheck is one check of a schedule for a problem.
+type SolutionCheck struct {
+	Name     string
+	Severity Severity
+	Check    func(mlsys.InputProblem, mlsys.OutputSolution, mlsys.Options) error
+}
+
+// ProblemChecks lists every problem check: those of mlsys.ValidateProblem,
+// as errors and in its order, then the warnings.
+func ProblemChecks() []ProblemCheck {
+	var checks []ProblemCheck
+	for _, c := range mlsys.ProblemChecks() {
+		checks = append(checks, ProblemCheck{Name: c.Name, Severity: Error, Check: c.Check})
+	}
+	return append(checks,
+		ProblemCheck{Name: "unknown_op_types", Severity: Warning, Check: UnknownOpTypes},
+		ProblemCheck{Name: "unused_tensors", Severity: Warning, Check: UnusedTensors},
+	)
+}
+
+// SolutionChecks lists every schedule check: those of
+// mlsys.ValidateSolution, as errors and in its order, then the warnings.
+func SolutionChecks() []SolutionCheck {
+	var checks []SolutionCheck
+	for _, c := range mlsys.SolutionChecks() {
+		checks = append(checks, SolutionCheck{Name: c.Name, Severity: Error, Check: c.Check})
+	}
+	return append(checks,
+		SolutionCheck{Name: "subgraph_latencies", Severity: Warning, Check: SubgraphLatencies},
+		SolutionCheck{Name: mlsys.WarningNearCapacity, Severity: Warning, Check: ScheduleWarnings(mlsys.WarningNearCapacity)},
+		SolutionCheck{Name: mlsys.WarningSmallTile, Severity: Warning, Check: ScheduleWarnings(mlsys.WarningSmallTile)},
+		SolutionCheck{Name: mlsys.WarningUnconsumedRetained, Severity: Warning, Check: ScheduleWarnings(mlsys.WarningUnconsumedRetained)},
+	)
+}
+
+// Problem runs checks on p in order. It stops at the first error, since
+// later checks may assume that the earlier ones passed.
+func Problem(p mlsys.InputProblem, checks []ProblemCheck) Report {
+	var r Report
+	for _, c := range checks {
+		if err := c.Check(p); err != nil {
+			r = append(r, Finding{Check: c.Name, Severity: c.Severity, Err: err})
+			if c.Severity == Error {
+				break
+			}
+		}
+	}
+	return r
+}
+
+// Solution runs checks on s, a schedule for p, as Problem does.
+func Solution(p mlsys.InputProblem, s mlsys.OutputSolution, opts mlsys.Options, checks []SolutionCheck) Report {
+	var r Report
+	for _, c := range checks {
+		if err := c.Check(p, s, opts); err != nil {
+			r = append(r, Finding{Check: c.Name, Severity: c.Severity, Err: err})
+			if c.Severity == Error {
+				break
+			}
+		}
+	}
+	return r
+}
+
+// UnknownOpTypes reports op types missing from the registry, which the
+// solver models as the unknown-op policy says.
+func UnknownOpTypes(p mlsys.InputProblem) error {
+	var unknown []string
+	seen := make(map[string]bool)
+	for _, name := range p.OpTypes {
+		if !mlsys.KnownOpType(name) && !seen[name] {
+			seen[name] = true
+			unknown = append(unknown, fmt.Sprintf("%q", name))
+		}
+	}
+	if unknown == nil {
+		return nil
+	}
+	return fmt.Errorf("unknown op types %s", strings.Join(unknown, ", "))
+}
+
+// UnusedTensors reports tensors that no op reads or writes and no view
+// refers to.
+func UnusedTensors(p mlsys.InputProblem) error {
+	used := make([]bool, len(p.Widths))
+	mark := func(ts []int) {
+		for _, t := range ts {
+			if t >= 0 && t < len(used) {
+				used[t] = true
+			}
+		}
+	}
+	for op := range p.OpTypes {
+		mark(p.Inputs[op])
+		mark(p.Outputs[op])
+	}
+	for _, v := range p.Views {
+		mark([]int{v.Tensor, v.Base})
+	}
+	var unused []string
+	for t, ok := range used {
+		if !ok {
+			unused = append(unused, fmt.Sprint(t))
+		}
+	}
+	if unused == nil {
+		return nil
+	}
+	return fmt.Errorf("tensors %s are not used by any op", strings.Join(unused, ", "))
+}
+
+// latencyTolerance is the relative difference between a stated and a
+// modeled subgraph latency that SubgraphLatencies lets pass.
+const latencyTolerance = 1e-6
+
+// SubgraphLatencies reports a schedule without subgraph latencies, or whose
+// latencies differ from what the cost model gives.
+func SubgraphLatencies(p mlsys.InputProblem, s mlsys.OutputSolution, opts mlsys.Options) error {
+	if s.SubgraphLatencies == nil {
+		return errors.New("schedule has no subgraph_latencies")
+	}
+	stats, err := mlsys.AnalyzeSolution(p, s, opts)
+	if err != nil {
+		return err
+	}
+	for i, st := range stats {
+		stated := s.SubgraphLatencies[i]
+		if math.Abs(stated-st.Latency) > latencyTolerance*math.Max(1, math.Abs(st.Latency)) {
+			return fmt.Errorf("subgraph %d: latency %g, but the cost model gives %g", i, stated, st.Latency)
+		}
+	}
+	return nil
+}
+
+// ScheduleWarnings returns a check reporting the mlsys.ScheduleWarnings of
+// a schedule that are of the given kind.
+func ScheduleWarnings(kind string) func(mlsys.InputProblem, mlsys.OutputSolution, mlsys.Options) error {
+	return func(p mlsys.InputProblem, s mlsys.OutputSolution, opts mlsys.Options) error {
+		warnings, err := mlsys.ScheduleWarnings(p, s, opts)
+		if err != nil {
+			return err
+		}
+		var msgs []string
+		for _, w := range warnings {
+			if w.Kind == kind {
+				msgs = append(msgs, w.Message)
+			}
+		}
+		if msgs == nil {
+			return nil
+		}
+		return errors.New(strings.Join(msgs, "; "))
+	}
+}
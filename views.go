@@ -0,0 +1,96 @@
+package mlsys
+
+import "fmt"
+
+// TensorView declares Tensor to be a view of Base, e.g. a slice or a
+// reshape: it has no storage of its own, and element (r, c) of the view is
+// element Offset + r*Stride + c of Base in row-major order. Stride defaults
+// to the width of Base. A view is produced whenever its base is, by the
+// same ops, and views of the same storage share their footprint.
+type TensorView struct {
+	Tensor int   `json:"tensor"`
+	Base   int   `json:"base"`
+	Offset int64 `json:"offset,omitempty"`
+	Stride int64 `json:"stride,omitempty"`
+}
+
+// stride returns the row stride of v within its base.
+func (v TensorView) stride(p InputProblem) int64 {
+	if v.Stride > 0 {
+		return v.Stride
+	}
+	return p.Widths[v.Base]
+}
+
+// tileCompatible reports whether every tile of the view is a tile of its
+// base: the view is a run of whole base rows. Only such views can be
+// consumed in the subgraph that produces their base.
+func (v TensorView) tileCompatible(p InputProblem) bool {
+	w := p.Widths[v.Base]
+	return v.stride(p) == w && p.Widths[v.Tensor] == w && v.Offset%w == 0
+}
+
+func validateViews(p InputProblem) error {
+	if len(p.Views) == 0 {
+		return nil
+	}
+	nTensors := len(p.Widths)
+	isView := make(map[int]bool, len(p.Views))
+	for i, v := range p.Views {
+		if v.Tensor < 0 || v.Tensor >= nTensors || v.Base < 0 || v.Base >= nTensors {
+			return fmt.Errorf("view %d: tensor index out of range", i)
+		}
+		if v.Tensor == v.Base {
+			return fmt.Errorf("view %d: tensor %d is a view of itself", i, v.Tensor)
+		}
+		if isView[v.Tensor] {
+			return fmt.Errorf("view %d: tensor %d is declared a view twice", i, v.Tensor)
+		}
+		isView[v.Tensor] = true
+		if v.Offset < 0 || v.Stride < 0 {
+			return fmt.Errorf("view %d: offset and stride must be >= 0", i)
+		}
+		w, h := p.Widths[v.Tensor], p.Heights[v.Tensor]
+		if v.Stride > 0 && v.Stride < w {
+			return fmt.Errorf("view %d: stride %d is below the view width %d", i, v.Stride, w)
+		}
+		if end := v.Offset + (h-1)*v.stride(p) + w; end > p.Widths[v.Base]*p.Heights[v.Base] {
+			return fmt.Errorf("view %d: extends past the end of tensor %d", i, v.Base)
+		}
+	}
+	for i, v := range p.Views {
+		if isView[v.Base] {
+			return fmt.Errorf("view %d: base %d is itself a view; declare views of its base instead", i, v.Base)
+		}
+	}
+	for op, outs := range p.Outputs {
+		for _, t := range outs {
+			if isView[t] {
+				return fmt.Errorf("op %d writes tensor %d, which is a view; write its base instead", op, t)
+			}
+		}
+	}
+	return nil
+}
+
+// viewData copies the elements of view v out of its base's data. Indices
+// past the end of the base wrap, as the interpreter does elsewhere.
+func viewData(p InputProblem, v TensorView, base []float64) []float64 {
+	w, h := p.Widths[v.Tensor], p.Heights[v.Tensor]
+	out := make([]float64, w*h)
+	stride := v.stride(p)
+	n := int64(len(base))
+	for r := int64(0); r < h; r++ {
+		for c := int64(0); c < w; c++ {
+			out[c+r*w] = base[(v.Offset+r*stride+c)%n]
+		}
+	}
+	return out
+}
+
+// materializeViews fills in the views of t from its data in mem.
+func materializeViews(p InputProblem, gi graphIndex, t int, mem [][]float64) {
+	for _, i := range gi.views[t] {
+		mem[p.Views[i].Tensor] = viewData(p, p.Views[i], mem[t])
+	}
+}
@@ -0,0 +1,108 @@
+package mlsys
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ScheduleWarning flags a part of a valid schedule that is legal but
+// likely not what its user wants, such as a subgraph that leaves almost no
+// headroom in fast memory.
+type ScheduleWarning struct {
+	// Kind is one of the Warning constants.
+	Kind     string `json:"kind"`
+	Subgraph int    `json:"subgraph"`
+	Message  string `json:"message"`
+}
+
+// Kinds of ScheduleWarning.
+const (
+	// WarningNearCapacity flags a subgraph that fills more than
+	// nearCapacityShare of fast memory, or of one of the fast memories, so
+	// any growth in a tensor or error in the footprint model overflows it.
+	WarningNearCapacity = "near_capacity"
+	// WarningSmallTile flags a subgraph whose tile is at most
+	// 1/smallTileFactor of what the native granularity and its output
+	// shapes allow in some dimension, wasting most of every native tile.
+	WarningSmallTile = "small_tile"
+	// WarningUnconsumedRetained flags a tensor retained after a subgraph
+	// that the next subgraph neither reads nor retains further, which
+	// holds fast memory for nothing.
+	WarningUnconsumedRetained = "unconsumed_retained"
+)
+
+const (
+	nearCapacityShare = 0.98
+	smallTileFactor   = 4
+)
+
+// ScheduleWarnings lists what a schedule s for p does that is legal but
+// suspect, in subgraph order. s must have passed ValidateSolution; opts
+// are the options it was solved with, and its capacity margin is ignored:
+// fill is measured against the whole of fast memory.
+func ScheduleWarnings(p InputProblem, s OutputSolution, opts Options) ([]ScheduleWarning, error) {
+	if len(s.Granularities) != len(s.Subgraphs) {
+		return nil, errors.New("subgraphs/granularities length mismatch")
+	}
+	near := opts
+	near.CapacityMargin = nearCapacityShare
+	q, gi, err := solutionModel(p, s, near)
+	if err != nil {
+		return nil, err
+	}
+	sc := newGroupScratch(q)
+	var out []ScheduleWarning
+	warn := func(kind string, i int, format string, args ...any) {
+		out = append(out, ScheduleWarning{Kind: kind, Subgraph: i, Message: fmt.Sprintf("subgraph %d: "+format, append([]any{i}, args...)...)})
+	}
+	for i, ops := range s.Subgraphs {
+		if len(ops) == 0 {
+			continue
+		}
+		for _, op := range ops {
+			if op < 0 || op >= len(p.OpTypes) {
+				return nil, fmt.Errorf("subgraph %d: op index out of range: %d", i, op)
+			}
+		}
+		ops = sortedUnique(ops)
+		g := s.Granularities[i]
+		fill, info := withRetained(q, analyzeGroup(q, gi, ops, sc), retainedInto(gi, s, i))
+		if err := checkGroupFits(fill, info, g); err != nil {
+			warn(WarningNearCapacity, i, "fills more than %g%% of fast memory; at that share it %v", 100*nearCapacityShare, err)
+		}
+
+		// The tile cannot usefully exceed the native tile, nor the widest
+		// and tallest output of the subgraph.
+		var maxW, maxH int64
+		for _, op := range ops {
+			for _, t := range p.Outputs[op] {
+				maxW, maxH = max(maxW, p.Widths[t]), max(maxH, p.Heights[t])
+			}
+		}
+		capW, capH := min(p.NativeGranularity[0], maxW), min(p.NativeGranularity[1], maxH)
+		if g[0]*smallTileFactor <= capW || g[1]*smallTileFactor <= capH {
+			warn(WarningSmallTile, i, "tile %dx%d is far below the %dx%d its native granularity and outputs allow", g[0], g[1], capW, capH)
+		}
+
+		if i >= len(s.TensorsToRetain) {
+			continue
+		}
+		var read []int
+		var kept map[int]bool
+		if i+1 < len(s.Subgraphs) {
+			for _, op := range s.Subgraphs[i+1] {
+				read = append(read, p.Inputs[op]...)
+			}
+			if i+1 < len(s.TensorsToRetain) {
+				kept = intSet(s.TensorsToRetain[i+1])
+			}
+		}
+		consumed := intSet(read)
+		for _, t := range sortedUnique(s.TensorsToRetain[i]) {
+			if !consumed[t] && !kept[t] {
+				warn(WarningUnconsumedRetained, i, "retains tensor %d, which the next subgraph neither reads nor retains", t)
+			}
+		}
+	}
+	return out, nil
+}